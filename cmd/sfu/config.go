@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/gotolive/sfu/rtc/bwe"
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/gotolive/sfu/rtc/ice"
+	"github.com/gotolive/sfu/rtc/logger"
+)
+
+// Config is the file of the server, it's YAML or TOML by the extension, the keys are the same.
+// The settings tagged reload are applied by SIGHUP, the others require a restart.
+type Config struct {
+	// Listen is the address of signaling, WHIP and WHEP.
+	Listen string    `yaml:"listen"`
+	TLS    TLSConfig `yaml:"tls"`
+	ICE    ICEConfig `yaml:"ice"`
+	// DTLS is the certificate of the connections, a self-signed one is generated if empty.
+	DTLS      TLSConfig       `yaml:"dtls"`
+	BweType   string          `yaml:"bweType"`
+	Codecs    []string        `yaml:"codecs"`
+	Signaling SignalingConfig `yaml:"signaling"`
+	WHIP      WHIPConfig      `yaml:"whip"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Auth      AuthConfig      `yaml:"auth" reload:"true"`
+	Logging   LoggingConfig   `yaml:"logging" reload:"true"`
+}
+
+// TLSConfig is the paths of the PEM files, both or neither.
+type TLSConfig struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+type ICEConfig struct {
+	IPs        []string `yaml:"ips"`
+	MinPort    uint16   `yaml:"minPort"`
+	MaxPort    uint16   `yaml:"maxPort"`
+	EnableIPv6 bool     `yaml:"enableIPv6"`
+	EnableTCP  bool     `yaml:"enableTCP"`
+	TCPPort    uint16   `yaml:"tcpPort"`
+	DisableUDP bool     `yaml:"disableUDP"`
+	// FailTimeout and DisconnectTimeout are in whole seconds, 30s and 5s by default.
+	FailTimeout       time.Duration `yaml:"failTimeout"`
+	DisconnectTimeout time.Duration `yaml:"disconnectTimeout"`
+}
+
+type SignalingConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Path          string        `yaml:"path"`
+	PingInterval  time.Duration `yaml:"pingInterval"`
+	PongTimeout   time.Duration `yaml:"pongTimeout"`
+	ResumeTimeout time.Duration `yaml:"resumeTimeout"`
+	// AllowedOrigins are the origins of upgrade requests allowed, all if empty.
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+type WHIPConfig struct {
+	Enabled        bool              `yaml:"enabled"`
+	ConnectTimeout time.Duration     `yaml:"connectTimeout"`
+	ICEServers     []ICEServerConfig `yaml:"iceServers"`
+}
+
+type ICEServerConfig struct {
+	URLs       []string `yaml:"urls"`
+	Username   string   `yaml:"username"`
+	Credential string   `yaml:"credential"`
+}
+
+// AdminConfig is the admin API, it's disabled if Listen is empty.
+type AdminConfig struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token" reload:"true"`
+}
+
+// AuthConfig is the JWT of signaling, WHIP and WHEP, the tokens are not required if no key.
+type AuthConfig struct {
+	Secret string `yaml:"secret"`
+	// PublicKey is the path of the PEM of ES256.
+	PublicKey string        `yaml:"publicKey"`
+	Issuer    string        `yaml:"issuer"`
+	Audience  string        `yaml:"audience"`
+	Leeway    time.Duration `yaml:"leeway"`
+	// AllowNoExpiry accepts the tokens without exp, they are rejected by default.
+	AllowNoExpiry bool `yaml:"allowNoExpiry"`
+}
+
+// Enabled returns true if the tokens are required.
+func (c AuthConfig) Enabled() bool {
+	return c.Secret != "" || c.PublicKey != ""
+}
+
+type LoggingConfig struct {
+	// Level is error, warn, info or debug, info by default.
+	Level string `yaml:"level"`
+	// File is appended if not empty, it's reopened by SIGHUP for the rotation.
+	File string `yaml:"file"`
+}
+
+// defaultConfig returns the config of the keys not present in the file.
+func defaultConfig() *Config {
+	return &Config{
+		Listen:  ":8080",
+		BweType: bwe.Remb,
+		ICE: ICEConfig{
+			FailTimeout:       30 * time.Second,
+			DisconnectTimeout: 5 * time.Second,
+		},
+		Signaling: SignalingConfig{Enabled: true, Path: "/ws"},
+		WHIP:      WHIPConfig{Enabled: true},
+		Logging:   LoggingConfig{Level: "info"},
+	}
+}
+
+// LoadConfig loads the file by the extension, .yaml, .yml or .toml, and validates it.
+// The unknown keys are errors rather than ignored, they are likely typos.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		table := map[string]any{}
+		if err = toml.Unmarshal(data, &table); err != nil {
+			return nil, fmt.Errorf("%s: %w: %v", path, ErrInvalidTOML, err)
+		}
+		// the keys are the same, decode it by yaml for the defaults, durations and known fields.
+		if data, err = yaml.Marshal(table); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s: %w", path, ErrUnknownFormat)
+	}
+	config := defaultConfig()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Validate returns all the invalid settings joined, each is prefixed by the key.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key, format string, v ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s: %s", ErrInvalidConfig, key, fmt.Sprintf(format, v...)))
+	}
+	checkAddress := func(key, address string) {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			invalid(key, "%q is not host:port", address)
+		}
+	}
+	checkPair := func(key string, c TLSConfig) {
+		if (c.Cert == "") != (c.Key == "") {
+			invalid(key, "cert and key must be set together")
+		}
+	}
+	checkPositive := func(key string, d time.Duration) {
+		if d < 0 {
+			invalid(key, "must not be negative")
+		}
+	}
+
+	checkAddress("listen", c.Listen)
+	checkPair("tls", c.TLS)
+	checkPair("dtls", c.DTLS)
+	for _, ip := range c.ICE.IPs {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			invalid("ice.ips", "%q is not an ip", ip)
+		} else if parsed.To4() == nil && !c.ICE.EnableIPv6 {
+			invalid("ice.ips", "%q is ipv6 but ice.enableIPv6 is false", ip)
+		}
+	}
+	if c.ICE.MinPort > c.ICE.MaxPort && c.ICE.MaxPort != 0 {
+		invalid("ice.minPort", "%d is greater than ice.maxPort %d", c.ICE.MinPort, c.ICE.MaxPort)
+	}
+	if c.ICE.DisableUDP && !c.ICE.EnableTCP {
+		invalid("ice.disableUDP", "no transport left, enable ice.enableTCP")
+	}
+	checkSeconds := func(key string, d time.Duration) {
+		if d < time.Second || d%time.Second != 0 {
+			invalid(key, "%v must be whole seconds", d)
+		}
+	}
+	checkSeconds("ice.failTimeout", c.ICE.FailTimeout)
+	checkSeconds("ice.disconnectTimeout", c.ICE.DisconnectTimeout)
+	if c.BweType != bwe.Remb && c.BweType != bwe.TransportCC {
+		invalid("bweType", "%q is not %s or %s", c.BweType, bwe.Remb, bwe.TransportCC)
+	}
+	supported := map[string]bool{}
+	for _, v := range codec.Codecs() {
+		supported[strings.ToLower(v.EncoderName)] = true
+	}
+	for _, name := range c.Codecs {
+		if !supported[strings.ToLower(name)] {
+			invalid("codecs", "%q is not supported", name)
+		}
+	}
+	if !c.Signaling.Enabled && !c.WHIP.Enabled {
+		invalid("signaling.enabled", "nothing to serve, enable signaling or whip")
+	}
+	if c.Signaling.Enabled {
+		path := c.Signaling.Path
+		switch {
+		case !strings.HasPrefix(path, "/"):
+			invalid("signaling.path", "%q must start with /", path)
+		case c.WHIP.Enabled && (path == "/" || strings.HasPrefix(path+"/", "/whip/") || strings.HasPrefix(path+"/", "/whep/")):
+			invalid("signaling.path", "%q conflicts with whip", path)
+		}
+	}
+	checkPositive("signaling.pingInterval", c.Signaling.PingInterval)
+	checkPositive("signaling.pongTimeout", c.Signaling.PongTimeout)
+	checkPositive("signaling.resumeTimeout", c.Signaling.ResumeTimeout)
+	checkPositive("whip.connectTimeout", c.WHIP.ConnectTimeout)
+	for i, s := range c.WHIP.ICEServers {
+		if len(s.URLs) == 0 {
+			invalid(fmt.Sprintf("whip.iceServers[%d].urls", i), "must not be empty")
+		}
+	}
+	if c.Admin.Listen != "" {
+		checkAddress("admin.listen", c.Admin.Listen)
+		if c.Admin.Listen == c.Listen {
+			invalid("admin.listen", "must be different from listen")
+		}
+	}
+	checkPositive("auth.leeway", c.Auth.Leeway)
+	if _, ok := logLevels[strings.ToLower(c.Logging.Level)]; !ok {
+		invalid("logging.level", "%q is not error, warn, info or debug", c.Logging.Level)
+	}
+	return errors.Join(errs...)
+}
+
+var logLevels = map[string]int{
+	"error": logger.LevelError,
+	"warn":  logger.LevelWarn,
+	"info":  logger.LevelInfo,
+	"debug": logger.LevelDebug,
+}
+
+// iceOption returns the option of the broker, the config must be valid.
+func (c *Config) iceOption() ice.Option {
+	return ice.Option{
+		MinPort:           c.ICE.MinPort,
+		MaxPort:           c.ICE.MaxPort,
+		EnableIPV6:        c.ICE.EnableIPv6,
+		EnableTCP:         c.ICE.EnableTCP,
+		TCPPort:           c.ICE.TCPPort,
+		DisableUDP:        c.ICE.DisableUDP,
+		IPs:               c.ICE.IPs,
+		FailTimeout:       int64(c.ICE.FailTimeout / time.Second),
+		DisconnectTimeout: int64(c.ICE.DisconnectTimeout / time.Second),
+	}
+}
+
+// restartRequired returns the keys of the changes not applied by reload, the fields tagged reload
+// are compared by the fields inside only.
+func restartRequired(old, new *Config) []string {
+	var keys []string
+	var walk func(prefix string, a, b reflect.Value)
+	walk = func(prefix string, a, b reflect.Value) {
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			key := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if field.Tag.Get("reload") == "true" {
+				continue
+			}
+			if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+				walk(key+".", a.Field(i), b.Field(i))
+				continue
+			}
+			if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	walk("", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem())
+	return keys
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+// writeConfig writes the content to the file of name in a temp dir and returns the path.
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert(t, os.WriteFile(path, []byte(content), 0o600), nil)
+	return path
+}
+
+func TestConfig(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "examples",
+			description: "the example files are valid and the same",
+			method: func(t *testing.T) {
+				yamlConfig, err := LoadConfig("sfu.example.yaml")
+				assert(t, err, nil)
+				tomlConfig, err := LoadConfig("sfu.example.toml")
+				assert(t, err, nil)
+				assert(t, tomlConfig, yamlConfig)
+				assert(t, yamlConfig.ICE.FailTimeout, 30*time.Second)
+				assert(t, yamlConfig.WHIP.ICEServers[0].URLs, []string{"stun:stun.l.google.com:19302"})
+				assert(t, yamlConfig.iceOption().FailTimeout, int64(30))
+			},
+		},
+		{
+			name:        "defaults",
+			description: "the keys not present are the defaults",
+			method: func(t *testing.T) {
+				config, err := LoadConfig(writeConfig(t, "sfu.yml", "admin:\n  listen: 127.0.0.1:9000\n"))
+				assert(t, err, nil)
+				expected := defaultConfig()
+				expected.Admin.Listen = "127.0.0.1:9000"
+				assert(t, config, expected)
+				config, err = LoadConfig(writeConfig(t, "sfu.yaml", ""))
+				assert(t, err, nil)
+				assert(t, config, defaultConfig())
+			},
+		},
+		{
+			name:        "toml",
+			description: "the toml beyond the example is loaded, e.g. multi-line arrays and inline tables",
+			method: func(t *testing.T) {
+				config, err := LoadConfig(writeConfig(t, "sfu.toml", `
+listen = ":9000" # comment
+codecs = [
+  "opus",
+  'VP8',
+]
+tls = { cert = "a.crt", key = "a.key" }
+whip.enabled = true
+[ice]
+ips = ["127.0.0.1"]
+failTimeout = "10s"
+[[whip.iceServers]]
+urls = ["stun:stun.example.net"]
+`))
+				assert(t, err, nil)
+				assert(t, config.Listen, ":9000")
+				assert(t, config.Codecs, []string{"opus", "VP8"})
+				assert(t, config.TLS, TLSConfig{Cert: "a.crt", Key: "a.key"})
+				assert(t, config.ICE.IPs, []string{"127.0.0.1"})
+				assert(t, config.ICE.FailTimeout, 10*time.Second)
+				assert(t, len(config.WHIP.ICEServers), 1)
+				for _, invalid := range []string{"a", "a = ", "a = 1\na = 2", "[a", "a = [1", "a = 1\n[a]", "a = 'x", "a = nope"} {
+					_, err = LoadConfig(writeConfig(t, "sfu.toml", invalid))
+					assert(t, errors.Is(err, ErrInvalidTOML), true)
+				}
+				_, err = LoadConfig(writeConfig(t, "sfu.toml", "a = 1\nb = 'x\n"))
+				assert(t, strings.Contains(err.Error(), "line 2"), true)
+			},
+		},
+		{
+			name:        "invalid",
+			description: "the invalid settings are reported together with the keys",
+			method: func(t *testing.T) {
+				_, err := LoadConfig(writeConfig(t, "sfu.json", "{}"))
+				assert(t, errors.Is(err, ErrUnknownFormat), true)
+				_, err = LoadConfig(writeConfig(t, "sfu.yaml", "ice:\n  minport: 1\n"))
+				assert(t, strings.Contains(err.Error(), "field minport not found"), true)
+				_, err = LoadConfig(writeConfig(t, "sfu.toml", "[ice]\nfailTimeout = \"soon\"\n"))
+				assert(t, err != nil, true)
+				_, err = LoadConfig(writeConfig(t, "sfu.yaml", "listen: \"8080\"\n"))
+				assert(t, strings.Contains(err.Error(), `listen: "8080" is not host:port`), true)
+
+				_, err = LoadConfig(writeConfig(t, "sfu.yaml", `
+listen: ":8080"
+tls: {cert: a.crt}
+ice: {ips: ["::1", "x"], minPort: 2000, maxPort: 1000, disableUDP: true, failTimeout: 1500ms}
+bweType: gcc
+codecs: [opus, G711]
+signaling: {path: /whip}
+whip: {iceServers: [{username: a}]}
+admin: {listen: ":8080", token: x}
+logging: {level: trace}
+`))
+				assert(t, errors.Is(err, ErrInvalidConfig), true)
+				for _, expected := range []string{
+					"tls: cert and key must be set together",
+					`ice.ips: "::1" is ipv6 but ice.enableIPv6 is false`,
+					`ice.ips: "x" is not an ip`,
+					"ice.minPort: 2000 is greater than ice.maxPort 1000",
+					"ice.disableUDP: no transport left",
+					"ice.failTimeout: 1.5s must be whole seconds",
+					`bweType: "gcc" is not remb or tcc`,
+					`codecs: "G711" is not supported`,
+					`signaling.path: "/whip" conflicts with whip`,
+					"whip.iceServers[0].urls: must not be empty",
+					`admin.listen: must be different from listen`,
+					`logging.level: "trace" is not error, warn, info or debug`,
+				} {
+					if !strings.Contains(err.Error(), expected) {
+						t.Fatalf("%v expected: %v, but got: %v", t.Name(), expected, err)
+					}
+				}
+				assert(t, len(strings.Split(err.Error(), "\n")), 12)
+			},
+		},
+		{
+			name:        "restart",
+			description: "the changes of the settings not reloadable are reported",
+			method: func(t *testing.T) {
+				old := defaultConfig()
+				next := defaultConfig()
+				next.Logging.Level = "debug"
+				next.Auth.Secret = "secret"
+				next.Admin.Token = "token"
+				assert(t, len(restartRequired(old, next)), 0)
+				next.ICE.MaxPort = 5000
+				next.Codecs = []string{"opus"}
+				next.Admin.Listen = ":9000"
+				assert(t, restartRequired(old, next), []string{"ice.maxPort", "codecs", "admin.listen"})
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	config := defaultConfig()
+	config.Listen = "127.0.0.1:0"
+	config.ICE.IPs = []string{"127.0.0.1"}
+	config.Admin = AdminConfig{Listen: "localhost:0", Token: "old"}
+	config.Auth.Secret = "secret"
+	assert(t, config.Validate(), nil)
+	s, err := newServer(config)
+	assert(t, err, nil)
+	defer s.shutdown(context.Background())
+	status := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.admin.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert(t, status("old"), http.StatusOK)
+
+	next := *config
+	next.Admin.Token = "new"
+	next.Auth.Secret = "rotated"
+	next.Logging = LoggingConfig{Level: "warn", File: filepath.Join(t.TempDir(), "sfu.log")}
+	next.ICE.MaxPort = 5000
+	assert(t, s.reload(&next), nil)
+	assert(t, status("old"), http.StatusUnauthorized)
+	assert(t, status("new"), http.StatusOK)
+	assert(t, s.config.Auth.Secret, "rotated")
+	// the structural change is not applied.
+	assert(t, s.config.ICE.MaxPort, uint16(0))
+	_, err = os.Stat(next.Logging.File)
+	assert(t, err, nil)
+
+	// disabling auth requires a restart, the others are applied.
+	disabled := next
+	disabled.Auth = AuthConfig{}
+	disabled.Admin.Token = ""
+	assert(t, s.reload(&disabled), nil)
+	assert(t, s.config.Auth.Secret, "rotated")
+	assert(t, status(""), http.StatusOK)
+
+	invalid := next
+	invalid.Logging.File = filepath.Join(t.TempDir(), "missing", "sfu.log")
+	invalid.Admin.Token = "ignored"
+	assert(t, s.reload(&invalid) != nil, true)
+	assert(t, s.config.Admin.Token, "")
+}
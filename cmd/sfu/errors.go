@@ -0,0 +1,9 @@
+package main
+
+import "errors"
+
+var (
+	ErrUnknownFormat = errors.New("unknown config format, expected .yaml, .yml or .toml")
+	ErrInvalidConfig = errors.New("invalid config")
+	ErrInvalidTOML   = errors.New("invalid toml")
+)
@@ -0,0 +1,91 @@
+// Command sfu runs a standalone SFU server of the config file, it serves the signaling over WebSocket,
+// WHIP and WHEP on the listen address, and the admin API on the admin address if set.
+//
+//	sfu -config sfu.yaml
+//	sfu -config sfu.toml -check
+//
+// SIGHUP reloads the config, the logging, auth keys and admin token are applied on the fly,
+// the changes of others are logged and require a restart. SIGINT and SIGTERM shutdown the server.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	// the codecs supported.
+	_ "github.com/gotolive/sfu/rtc/codec/av1"
+	_ "github.com/gotolive/sfu/rtc/codec/h264"
+	_ "github.com/gotolive/sfu/rtc/codec/opus"
+	_ "github.com/gotolive/sfu/rtc/codec/vp8"
+	_ "github.com/gotolive/sfu/rtc/codec/vp9"
+
+	"github.com/gotolive/sfu/rtc/logger"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	path := flag.String("config", "sfu.yaml", "the config file, .yaml, .yml or .toml")
+	check := flag.Bool("check", false, "validate the config and exit")
+	flag.Parse()
+
+	config, err := LoadConfig(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *check {
+		fmt.Println(*path, "is valid")
+		return
+	}
+	if err = run(*path, config); err != nil {
+		logger.Error("sfu exit:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, config *Config) error {
+	s, err := newServer(config)
+	if err != nil {
+		return err
+	}
+	if err = s.listen(); err != nil {
+		s.shutdown(context.Background())
+		return err
+	}
+	errCh := make(chan error, len(s.servers))
+	s.serve(errCh)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case err = <-errCh:
+			s.shutdown(context.Background())
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				// the invalid config is rejected as a whole, the server keeps running with the current one.
+				next, err := LoadConfig(path)
+				if err == nil {
+					err = s.reload(next)
+				}
+				if err != nil {
+					logger.Error("sfu reload failed:", err)
+				}
+				continue
+			}
+			logger.Info("sfu shutdown by", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			s.shutdown(ctx)
+			cancel()
+			return nil
+		}
+	}
+}
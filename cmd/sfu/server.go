@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gotolive/sfu/rtc/admin"
+	"github.com/gotolive/sfu/rtc/auth"
+	"github.com/gotolive/sfu/rtc/dtls"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/room"
+	"github.com/gotolive/sfu/rtc/signaling"
+	"github.com/gotolive/sfu/rtc/whip"
+)
+
+// server runs the endpoints of the config on a broker.
+type server struct {
+	broker    *peer.Broker
+	rooms     *room.Manager
+	signaling *signaling.Handler
+	whip      *whip.Handler
+	admin     *admin.Handler
+	verifier  *auth.Verifier
+	servers   []*http.Server
+	listeners []net.Listener
+	tls       TLSConfig
+
+	mutex   sync.Mutex
+	config  *Config
+	logFile *os.File
+}
+
+func newServer(config *Config) (*server, error) {
+	s := &server{config: config, tls: config.TLS}
+	if err := s.applyLogging(config.Logging); err != nil {
+		return nil, err
+	}
+	option := peer.BrokerOption{ICE: config.iceOption()}
+	if config.DTLS.Cert != "" {
+		cert, err := dtls.LoadCertificate(config.DTLS.Cert, config.DTLS.Key)
+		if err != nil {
+			return nil, err
+		}
+		option.Certificate = cert
+	}
+	if config.Auth.Enabled() {
+		verifierOption, err := verifierOptionOf(config.Auth)
+		if err != nil {
+			return nil, err
+		}
+		if s.verifier, err = auth.NewVerifier(verifierOption); err != nil {
+			return nil, err
+		}
+	}
+	broker, err := peer.NewBroker(option)
+	if err != nil {
+		return nil, err
+	}
+	s.broker = broker
+
+	mux := http.NewServeMux()
+	if config.Signaling.Enabled {
+		s.rooms = room.NewManager(broker)
+		s.signaling = signaling.NewHandler(&signaling.HandlerOption{
+			Rooms:         s.rooms,
+			Codecs:        config.Codecs,
+			BweType:       config.BweType,
+			PingInterval:  config.Signaling.PingInterval,
+			PongTimeout:   config.Signaling.PongTimeout,
+			ResumeTimeout: config.Signaling.ResumeTimeout,
+			CheckOrigin:   checkOrigin(config.Signaling.AllowedOrigins),
+			Verifier:      s.verifier,
+		})
+		mux.Handle(config.Signaling.Path, s.signaling)
+	}
+	if config.WHIP.Enabled {
+		servers := make([]whip.ICEServer, 0, len(config.WHIP.ICEServers))
+		for _, v := range config.WHIP.ICEServers {
+			servers = append(servers, whip.ICEServer{URLs: v.URLs, Username: v.Username, Credential: v.Credential})
+		}
+		s.whip = whip.NewHandler(&whip.HandlerOption{
+			Broker:         broker,
+			ICEServers:     servers,
+			Verifier:       s.verifier,
+			Codecs:         config.Codecs,
+			BweType:        config.BweType,
+			ConnectTimeout: config.WHIP.ConnectTimeout,
+		})
+		mux.Handle("/whip/", s.whip)
+		mux.Handle("/whep/", s.whip)
+	}
+	s.servers = append(s.servers, &http.Server{Addr: config.Listen, Handler: mux})
+	if config.Admin.Listen != "" {
+		s.admin = admin.NewHandler(&admin.HandlerOption{Broker: broker, Token: config.Admin.Token})
+		s.servers = append(s.servers, &http.Server{Addr: config.Admin.Listen, Handler: s.admin})
+	}
+	return s, nil
+}
+
+// listen listens all addresses before serving, so an address in use fails the start.
+func (s *server) listen() error {
+	for _, srv := range s.servers {
+		l, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			for _, v := range s.listeners {
+				_ = v.Close()
+			}
+			s.listeners = nil
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+	}
+	return nil
+}
+
+// serve serves the listeners until shutdown, the first error is sent to errCh.
+// The tls is of the main listener only, the admin API is meant for the private network.
+func (s *server) serve(errCh chan<- error) {
+	for i, srv := range s.servers {
+		go func(i int, srv *http.Server) {
+			logger.Info("sfu listen on", s.listeners[i].Addr())
+			var err error
+			if i == 0 && s.tls.Cert != "" {
+				err = srv.ServeTLS(s.listeners[i], s.tls.Cert, s.tls.Key)
+			} else {
+				err = srv.Serve(s.listeners[i])
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errCh <- err
+			}
+		}(i, srv)
+	}
+}
+
+// reload applies the settings of the config could be changed on the fly, the others are logged
+// and ignored until restart. The config is unchanged if the new settings could not be applied.
+func (s *server) reload(config *Config) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, key := range restartRequired(s.config, config) {
+		logger.Warn("sfu reload ignored the change of", key, "it requires a restart")
+	}
+	var verifierOption *auth.VerifierOption
+	authConfig := config.Auth
+	if s.config.Auth.Enabled() != config.Auth.Enabled() {
+		logger.Warn("sfu reload ignored enabling or disabling auth, it requires a restart")
+		authConfig = s.config.Auth
+	} else if s.verifier != nil {
+		var err error
+		if verifierOption, err = verifierOptionOf(config.Auth); err != nil {
+			return err
+		}
+		// check the keys before applying anything.
+		if _, err = auth.NewVerifier(verifierOption); err != nil {
+			return err
+		}
+	}
+	if err := s.applyLogging(config.Logging); err != nil {
+		return err
+	}
+	if verifierOption != nil {
+		_ = s.verifier.Update(verifierOption)
+	}
+	if s.admin != nil {
+		s.admin.SetToken(config.Admin.Token)
+	}
+	next := *s.config
+	next.Auth = authConfig
+	next.Logging = config.Logging
+	next.Admin.Token = config.Admin.Token
+	s.config = &next
+	logger.Info("sfu config reloaded")
+	return nil
+}
+
+// applyLogging sets the level and reopens the file, the previous file is closed.
+func (s *server) applyLogging(config LoggingConfig) error {
+	if config.File == "" {
+		logger.SetOutput(os.Stderr)
+	} else {
+		f, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		logger.SetOutput(f)
+		if s.logFile != nil {
+			_ = s.logFile.Close()
+		}
+		s.logFile = f
+	}
+	logger.SetLevel(logLevels[strings.ToLower(config.Level)])
+	return nil
+}
+
+// shutdown stops accepting requests, closes the sessions and the broker.
+func (s *server) shutdown(ctx context.Context) {
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("sfu shutdown:", err)
+		}
+	}
+	if s.signaling != nil {
+		s.signaling.Close()
+		s.rooms.Close()
+	}
+	if s.whip != nil {
+		s.whip.Close()
+	}
+	s.broker.Close()
+	if s.logFile != nil {
+		logger.SetOutput(os.Stderr)
+		_ = s.logFile.Close()
+	}
+}
+
+func verifierOptionOf(config AuthConfig) (*auth.VerifierOption, error) {
+	option := &auth.VerifierOption{
+		Secret:        []byte(config.Secret),
+		Issuer:        config.Issuer,
+		Audience:      config.Audience,
+		Leeway:        config.Leeway,
+		AllowNoExpiry: config.AllowNoExpiry,
+	}
+	if config.PublicKey != "" {
+		data, err := os.ReadFile(config.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if option.PublicKey, err = auth.ParseECDSAPublicKey(data); err != nil {
+			return nil, err
+		}
+	}
+	return option, nil
+}
+
+// checkOrigin returns nil if all origins are allowed.
+func checkOrigin(origins []string) func(r *http.Request) bool {
+	if len(origins) == 0 {
+		return nil
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		for _, o := range origins {
+			if strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
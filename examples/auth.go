@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gotolive/sfu/rtc/auth"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+// authSecretEnv is the HS256 secret of the tokens, the handlers are open if it's empty.
+const authSecretEnv = "SFU_AUTH_SECRET"
+
+// whipRoom is the room of the tokens of /whip and /whep, the room of /pub, /sub and /change is the sessionId.
+const whipRoom = "whip"
+
+var verifier *auth.Verifier
+
+func newVerifier() error {
+	secret := os.Getenv(authSecretEnv)
+	if secret == "" {
+		logger.Warn("auth disabled, set", authSecretEnv, "to require the tokens")
+		return nil
+	}
+	var err error
+	verifier, err = auth.NewVerifier(&auth.VerifierOption{Secret: []byte(secret)})
+	return err
+}
+
+// requestToken returns the bearer token, or the token of the query for the pages could not set the header.
+func requestToken(r *http.Request) string {
+	value := r.Header.Get("Authorization")
+	if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+		return strings.TrimSpace(value[7:])
+	}
+	return r.FormValue("token")
+}
+
+// authorize writes the error response if the request is not granted, the authorizer is nil if auth is disabled.
+func authorize(w http.ResponseWriter, r *http.Request, room string, action auth.Action) (*auth.Authorizer, bool) {
+	if verifier == nil {
+		return nil, true
+	}
+	claims, err := verifier.Authorize(requestToken(r), room, action)
+	switch {
+	case err == nil:
+		return verifier.Authorizer(claims, room), true
+	case errors.Is(err, auth.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	}
+	return nil, false
+}
+
+// setAuthorizer enforces the grants on the receivers and senders of the connection.
+func setAuthorizer(connection *peer.Connection, authorizer *auth.Authorizer) {
+	if authorizer != nil {
+		connection.SetAuthorizer(authorizer)
+	}
+}
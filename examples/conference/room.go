@@ -1,7 +1,6 @@
 package conference
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -16,6 +15,7 @@ import (
 	"github.com/gotolive/sfu/rtc/dtls"
 	"github.com/gotolive/sfu/rtc/ice"
 	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/negotiation"
 	"github.com/gotolive/sfu/rtc/peer"
 	"github.com/gotolive/sfu/rtc/sdp"
 )
@@ -232,10 +232,15 @@ func (client *Client) handleMessage(room *Room, msg Message) {
 			return
 		}
 
+		role, err := dtls.NegotiateRole(jsdp.TransportInfo.ConnectionRole)
+		if err != nil {
+			logger.Error("err:", err)
+			return
+		}
 		t, err := broker.NewWebRTCConnection(&peer.WebRTCOption{
 			ID: room.id + "-" + client.id + "-pub",
 			DtlsOption: dtls.Option{
-				Role: dtls.Active,
+				Role: role,
 			},
 			BweType: bwe.Remb,
 		})
@@ -274,8 +279,9 @@ func (client *Client) handleMessage(room *Room, msg Message) {
 		if client.subscribeConnection == nil {
 			t, err := broker.NewWebRTCConnection(&peer.WebRTCOption{
 				ID: room.id + "-" + client.id + "-sub",
+				// we are the offerer, rfc8842 requires actpass.
 				DtlsOption: dtls.Option{
-					Role: dtls.Active,
+					Role: dtls.Actpass,
 				},
 				BweType: bwe.Remb,
 			})
@@ -399,125 +405,23 @@ func getOptionsFromSdp(v *sdp.MediaDescription) *peer.ReceiverOption {
 
 func generateSdp(kind string, t *peer.Connection) map[string]string {
 	i := t.Transport().Info()
-	bundleList := []string{}
-	buf := new(bytes.Buffer)
-	buf.WriteString("v=0\r\n" +
-		"o=" + "-" + " " + fmt.Sprint(time.Now().UnixNano()) + " 0" + " IN IP4 127.0.0.1\r\n" +
-		"s=-\r\n" +
-		"t=0 0\r\n")
-	buf.WriteString("a=extmap-allow-mixed\r\n" +
-		"a=msid-semantic:WMS *\r\n" +
-		"a=rtcp-mux\r\n" +
-		"a=ice-lite\r\n" +
-		"{{bundle-place-holder}}")
-
+	var mids []string
 	for _, r := range t.Receivers() {
-		bundleList = append(bundleList, r.MID())
-		cp := []string{}
-		cp = append(cp, fmt.Sprint(r.Codec().PayloadType))
-		if r.Codec().RTX != 0 {
-			cp = append(cp, fmt.Sprint(r.Codec().RTX))
-		}
-		buf.WriteString("m=" + r.MediaType() + " 9 UDP/TLS/RTP/SAVPF " + strings.Join(cp, " ") + "\r\n")
-		buf.WriteString("c=IN IP4 0.0.0.0\r\n")
-		buf.WriteString("a=rtcp:9 IN IP4 0.0.0.0\r\n")
-		c := r.Codec()
-		buf.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%d\r\n", c.PayloadType, c.EncoderName, c.ClockRate, c.Channels))
-
-		tmp := []string{}
-		for key, value := range c.Parameters {
-			tmp = append(tmp, fmt.Sprintf("%s=%v", key, value))
-		}
-		buf.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.PayloadType, strings.Join(tmp, ";")))
-		for _, fb := range c.FeedbackParams {
-			buf.WriteString(fmt.Sprintf("a=rtcp-fb:%d %s %v \r\n", c.PayloadType, fb.Type, fb.Parameter))
-		}
-		if c.RTX != 0 {
-			buf.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%d\r\n", c.RTX, "RTX", c.ClockRate, c.Channels))
-			buf.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.RTX, fmt.Sprintf("apt=%d", c.PayloadType)))
-		}
-
-		buf.WriteString("a=rtcp-mux\r\n")
-		for _, header := range r.HeaderExtensions() {
-			buf.WriteString(fmt.Sprintf("a=extmap:%d %s\r\n", header.ID, header.URI))
-		}
-		buf.WriteString(fmt.Sprintf("a=mid:%s\r\n", r.MID()))
-		buf.WriteString("a=recvonly\r\n")
-		buf.WriteString(fmt.Sprintf("a=setup:%s\r\n", "active"))
-		for _, f := range i.DtlsInfo.Fingerprints {
-			buf.WriteString(fmt.Sprintf("a=fingerprint:%s %s\r\n", f.Algorithm, f.Value))
-		}
-		buf.WriteString(fmt.Sprintf("a=ice-ufrag:%s\r\n", i.IceInfo.Ufrag))
-		buf.WriteString(fmt.Sprintf("a=ice-pwd:%s\r\n", i.IceInfo.Pwd))
-		buf.WriteString("a=ice-lite\r\n")
-		buf.WriteString("a=ice-options:trickle\r\n")
-		for _, c := range i.IceInfo.Candidates {
-			buf.WriteString(fmt.Sprintf("a=candidate:%s 1 %s %d %s %d typ %s \r\n", c.Foundation, c.Protocol, c.Priority, c.IP, c.Port, c.Type))
-		}
-		buf.WriteString("a=end-of-candidates\r\n")
-		if len(r.GetRTPStreams()) > 1 {
-			simulcast := []string{}
-			for _, stream := range r.GetRTPStreams() {
-				buf.WriteString(fmt.Sprintf("a=rid %s recv\r\n", stream.RID()))
-				simulcast = append(simulcast, stream.RID())
-			}
-			buf.WriteString(fmt.Sprintf("a=simulcast:recv %s\r\n", strings.Join(simulcast, ";")))
-		}
-
+		mids = append(mids, r.MID())
 	}
-	for _, r := range t.Senders() {
-
-		bundleList = append(bundleList, r.MID())
-		cp := []string{}
-
-		codecs := r.Codec()
-		cp = append(cp, fmt.Sprint(codecs.PayloadType))
-		if codecs.RTX != 0 {
-			cp = append(cp, fmt.Sprint(codecs.RTX))
-		}
-		buf.WriteString("m=" + r.MediaType() + " 9 UDP/TLS/RTP/SAVPF " + strings.Join(cp, " ") + "\r\n")
-		buf.WriteString("c=IN IP4 0.0.0.0\r\n")
-		buf.WriteString("a=rtcp:9 IN IP4 0.0.0.0\r\n")
-		c := r.Codec()
-		buf.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%d\r\n", c.PayloadType, c.EncoderName, c.ClockRate, c.Channels))
-
-		tmp := []string{}
-		for key, value := range c.Parameters {
-			tmp = append(tmp, fmt.Sprintf("%s=%v", key, value))
-		}
-		buf.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.PayloadType, strings.Join(tmp, ";")))
-		for _, fb := range c.FeedbackParams {
-			buf.WriteString(fmt.Sprintf("a=rtcp-fb:%d %s %v \r\n", c.PayloadType, fb.Type, fb.Parameter))
-		}
-		if c.RTX != 0 {
-			buf.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%d\r\n", c.RTX, "RTX", c.ClockRate, c.Channels))
-			buf.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.RTX, fmt.Sprintf("apt=%d", c.PayloadType)))
-		}
-		buf.WriteString("a=rtcp-mux\r\n")
-		for _, header := range r.HeaderExtensions() {
-			buf.WriteString(fmt.Sprintf("a=extmap:%d %s\r\n", header.ID, header.URI))
-		}
-		buf.WriteString(fmt.Sprintf("a=mid:%s\r\n", r.MID()))
-		buf.WriteString("a=sendonly\r\n")
-		buf.WriteString(fmt.Sprintf("a=setup:%s\r\n", "active"))
-		for _, f := range i.DtlsInfo.Fingerprints {
-			buf.WriteString(fmt.Sprintf("a=fingerprint:%s %s\r\n", f.Algorithm, f.Value))
-		}
-		buf.WriteString(fmt.Sprintf("a=ice-ufrag:%s\r\n", i.IceInfo.Ufrag))
-		buf.WriteString(fmt.Sprintf("a=ice-pwd:%s\r\n", i.IceInfo.Pwd))
-		buf.WriteString("a=ice-lite\r\n")
-		buf.WriteString("a=ice-options:trickle\r\n")
-		for _, c := range i.IceInfo.Candidates {
-			buf.WriteString(fmt.Sprintf("a=candidate:%s 1 %s %d %s %d typ %s \r\n", c.Foundation, c.Protocol, c.Priority, c.IP, c.Port, c.Type))
-		}
-		buf.WriteString("a=end-of-candidates\r\n")
-		buf.WriteString(fmt.Sprintf("a=ssrc:%d cname:%s\r\n", r.Stream().SSRC, peer.RandomString(12)))
-
+	for _, s := range t.Senders() {
+		mids = append(mids, s.MID())
+	}
+	b := new(strings.Builder)
+	negotiation.WriteHeader(b, &negotiation.Header{Mids: mids, Lite: true, ExtmapAllowMixed: true, Cryptex: false})
+	for _, r := range t.Receivers() {
+		negotiation.WriteReceiver(b, i, r)
+	}
+	for _, s := range t.Senders() {
+		negotiation.WriteSender(b, i, s)
 	}
-	sdp := buf.String()
-	sdp = strings.ReplaceAll(sdp, "{{bundle-place-holder}}", fmt.Sprintf("a=group:BUNDLE %s\r\n", strings.Join(bundleList, " ")))
 	return map[string]string{
 		"type": kind,
-		"sdp":  sdp,
+		"sdp":  b.String(),
 	}
 }
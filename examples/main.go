@@ -1,23 +1,22 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/gotolive/sfu/examples/conference"
 	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/auth"
 	"github.com/gotolive/sfu/rtc/bwe"
 	"github.com/gotolive/sfu/rtc/dtls"
 	"github.com/gotolive/sfu/rtc/ice"
 	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/negotiation"
 	"github.com/gotolive/sfu/rtc/peer"
 	"github.com/gotolive/sfu/rtc/sdp"
 )
@@ -26,129 +25,27 @@ var (
 	listenAddress = ":8990"
 )
 
-// The order of m-line must match offer.
-func generateSdp(kind string, t *peer.Connection) map[string]string {
+// The order of m-line must match offer. The cryptex is offered, or accepted if it's enabled on the connection.
+func generateSdp(kind string, t *peer.Connection, cryptex bool) map[string]string {
 	i := t.Transport().Info()
-	bundleList := []string{}
-	buf := new(bytes.Buffer)
-	buf.WriteString("v=0\r\n" +
-		"o=" + "-" + " " + fmt.Sprint(time.Now().UnixNano()) + " 0" + " IN IP4 127.0.0.1\r\n" +
-		"s=-\r\n" +
-		"t=0 0\r\n")
-	buf.WriteString("a=extmap-allow-mixed\r\n" +
-		"a=msid-semantic:WMS *\r\n" +
-		"a=rtcp-mux\r\n" +
-		"a=ice-lite\r\n" +
-		"{{bundle-place-holder}}")
-
+	var mids []string
 	for _, r := range t.Receivers() {
-		bundleList = append(bundleList, r.MID())
-		cp := []string{}
-		cp = append(cp, fmt.Sprint(r.Codec().PayloadType))
-		if r.Codec().RTX != 0 {
-			cp = append(cp, fmt.Sprint(r.Codec().RTX))
-		}
-		buf.WriteString("m=" + r.MediaType() + " 9 UDP/TLS/RTP/SAVPF " + strings.Join(cp, " ") + "\r\n")
-		buf.WriteString("c=IN IP4 0.0.0.0\r\n")
-		buf.WriteString("a=rtcp:9 IN IP4 0.0.0.0\r\n")
-		c := r.Codec()
-		buf.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%d\r\n", c.PayloadType, c.EncoderName, c.ClockRate, c.Channels))
-
-		tmp := []string{}
-		for key, value := range c.Parameters {
-			tmp = append(tmp, fmt.Sprintf("%s=%v", key, value))
-		}
-		buf.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.PayloadType, strings.Join(tmp, ";")))
-		for _, fb := range c.FeedbackParams {
-			buf.WriteString(fmt.Sprintf("a=rtcp-fb:%d %s %v \r\n", c.PayloadType, fb.Type, fb.Parameter))
-		}
-		if c.RTX != 0 {
-			buf.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%d\r\n", c.RTX, "RTX", c.ClockRate, c.Channels))
-			buf.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.RTX, fmt.Sprintf("apt=%d", c.PayloadType)))
-		}
-
-		buf.WriteString("a=rtcp-mux\r\n")
-		for _, header := range r.HeaderExtensions() {
-			buf.WriteString(fmt.Sprintf("a=extmap:%d %s\r\n", header.ID, header.URI))
-		}
-		buf.WriteString(fmt.Sprintf("a=mid:%s\r\n", r.MID()))
-		buf.WriteString("a=recvonly\r\n")
-		buf.WriteString(fmt.Sprintf("a=setup:%s\r\n", "active"))
-		for _, f := range i.DtlsInfo.Fingerprints {
-			buf.WriteString(fmt.Sprintf("a=fingerprint:%s %s\r\n", f.Algorithm, f.Value))
-		}
-		buf.WriteString(fmt.Sprintf("a=ice-ufrag:%s\r\n", i.IceInfo.Ufrag))
-		buf.WriteString(fmt.Sprintf("a=ice-pwd:%s\r\n", i.IceInfo.Pwd))
-		buf.WriteString("a=ice-lite\r\n")
-		buf.WriteString("a=ice-options:trickle\r\n")
-		for _, c := range i.IceInfo.Candidates {
-			buf.WriteString(fmt.Sprintf("a=candidate:%s 1 %s %d %s %d typ %s \r\n", c.Foundation, c.Protocol, c.Priority, c.IP, c.Port, c.Type))
-		}
-		buf.WriteString("a=end-of-candidates\r\n")
-		if len(r.GetRTPStreams()) > 1 {
-			simulcast := []string{}
-			for _, stream := range r.GetRTPStreams() {
-				buf.WriteString(fmt.Sprintf("a=rid %s recv\r\n", stream.RID()))
-				simulcast = append(simulcast, stream.RID())
-			}
-			buf.WriteString(fmt.Sprintf("a=simulcast:recv %s\r\n", strings.Join(simulcast, ";")))
-		}
-
+		mids = append(mids, r.MID())
 	}
-	for _, r := range t.Senders() {
-
-		bundleList = append(bundleList, r.MID())
-		cp := []string{}
-
-		codecs := r.Codec()
-		cp = append(cp, fmt.Sprint(codecs.PayloadType))
-		if codecs.RTX != 0 {
-			cp = append(cp, fmt.Sprint(codecs.RTX))
-		}
-		buf.WriteString("m=" + r.MediaType() + " 9 UDP/TLS/RTP/SAVPF " + strings.Join(cp, " ") + "\r\n")
-		buf.WriteString("c=IN IP4 0.0.0.0\r\n")
-		buf.WriteString("a=rtcp:9 IN IP4 0.0.0.0\r\n")
-		c := r.Codec()
-		buf.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%d\r\n", c.PayloadType, c.EncoderName, c.ClockRate, c.Channels))
-
-		tmp := []string{}
-		for key, value := range c.Parameters {
-			tmp = append(tmp, fmt.Sprintf("%s=%v", key, value))
-		}
-		buf.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.PayloadType, strings.Join(tmp, ";")))
-		for _, fb := range c.FeedbackParams {
-			buf.WriteString(fmt.Sprintf("a=rtcp-fb:%d %s %v \r\n", c.PayloadType, fb.Type, fb.Parameter))
-		}
-		if c.RTX != 0 {
-			buf.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%d\r\n", c.RTX, "RTX", c.ClockRate, c.Channels))
-			buf.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.RTX, fmt.Sprintf("apt=%d", c.PayloadType)))
-		}
-		buf.WriteString("a=rtcp-mux\r\n")
-		for _, header := range r.HeaderExtensions() {
-			buf.WriteString(fmt.Sprintf("a=extmap:%d %s\r\n", header.ID, header.URI))
-		}
-		buf.WriteString(fmt.Sprintf("a=mid:%s\r\n", r.MID()))
-		buf.WriteString("a=sendonly\r\n")
-		buf.WriteString(fmt.Sprintf("a=setup:%s\r\n", "active"))
-		for _, f := range i.DtlsInfo.Fingerprints {
-			buf.WriteString(fmt.Sprintf("a=fingerprint:%s %s\r\n", f.Algorithm, f.Value))
-		}
-		buf.WriteString(fmt.Sprintf("a=ice-ufrag:%s\r\n", i.IceInfo.Ufrag))
-		buf.WriteString(fmt.Sprintf("a=ice-pwd:%s\r\n", i.IceInfo.Pwd))
-		buf.WriteString("a=ice-lite\r\n")
-		buf.WriteString("a=ice-options:trickle\r\n")
-		for _, c := range i.IceInfo.Candidates {
-			buf.WriteString(fmt.Sprintf("a=candidate:%s 1 %s %d %s %d typ %s \r\n", c.Foundation, c.Protocol, c.Priority, c.IP, c.Port, c.Type))
-		}
-		buf.WriteString("a=end-of-candidates\r\n")
-		buf.WriteString(fmt.Sprintf("a=ssrc:%d cname:%s\r\n", r.Stream().SSRC, peer.RandomString(12)))
-
+	for _, s := range t.Senders() {
+		mids = append(mids, s.MID())
+	}
+	b := new(strings.Builder)
+	negotiation.WriteHeader(b, &negotiation.Header{Mids: mids, Lite: true, ExtmapAllowMixed: true, Cryptex: cryptex})
+	for _, r := range t.Receivers() {
+		negotiation.WriteReceiver(b, i, r)
+	}
+	for _, s := range t.Senders() {
+		negotiation.WriteSender(b, i, s)
 	}
-	sdp := buf.String()
-	sdp = strings.ReplaceAll(sdp, "{{bundle-place-holder}}", fmt.Sprintf("a=group:BUNDLE %s\r\n", strings.Join(bundleList, " ")))
 	return map[string]string{
 		"type": kind,
-		"sdp":  sdp,
+		"sdp":  b.String(),
 	}
 }
 
@@ -172,11 +69,18 @@ func main() {
 	if err != nil {
 		log.Fatal("broker start fail:", err)
 	}
+	if err = newVerifier(); err != nil {
+		log.Fatal("auth start fail:", err)
+	}
 
 	{
 		http.HandleFunc("/pub", func(writer http.ResponseWriter, request *http.Request) {
 			request.ParseForm()
 			sessionId := request.FormValue("sessionId")
+			authorizer, ok := authorize(writer, request, sessionId, auth.ActionPublish)
+			if !ok {
+				return
+			}
 			requestBody, _ := io.ReadAll(request.Body)
 			defer request.Body.Close()
 			offer := SDP{}
@@ -187,10 +91,15 @@ func main() {
 				return
 			}
 
+			role, err := dtls.NegotiateRole(jsdp.TransportInfo.ConnectionRole)
+			if err != nil {
+				logger.Error("err:", err)
+				return
+			}
 			t, err := broker.NewWebRTCConnection(&peer.WebRTCOption{
 				ID: sessionId,
 				DtlsOption: dtls.Option{
-					Role: dtls.Active,
+					Role: role,
 				},
 				BweType: bwe.Remb,
 			})
@@ -198,6 +107,7 @@ func main() {
 				logger.Error("create connection fail:", err, sessionId)
 				return
 			}
+			setAuthorizer(t, authorizer)
 			t.OnStateChange(func(state int) {
 				if state == 2 {
 					t.Close()
@@ -212,16 +122,24 @@ func main() {
 				}
 			}
 
-			answer := generateSdp("answer", t)
+			answer := generateSdp("answer", t, jsdp.CryptexEnabled() && t.SetCryptex(true) == nil)
 			r, _ := json.Marshal(answer)
 			sessionMap.Store(sessionId, t)
 			writer.Write(r)
 		})
 
 		http.HandleFunc("/sub", func(writer http.ResponseWriter, request *http.Request) {
+			request.ParseForm()
+			defer request.Body.Close()
+			sessionId := request.FormValue("sessionId")
+			authorizer, ok := authorize(writer, request, sessionId, auth.ActionSubscribe)
+			if !ok {
+				return
+			}
+			// we are the offerer, rfc8842 requires actpass.
 			t, err := broker.NewWebRTCConnection(&peer.WebRTCOption{
 				DtlsOption: dtls.Option{
-					Role: dtls.Active,
+					Role: dtls.Actpass,
 				},
 				BweType: bwe.Remb,
 			})
@@ -229,14 +147,12 @@ func main() {
 				logger.Error("create connection fail:", err)
 				return
 			}
+			setAuthorizer(t, authorizer)
 			t.OnStateChange(func(state int) {
 				if state == 2 {
 					t.Close()
 				}
 			})
-			request.ParseForm()
-			defer request.Body.Close()
-			sessionId := request.FormValue("sessionId")
 			v, ok := sessionMap.Load(sessionId)
 			if !ok {
 				return
@@ -253,14 +169,55 @@ func main() {
 				t.NewSender(op)
 			}
 			sessionMap.Store(sessionId+"-sub", t)
-			offer := generateSdp("offer", t)
+			offer := generateSdp("offer", t, true)
 			r, _ := json.Marshal(offer)
 			writer.Write(r)
 		})
 
+		// the answer of /sub, it resolves the dtls role of the actpass offered,
+		// the cryptex offered is enabled if it's accepted.
+		http.HandleFunc("/answer", func(writer http.ResponseWriter, request *http.Request) {
+			request.ParseForm()
+			sessionId := request.FormValue("sessionId")
+			if _, ok := authorize(writer, request, sessionId, auth.ActionSubscribe); !ok {
+				return
+			}
+			v, ok := sessionMap.Load(sessionId + "-sub")
+			if !ok {
+				return
+			}
+			subscriber := v.(*peer.Connection)
+			requestBody, _ := io.ReadAll(request.Body)
+			defer request.Body.Close()
+			answer := SDP{}
+			json.Unmarshal(requestBody, &answer)
+			jsdp, err := sdp.Unmarshal(answer.SDP)
+			if err != nil {
+				logger.Error("err:", err)
+				return
+			}
+			remote := peer.TransportInfo{}
+			remote.DtlsInfo.Role = jsdp.TransportInfo.ConnectionRole
+			if f := jsdp.TransportInfo.FingerPrint; f != nil {
+				remote.DtlsInfo.Fingerprints = []dtls.Fingerprint{{Algorithm: f.Algorithm, Value: f.Value}}
+			}
+			if err = subscriber.SetRemote(remote); err != nil {
+				logger.Error("err:", err)
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err = subscriber.SetCryptex(jsdp.CryptexEnabled()); err != nil {
+				logger.Error("err:", err)
+			}
+			writer.Write([]byte("OK"))
+		})
+
 		http.HandleFunc("/change", func(writer http.ResponseWriter, request *http.Request) {
 			request.ParseForm()
 			sessionId := request.FormValue("sessionId")
+			if _, ok := authorize(writer, request, sessionId, auth.ActionSubscribe); !ok {
+				return
+			}
 			v, ok := sessionMap.Load(sessionId + "-sub")
 			if !ok {
 				return
@@ -313,6 +270,10 @@ func main() {
 }
 
 func whip(writer http.ResponseWriter, request *http.Request) {
+	authorizer, ok := authorize(writer, request, whipRoom, auth.ActionPublish)
+	if !ok {
+		return
+	}
 	requestBody, _ := io.ReadAll(request.Body)
 	defer request.Body.Close()
 	jsdp, err := sdp.Unmarshal(string(requestBody))
@@ -321,10 +282,15 @@ func whip(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	role, err := dtls.NegotiateRole(jsdp.TransportInfo.ConnectionRole)
+	if err != nil {
+		logger.Error("err:", err)
+		return
+	}
 	t, err := broker.NewWebRTCConnection(&peer.WebRTCOption{
 		ID: "whip",
 		DtlsOption: dtls.Option{
-			Role: dtls.Active,
+			Role: role,
 		},
 		BweType: bwe.Remb,
 	})
@@ -332,6 +298,7 @@ func whip(writer http.ResponseWriter, request *http.Request) {
 		logger.Error("create connection fail:", err, "whip")
 		return
 	}
+	setAuthorizer(t, authorizer)
 	t.OnStateChange(func(state int) {
 		if state == 2 {
 			t.Close()
@@ -346,12 +313,16 @@ func whip(writer http.ResponseWriter, request *http.Request) {
 		}
 	}
 
-	answer := generateSdp("answer", t)
+	answer := generateSdp("answer", t, jsdp.CryptexEnabled() && t.SetCryptex(true) == nil)
 	sessionMap.Store("whip", t)
 	writer.Write([]byte(answer["sdp"]))
 }
 
 func whep(writer http.ResponseWriter, request *http.Request) {
+	authorizer, ok := authorize(writer, request, whipRoom, auth.ActionSubscribe)
+	if !ok {
+		return
+	}
 	requestBody, _ := io.ReadAll(request.Body)
 	defer request.Body.Close()
 	jsdp, err := sdp.Unmarshal(string(requestBody))
@@ -360,9 +331,14 @@ func whep(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	role, err := dtls.NegotiateRole(jsdp.TransportInfo.ConnectionRole)
+	if err != nil {
+		logger.Error("err:", err)
+		return
+	}
 	t, err := broker.NewWebRTCConnection(&peer.WebRTCOption{
 		DtlsOption: dtls.Option{
-			Role: dtls.Active,
+			Role: role,
 		},
 		BweType: bwe.Remb,
 	})
@@ -370,6 +346,7 @@ func whep(writer http.ResponseWriter, request *http.Request) {
 		logger.Error("create connection fail:", err)
 		return
 	}
+	setAuthorizer(t, authorizer)
 	t.OnStateChange(func(state int) {
 		if state == 2 {
 			t.Close()
@@ -399,7 +376,7 @@ func whep(writer http.ResponseWriter, request *http.Request) {
 		t.NewSender(op)
 	}
 	sessionMap.Store("whep", t)
-	offer := generateSdp("offer", t)
+	offer := generateSdp("offer", t, jsdp.CryptexEnabled() && t.SetCryptex(true) == nil)
 	writer.Write([]byte(offer["sdp"]))
 }
 
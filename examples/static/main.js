@@ -18,6 +18,8 @@ const subProfileSelect = document.querySelector('#subProfile');
 const subpcTracksButton = document.querySelector('#subpcTracks');
 const subChangeLayerButton = document.querySelector('#subChangeLayer');
 let sessionId = getRandomId(12);
+// the token of the page url is sent to the server if auth is enabled.
+const token = new URLSearchParams(window.location.search).get('token') || '';
 
 // Global Variable
 var pubStreamVisualizer = null;
@@ -115,7 +117,8 @@ async function publish() {
             method: 'post',
             headers: {
                 'Accept': 'application/json, text/plain, */*',
-                'Content-Type': 'application/json'
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`
             },
             body: JSON.stringify(offer)
         })
@@ -143,7 +146,8 @@ function changeProfile() {
         method: 'post',
         headers: {
             'Accept': 'application/json, text/plain, */*',
-            'Content-Type': 'application/json'
+            'Content-Type': 'application/json',
+            'Authorization': `Bearer ${token}`
         }
     }).then(res => {
         console.log("change result:", res);
@@ -168,12 +172,22 @@ async function subscribe() {
         method: 'post',
         headers: {
             'Accept': 'application/json, text/plain, */*',
-            'Content-Type': 'application/json'
+            'Content-Type': 'application/json',
+            'Authorization': `Bearer ${token}`
         }
     }).then(res => res.json()).then(res => {
         peerConnection.setRemoteDescription(res);
         peerConnection.createAnswer().then(answer => {
             peerConnection.setLocalDescription(answer);
+            fetch("/answer?sessionId=" + sessionId, {
+                method: 'post',
+                headers: {
+                    'Accept': 'application/json, text/plain, */*',
+                    'Content-Type': 'application/json',
+                    'Authorization': `Bearer ${token}`
+                },
+                body: JSON.stringify(answer)
+            })
         });
     })
 }
\ No newline at end of file
@@ -0,0 +1,144 @@
+// Package admin implements an HTTP/JSON API for operators to inspect and control the Broker.
+//
+// All responses are JSON, the errors are {"error": "..."} with the status code:
+//
+//	GET    /connections                                     -> [connection]
+//	GET    /connections/{id}                                -> connection
+//	DELETE /connections/{id}                                -> 204, closes the connection.
+//	GET    /connections/{id}/receivers                      -> [receiver]
+//	GET    /connections/{id}/senders                        -> [sender]
+//	POST   /connections/{id}/receivers/{receiver}/keyframe  -> 204, requests a key frame of every stream.
+//	POST   /connections/{id}/senders/{sender}/keyframe      -> 204, requests a key frame of the receiver.
+//	PUT    /connections/{id}/senders/{sender}/layer         -> 204, {"layer": 0} sets the preferred spatial layer.
+//	GET    /stats                                           -> the totals of the broker.
+//
+// The bitrates are in bps of the last second. The API exposes the ice ufrag, the candidates and the
+// dtls fingerprints, not the ice password, and it closes the connections, so it should listen on
+// a private address or be protected by the Token.
+package admin
+
+import (
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+// Connection is the state of a connection.
+type Connection struct {
+	ID        string    `json:"id"`
+	Connected bool      `json:"connected"`
+	Transport Transport `json:"transport"`
+	Receivers int       `json:"receivers"`
+	Senders   int       `json:"senders"`
+	Stats     Stats     `json:"stats"`
+}
+
+// Transport is the peer.TransportInfo, it's empty for the direct connections.
+type Transport struct {
+	ID           string        `json:"id,omitempty"`
+	IceRole      string        `json:"iceRole,omitempty"`
+	IceLite      bool          `json:"iceLite,omitempty"`
+	Ufrag        string        `json:"ufrag,omitempty"`
+	Candidates   []Candidate   `json:"candidates,omitempty"`
+	DtlsRole     string        `json:"dtlsRole,omitempty"`
+	Fingerprints []Fingerprint `json:"fingerprints,omitempty"`
+}
+
+type Candidate struct {
+	Type       string `json:"type"`
+	Protocol   string `json:"protocol"`
+	IP         string `json:"ip"`
+	Port       uint16 `json:"port"`
+	Priority   int    `json:"priority"`
+	Foundation string `json:"foundation"`
+}
+
+type Fingerprint struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// Stats is the traffic of a connection, or the sum of all connections.
+type Stats struct {
+	PacketsReceived    int64 `json:"packetsReceived"`
+	BytesReceived      int64 `json:"bytesReceived"`
+	BytesSent          int64 `json:"bytesSent"`
+	ReceiveBitrate     int64 `json:"receiveBitrate"`
+	SendBitrate        int64 `json:"sendBitrate"`
+	SrtpAuthFailures   int64 `json:"srtpAuthFailures"`
+	SrtpReplays        int64 `json:"srtpReplays"`
+	UnknownSsrcPackets int64 `json:"unknownSsrcPackets"`
+}
+
+type Codec struct {
+	Name        string            `json:"name"`
+	PayloadType uint8             `json:"payloadType"`
+	ClockRate   int               `json:"clockRate"`
+	Channels    int               `json:"channels,omitempty"`
+	RTX         uint8             `json:"rtx,omitempty"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+}
+
+// Receiver is the state of a receiver, the streams are ordered by the encodings.
+type Receiver struct {
+	ID        string   `json:"id"`
+	MID       string   `json:"mid"`
+	MediaType string   `json:"mediaType"`
+	Kind      string   `json:"kind"`
+	Codec     *Codec   `json:"codec,omitempty"`
+	Streams   []Stream `json:"streams"`
+}
+
+type Stream struct {
+	SSRC            uint32 `json:"ssrc"`
+	RtxSSRC         uint32 `json:"rtxSsrc,omitempty"`
+	RID             string `json:"rid,omitempty"`
+	PacketsReceived int64  `json:"packetsReceived"`
+	BytesReceived   int64  `json:"bytesReceived"`
+	Bitrate         int64  `json:"bitrate"`
+	FractionLost    uint8  `json:"fractionLost"`
+}
+
+// Sender is the state of a sender. The layers are of the simulcast senders only, the current layer
+// is -1 until the first key frame. The bitrates are of the forwarded layers of the receiver,
+// the layers above the preferred one are zero.
+type Sender struct {
+	ID           string  `json:"id"`
+	MID          string  `json:"mid"`
+	MediaType    string  `json:"mediaType"`
+	Kind         string  `json:"kind"`
+	ReceiverID   string  `json:"receiverId"`
+	SSRC         uint32  `json:"ssrc"`
+	Codec        *Codec  `json:"codec,omitempty"`
+	Layers       *Layers `json:"layers,omitempty"`
+	Bitrates     []int64 `json:"bitrates"`
+	FractionLost uint8   `json:"fractionLost"`
+}
+
+type Layers struct {
+	Count     int `json:"count"`
+	Current   int `json:"current"`
+	Target    int `json:"target"`
+	Preferred int `json:"preferred"`
+}
+
+// Totals are the server-wide stats of the broker.
+type Totals struct {
+	Connections int   `json:"connections"`
+	Connected   int   `json:"connected"`
+	Receivers   int   `json:"receivers"`
+	Senders     int   `json:"senders"`
+	Stats       Stats `json:"stats"`
+}
+
+func newCodec(c *peer.Codec) *Codec {
+	if c == nil {
+		return nil
+	}
+	return &Codec{
+		Name:        c.EncoderName,
+		PayloadType: uint8(c.PayloadType),
+		ClockRate:   c.ClockRate,
+		Channels:    c.Channels,
+		RTX:         uint8(c.RTX),
+		Parameters:  c.Parameters,
+	}
+}
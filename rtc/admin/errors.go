@@ -0,0 +1,13 @@
+package admin
+
+import "errors"
+
+var (
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrReceiverNotFound   = errors.New("receiver not found")
+	ErrSenderNotFound     = errors.New("sender not found")
+	ErrNotVideo           = errors.New("not a video track")
+	ErrInvalidLayer       = errors.New("invalid layer")
+	ErrInvalidRequest     = errors.New("invalid request")
+)
@@ -0,0 +1,357 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+const maxBodySize = 4 * 1024
+
+type HandlerOption struct {
+	Broker *peer.Broker
+	// Token is the bearer token required by all requests if not empty.
+	Token string
+}
+
+// Handler serves the admin API of the broker, see the package doc for the endpoints.
+type Handler struct {
+	option HandlerOption
+	mux    *http.ServeMux
+	token  atomic.Value // string
+}
+
+func NewHandler(option *HandlerOption) *Handler {
+	h := &Handler{
+		option: *option,
+		mux:    http.NewServeMux(),
+	}
+	h.token.Store(option.Token)
+	h.mux.HandleFunc("GET /connections", h.connections)
+	h.mux.HandleFunc("GET /connections/{id}", h.connection)
+	h.mux.HandleFunc("DELETE /connections/{id}", h.closeConnection)
+	h.mux.HandleFunc("GET /connections/{id}/receivers", h.receivers)
+	h.mux.HandleFunc("GET /connections/{id}/senders", h.senders)
+	h.mux.HandleFunc("POST /connections/{id}/receivers/{receiver}/keyframe", h.receiverKeyframe)
+	h.mux.HandleFunc("POST /connections/{id}/senders/{sender}/keyframe", h.senderKeyframe)
+	h.mux.HandleFunc("PUT /connections/{id}/senders/{sender}/layer", h.layer)
+	h.mux.HandleFunc("GET /stats", h.stats)
+	return h
+}
+
+// SetToken replaces the token of HandlerOption, the requests are not checked if empty.
+func (h *Handler) SetToken(token string) {
+	h.token.Store(token)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if token := h.token.Load().(string); token != "" && !authorized(r, token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, ErrUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func authorized(r *http.Request, token string) bool {
+	value := r.Header.Get("Authorization")
+	if len(value) <= 7 || !strings.EqualFold(value[:7], "bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(value[7:])), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("admin write response failed:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, ErrConnectionNotFound), errors.Is(err, ErrReceiverNotFound), errors.Is(err, ErrSenderNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrNotVideo), errors.Is(err, ErrInvalidLayer), errors.Is(err, ErrInvalidRequest):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// lookup writes the error response if the connection of the request not exist.
+func (h *Handler) lookup(w http.ResponseWriter, r *http.Request) *peer.Connection {
+	conn := h.option.Broker.Connection(r.PathValue("id"))
+	if conn == nil {
+		writeError(w, ErrConnectionNotFound)
+	}
+	return conn
+}
+
+func (h *Handler) connections(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now().UnixMilli()
+	result := make([]Connection, 0)
+	for _, conn := range h.option.Broker.Connections() {
+		result = append(result, connectionOf(conn, now))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) connection(w http.ResponseWriter, r *http.Request) {
+	conn := h.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, connectionOf(conn, time.Now().UnixMilli()))
+}
+
+func (h *Handler) closeConnection(w http.ResponseWriter, r *http.Request) {
+	conn := h.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	logger.Info("admin close connection:", conn.ID())
+	conn.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) receivers(w http.ResponseWriter, r *http.Request) {
+	conn := h.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	now := time.Now().UnixMilli()
+	result := make([]Receiver, 0)
+	for _, receiver := range conn.Receivers() {
+		result = append(result, receiverOf(receiver, now))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) senders(w http.ResponseWriter, r *http.Request) {
+	conn := h.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	result := make([]Sender, 0)
+	for _, sender := range conn.Senders() {
+		result = append(result, senderOf(sender))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) receiverKeyframe(w http.ResponseWriter, r *http.Request) {
+	conn := h.lookup(w, r)
+	if conn == nil {
+		return
+	}
+	var receiver *peer.Receiver
+	for _, v := range conn.Receivers() {
+		if v.ID() == r.PathValue("receiver") {
+			receiver = v
+			break
+		}
+	}
+	if receiver == nil {
+		writeError(w, ErrReceiverNotFound)
+		return
+	}
+	if receiver.MediaType() != rtc.MediaTypeVideo {
+		writeError(w, ErrNotVideo)
+		return
+	}
+	for _, s := range receiver.GetRTPStreams() {
+		// the ssrc is unknown until the first packet of the rid streams.
+		if s.SSRC() != 0 {
+			receiver.RequestKeyFrame(s.SSRC())
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sender writes the error response if the sender of the request not exist.
+func (h *Handler) sender(w http.ResponseWriter, r *http.Request) peer.Sender {
+	conn := h.lookup(w, r)
+	if conn == nil {
+		return nil
+	}
+	for _, s := range conn.Senders() {
+		if s.ID() == r.PathValue("sender") {
+			return s
+		}
+	}
+	writeError(w, ErrSenderNotFound)
+	return nil
+}
+
+func (h *Handler) senderKeyframe(w http.ResponseWriter, r *http.Request) {
+	sender := h.sender(w, r)
+	if sender == nil {
+		return
+	}
+	if sender.MediaType() != rtc.MediaTypeVideo {
+		writeError(w, ErrNotVideo)
+		return
+	}
+	sender.RequestKeyframe()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type layerRequest struct {
+	Layer *int `json:"layer"`
+}
+
+func (h *Handler) layer(w http.ResponseWriter, r *http.Request) {
+	sender := h.sender(w, r)
+	if sender == nil {
+		return
+	}
+	req := &layerRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(req); err != nil || req.Layer == nil {
+		writeError(w, ErrInvalidRequest)
+		return
+	}
+	simulcast, ok := sender.(*peer.SimulcastConsumer)
+	if !ok {
+		writeError(w, ErrInvalidLayer)
+		return
+	}
+	if *req.Layer < 0 || *req.Layer >= simulcast.SpatialLayers() {
+		writeError(w, ErrInvalidLayer)
+		return
+	}
+	simulcast.UpdateLayer(*req.Layer)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now().UnixMilli()
+	totals := Totals{}
+	for _, conn := range h.option.Broker.Connections() {
+		c := connectionOf(conn, now)
+		totals.Connections++
+		if c.Connected {
+			totals.Connected++
+		}
+		totals.Receivers += c.Receivers
+		totals.Senders += c.Senders
+		totals.Stats.PacketsReceived += c.Stats.PacketsReceived
+		totals.Stats.BytesReceived += c.Stats.BytesReceived
+		totals.Stats.BytesSent += c.Stats.BytesSent
+		totals.Stats.ReceiveBitrate += c.Stats.ReceiveBitrate
+		totals.Stats.SendBitrate += c.Stats.SendBitrate
+		totals.Stats.SrtpAuthFailures += c.Stats.SrtpAuthFailures
+		totals.Stats.SrtpReplays += c.Stats.SrtpReplays
+		totals.Stats.UnknownSsrcPackets += c.Stats.UnknownSsrcPackets
+	}
+	writeJSON(w, http.StatusOK, totals)
+}
+
+func connectionOf(conn *peer.Connection, now int64) Connection {
+	stats := conn.Stats()
+	c := Connection{
+		ID:        conn.ID(),
+		Receivers: len(conn.Receivers()),
+		Senders:   len(conn.Senders()),
+		Stats: Stats{
+			PacketsReceived:    stats.PacketsReceived(),
+			BytesReceived:      stats.BytesReceived(),
+			BytesSent:          stats.BytesSend(),
+			ReceiveBitrate:     stats.ReceiveBPS(now),
+			SendBitrate:        stats.SentBPS(now),
+			SrtpAuthFailures:   stats.SrtpAuthFailures(),
+			SrtpReplays:        stats.SrtpReplays(),
+			UnknownSsrcPackets: stats.UnknownSsrcPackets(),
+		},
+	}
+	if transport := conn.Transport(); transport != nil {
+		c.Connected = transport.IsConnected()
+		c.Transport = transportOf(transport.Info())
+	}
+	return c
+}
+
+// transportOf omits the ice password, the api is not meant to hand out the credentials.
+func transportOf(info peer.TransportInfo) Transport {
+	t := Transport{
+		ID:       info.ID,
+		IceRole:  info.IceInfo.Role,
+		IceLite:  info.IceInfo.Lite,
+		Ufrag:    info.IceInfo.Ufrag,
+		DtlsRole: info.DtlsInfo.Role,
+	}
+	for _, c := range info.IceInfo.Candidates {
+		t.Candidates = append(t.Candidates, Candidate{
+			Type:       c.Type,
+			Protocol:   c.Protocol,
+			IP:         c.IP,
+			Port:       c.Port,
+			Priority:   c.Priority,
+			Foundation: c.Foundation,
+		})
+	}
+	for _, f := range info.DtlsInfo.Fingerprints {
+		t.Fingerprints = append(t.Fingerprints, Fingerprint{Algorithm: f.Algorithm, Value: f.Value})
+	}
+	return t
+}
+
+func receiverOf(receiver *peer.Receiver, now int64) Receiver {
+	result := Receiver{
+		ID:        receiver.ID(),
+		MID:       receiver.MID(),
+		MediaType: receiver.MediaType(),
+		Kind:      receiver.Kind(),
+		Codec:     newCodec(receiver.Codec()),
+		Streams:   make([]Stream, 0),
+	}
+	for _, s := range receiver.GetRTPStreams() {
+		stats := s.Stats()
+		result.Streams = append(result.Streams, Stream{
+			SSRC:            s.SSRC(),
+			RtxSSRC:         s.RtxSSRC(),
+			RID:             s.RID(),
+			PacketsReceived: stats.PacketsReceived(),
+			BytesReceived:   stats.BytesReceived(),
+			Bitrate:         stats.ReceiveBPS(now),
+			FractionLost:    s.FractionLost(),
+		})
+	}
+	return result
+}
+
+func senderOf(sender peer.Sender) Sender {
+	result := Sender{
+		ID:           sender.ID(),
+		MID:          sender.MID(),
+		MediaType:    sender.MediaType(),
+		Kind:         sender.Kind(),
+		ReceiverID:   sender.ReceiverID(),
+		Codec:        newCodec(sender.Codec()),
+		Bitrates:     []int64{sender.GetBitrate(0)},
+		FractionLost: sender.FractionLost(),
+	}
+	if stream := sender.Stream(); stream != nil {
+		result.SSRC = stream.SSRC
+	}
+	if simulcast, ok := sender.(*peer.SimulcastConsumer); ok {
+		current, target, preferred := simulcast.Layers()
+		result.Layers = &Layers{Count: simulcast.SpatialLayers(), Current: current, Target: target, Preferred: preferred}
+		result.Bitrates = make([]int64, 0, result.Layers.Count)
+		for i := 0; i < result.Layers.Count; i++ {
+			result.Bitrates = append(result.Bitrates, simulcast.GetBitrate(i))
+		}
+	}
+	return result
+}
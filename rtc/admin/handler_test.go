@@ -0,0 +1,204 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+// request sends the request to the server and decodes the json response into v if not nil, it returns the status.
+func request(t *testing.T, method, url, token, body string, v any) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert(t, err, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert(t, err, nil)
+	defer resp.Body.Close()
+	if v != nil {
+		assert(t, json.NewDecoder(resp.Body).Decode(v), nil)
+	}
+	return resp.StatusCode
+}
+
+func TestHandler(t *testing.T) {
+	broker, err := peer.NewBroker(peer.BrokerOption{})
+	assert(t, err, nil)
+	defer broker.Close()
+	handler := NewHandler(&HandlerOption{Broker: broker, Token: "secret"})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	publisher, err := broker.NewDirectConnection(&peer.DirectOption{ID: "publisher"})
+	assert(t, err, nil)
+	_, err = publisher.NewReceiver(&peer.ReceiverOption{
+		ID:        "audio",
+		MID:       "0",
+		MediaType: rtc.MediaTypeAudio,
+		Codec:     &peer.Codec{PayloadType: 111, EncoderName: "opus", ClockRate: 48000, Channels: 2},
+		Streams:   []peer.StreamOption{{SSRC: 1111, PayloadType: 111}},
+	})
+	assert(t, err, nil)
+	_, err = publisher.NewReceiver(&peer.ReceiverOption{
+		ID:        "video",
+		MID:       "1",
+		MediaType: rtc.MediaTypeVideo,
+		Codec:     &peer.Codec{PayloadType: 96, EncoderName: "VP8", ClockRate: 90000},
+		Streams: []peer.StreamOption{
+			{SSRC: 2221, PayloadType: 96, RID: "l"},
+			{SSRC: 2222, PayloadType: 96, RID: "m"},
+			{SSRC: 2223, PayloadType: 96, RID: "h"},
+		},
+	})
+	assert(t, err, nil)
+	player, err := broker.NewDirectConnection(&peer.DirectOption{ID: "player"})
+	assert(t, err, nil)
+	_, err = player.NewSender(&peer.SenderOption{ID: "audio", ConnectionID: "publisher", ReceiverID: "audio"})
+	assert(t, err, nil)
+	_, err = player.NewSender(&peer.SenderOption{ID: "video", ConnectionID: "publisher", ReceiverID: "video"})
+	assert(t, err, nil)
+
+	tests := []testHelper{
+		{
+			name:        "unauthorized",
+			description: "the requests without the token are rejected",
+			method: func(t *testing.T) {
+				result := map[string]string{}
+				assert(t, request(t, http.MethodGet, server.URL+"/stats", "", "", &result), http.StatusUnauthorized)
+				assert(t, result["error"], ErrUnauthorized.Error())
+				assert(t, request(t, http.MethodGet, server.URL+"/stats", "other", "", nil), http.StatusUnauthorized)
+				handler.SetToken("other")
+				assert(t, request(t, http.MethodGet, server.URL+"/stats", "other", "", nil), http.StatusOK)
+				handler.SetToken("secret")
+			},
+		},
+		{
+			name:        "connections",
+			description: "the connections are listed with the counts of receivers and senders",
+			method: func(t *testing.T) {
+				var result []Connection
+				assert(t, request(t, http.MethodGet, server.URL+"/connections", "secret", "", &result), http.StatusOK)
+				assert(t, len(result), 2)
+				connection := Connection{}
+				assert(t, request(t, http.MethodGet, server.URL+"/connections/publisher", "secret", "", &connection), http.StatusOK)
+				assert(t, connection.ID, "publisher")
+				assert(t, connection.Receivers, 2)
+				assert(t, connection.Senders, 0)
+				assert(t, request(t, http.MethodGet, server.URL+"/connections/unknown", "secret", "", nil), http.StatusNotFound)
+			},
+		},
+		{
+			name:        "receivers",
+			description: "the receivers are listed with the codecs and streams",
+			method: func(t *testing.T) {
+				var result []Receiver
+				assert(t, request(t, http.MethodGet, server.URL+"/connections/publisher/receivers", "secret", "", &result), http.StatusOK)
+				assert(t, len(result), 2)
+				// the payload type is of the connection, it could be different from the option.
+				assert(t, result[0].Codec.Name, "opus")
+				assert(t, result[0].Codec.ClockRate, 48000)
+				assert(t, result[0].Codec.Channels, 2)
+				assert(t, result[1].Kind, peer.RTPTypeSimulcast)
+				assert(t, len(result[1].Streams), 3)
+				assert(t, result[1].Streams[2].SSRC, uint32(2223))
+				assert(t, result[1].Streams[2].RID, "h")
+			},
+		},
+		{
+			name:        "senders",
+			description: "the simulcast senders are listed with the layers",
+			method: func(t *testing.T) {
+				var result []Sender
+				assert(t, request(t, http.MethodGet, server.URL+"/connections/player/senders", "secret", "", &result), http.StatusOK)
+				assert(t, len(result), 2)
+				senders := map[string]Sender{}
+				for _, s := range result {
+					senders[s.ID] = s
+				}
+				assert(t, senders["audio"].Layers == nil, true)
+				assert(t, senders["audio"].Bitrates, []int64{0})
+				assert(t, senders["video"].ReceiverID, "video")
+				// no key frame received yet.
+				assert(t, senders["video"].Layers.Count, 3)
+				assert(t, senders["video"].Layers.Current, -1)
+				assert(t, senders["video"].Layers.Preferred, 2)
+				assert(t, len(senders["video"].Bitrates), 3)
+			},
+		},
+		{
+			name:        "layer",
+			description: "the preferred layer of the simulcast sender is updated",
+			method: func(t *testing.T) {
+				url := server.URL + "/connections/player/senders/video/layer"
+				assert(t, request(t, http.MethodPut, url, "secret", `{"layer":0}`, nil), http.StatusNoContent)
+				var result []Sender
+				assert(t, request(t, http.MethodGet, server.URL+"/connections/player/senders", "secret", "", &result), http.StatusOK)
+				for _, s := range result {
+					if s.ID == "video" {
+						assert(t, s.Layers.Preferred, 0)
+					}
+				}
+				assert(t, request(t, http.MethodPut, url, "secret", `{"layer":3}`, nil), http.StatusBadRequest)
+				assert(t, request(t, http.MethodPut, url, "secret", `{}`, nil), http.StatusBadRequest)
+				assert(t, request(t, http.MethodPut, server.URL+"/connections/player/senders/audio/layer", "secret", `{"layer":0}`, nil), http.StatusBadRequest)
+				assert(t, request(t, http.MethodPut, server.URL+"/connections/player/senders/unknown/layer", "secret", `{"layer":0}`, nil), http.StatusNotFound)
+			},
+		},
+		{
+			name:        "keyframe",
+			description: "the key frames are requested of the video only",
+			method: func(t *testing.T) {
+				assert(t, request(t, http.MethodPost, server.URL+"/connections/publisher/receivers/video/keyframe", "secret", "", nil), http.StatusNoContent)
+				assert(t, request(t, http.MethodPost, server.URL+"/connections/publisher/receivers/audio/keyframe", "secret", "", nil), http.StatusBadRequest)
+				assert(t, request(t, http.MethodPost, server.URL+"/connections/publisher/receivers/unknown/keyframe", "secret", "", nil), http.StatusNotFound)
+				assert(t, request(t, http.MethodPost, server.URL+"/connections/player/senders/video/keyframe", "secret", "", nil), http.StatusNoContent)
+				assert(t, request(t, http.MethodPost, server.URL+"/connections/player/senders/audio/keyframe", "secret", "", nil), http.StatusBadRequest)
+			},
+		},
+		{
+			name:        "stats",
+			description: "the totals are summed of all connections",
+			method: func(t *testing.T) {
+				totals := Totals{}
+				assert(t, request(t, http.MethodGet, server.URL+"/stats", "secret", "", &totals), http.StatusOK)
+				assert(t, totals.Connections, 2)
+				assert(t, totals.Receivers, 2)
+				assert(t, totals.Senders, 2)
+			},
+		},
+		{
+			name:        "close",
+			description: "the connection is closed and removed from the broker",
+			method: func(t *testing.T) {
+				assert(t, request(t, http.MethodDelete, server.URL+"/connections/player", "secret", "", nil), http.StatusNoContent)
+				assert(t, broker.Connection("player") == nil, true)
+				assert(t, request(t, http.MethodDelete, server.URL+"/connections/player", "secret", "", nil), http.StatusNotFound)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
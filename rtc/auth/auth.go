@@ -0,0 +1,140 @@
+// Package auth verifies the JWT of clients and enforces their grants on the connections.
+//
+// The tokens are signed by HS256 or ES256, the claims are the registered ones and the grants:
+//
+//	{
+//	  "sub": "alice",          // the participant id
+//	  "room": "demo",          // the room or stream key, * for all
+//	  "publish": true,
+//	  "subscribe": true,
+//	  "kinds": ["audio"],      // the media types allowed, all if empty
+//	  "maxBitrate": 1000000,   // the max incoming bitrate of publishing in bps, unlimited if 0
+//	  "exp": 1700000000
+//	}
+//
+// Verifier.Authorize checks the token of a request, and the Authorizer returned by Verifier.Authorizer
+// is set to the connections by peer.Connection.SetAuthorizer, so the grants are checked again when
+// the receivers and senders are created. Every decision is sent to the audit callback.
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/logger"
+)
+
+// Action is what the token is used for.
+type Action string
+
+const (
+	ActionJoin      Action = "join"
+	ActionPublish   Action = "publish"
+	ActionSubscribe Action = "subscribe"
+)
+
+// AnyRoom is the room of claims which is allowed in all rooms.
+const AnyRoom = "*"
+
+// Claims are the registered claims of rfc7519 and the grants of the sfu.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+
+	Room      string `json:"room,omitempty"`
+	Publish   bool   `json:"publish,omitempty"`
+	Subscribe bool   `json:"subscribe,omitempty"`
+	// Kinds are the media types could be published and subscribed, all if empty.
+	Kinds []string `json:"kinds,omitempty"`
+	// MaxBitrate limits the incoming bitrate of publishing in bps, unlimited if 0.
+	MaxBitrate uint64 `json:"maxBitrate,omitempty"`
+}
+
+// AllowRoom returns true if the claims grant the room.
+func (c *Claims) AllowRoom(room string) bool {
+	return c.Room == AnyRoom || (c.Room != "" && c.Room == room)
+}
+
+// AllowKind returns true if the media type is granted.
+func (c *Claims) AllowKind(kind string) bool {
+	if len(c.Kinds) == 0 {
+		return true
+	}
+	for _, k := range c.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow returns true if the action is granted, the join needs the room only.
+func (c *Claims) Allow(action Action) bool {
+	switch action {
+	case ActionPublish:
+		return c.Publish
+	case ActionSubscribe:
+		return c.Subscribe
+	default:
+		return true
+	}
+}
+
+// Audience is the aud claim, which is a string or an array of strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// AuditEvent is a decision of authorization, the resource is the receiver or sender if any.
+type AuditEvent struct {
+	Time     time.Time
+	Subject  string
+	Room     string
+	Action   Action
+	Resource string
+	Allowed  bool
+	// Reason is the error of denied.
+	Reason string
+}
+
+func (e AuditEvent) String() string {
+	result := "allow"
+	if !e.Allowed {
+		result = "deny"
+	}
+	s := fmt.Sprintf("auth %s %s subject=%q room=%q", result, e.Action, e.Subject, e.Room)
+	if e.Resource != "" {
+		s += fmt.Sprintf(" resource=%q", e.Resource)
+	}
+	if e.Reason != "" {
+		s += fmt.Sprintf(" reason=%q", e.Reason)
+	}
+	return s
+}
+
+// logAudit is the default audit, the denied are warnings.
+func logAudit(event AuditEvent) {
+	if event.Allowed {
+		logger.Info(event)
+		return
+	}
+	logger.Warn(event)
+}
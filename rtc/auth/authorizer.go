@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+var _ peer.Authorizer = new(Authorizer)
+
+// Authorizer enforces the grants of claims on the connections, see peer.Connection.SetAuthorizer.
+// The publishing connection is limited by MaxBitrate when its first receiver is authorized.
+type Authorizer struct {
+	claims *Claims
+	room   string
+	audit  func(event AuditEvent)
+}
+
+func (a *Authorizer) AuthorizeReceiver(connection *peer.Connection, option *peer.ReceiverOption) error {
+	err := a.check(ActionPublish, option.MediaType)
+	if err == nil && a.claims.MaxBitrate != 0 {
+		for _, s := range option.Streams {
+			if s.MaxBitrate > 0 && uint64(s.MaxBitrate) > a.claims.MaxBitrate {
+				err = fmt.Errorf("%w: max bitrate %d exceeds %d", ErrForbidden, s.MaxBitrate, a.claims.MaxBitrate)
+				break
+			}
+		}
+		if err == nil {
+			connection.SetMaxIncomingBitrate(a.claims.MaxBitrate)
+		}
+	}
+	a.emit(ActionPublish, connection.ID()+"/"+option.ID, err)
+	return err
+}
+
+// AuthorizeSender checks the subscribe grant, and the receiver is published in the same room,
+// so a token could not consume the receivers of other rooms by their connection id.
+func (a *Authorizer) AuthorizeSender(connection *peer.Connection, option *peer.SenderOption, producer *peer.Connection, receiver *peer.Receiver) error {
+	err := a.check(ActionSubscribe, receiver.MediaType())
+	if err == nil && !a.sameRoom(producer) {
+		err = fmt.Errorf("%w: receiver is not in room %s", ErrForbidden, a.room)
+	}
+	a.emit(ActionSubscribe, option.ConnectionID+"/"+option.ReceiverID, err)
+	return err
+}
+
+// sameRoom returns true if the producer is authorized in the room of a, the connections
+// without the Authorizer of this package are not in any room.
+func (a *Authorizer) sameRoom(producer *peer.Connection) bool {
+	owner, ok := producer.Authorizer().(*Authorizer)
+	return ok && owner.room == a.room
+}
+
+func (a *Authorizer) check(action Action, kind string) error {
+	if !a.claims.AllowRoom(a.room) || !a.claims.Allow(action) {
+		return ErrForbidden
+	}
+	if !a.claims.AllowKind(kind) {
+		return fmt.Errorf("%w: kind %s", ErrForbidden, kind)
+	}
+	return nil
+}
+
+func (a *Authorizer) emit(action Action, resource string, err error) {
+	event := AuditEvent{
+		Time:     time.Now(),
+		Subject:  a.claims.Subject,
+		Room:     a.room,
+		Action:   action,
+		Resource: resource,
+		Allowed:  err == nil,
+	}
+	if err != nil {
+		event.Reason = err.Error()
+	}
+	a.audit(event)
+}
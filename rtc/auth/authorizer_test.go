@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+func audioOption() *peer.ReceiverOption {
+	return &peer.ReceiverOption{
+		ID:        "audio",
+		MediaType: rtc.MediaTypeAudio,
+		Codec:     &peer.Codec{PayloadType: 111, EncoderName: "opus", ClockRate: 48000, Channels: 2},
+		Streams:   []peer.StreamOption{{SSRC: 1111, PayloadType: 111}},
+	}
+}
+
+func videoOption() *peer.ReceiverOption {
+	return &peer.ReceiverOption{
+		ID:        "video",
+		MediaType: rtc.MediaTypeVideo,
+		Codec:     &peer.Codec{PayloadType: 96, EncoderName: "VP8", ClockRate: 90000},
+		Streams:   []peer.StreamOption{{SSRC: 2222, PayloadType: 96, MaxBitrate: 2000000}},
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	var events []AuditEvent
+	v := newTestVerifier(t, []byte("secret"), nil, &events)
+	broker, err := peer.NewBroker(peer.BrokerOption{})
+	assert(t, err, nil)
+	defer broker.Close()
+	tests := []testHelper{
+		{
+			name:        "publish",
+			description: "the receivers of kinds not granted or over the max bitrate are rejected",
+			method: func(t *testing.T) {
+				events = nil
+				conn, err := broker.NewDirectConnection(&peer.DirectOption{ID: "publish"})
+				assert(t, err, nil)
+				conn.SetAuthorizer(v.Authorizer(&Claims{Subject: "alice", Room: "demo", Publish: true, Kinds: []string{rtc.MediaTypeAudio}}, "demo"))
+				_, err = conn.NewReceiver(audioOption())
+				assert(t, err, nil)
+				_, err = conn.NewReceiver(videoOption())
+				isError(t, err, ErrForbidden)
+				assert(t, len(conn.Receivers()), 1)
+
+				conn.SetAuthorizer(v.Authorizer(&Claims{Subject: "alice", Room: "demo", Publish: true, MaxBitrate: 1000000}, "demo"))
+				_, err = conn.NewReceiver(videoOption())
+				isError(t, err, ErrForbidden)
+				option := videoOption()
+				option.Streams[0].MaxBitrate = 0
+				_, err = conn.NewReceiver(option)
+				assert(t, err, nil)
+
+				conn.SetAuthorizer(v.Authorizer(&Claims{Subject: "alice", Room: "other", Publish: true}, "demo"))
+				option = audioOption()
+				option.ID = "denied"
+				_, err = conn.NewReceiver(option)
+				isError(t, err, ErrForbidden)
+
+				assert(t, len(events), 5)
+				assert(t, events[0].Resource, "publish/audio")
+				assert(t, events[0].Allowed, true)
+				assert(t, events[1].Allowed, false)
+			},
+		},
+		{
+			name:        "subscribe",
+			description: "the senders are rejected without the subscribe grant or out of the room",
+			method: func(t *testing.T) {
+				events = nil
+				source, err := broker.NewDirectConnection(&peer.DirectOption{ID: "source"})
+				assert(t, err, nil)
+				source.SetAuthorizer(v.Authorizer(&Claims{Subject: "alice", Room: "demo", Publish: true}, "demo"))
+				_, err = source.NewReceiver(audioOption())
+				assert(t, err, nil)
+				other, err := broker.NewDirectConnection(&peer.DirectOption{ID: "other"})
+				assert(t, err, nil)
+				other.SetAuthorizer(v.Authorizer(&Claims{Subject: "carol", Room: "other", Publish: true}, "other"))
+				_, err = other.NewReceiver(audioOption())
+				assert(t, err, nil)
+				events = nil
+				conn, err := broker.NewDirectConnection(&peer.DirectOption{ID: "subscribe"})
+				assert(t, err, nil)
+
+				conn.SetAuthorizer(v.Authorizer(&Claims{Subject: "bob", Room: "demo", Publish: true}, "demo"))
+				_, err = conn.NewSender(&peer.SenderOption{ConnectionID: "source", ReceiverID: "audio"})
+				isError(t, err, ErrForbidden)
+				conn.SetAuthorizer(v.Authorizer(&Claims{Subject: "bob", Room: "demo", Subscribe: true}, "demo"))
+				_, err = conn.NewSender(&peer.SenderOption{ConnectionID: "source", ReceiverID: "audio"})
+				assert(t, err, nil)
+				// the receiver not exist is not audited.
+				_, err = conn.NewSender(&peer.SenderOption{ConnectionID: "source", ReceiverID: "video"})
+				assert(t, errors.Is(err, peer.ErrReceiverNotExist), true)
+				// the receivers of other rooms are rejected even if the connection id is known.
+				_, err = conn.NewSender(&peer.SenderOption{ConnectionID: "other", ReceiverID: "audio"})
+				isError(t, err, ErrForbidden)
+
+				assert(t, len(events), 3)
+				assert(t, events[1].String(), `auth allow subscribe subject="bob" room="demo" resource="source/audio"`)
+				assert(t, events[2].Allowed, false)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package auth
+
+import "errors"
+
+var (
+	ErrNoKey                = errors.New("no key for verifying")
+	ErrInvalidKey           = errors.New("invalid key")
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrTokenExpired         = errors.New("token expired")
+	ErrMissingExpiry        = errors.New("token without expiry")
+	ErrTokenNotValidYet     = errors.New("token not valid yet")
+	ErrInvalidIssuer        = errors.New("invalid issuer")
+	ErrInvalidAudience      = errors.New("invalid audience")
+	// ErrForbidden is returned if the token is valid but the grants are not enough.
+	ErrForbidden = errors.New("forbidden")
+)
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmES256 = "ES256"
+
+	// es256SignatureSize is the size of r||s, see rfc7518#section-3.4.
+	es256SignatureSize = 64
+)
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+type VerifierOption struct {
+	// Secret is the key of HS256, the tokens of HS256 are rejected if empty.
+	Secret []byte
+	// PublicKey is the P-256 key of ES256, the tokens of ES256 are rejected if nil.
+	PublicKey *ecdsa.PublicKey
+	// Issuer and Audience are checked if not empty.
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew allowed for exp and nbf.
+	Leeway time.Duration
+	// AllowNoExpiry accepts the tokens without exp, which are valid forever until the key is rotated.
+	AllowNoExpiry bool
+	// Audit receives the decisions, they are logged by default.
+	Audit func(event AuditEvent)
+}
+
+// Verifier verifies the tokens by the keys of option, the algorithm is chosen by the key type rather than
+// trusting the header only, so a token could not be verified by a key of other algorithm.
+type Verifier struct {
+	mutex  sync.RWMutex
+	option VerifierOption
+}
+
+func NewVerifier(option *VerifierOption) (*Verifier, error) {
+	v := &Verifier{}
+	if err := v.Update(option); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Update replaces the keys and checks of the verifier, e.g. rotating the keys, the tokens verified
+// before are not affected. The verifier is unchanged if the option is invalid.
+func (v *Verifier) Update(option *VerifierOption) error {
+	if len(option.Secret) == 0 && option.PublicKey == nil {
+		return ErrNoKey
+	}
+	if option.PublicKey != nil && option.PublicKey.Curve != elliptic.P256() {
+		return ErrInvalidKey
+	}
+	o := *option
+	if o.Audit == nil {
+		o.Audit = logAudit
+	}
+	v.mutex.Lock()
+	v.option = o
+	v.mutex.Unlock()
+	return nil
+}
+
+func (v *Verifier) options() VerifierOption {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.option
+}
+
+// Verify checks the signature and the registered claims, and returns the claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	return v.verify(v.options(), token)
+}
+
+func (v *Verifier) verify(option VerifierOption, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	h := &header{}
+	if err := decodeSegment(parts[0], h); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch h.Algorithm {
+	case AlgorithmHS256:
+		if len(option.Secret) == 0 {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		if !hmac.Equal(signature, signHMAC(signed, option.Secret)) {
+			return nil, ErrInvalidSignature
+		}
+	case AlgorithmES256:
+		if option.PublicKey == nil {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		if len(signature) != es256SignatureSize {
+			return nil, ErrInvalidSignature
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(option.PublicKey, digest[:], r, s) {
+			return nil, ErrInvalidSignature
+		}
+	default:
+		// including none.
+		return nil, ErrUnsupportedAlgorithm
+	}
+	claims := &Claims{}
+	if err = decodeSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+	if err = validate(option, claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func validate(option VerifierOption, claims *Claims, now time.Time) error {
+	leeway := int64(option.Leeway / time.Second)
+	if claims.ExpiresAt == 0 && !option.AllowNoExpiry {
+		return ErrMissingExpiry
+	}
+	if claims.ExpiresAt != 0 && now.Unix() > claims.ExpiresAt+leeway {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore-leeway {
+		return ErrTokenNotValidYet
+	}
+	if option.Issuer != "" && claims.Issuer != option.Issuer {
+		return ErrInvalidIssuer
+	}
+	if option.Audience != "" {
+		for _, a := range claims.Audience {
+			if a == option.Audience {
+				return nil
+			}
+		}
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// Authorize verifies the token and checks the room and action are granted, the decision is audited.
+// It returns ErrForbidden if the token is valid but not granted.
+func (v *Verifier) Authorize(token, room string, action Action) (*Claims, error) {
+	option := v.options()
+	event := AuditEvent{Time: time.Now(), Room: room, Action: action}
+	claims, err := v.verify(option, token)
+	if err == nil {
+		event.Subject = claims.Subject
+		if !claims.AllowRoom(room) || !claims.Allow(action) {
+			err = ErrForbidden
+		}
+	}
+	event.Allowed = err == nil
+	if err != nil {
+		event.Reason = err.Error()
+	}
+	option.Audit(event)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Authorizer returns the authorizer of the claims in the room, the decisions are audited by the verifier.
+func (v *Verifier) Authorizer(claims *Claims, room string) *Authorizer {
+	return &Authorizer{claims: claims, room: room, audit: v.options().Audit}
+}
+
+// SignHS256 returns the token of claims signed by the secret.
+func SignHS256(claims *Claims, secret []byte) (string, error) {
+	signed, err := encodeSigned(AlgorithmHS256, claims)
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signHMAC([]byte(signed), secret)), nil
+}
+
+// SignES256 returns the token of claims signed by the P-256 key.
+func SignES256(claims *Claims, key *ecdsa.PrivateKey) (string, error) {
+	if key.Curve != elliptic.P256() {
+		return "", ErrInvalidKey
+	}
+	signed, err := encodeSigned(AlgorithmES256, claims)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, es256SignatureSize)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ParseECDSAPublicKey parses the PEM of PKIX public key, it's the format of openssl ec -pubout.
+func ParseECDSAPublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	return ecKey, nil
+}
+
+func signHMAC(data, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func encodeSigned(algorithm string, claims *Claims) (string, error) {
+	h, err := json.Marshal(&header{Algorithm: algorithm, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c), nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+func isError(t *testing.T, err, target error) {
+	if !errors.Is(err, target) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), target, err)
+		t.FailNow()
+	}
+}
+
+// newTestVerifier returns a verifier of the secret and the key, the audit events are appended to events.
+func newTestVerifier(t *testing.T, secret []byte, key *ecdsa.PrivateKey, events *[]AuditEvent) *Verifier {
+	option := &VerifierOption{Secret: secret, Issuer: "sfu", Audit: func(event AuditEvent) {
+		*events = append(*events, event)
+	}}
+	if key != nil {
+		option.PublicKey = &key.PublicKey
+	}
+	v, err := NewVerifier(option)
+	assert(t, err, nil)
+	return v
+}
+
+func TestVerifier(t *testing.T) {
+	secret := []byte("secret")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert(t, err, nil)
+	claims := func() *Claims {
+		return &Claims{Issuer: "sfu", Subject: "alice", Room: "demo", Publish: true, ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	}
+	tests := []testHelper{
+		{
+			name:        "hs256",
+			description: "the token signed by the secret is verified",
+			method: func(t *testing.T) {
+				var events []AuditEvent
+				v := newTestVerifier(t, secret, nil, &events)
+				token, err := SignHS256(claims(), secret)
+				assert(t, err, nil)
+				result, err := v.Verify(token)
+				assert(t, err, nil)
+				assert(t, result, claims())
+
+				other, err := SignHS256(claims(), []byte("other"))
+				assert(t, err, nil)
+				_, err = v.Verify(other)
+				isError(t, err, ErrInvalidSignature)
+				// no key of es256.
+				es, err := SignES256(claims(), key)
+				assert(t, err, nil)
+				_, err = v.Verify(es)
+				isError(t, err, ErrUnsupportedAlgorithm)
+			},
+		},
+		{
+			name:        "es256",
+			description: "the token signed by the private key is verified by the public key from PEM",
+			method: func(t *testing.T) {
+				der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+				assert(t, err, nil)
+				public, err := ParseECDSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+				assert(t, err, nil)
+				v, err := NewVerifier(&VerifierOption{PublicKey: public})
+				assert(t, err, nil)
+				token, err := SignES256(claims(), key)
+				assert(t, err, nil)
+				result, err := v.Verify(token)
+				assert(t, err, nil)
+				assert(t, result.Subject, "alice")
+
+				// the secret is not a key of es256, and hs256 is rejected without the secret.
+				hs, err := SignHS256(claims(), secret)
+				assert(t, err, nil)
+				_, err = v.Verify(hs)
+				isError(t, err, ErrUnsupportedAlgorithm)
+				parts := strings.Split(token, ".")
+				tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"bob"}`)) + "." + parts[2]
+				_, err = v.Verify(tampered)
+				isError(t, err, ErrInvalidSignature)
+				_, err = ParseECDSAPublicKey([]byte("invalid"))
+				isError(t, err, ErrInvalidKey)
+			},
+		},
+		{
+			name:        "malformed",
+			description: "the malformed tokens and alg none are rejected",
+			method: func(t *testing.T) {
+				var events []AuditEvent
+				v := newTestVerifier(t, secret, nil, &events)
+				_, err := v.Verify("a.b")
+				isError(t, err, ErrMalformedToken)
+				_, err = v.Verify("!.b.c")
+				isError(t, err, ErrMalformedToken)
+				none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+					base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + "."
+				_, err = v.Verify(none)
+				isError(t, err, ErrUnsupportedAlgorithm)
+				_, err = NewVerifier(&VerifierOption{})
+				isError(t, err, ErrNoKey)
+			},
+		},
+		{
+			name:        "claims",
+			description: "the exp, nbf, iss and aud are checked, exp is required by default",
+			method: func(t *testing.T) {
+				var events []AuditEvent
+				v := newTestVerifier(t, secret, nil, &events)
+				sign := func(modify func(c *Claims)) string {
+					c := claims()
+					modify(c)
+					token, err := SignHS256(c, secret)
+					assert(t, err, nil)
+					return token
+				}
+				_, err := v.Verify(sign(func(c *Claims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() }))
+				isError(t, err, ErrTokenExpired)
+				_, err = v.Verify(sign(func(c *Claims) { c.NotBefore = time.Now().Add(time.Minute).Unix() }))
+				isError(t, err, ErrTokenNotValidYet)
+				_, err = v.Verify(sign(func(c *Claims) { c.Issuer = "other" }))
+				isError(t, err, ErrInvalidIssuer)
+
+				v.option.Audience = "media"
+				_, err = v.Verify(sign(func(c *Claims) { c.Audience = Audience{"api"} }))
+				isError(t, err, ErrInvalidAudience)
+				result, err := v.Verify(sign(func(c *Claims) { c.Audience = Audience{"api", "media"} }))
+				assert(t, err, nil)
+				assert(t, result.Audience, Audience{"api", "media"})
+
+				v.option.Audience = ""
+				v.option.Leeway = 2 * time.Minute
+				_, err = v.Verify(sign(func(c *Claims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() }))
+				assert(t, err, nil)
+
+				// the tokens without exp are rejected unless allowed.
+				_, err = v.Verify(sign(func(c *Claims) { c.ExpiresAt = 0 }))
+				isError(t, err, ErrMissingExpiry)
+				v.option.AllowNoExpiry = true
+				_, err = v.Verify(sign(func(c *Claims) { c.ExpiresAt = 0 }))
+				assert(t, err, nil)
+			},
+		},
+		{
+			name:        "update",
+			description: "the tokens of the old secret are rejected after update, the invalid option is ignored",
+			method: func(t *testing.T) {
+				var events []AuditEvent
+				v := newTestVerifier(t, secret, nil, &events)
+				token, err := SignHS256(claims(), secret)
+				assert(t, err, nil)
+				isError(t, v.Update(&VerifierOption{}), ErrNoKey)
+				_, err = v.Verify(token)
+				assert(t, err, nil)
+
+				assert(t, v.Update(&VerifierOption{Secret: []byte("rotated"), Issuer: "sfu"}), nil)
+				_, err = v.Verify(token)
+				isError(t, err, ErrInvalidSignature)
+				rotated, err := SignHS256(claims(), []byte("rotated"))
+				assert(t, err, nil)
+				_, err = v.Verify(rotated)
+				assert(t, err, nil)
+			},
+		},
+		{
+			name:        "authorize",
+			description: "the room and action are checked, the decisions are audited",
+			method: func(t *testing.T) {
+				var events []AuditEvent
+				v := newTestVerifier(t, secret, nil, &events)
+				token, err := SignHS256(claims(), secret)
+				assert(t, err, nil)
+				result, err := v.Authorize(token, "demo", ActionPublish)
+				assert(t, err, nil)
+				assert(t, result.Subject, "alice")
+				_, err = v.Authorize(token, "demo", ActionSubscribe)
+				isError(t, err, ErrForbidden)
+				_, err = v.Authorize(token, "other", ActionJoin)
+				isError(t, err, ErrForbidden)
+				_, err = v.Authorize("", "demo", ActionJoin)
+				isError(t, err, ErrMalformedToken)
+
+				assert(t, len(events), 4)
+				assert(t, events[0].Allowed, true)
+				assert(t, events[1].Subject, "alice")
+				assert(t, events[1].Reason, ErrForbidden.Error())
+				assert(t, events[1].String(), `auth deny subscribe subject="alice" room="demo" reason="forbidden"`)
+				assert(t, events[3].Allowed, false)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
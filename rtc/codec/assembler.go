@@ -0,0 +1,180 @@
+package codec
+
+import (
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/pion/rtp"
+)
+
+// Frame is assembled from the rtp packets of the same timestamp.
+type Frame struct {
+	Data     []byte
+	KeyFrame bool
+	// Timestamp is the rtp timestamp, and ExtendedTimestamp is unwrapped and relative to the first packet.
+	Timestamp         uint32
+	ExtendedTimestamp uint64
+	// CaptureTime is mapped by the sender report, it's zero if no sender report set.
+	CaptureTime time.Time
+	Packets     int
+}
+
+// AssemblerStats is counted since the assembler created.
+type AssemblerStats struct {
+	Frames        int
+	KeyFrames     int
+	Gaps          int
+	LostPackets   int
+	DroppedFrames int
+}
+
+// FrameAssembler groups the rtp packets by timestamp, a frame ends with the marker bit or
+// a packet of next timestamp, and every packet is a frame for audio.
+// After packets lost, the frames of the codec supports keyframe are dropped until next keyframe,
+// the owner should request one in OnKeyFrameNeeded. It's not thread safe.
+type FrameAssembler struct {
+	codec            *Codec
+	clockRate        int
+	onKeyFrameNeeded func()
+
+	started  bool
+	lastSeq  uint16
+	lastTS   uint32
+	extTS    uint64
+	payloads [][]byte
+	// the timestamps of pending payloads
+	frameTS    uint32
+	frameExtTS uint64
+	broken     bool
+	srNtpTime  uint64
+	srRTPTime  uint32
+	stats      AssemblerStats
+}
+
+func NewFrameAssembler(encoderName string, clockRate int) (*FrameAssembler, error) {
+	codec, ok := allCodecs[encoderName]
+	if !ok || codec.NewDepacketizer == nil || clockRate <= 0 {
+		return nil, ErrNoDepacketizer
+	}
+	return &FrameAssembler{
+		codec:     codec,
+		clockRate: clockRate,
+		// wait for the first keyframe.
+		broken: codec.SupportKeyFrame,
+	}, nil
+}
+
+// OnKeyFrameNeeded is called when packets lost or a frame dropped for waiting keyframe, it's not rate limited.
+func (a *FrameAssembler) OnKeyFrameNeeded(callback func()) {
+	a.onKeyFrameNeeded = callback
+}
+
+// SetSenderReport updates the mapping of rtp timestamp to capture time, the ntp time is
+// the 64 bits timestamp of sender report.
+func (a *FrameAssembler) SetSenderReport(ntpTime uint64, rtpTime uint32) {
+	a.srNtpTime, a.srRTPTime = ntpTime, rtpTime
+}
+
+// Push returns the frames completed by the packet, it's usually one or none.
+func (a *FrameAssembler) Push(packet *rtp.Packet) []*Frame {
+	var frames []*Frame
+	if !a.started {
+		a.started = true
+		a.lastTS = packet.Timestamp
+	} else {
+		diff := packet.SequenceNumber - a.lastSeq
+		if diff == 0 || diff >= 0x8000 {
+			// duplicated or too old, the frame is gone.
+			return nil
+		}
+		if diff > 1 {
+			a.stats.Gaps++
+			a.stats.LostPackets += int(diff - 1)
+			if a.codec.SupportKeyFrame {
+				a.broken = true
+				a.payloads = nil
+				a.keyFrameNeeded()
+			}
+		}
+	}
+	a.lastSeq = packet.SequenceNumber
+	// unwrap the timestamp.
+	a.extTS += uint64(int64(int32(packet.Timestamp - a.lastTS)))
+	a.lastTS = packet.Timestamp
+
+	if len(a.payloads) != 0 && a.extTS != a.frameExtTS {
+		// the marker of last frame is lost.
+		if frame := a.assemble(); frame != nil {
+			frames = append(frames, frame)
+		}
+	}
+	a.frameTS, a.frameExtTS = packet.Timestamp, a.extTS
+	a.payloads = append(a.payloads, packet.Payload)
+	if packet.Marker || a.codec.MediaType == rtc.MediaTypeAudio {
+		if frame := a.assemble(); frame != nil {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// Flush returns the pending frame, which has no marker yet.
+func (a *FrameAssembler) Flush() *Frame {
+	if len(a.payloads) == 0 {
+		return nil
+	}
+	return a.assemble()
+}
+
+func (a *FrameAssembler) assemble() *Frame {
+	payloads := a.payloads
+	a.payloads = nil
+	keyframe := false
+	for _, p := range payloads {
+		keyframe = keyframe || IsKeyFrame(a.codec.EncoderName, p)
+	}
+	if a.broken {
+		if !keyframe {
+			a.stats.DroppedFrames++
+			a.keyFrameNeeded()
+			return nil
+		}
+		a.broken = false
+	}
+	data, err := a.codec.NewDepacketizer().Depacketize(payloads)
+	if err != nil || len(data) == 0 {
+		a.stats.DroppedFrames++
+		return nil
+	}
+	a.stats.Frames++
+	if keyframe {
+		a.stats.KeyFrames++
+	}
+	return &Frame{
+		Data:              data,
+		KeyFrame:          keyframe,
+		Timestamp:         a.frameTS,
+		ExtendedTimestamp: a.frameExtTS,
+		CaptureTime:       a.CaptureTime(a.frameTS),
+		Packets:           len(payloads),
+	}
+}
+
+// CaptureTime maps the rtp timestamp by the sender report, it's zero if no sender report set.
+func (a *FrameAssembler) CaptureTime(timestamp uint32) time.Time {
+	if a.srNtpTime == 0 {
+		return time.Time{}
+	}
+	diff := int64(int32(timestamp - a.srRTPTime))
+	return rtc.FromNtpTime(a.srNtpTime).Add(time.Duration(diff) * time.Second / time.Duration(a.clockRate))
+}
+
+func (a *FrameAssembler) keyFrameNeeded() {
+	if a.onKeyFrameNeeded != nil {
+		a.onKeyFrameNeeded()
+	}
+}
+
+func (a *FrameAssembler) Stats() AssemblerStats {
+	return a.stats
+}
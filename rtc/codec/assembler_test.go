@@ -0,0 +1,130 @@
+package codec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/pion/rtp"
+)
+
+type testPayloadDescriptor bool
+
+func (d testPayloadDescriptor) IsKeyFrame() bool {
+	return bool(d)
+}
+
+// testPacketizer prefixes every payload with the keyframe flag of the frame.
+type testPacketizer struct{}
+
+func (p testPacketizer) Packetize(frame []byte, mtu int) [][]byte {
+	var payloads [][]byte
+	for offset := 1; offset < len(frame); offset += mtu - 1 {
+		end := min(offset+mtu-1, len(frame))
+		payloads = append(payloads, append([]byte{frame[0]}, frame[offset:end]...))
+	}
+	return payloads
+}
+
+type testDepacketizer struct{}
+
+func (d testDepacketizer) Depacketize(payloads [][]byte) ([]byte, error) {
+	var data []byte
+	for _, p := range payloads {
+		data = append(data, p[1:]...)
+	}
+	return data, nil
+}
+
+func init() {
+	// the first byte of payload is the keyframe flag.
+	Register(&Codec{
+		MediaType:       rtc.MediaTypeVideo,
+		EncoderName:     "test",
+		SupportKeyFrame: true,
+		Process: func(payload []byte) rtc.PayloadDescriptor {
+			return testPayloadDescriptor(payload[0] == 1)
+		},
+		NewDepacketizer: func() Depacketizer { return testDepacketizer{} },
+		NewPacketizer:   func() Packetizer { return testPacketizer{} },
+	})
+	Register(&Codec{
+		MediaType:       rtc.MediaTypeAudio,
+		EncoderName:     "test-audio",
+		NewDepacketizer: func() Depacketizer { return testDepacketizer{} },
+	})
+}
+
+func testPacket(seq uint16, ts uint32, marker bool, payload ...byte) *rtp.Packet {
+	return &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts, Marker: marker}, Payload: payload}
+}
+
+func TestFrameAssembler(t *testing.T) {
+	if _, err := NewFrameAssembler("unknown", 90000); err != ErrNoDepacketizer {
+		t.Fatal("unknown codec should fail:", err)
+	}
+	a, err := NewFrameAssembler("test", 90000)
+	if err != nil {
+		t.Fatal("create assembler fail:", err)
+	}
+	var requests int
+	a.OnKeyFrameNeeded(func() {
+		requests++
+	})
+	// waits for the first keyframe.
+	if frames := a.Push(testPacket(65534, 0xfffff000, true, 0, 1)); len(frames) != 0 || requests != 1 {
+		t.Fatal("frame before keyframe should be dropped:", len(frames), requests)
+	}
+	a.Push(testPacket(65535, 0xfffff000+3000, false, 1, 1))
+	frames := a.Push(testPacket(0, 0xfffff000+3000, true, 0, 2))
+	if len(frames) != 1 || !frames[0].KeyFrame || string(frames[0].Data) != "\x01\x02" || frames[0].Packets != 2 {
+		t.Fatal("wrong keyframe:", frames)
+	}
+	// the marker is lost, the frame ends by next timestamp, which is wrapped.
+	a.Push(testPacket(1, 1904, false, 0, 3))
+	frames = a.Push(testPacket(2, 4904, true, 0, 4))
+	if len(frames) != 2 || frames[0].ExtendedTimestamp != 6000 || frames[1].ExtendedTimestamp != 9000 || frames[1].Timestamp != 4904 {
+		t.Fatal("wrong frames without marker:", frames)
+	}
+	if frames = a.Push(testPacket(2, 4904, true, 0, 4)); len(frames) != 0 {
+		t.Fatal("duplicated packet should be ignored")
+	}
+	// lost packet 3, the frames are dropped until next keyframe.
+	requests = 0
+	if frames = a.Push(testPacket(4, 7904, true, 0, 5)); len(frames) != 0 || requests != 2 {
+		t.Fatal("frame after loss should be dropped:", len(frames), requests)
+	}
+	if frames = a.Push(testPacket(5, 10904, true, 1, 6)); len(frames) != 1 {
+		t.Fatal("keyframe after loss should be assembled")
+	}
+	a.Push(testPacket(6, 13904, false, 0, 7))
+	if frame := a.Flush(); frame == nil || frame.Data[0] != 7 || a.Flush() != nil {
+		t.Fatal("flush fail:", frame)
+	}
+	stats := a.Stats()
+	expected := AssemblerStats{Frames: 5, KeyFrames: 2, Gaps: 1, LostPackets: 1, DroppedFrames: 2}
+	if stats != expected {
+		t.Fatal("wrong stats:", stats)
+	}
+}
+
+func TestFrameAssemblerAudio(t *testing.T) {
+	a, err := NewFrameAssembler("test-audio", 48000)
+	if err != nil {
+		t.Fatal("create assembler fail:", err)
+	}
+	if frame := a.Push(testPacket(1, 960, false, 0, 1)); len(frame) != 1 || !frame[0].CaptureTime.IsZero() {
+		t.Fatal("every audio packet is a frame")
+	}
+	// the sender report says 48000 is captured at the base time.
+	base := time.Unix(1700000000, 0)
+	a.SetSenderReport(rtc.ToNtpTime(base), 48000)
+	frames := a.Push(testPacket(3, 48000+4800, false, 0, 2))
+	if len(frames) != 1 || !frames[0].CaptureTime.Equal(base.Add(100*time.Millisecond)) {
+		t.Fatal("wrong capture time:", frames)
+	}
+	// audio is not dropped after loss.
+	if stats := a.Stats(); stats.Gaps != 1 || stats.DroppedFrames != 0 || stats.Frames != 2 {
+		t.Fatal("wrong stats:", stats)
+	}
+}
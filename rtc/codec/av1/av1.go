@@ -15,13 +15,22 @@ func Codec() *codec.Codec {
 	return &codec.Codec{
 		MediaType:        rtc.MediaTypeVideo,
 		EncoderName:      CodecName,
+		ClockRate:        90000,
+		PayloadType:      45,
+		RTX:              46,
 		SupportKeyFrame:  true,
 		SupportSimulcast: false,
 		SupportSVC:       true,
 		Process:          parsePayload,
+		NewDepacketizer:  newDepacketizer,
+		NewPacketizer:    newPacketizer,
 	}
 }
 
+func newDepacketizer() codec.Depacketizer {
+	return new(depacketizer)
+}
+
 type av1PayloadDescriptor struct {
 	isKeyFrame bool
 }
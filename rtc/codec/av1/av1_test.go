@@ -2,10 +2,13 @@ package av1
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/hex"
 	"io"
 	"os"
 	"testing"
+
+	"github.com/gotolive/sfu/rtc/codec"
 )
 
 func TestAV1PayloadDescriptor_IsKeyFrame(t *testing.T) {
@@ -37,3 +40,68 @@ func TestAV1PayloadDescriptor_IsKeyFrame(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSequenceHeader(t *testing.T) {
+	// the first obu of testdata.
+	obu, _ := hex.DecodeString("08000000043cffbc01a008")
+	s, err := ParseSequenceHeader(obu)
+	if err != nil {
+		t.Fatal("parse fail:", err)
+	}
+	if s.Profile != 0 || s.MaxWidth != 320 || s.MaxHeight != 240 || s.HighBitdepth || !s.ChromaSubsamplingX || !s.ChromaSubsamplingY {
+		t.Fatal("wrong sequence header:", s)
+	}
+	// with size field.
+	s2, err := ParseSequenceHeader(append([]byte{0x0a, 0x0a}, obu[1:]...))
+	if err != nil || *s2 != *s {
+		t.Fatal("parse with size fail:", err)
+	}
+	if _, err = ParseSequenceHeader(obu[:4]); err != ErrInvalidSequenceHeader {
+		t.Fatal("truncated should fail:", err)
+	}
+}
+
+func TestDepacketizer(t *testing.T) {
+	header, _ := hex.DecodeString("08000000043cffbc01a008")
+	depacketizer, err := codec.NewDepacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no depacketizer:", err)
+	}
+	// W=1 and N=1, one obu without size field.
+	data, err := depacketizer.Depacketize([][]byte{append([]byte{0x18}, header...)})
+	if err != nil {
+		t.Fatal("depacketize fail:", err)
+	}
+	expected := append([]byte{0x12, 0x00, 0x0a, 0x0a}, header[1:]...)
+	if !bytes.Equal(data, expected) {
+		t.Fatal("wrong temporal unit:", hex.EncodeToString(data))
+	}
+	if v := AppendLeb128(nil, 300); !bytes.Equal(v, []byte{0xac, 0x02}) {
+		t.Fatal("wrong leb128:", v)
+	}
+}
+
+func TestPacketizer(t *testing.T) {
+	header, _ := hex.DecodeString("0a0a000000043cffbc01a008")
+	// temporal delimiter, sequence header and a frame obu.
+	frame := append([]byte{0x12, 0x00}, header...)
+	frame = append(frame, 0x32, 0x0a, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	packetizer, err := codec.NewPacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no packetizer:", err)
+	}
+	payloads := packetizer.Packetize(frame, 20)
+	if len(payloads) != 2 {
+		t.Fatal("wrong payloads:", len(payloads))
+	}
+	if !Codec().Process(payloads[0]).IsKeyFrame() || Codec().Process(payloads[1]).IsKeyFrame() {
+		t.Fatal("the first payload should start a coded video sequence")
+	}
+	depacketizer, _ := codec.NewDepacketizer(CodecName)
+	if data, err := depacketizer.Depacketize(payloads); err != nil || !bytes.Equal(data, frame) {
+		t.Fatal("wrong frame:", hex.EncodeToString(data), err)
+	}
+	if !bytes.Equal(FindOBU(frame, ObuTypeSequenceHeader), header) || FindOBU(frame, 5) != nil {
+		t.Fatal("find obu fail")
+	}
+}
@@ -0,0 +1,59 @@
+package av1
+
+import (
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/rtp/pkg/frame"
+)
+
+// depacketizer returns a temporal unit in low overhead bitstream format, as ivf and mp4 required,
+// it starts with a temporal delimiter and every obu has the size field.
+type depacketizer struct {
+	assembler frame.AV1
+}
+
+func (d *depacketizer) Depacketize(payloads [][]byte) ([]byte, error) {
+	data := []byte{ObuTypeTemporalDelimiter<<3 | obuHasSizeField, 0}
+	for _, payload := range payloads {
+		packet := &codecs.AV1Packet{}
+		if _, err := packet.Unmarshal(payload); err != nil {
+			return nil, err
+		}
+		obus, err := d.assembler.ReadFrames(packet)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range obus {
+			if len(o) == 0 || (o[0]>>3)&0x0f == ObuTypeTemporalDelimiter {
+				continue
+			}
+			if o[0]&obuHasSizeField != 0 {
+				data = append(data, o...)
+				continue
+			}
+			headerSize := 1
+			if o[0]&obuHasExtension != 0 {
+				headerSize++
+			}
+			if len(o) < headerSize {
+				continue
+			}
+			data = append(data, o[0]|obuHasSizeField)
+			data = append(data, o[1:headerSize]...)
+			data = AppendLeb128(data, uint(len(o)-headerSize))
+			data = append(data, o[headerSize:]...)
+		}
+	}
+	return data, nil
+}
+
+// AppendLeb128 appends the value in leb128 format.
+func AppendLeb128(data []byte, value uint) []byte {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if value == 0 {
+			return append(data, b)
+		}
+		data = append(data, b|0x80)
+	}
+}
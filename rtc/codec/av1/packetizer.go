@@ -0,0 +1,88 @@
+package av1
+
+import (
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/rtp/pkg/obu"
+)
+
+func newPacketizer() codec.Packetizer {
+	return new(packetizer)
+}
+
+// packetizer splits the temporal unit into obus without size field, the temporal delimiter is dropped,
+// and the sequence header is sent with the next obu, which sets the N bit.
+type packetizer struct {
+	payloader codecs.AV1Payloader
+}
+
+func (p *packetizer) Packetize(frame []byte, mtu int) [][]byte {
+	if mtu <= 0 || mtu > 0xffff {
+		return nil
+	}
+	var payloads [][]byte
+	for _, o := range splitOBUs(frame) {
+		if (o[0]>>3)&0x0f == ObuTypeTemporalDelimiter {
+			continue
+		}
+		payloads = append(payloads, p.payloader.Payload(uint16(mtu), o)...)
+	}
+	return payloads
+}
+
+// splitOBUs returns the obus without size field.
+func splitOBUs(data []byte) [][]byte {
+	var obus [][]byte
+	for len(data) > 0 {
+		header := data[0]
+		headerSize := 1
+		if header&obuHasExtension != 0 {
+			headerSize++
+		}
+		if len(data) < headerSize {
+			break
+		}
+		size := len(data) - headerSize
+		sizeLen := 0
+		if header&obuHasSizeField != 0 {
+			s, n, err := obu.ReadLeb128(data[headerSize:])
+			if err != nil {
+				break
+			}
+			size, sizeLen = int(s), int(n)
+		}
+		end := headerSize + sizeLen + size
+		if end > len(data) {
+			break
+		}
+		o := make([]byte, 0, headerSize+size)
+		o = append(o, header&^obuHasSizeField)
+		o = append(o, data[1:headerSize]...)
+		obus = append(obus, append(o, data[headerSize+sizeLen:end]...))
+		data = data[end:]
+	}
+	return obus
+}
+
+// FindOBU returns the first obu of the type in the temporal unit, every obu should have the size field.
+func FindOBU(data []byte, obuType uint8) []byte {
+	for len(data) > 0 {
+		headerSize := 1
+		if data[0]&obuHasExtension != 0 {
+			headerSize++
+		}
+		if data[0]&obuHasSizeField == 0 || len(data) < headerSize {
+			return nil
+		}
+		size, n, err := obu.ReadLeb128(data[headerSize:])
+		end := headerSize + int(n) + int(size)
+		if err != nil || end > len(data) {
+			return nil
+		}
+		if (data[0]>>3)&0x0f == obuType {
+			return data[:end]
+		}
+		data = data[end:]
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package av1
+
+import (
+	"errors"
+
+	"github.com/gotolive/sfu/rtc/codec"
+)
+
+var ErrInvalidSequenceHeader = errors.New("invalid sequence header")
+
+const (
+	ObuTypeSequenceHeader    = 1
+	ObuTypeTemporalDelimiter = 2
+
+	obuHasSizeField = 0x02
+	obuHasExtension = 0x04
+
+	colorPrimariesBT709        = 1
+	transferSRGB               = 13
+	matrixCoefficientsIdentity = 0
+)
+
+// SequenceHeader is the part of sequence header obu needed by containers, see av1 spec 5.5.
+type SequenceHeader struct {
+	Profile              uint8
+	Level                uint8 // of the first operating point
+	Tier                 uint8
+	MaxWidth             int
+	MaxHeight            int
+	HighBitdepth         bool
+	TwelveBit            bool
+	Monochrome           bool
+	ChromaSubsamplingX   bool
+	ChromaSubsamplingY   bool
+	ChromaSamplePosition uint8
+}
+
+// ParseSequenceHeader parses the sequence header obu, with or without the size field.
+func ParseSequenceHeader(obu []byte) (*SequenceHeader, error) {
+	if len(obu) < 2 || (obu[0]>>3)&0x0f != ObuTypeSequenceHeader {
+		return nil, ErrInvalidSequenceHeader
+	}
+	offset := 1
+	if obu[0]&0x04 != 0 {
+		offset++
+	}
+	r := codec.NewBitReader(obu[offset:])
+	if obu[0]&0x02 != 0 {
+		// leb128 size
+		for {
+			b, err := r.ReadBits(8)
+			if err != nil {
+				return nil, ErrInvalidSequenceHeader
+			}
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	var err error
+	bits := func(n int) uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = r.ReadBits(n)
+		return v
+	}
+	flag := func() bool {
+		return bits(1) == 1
+	}
+	s := &SequenceHeader{Profile: uint8(bits(3))}
+	bits(1) // still_picture
+	reduced := flag()
+	if reduced {
+		s.Level = uint8(bits(5))
+	} else {
+		decoderModelInfo := false
+		bufferDelayLength := 0
+		if flag() { // timing_info_present_flag
+			bits(32)    // num_units_in_display_tick
+			bits(32)    // time_scale
+			if flag() { // equal_picture_interval
+				// num_ticks_per_picture_minus_1 in uvlc
+				zeros := 0
+				for !flag() && err == nil {
+					zeros++
+				}
+				if zeros < 32 {
+					bits(zeros)
+				}
+			}
+			decoderModelInfo = flag()
+			if decoderModelInfo {
+				bufferDelayLength = int(bits(5)) + 1
+				bits(32) // num_units_in_decoding_tick
+				bits(5)  // buffer_removal_time_length_minus_1
+				bits(5)  // frame_presentation_time_length_minus_1
+			}
+		}
+		initialDisplayDelay := flag()
+		count := int(bits(5)) + 1
+		for i := 0; i < count; i++ {
+			bits(12) // operating_point_idc
+			level := uint8(bits(5))
+			var tier uint8
+			if level > 7 {
+				tier = uint8(bits(1))
+			}
+			if i == 0 {
+				s.Level, s.Tier = level, tier
+			}
+			if decoderModelInfo && flag() {
+				bits(bufferDelayLength) // decoder_buffer_delay
+				bits(bufferDelayLength) // encoder_buffer_delay
+				bits(1)                 // low_delay_mode_flag
+			}
+			if initialDisplayDelay && flag() {
+				bits(4)
+			}
+		}
+	}
+	widthBits := int(bits(4)) + 1
+	heightBits := int(bits(4)) + 1
+	s.MaxWidth = int(bits(widthBits)) + 1
+	s.MaxHeight = int(bits(heightBits)) + 1
+	if !reduced && flag() { // frame_id_numbers_present_flag
+		bits(4)
+		bits(3)
+	}
+	bits(3) // use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	if !reduced {
+		bits(4) // enable_interintra_compound, enable_masked_compound, enable_warped_motion, enable_dual_filter
+		orderHint := flag()
+		if orderHint {
+			bits(2) // enable_jnt_comp, enable_ref_frame_mvs
+		}
+		forceScreenContentTools := uint64(2)
+		if !flag() { // seq_choose_screen_content_tools
+			forceScreenContentTools = bits(1)
+		}
+		if forceScreenContentTools > 0 && !flag() { // seq_choose_integer_mv
+			bits(1)
+		}
+		if orderHint {
+			bits(3)
+		}
+	}
+	bits(3) // enable_superres, enable_cdef, enable_restoration
+	s.parseColorConfig(bits, flag)
+	if err != nil {
+		return nil, ErrInvalidSequenceHeader
+	}
+	return s, nil
+}
+
+func (s *SequenceHeader) parseColorConfig(bits func(int) uint64, flag func() bool) {
+	s.HighBitdepth = flag()
+	if s.Profile == 2 && s.HighBitdepth {
+		s.TwelveBit = flag()
+	}
+	if s.Profile != 1 {
+		s.Monochrome = flag()
+	}
+	primaries, transfer, matrix := uint64(2), uint64(2), uint64(2)
+	if flag() { // color_description_present_flag
+		primaries, transfer, matrix = bits(8), bits(8), bits(8)
+	}
+	switch {
+	case s.Monochrome:
+		bits(1) // color_range
+		s.ChromaSubsamplingX, s.ChromaSubsamplingY = true, true
+		return
+	case primaries == colorPrimariesBT709 && transfer == transferSRGB && matrix == matrixCoefficientsIdentity:
+		return
+	}
+	bits(1) // color_range
+	switch s.Profile {
+	case 0:
+		s.ChromaSubsamplingX, s.ChromaSubsamplingY = true, true
+	case 1:
+	default:
+		if s.TwelveBit {
+			s.ChromaSubsamplingX = flag()
+			if s.ChromaSubsamplingX {
+				s.ChromaSubsamplingY = flag()
+			}
+		} else {
+			s.ChromaSubsamplingX = true
+		}
+	}
+	if s.ChromaSubsamplingX && s.ChromaSubsamplingY {
+		s.ChromaSamplePosition = uint8(bits(2))
+	}
+}
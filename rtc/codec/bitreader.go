@@ -0,0 +1,69 @@
+package codec
+
+import (
+	"errors"
+)
+
+var ErrNotEnoughBits = errors.New("not enough bits")
+
+// BitReader reads bits in msb first order, it's used by the parameter set parsers.
+type BitReader struct {
+	data   []byte
+	offset int // in bits
+}
+
+func NewBitReader(data []byte) *BitReader {
+	return &BitReader{data: data}
+}
+
+func (r *BitReader) ReadBits(n int) (uint64, error) {
+	if r.offset+n > len(r.data)*8 {
+		return 0, ErrNotEnoughBits
+	}
+	var v uint64
+	for i := 0; i < n; i++ {
+		bit := r.data[r.offset/8] >> (7 - r.offset%8) & 0x01
+		v = v<<1 | uint64(bit)
+		r.offset++
+	}
+	return v, nil
+}
+
+func (r *BitReader) ReadFlag() (bool, error) {
+	v, err := r.ReadBits(1)
+	return v == 1, err
+}
+
+func (r *BitReader) Skip(n int) error {
+	_, err := r.ReadBits(n)
+	return err
+}
+
+// ReadUE reads an unsigned exp-golomb code.
+func (r *BitReader) ReadUE() (uint64, error) {
+	zeros := 0
+	for {
+		bit, err := r.ReadBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+		zeros++
+		if zeros > 32 {
+			return 0, ErrNotEnoughBits
+		}
+	}
+	v, err := r.ReadBits(zeros)
+	return 1<<zeros - 1 + v, err
+}
+
+// ReadSE reads a signed exp-golomb code.
+func (r *BitReader) ReadSE() (int64, error) {
+	v, err := r.ReadUE()
+	if v%2 == 1 {
+		return int64(v+1) / 2, err
+	}
+	return -int64(v / 2), err
+}
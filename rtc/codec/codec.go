@@ -1,6 +1,8 @@
 package codec
 
 import (
+	"sort"
+
 	"github.com/gotolive/sfu/rtc"
 )
 
@@ -11,13 +13,40 @@ func Register(codec *Codec) {
 
 var allCodecs = map[string]*Codec{}
 
+// Codecs returns the registered codecs, audio first and then by encoder name.
+func Codecs() []*Codec {
+	codecs := make([]*Codec, 0, len(allCodecs))
+	for _, c := range allCodecs {
+		codecs = append(codecs, c)
+	}
+	sort.Slice(codecs, func(i, j int) bool {
+		if codecs[i].MediaType != codecs[j].MediaType {
+			return codecs[i].MediaType == rtc.MediaTypeAudio
+		}
+		return codecs[i].EncoderName < codecs[j].EncoderName
+	})
+	return codecs
+}
+
 type Codec struct {
-	MediaType        string
-	EncoderName      string
+	MediaType   string
+	EncoderName string
+	ClockRate   int
+	// Channels is 0 for video.
+	Channels int
+	// PayloadType and RTX are the preferred payload types, the same as the browsers, RTX is 0 if not supported.
+	PayloadType rtc.PayloadType
+	RTX         rtc.PayloadType
+	// Parameters are the default format parameters.
+	Parameters       map[string]string
 	SupportKeyFrame  bool
 	SupportSimulcast bool
 	SupportSVC       bool
 	Process          func([]byte) rtc.PayloadDescriptor
+	// NewDepacketizer is optional, it's called for each frame.
+	NewDepacketizer func() Depacketizer
+	// NewPacketizer is optional, it's called for each stream.
+	NewPacketizer func() Packetizer
 }
 
 // ProcessRTPPacket try to update packet according the encoder name
@@ -31,6 +60,16 @@ func ProcessRTPPacket(packet rtc.Packet, encoderName string) {
 	}
 }
 
+// IsKeyFrame checks the rtp payload, it's false if the codec unknown.
+func IsKeyFrame(encoderName string, payload []byte) bool {
+	if codec, ok := allCodecs[encoderName]; ok && codec.Process != nil {
+		if pd := codec.Process(payload); pd != nil {
+			return pd.IsKeyFrame()
+		}
+	}
+	return false
+}
+
 func CanBeKeyFrame(encoderName string) bool {
 	if codec, ok := allCodecs[encoderName]; ok {
 		return codec.SupportKeyFrame
@@ -0,0 +1,23 @@
+package codec
+
+import (
+	"errors"
+)
+
+var ErrNoDepacketizer = errors.New("no depacketizer for codec")
+
+// Depacketizer extracts the frame from the rtp payloads of it, the payloads are in sequence order.
+// The output format is decided by the codec: avc for h264, low overhead bitstream for av1,
+// and the raw frame for others.
+type Depacketizer interface {
+	Depacketize(payloads [][]byte) ([]byte, error)
+}
+
+// NewDepacketizer returns the depacketizer of the codec, a new one should be used for each frame,
+// since some formats keep state between the packets.
+func NewDepacketizer(encoderName string) (Depacketizer, error) {
+	if codec, ok := allCodecs[encoderName]; ok && codec.NewDepacketizer != nil {
+		return codec.NewDepacketizer(), nil
+	}
+	return nil, ErrNoDepacketizer
+}
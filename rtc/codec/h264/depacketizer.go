@@ -0,0 +1,25 @@
+package h264
+
+import (
+	"github.com/pion/rtp/codecs"
+)
+
+// depacketizer returns the nal units in avc format, each one is prefixed by 4 bytes length,
+// STAP-A is split and FU-A is joined.
+type depacketizer struct {
+	packet codecs.H264Packet
+}
+
+func (d *depacketizer) Depacketize(payloads [][]byte) ([]byte, error) {
+	// the fragmentation units are buffered in the packet.
+	d.packet.IsAVC = true
+	var data []byte
+	for _, payload := range payloads {
+		p, err := d.packet.Unmarshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, p...)
+	}
+	return data, nil
+}
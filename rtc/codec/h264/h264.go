@@ -17,13 +17,23 @@ func Codec() *codec.Codec {
 	return &codec.Codec{
 		MediaType:        rtc.MediaTypeVideo,
 		EncoderName:      CodecName,
+		ClockRate:        90000,
+		PayloadType:      102,
+		RTX:              103,
+		Parameters:       map[string]string{"level-asymmetry-allowed": "1", "packetization-mode": "1", "profile-level-id": "42e01f"},
 		SupportKeyFrame:  true,
 		SupportSimulcast: true,
 		SupportSVC:       true,
 		Process:          parsePayload,
+		NewDepacketizer:  newDepacketizer,
+		NewPacketizer:    newPacketizer,
 	}
 }
 
+func newDepacketizer() codec.Depacketizer {
+	return new(depacketizer)
+}
+
 type h264PayloadDescriptor struct {
 	isKeyFrame bool
 }
@@ -2,10 +2,14 @@ package h264
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"io"
 	"os"
 	"testing"
+
+	"github.com/gotolive/sfu/rtc/codec"
 )
 
 func TestH264PayloadDescriptor_IsKeyFrame(t *testing.T) {
@@ -42,3 +46,96 @@ func TestH264PayloadDescriptor_IsKeyFrame(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSPS(t *testing.T) {
+	sps, _ := hex.DecodeString("6742c0158c8d40a0f900f08846a0")
+	s, err := ParseSPS(sps)
+	if err != nil {
+		t.Fatal("parse sps fail:", err)
+	}
+	if s.ProfileIdc != 66 || s.LevelIdc != 21 || !s.ConstraintSet0Flag {
+		t.Fatal("wrong profile:", s.ProfileIdc, s.LevelIdc)
+	}
+	if s.Width() != 320 || s.Height() != 240 {
+		t.Fatal("wrong size:", s.Width(), s.Height())
+	}
+	// high profile 1080p, cropped from 1088.
+	sps, _ = hex.DecodeString("67640028acd940780227e5c05a808080a0000003002000000781e30632c0")
+	if s, err = ParseSPS(sps); err != nil || s.Width() != 1920 || s.Height() != 1080 {
+		t.Fatal("parse high profile sps fail:", err)
+	}
+	if _, err = ParseSPS(sps[:5]); err != ErrInvalidParameterSet {
+		t.Fatal("truncated sps should fail:", err)
+	}
+	if _, err = ParseSPS([]byte{0x68, 0xce, 0x3c, 0x80}); err != ErrInvalidParameterSet {
+		t.Fatal("pps is not sps:", err)
+	}
+}
+
+func TestDepacketizer(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x15}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 1, 2, 3, 4}
+	stapA := []byte{0x18, 0, 4}
+	stapA = append(append(append(stapA, sps...), 0, 4), pps...)
+	// FU-A with start and end bit.
+	fuStart := []byte{0x7c, 0x85, 1, 2}
+	fuEnd := []byte{0x7c, 0x45, 3, 4}
+	depacketizer, err := codec.NewDepacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no depacketizer:", err)
+	}
+	data, err := depacketizer.Depacketize([][]byte{stapA, fuStart, fuEnd})
+	if err != nil {
+		t.Fatal("depacketize fail:", err)
+	}
+	var expected []byte
+	for _, nalu := range [][]byte{sps, pps, idr} {
+		expected = append(append(expected, 0, 0, 0, byte(len(nalu))), nalu...)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatal("wrong avc:", hex.EncodeToString(data))
+	}
+}
+
+func TestPacketizer(t *testing.T) {
+	file, err := os.Open("../../../testdata/codec/h264/stream")
+	if err != nil {
+		t.Fatal("read file fail", err)
+	}
+	defer file.Close()
+	var nalus [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		nalu, _ := hex.DecodeString(scanner.Text())
+		nalus = append(nalus, nalu)
+	}
+	var annexB, avc []byte
+	for _, nalu := range nalus[:3] {
+		annexB = append(append(annexB, 0, 0, 0, 1), nalu...)
+		avc = append(binary.BigEndian.AppendUint32(avc, uint32(len(nalu))), nalu...)
+	}
+	for _, frame := range [][]byte{annexB, avc} {
+		packetizer, err := codec.NewPacketizer(CodecName)
+		if err != nil {
+			t.Fatal("no packetizer:", err)
+		}
+		payloads := packetizer.Packetize(frame, 100)
+		if len(payloads) < 2 {
+			t.Fatal("the idr should be split:", len(payloads))
+		}
+		// sps and pps in STAP-A.
+		if payloads[0][0]&0x1f != 24 || !Codec().Process(payloads[0]).IsKeyFrame() {
+			t.Fatal("the first payload should be a keyframe")
+		}
+		for _, payload := range payloads {
+			if len(payload) > 100 {
+				t.Fatal("payload too large:", len(payload))
+			}
+		}
+		depacketizer, _ := codec.NewDepacketizer(CodecName)
+		if data, err := depacketizer.Depacketize(payloads); err != nil || !bytes.Equal(data, avc) {
+			t.Fatal("wrong frame:", err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package h264
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/pion/rtp/codecs"
+)
+
+var (
+	startCode      = []byte{0, 0, 0, 1}
+	shortStartCode = []byte{0, 0, 1}
+)
+
+func newPacketizer() codec.Packetizer {
+	return new(packetizer)
+}
+
+// packetizer sends sps and pps in STAP-A before the next nal unit, and splits the large ones to FU-A.
+// The frame in avc format is converted to annex-b first.
+type packetizer struct {
+	payloader codecs.H264Payloader
+}
+
+func (p *packetizer) Packetize(frame []byte, mtu int) [][]byte {
+	if mtu <= 0 || mtu > 0xffff {
+		return nil
+	}
+	if !bytes.HasPrefix(frame, startCode) && !bytes.HasPrefix(frame, shortStartCode) {
+		frame = avcToAnnexB(frame)
+	}
+	return p.payloader.Payload(uint16(mtu), frame)
+}
+
+func avcToAnnexB(frame []byte) []byte {
+	data := make([]byte, 0, len(frame))
+	for len(frame) > 4 {
+		size := int(binary.BigEndian.Uint32(frame))
+		frame = frame[4:]
+		if size > len(frame) {
+			break
+		}
+		data = append(append(data, startCode...), frame[:size]...)
+		frame = frame[size:]
+	}
+	return data
+}
@@ -1,5 +1,13 @@
 package h264
 
+import (
+	"errors"
+
+	"github.com/gotolive/sfu/rtc/codec"
+)
+
+var ErrInvalidParameterSet = errors.New("invalid parameter set")
+
 type SequenceParameterSet struct {
 	ProfileIdc                      uint8
 	ConstraintSet0Flag              bool
@@ -68,3 +76,178 @@ type PictureParameterSet struct {
 	PicScalingListPresentFlag             [8]bool
 	SecondChromaQpIndexOffset             int
 }
+
+// highProfiles have chroma format and scaling lists in sps.
+var highProfiles = map[uint8]bool{100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true, 118: true, 128: true, 138: true, 139: true, 134: true, 135: true}
+
+// rbsp removes the emulation prevention bytes.
+func rbsp(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		result = append(result, b)
+	}
+	return result
+}
+
+// ParseSPS parses the sps nal unit with header, the vui is not parsed.
+func ParseSPS(nalu []byte) (*SequenceParameterSet, error) {
+	if len(nalu) < 4 || nalu[0]&0x1f != 7 {
+		return nil, ErrInvalidParameterSet
+	}
+	s := &SequenceParameterSet{
+		ProfileIdc:         nalu[1],
+		ConstraintSet0Flag: nalu[2]&0x80 != 0,
+		ConstraintSet1Flag: nalu[2]&0x40 != 0,
+		ConstraintSet2Flag: nalu[2]&0x20 != 0,
+		ConstraintSet3Flag: nalu[2]&0x10 != 0,
+		ConstraintSet4Flag: nalu[2]&0x08 != 0,
+		ConstraintSet5Flag: nalu[2]&0x04 != 0,
+		LevelIdc:           nalu[3],
+		ChromaFormatIdc:    1,
+	}
+	r := codec.NewBitReader(rbsp(nalu[4:]))
+	var err error
+	ue := func() uint {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = r.ReadUE()
+		return uint(v)
+	}
+	se := func() int {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = r.ReadSE()
+		return int(v)
+	}
+	flag := func() bool {
+		if err != nil {
+			return false
+		}
+		var v bool
+		v, err = r.ReadFlag()
+		return v
+	}
+	s.SeqParameterSetId = uint8(ue())
+	if highProfiles[s.ProfileIdc] {
+		s.ChromaFormatIdc = uint8(ue())
+		if s.ChromaFormatIdc == 3 {
+			flag() // separate_colour_plane_flag
+		}
+		s.BitDepthLumaMinus8 = uint8(ue())
+		s.BitDepthChromaMinus8 = uint8(ue())
+		s.QpprimeYZeroTransformBypassFlag = flag()
+		s.SeqScalingMatrixPresentFlag = flag()
+		if s.SeqScalingMatrixPresentFlag {
+			count := 8
+			if s.ChromaFormatIdc == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				present := flag()
+				if i < len(s.SeqScalingListPresentFlag) {
+					s.SeqScalingListPresentFlag[i] = present
+				}
+				if !present {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size && err == nil; j++ {
+					if next != 0 {
+						next = (last + se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	s.Log2MaxFrameNumMinus4 = uint8(ue())
+	s.PicOrderCntType = uint8(ue())
+	switch s.PicOrderCntType {
+	case 0:
+		s.Log2MaxPicOrderCntLsbMinus4 = uint8(ue())
+	case 1:
+		s.DeltaPicOrderAlwaysZeroFlag = flag()
+		s.OffsetForNonRefPic = uint(se())
+		s.OffsetForTopToBottomField = se()
+		s.NumRefFramesInPicOrderCntCycle = ue()
+		if s.NumRefFramesInPicOrderCntCycle > uint(len(s.OffsetForRefFrame)) {
+			return nil, ErrInvalidParameterSet
+		}
+		for i := uint(0); i < s.NumRefFramesInPicOrderCntCycle; i++ {
+			s.OffsetForRefFrame[i] = se()
+		}
+	}
+	s.MaxNumRefFrames = ue()
+	s.GapsInFrameNumValueAllowedFlag = flag()
+	s.PicWidthInMbsMinus1 = ue()
+	s.PicHeightInMapUnitsMinus1 = ue()
+	s.FramingMbsOnlyFlag = flag()
+	if !s.FramingMbsOnlyFlag {
+		s.MbAdaptiveFrameFieldFlag = flag()
+	}
+	s.Direct8X8InferenceFlag = flag()
+	s.FrameCroppingFlag = flag()
+	if s.FrameCroppingFlag {
+		s.FrameCropLeftOffset = ue()
+		s.FrameCropRightOffset = ue()
+		s.FrameCropTopOffset = ue()
+		s.FrameCropBottomOffset = ue()
+	}
+	s.VuiParametersPresentFlag = flag()
+	if err != nil {
+		return nil, ErrInvalidParameterSet
+	}
+	return s, nil
+}
+
+func (s *SequenceParameterSet) cropUnit() (int, int) {
+	frames := 1
+	if !s.FramingMbsOnlyFlag {
+		frames = 2
+	}
+	switch s.ChromaFormatIdc {
+	case 0:
+		return 1, frames
+	case 1:
+		return 2, 2 * frames
+	case 2:
+		return 2, frames
+	}
+	return 1, frames
+}
+
+// Width is the picture width after cropping.
+func (s *SequenceParameterSet) Width() int {
+	x, _ := s.cropUnit()
+	return int(s.PicWidthInMbsMinus1+1)*16 - x*int(s.FrameCropLeftOffset+s.FrameCropRightOffset)
+}
+
+// Height is the picture height after cropping.
+func (s *SequenceParameterSet) Height() int {
+	_, y := s.cropUnit()
+	frames := 1
+	if !s.FramingMbsOnlyFlag {
+		frames = 2
+	}
+	return frames*int(s.PicHeightInMapUnitsMinus1+1)*16 - y*int(s.FrameCropTopOffset+s.FrameCropBottomOffset)
+}
@@ -0,0 +1,54 @@
+package opus
+
+import (
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec"
+)
+
+const CodecName = "opus"
+
+func init() {
+	codec.Register(Codec())
+}
+
+func Codec() *codec.Codec {
+	return &codec.Codec{
+		MediaType:       rtc.MediaTypeAudio,
+		EncoderName:     CodecName,
+		ClockRate:       48000,
+		Channels:        2,
+		PayloadType:     111,
+		Parameters:      map[string]string{"minptime": "10", "useinbandfec": "1"},
+		NewDepacketizer: newDepacketizer,
+		NewPacketizer:   newPacketizer,
+	}
+}
+
+func newDepacketizer() codec.Depacketizer {
+	return new(depacketizer)
+}
+
+// depacketizer returns the opus packet, there is no payload descriptor.
+type depacketizer struct{}
+
+func (d *depacketizer) Depacketize(payloads [][]byte) ([]byte, error) {
+	var data []byte
+	for _, payload := range payloads {
+		data = append(data, payload...)
+	}
+	return data, nil
+}
+
+func newPacketizer() codec.Packetizer {
+	return new(packetizer)
+}
+
+// packetizer sends one opus packet in a rtp packet, it's never split.
+type packetizer struct{}
+
+func (p *packetizer) Packetize(frame []byte, _ int) [][]byte {
+	if len(frame) == 0 {
+		return nil
+	}
+	return [][]byte{frame}
+}
@@ -0,0 +1,32 @@
+package opus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gotolive/sfu/rtc/codec"
+)
+
+func TestDepacketizer(t *testing.T) {
+	if codec.CanBeKeyFrame(CodecName) {
+		t.Fatal("opus has no keyframe")
+	}
+	depacketizer, err := codec.NewDepacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no depacketizer:", err)
+	}
+	data, err := depacketizer.Depacketize([][]byte{{0xf8, 1, 2}})
+	if err != nil || !bytes.Equal(data, []byte{0xf8, 1, 2}) {
+		t.Fatal("wrong packet:", data, err)
+	}
+}
+
+func TestPacketizer(t *testing.T) {
+	packetizer, err := codec.NewPacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no packetizer:", err)
+	}
+	if payloads := packetizer.Packetize([]byte{0xf8, 1, 2}, 2); len(payloads) != 1 || !bytes.Equal(payloads[0], []byte{0xf8, 1, 2}) {
+		t.Fatal("opus packet should not be split:", payloads)
+	}
+}
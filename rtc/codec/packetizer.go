@@ -0,0 +1,76 @@
+package codec
+
+import (
+	"errors"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/pion/rtp"
+)
+
+var ErrNoPacketizer = errors.New("no packetizer for codec")
+
+const rtpHeaderSize = 12
+
+// Packetizer splits a frame into rtp payloads with the payload descriptors, each one is no larger than mtu.
+// The input format is the same as the output of depacketizer, and h264 in annex-b format is accepted too.
+// It's created for each stream, since some descriptors have picture id.
+type Packetizer interface {
+	Packetize(frame []byte, mtu int) [][]byte
+}
+
+func NewPacketizer(encoderName string) (Packetizer, error) {
+	if codec, ok := allCodecs[encoderName]; ok && codec.NewPacketizer != nil {
+		return codec.NewPacketizer(), nil
+	}
+	return nil, ErrNoPacketizer
+}
+
+// FramePacketizer packetizes the frames of a stream to rtp packets, the marker is set on the last packet
+// of a frame, and the sequence number starts from random.
+type FramePacketizer struct {
+	packetizer  Packetizer
+	payloadType uint8
+	ssrc        uint32
+	mtu         int
+	sequence    uint16
+}
+
+// NewFramePacketizer creates a packetizer, the mtu is the max size of rtp packet without header extensions.
+func NewFramePacketizer(encoderName string, payloadType uint8, ssrc uint32, mtu int) (*FramePacketizer, error) {
+	packetizer, err := NewPacketizer(encoderName)
+	if err != nil {
+		return nil, err
+	}
+	return &FramePacketizer{
+		packetizer:  packetizer,
+		payloadType: payloadType,
+		ssrc:        ssrc,
+		mtu:         mtu,
+		sequence:    uint16(rtc.GenerateSSRC()),
+	}, nil
+}
+
+func (p *FramePacketizer) Packetize(frame []byte, timestamp uint32) []*rtp.Packet {
+	payloads := p.packetizer.Packetize(frame, p.mtu-rtpHeaderSize)
+	packets := make([]*rtp.Packet, 0, len(payloads))
+	for i, payload := range payloads {
+		p.sequence++
+		packets = append(packets, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == len(payloads)-1,
+				PayloadType:    p.payloadType,
+				SequenceNumber: p.sequence,
+				Timestamp:      timestamp,
+				SSRC:           p.ssrc,
+			},
+			Payload: payload,
+		})
+	}
+	return packets
+}
+
+// SequenceNumber returns the sequence number of last packet.
+func (p *FramePacketizer) SequenceNumber() uint16 {
+	return p.sequence
+}
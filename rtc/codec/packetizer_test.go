@@ -0,0 +1,43 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFramePacketizer(t *testing.T) {
+	if _, err := NewFramePacketizer("test-audio", 111, 1234, 1200); err != ErrNoPacketizer {
+		t.Fatal("codec without packetizer should fail:", err)
+	}
+	packetizer, err := NewFramePacketizer("test", 96, 1234, rtpHeaderSize+4)
+	if err != nil {
+		t.Fatal("create packetizer fail:", err)
+	}
+	assembler, _ := NewFrameAssembler("test", 90000)
+	// the first byte is the keyframe flag.
+	frames := [][]byte{{1, 1, 2, 3, 4, 5, 6, 7}, {0, 8, 9}}
+	for i, frame := range frames {
+		packets := packetizer.Packetize(frame, uint32(i*3000))
+		for j, packet := range packets {
+			if packet.SSRC != 1234 || packet.PayloadType != 96 || len(packet.Payload) > 4 {
+				t.Fatal("wrong packet:", packet)
+			}
+			if packet.Marker != (j == len(packets)-1) {
+				t.Fatal("the marker should be set on the last packet:", j)
+			}
+		}
+		if packets[len(packets)-1].SequenceNumber != packetizer.SequenceNumber() {
+			t.Fatal("wrong sequence number")
+		}
+		var assembled []*Frame
+		for _, packet := range packets {
+			assembled = append(assembled, assembler.Push(packet)...)
+		}
+		if len(assembled) != 1 || !bytes.Equal(assembled[0].Data, frame[1:]) || assembled[0].KeyFrame != (frame[0] == 1) {
+			t.Fatal("wrong frame:", i)
+		}
+	}
+	if stats := assembler.Stats(); stats.Gaps != 0 || stats.Frames != 2 {
+		t.Fatal("wrong stats:", stats)
+	}
+}
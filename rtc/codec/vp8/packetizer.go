@@ -0,0 +1,22 @@
+package vp8
+
+import (
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/pion/rtp/codecs"
+)
+
+func newPacketizer() codec.Packetizer {
+	return &packetizer{payloader: codecs.VP8Payloader{EnablePictureID: true}}
+}
+
+// packetizer writes the descriptor with 15 bits picture id, the S bit is set on the first packet.
+type packetizer struct {
+	payloader codecs.VP8Payloader
+}
+
+func (p *packetizer) Packetize(frame []byte, mtu int) [][]byte {
+	if mtu <= 0 || mtu > 0xffff {
+		return nil
+	}
+	return p.payloader.Payload(uint16(mtu), frame)
+}
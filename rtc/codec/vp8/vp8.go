@@ -15,13 +15,22 @@ func Codec() *codec.Codec {
 	return &codec.Codec{
 		MediaType:        rtc.MediaTypeVideo,
 		EncoderName:      CodecName,
+		ClockRate:        90000,
+		PayloadType:      96,
+		RTX:              97,
 		SupportKeyFrame:  true,
 		SupportSimulcast: true,
 		SupportSVC:       false,
 		Process:          parsePayload,
+		NewDepacketizer:  newDepacketizer,
+		NewPacketizer:    newPacketizer,
 	}
 }
 
+func newDepacketizer() codec.Depacketizer {
+	return new(depacketizer)
+}
+
 type vp8PayloadDescriptor struct {
 	isFirstPacketInFrame bool
 
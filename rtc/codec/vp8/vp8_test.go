@@ -2,10 +2,13 @@ package vp8
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/hex"
 	"io"
 	"os"
 	"testing"
+
+	"github.com/gotolive/sfu/rtc/codec"
 )
 
 func TestVP8PayloadDescriptor_IsKeyFrame(t *testing.T) {
@@ -37,3 +40,44 @@ func TestVP8PayloadDescriptor_IsKeyFrame(t *testing.T) {
 		}
 	}
 }
+
+func TestDepacketizer(t *testing.T) {
+	depacketizer, err := codec.NewDepacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no depacketizer:", err)
+	}
+	// the descriptors without optional fields.
+	data, err := depacketizer.Depacketize([][]byte{{0x10, 1, 2}, {0x00, 3}})
+	if err != nil {
+		t.Fatal("depacketize fail:", err)
+	}
+	if !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Fatal("wrong frame:", data)
+	}
+}
+
+func TestPacketizer(t *testing.T) {
+	packetizer, err := codec.NewPacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no packetizer:", err)
+	}
+	depacketizer, _ := codec.NewDepacketizer(CodecName)
+	// the P bit of frame tag is zero for keyframe.
+	for _, frame := range [][]byte{{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a, 1, 2, 3}, {0x11, 0x02, 0x00, 1, 2, 3, 4, 5, 6}} {
+		payloads := packetizer.Packetize(frame, 8)
+		if len(payloads) != 2 {
+			t.Fatal("wrong payloads:", len(payloads))
+		}
+		for i, payload := range payloads {
+			if len(payload) > 8 {
+				t.Fatal("payload too large:", len(payload))
+			}
+			if Codec().Process(payload).IsKeyFrame() != (i == 0 && frame[0]&0x01 == 0) {
+				t.Fatal("wrong keyframe:", i)
+			}
+		}
+		if data, err := depacketizer.Depacketize(payloads); err != nil || !bytes.Equal(data, frame) {
+			t.Fatal("wrong frame:", data, err)
+		}
+	}
+}
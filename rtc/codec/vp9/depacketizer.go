@@ -0,0 +1,20 @@
+package vp9
+
+import (
+	"github.com/pion/rtp/codecs"
+)
+
+// depacketizer strips the payload descriptors and joins the rest.
+type depacketizer struct{}
+
+func (d *depacketizer) Depacketize(payloads [][]byte) ([]byte, error) {
+	var data []byte
+	for _, payload := range payloads {
+		p, err := (&codecs.VP9Packet{}).Unmarshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, p...)
+	}
+	return data, nil
+}
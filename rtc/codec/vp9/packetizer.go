@@ -0,0 +1,69 @@
+package vp9
+
+import (
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec"
+)
+
+const (
+	descriptorSize = 3
+	frameMarker    = 2
+)
+
+func newPacketizer() codec.Packetizer {
+	return &packetizer{pictureID: uint16(rtc.GenerateSSRC()) & 0x7fff}
+}
+
+// packetizer writes the descriptor of non-flexible mode without layer indices, the P bit is
+// decided by the frame type, so the keyframe could be detected by parsePayload.
+type packetizer struct {
+	pictureID uint16
+}
+
+func (p *packetizer) Packetize(frame []byte, mtu int) [][]byte {
+	size := mtu - descriptorSize
+	if size <= 0 || len(frame) == 0 {
+		return nil
+	}
+	// I bit, and P bit for inter frame.
+	flags := byte(0x80)
+	if !isKeyFrame(frame) {
+		flags |= 0x40
+	}
+	var payloads [][]byte
+	for offset := 0; offset < len(frame); offset += size {
+		end := min(offset+size, len(frame))
+		descriptor := flags
+		if offset == 0 {
+			descriptor |= 0x08
+		}
+		if end == len(frame) {
+			descriptor |= 0x04
+		}
+		payload := make([]byte, 0, descriptorSize+end-offset)
+		payload = append(payload, descriptor, 0x80|byte(p.pictureID>>8), byte(p.pictureID))
+		payloads = append(payloads, append(payload, frame[offset:end]...))
+	}
+	p.pictureID = (p.pictureID + 1) & 0x7fff
+	return payloads
+}
+
+// isKeyFrame reads the frame type of the uncompressed header, see vp9 bitstream spec 6.2.
+func isKeyFrame(frame []byte) bool {
+	r := codec.NewBitReader(frame)
+	marker, _ := r.ReadBits(2)
+	low, _ := r.ReadFlag()
+	high, _ := r.ReadFlag()
+	if marker != frameMarker {
+		return false
+	}
+	if low && high {
+		// reserved zero of profile 3
+		_ = r.Skip(1)
+	}
+	if showExistingFrame, _ := r.ReadFlag(); showExistingFrame {
+		return false
+	}
+	interFrame, err := r.ReadFlag()
+	return err == nil && !interFrame
+}
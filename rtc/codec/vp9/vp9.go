@@ -15,13 +15,23 @@ func Codec() *codec.Codec {
 	return &codec.Codec{
 		MediaType:        rtc.MediaTypeVideo,
 		EncoderName:      CodecName,
+		ClockRate:        90000,
+		PayloadType:      98,
+		RTX:              99,
+		Parameters:       map[string]string{"profile-id": "0"},
 		SupportKeyFrame:  true,
 		SupportSimulcast: false,
 		SupportSVC:       true,
 		Process:          parsePayload,
+		NewDepacketizer:  newDepacketizer,
+		NewPacketizer:    newPacketizer,
 	}
 }
 
+func newDepacketizer() codec.Depacketizer {
+	return new(depacketizer)
+}
+
 /**
 https://datatracker.ietf.org/doc/html/draft-ietf-payload-vp9#section-4.2
 
@@ -2,10 +2,13 @@ package vp9
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/hex"
 	"io"
 	"os"
 	"testing"
+
+	"github.com/gotolive/sfu/rtc/codec"
 )
 
 func TestVp9PayloadDescriptor_IsKeyFrame(t *testing.T) {
@@ -37,3 +40,49 @@ func TestVp9PayloadDescriptor_IsKeyFrame(t *testing.T) {
 		}
 	}
 }
+
+func TestDepacketizer(t *testing.T) {
+	depacketizer, err := codec.NewDepacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no depacketizer:", err)
+	}
+	// the descriptors without optional fields.
+	data, err := depacketizer.Depacketize([][]byte{{0x08, 1, 2}, {0x04, 3}})
+	if err != nil {
+		t.Fatal("depacketize fail:", err)
+	}
+	if !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Fatal("wrong frame:", data)
+	}
+}
+
+func TestPacketizer(t *testing.T) {
+	packetizer, err := codec.NewPacketizer(CodecName)
+	if err != nil {
+		t.Fatal("no packetizer:", err)
+	}
+	// frame marker, profile 0, not show existing frame, and the frame type.
+	key := []byte{0x80, 0x49, 0x83, 0x42, 0x00, 1, 2}
+	inter := []byte{0x84, 0x00, 1, 2, 3, 4, 5}
+	var pictureID uint16
+	for _, frame := range [][]byte{key, inter} {
+		depacketizer, _ := codec.NewDepacketizer(CodecName)
+		payloads := packetizer.Packetize(frame, 6)
+		if len(payloads) != 3 {
+			t.Fatal("wrong payloads:", len(payloads))
+		}
+		for i, payload := range payloads {
+			if Codec().Process(payload).IsKeyFrame() != (i == 0 && frame[0] == 0x80) {
+				t.Fatal("wrong keyframe:", i)
+			}
+		}
+		if data, err := depacketizer.Depacketize(payloads); err != nil || !bytes.Equal(data, frame) {
+			t.Fatal("wrong frame:", data, err)
+		}
+		id := uint16(payloads[0][1]&0x7f)<<8 | uint16(payloads[0][2])
+		if pictureID != 0 && id != pictureID+1 {
+			t.Fatal("picture id should increase:", id)
+		}
+		pictureID = id
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
+	"errors"
 	"math/big"
 	"strings"
 	"time"
@@ -51,6 +53,29 @@ func NewCertManager(unique bool) (CertificateGenerator, error) {
 	return &defaultCertificateGenerator{cert: cert}, nil
 }
 
+// NewStaticCertManager uses the cert for all requests, e.g. the cert loaded by LoadCertificate.
+func NewStaticCertManager(cert *Certificate) CertificateGenerator {
+	return &defaultCertificateGenerator{cert: cert}
+}
+
+// LoadCertificate loads the cert and key from the PEM files, the key must be of RSA or ECDSA.
+func LoadCertificate(certFile, keyFile string) (*Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	switch pair.PrivateKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{privateKey: pair.PrivateKey, x509Cert: cert}, nil
+}
+
 func generateCertificate() (*Certificate, error) {
 	secretKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -1,6 +1,10 @@
 package dtls
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -43,6 +47,38 @@ func TestCertificateGenerator(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "load certificate",
+			method: func(t *testing.T) {
+				c, err := generateCertificate()
+				if err != nil {
+					t.Fatal("err should be nil:", err)
+				}
+				key, err := x509.MarshalPKCS8PrivateKey(c.privateKey)
+				if err != nil {
+					t.Fatal("err should be nil:", err)
+				}
+				dir := t.TempDir()
+				certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+				if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.x509Cert.Raw}), 0o600); err != nil {
+					t.Fatal("err should be nil:", err)
+				}
+				if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+					t.Fatal("err should be nil:", err)
+				}
+				loaded, err := LoadCertificate(certFile, keyFile)
+				if err != nil {
+					t.Fatal("err should be nil:", err)
+				}
+				cg := NewStaticCertManager(loaded)
+				if cg.GenerateCertificate().Fingerprints()[0] != c.Fingerprints()[0] {
+					t.Error("we expected the fingerprint of the loaded cert")
+				}
+				if _, err = LoadCertificate(keyFile, certFile); err == nil {
+					t.Error("we expected an error of the swapped files")
+				}
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, test.method)
@@ -0,0 +1,127 @@
+package dtls
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/pion/dtls/v2"
+)
+
+// dataConnQueueBytes is twice the sctp receive window of peer.sctpMaxReceiveBufferSize, a sctp peer never has more data
+// in flight than the window, so it's dropped only if the peer ignores the window.
+const dataConnQueueBytes = 2 * 1024 * 1024
+
+// dataConn carries the application data of dtls, e.g. sctp.
+// The transport keeps reading the dtls conn to detect close_notify,
+// so the application data is dispatched to here instead of reading the dtls conn directly.
+type dataConn struct {
+	conn      *dtls.Conn
+	notify    chan struct{}
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	dropped   atomic.Int64
+
+	// protect queue and queued, the head of queue is kept until it's read.
+	mutex  sync.Mutex
+	queue  [][]byte
+	queued int
+}
+
+var _ net.Conn = new(dataConn)
+
+func newDataConn(conn *dtls.Conn) *dataConn {
+	return &dataConn{
+		conn:    conn,
+		notify:  make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+	}
+}
+
+// push never blocks the transport read loop, which blocks the ice if it's blocked.
+// The packet is dropped if more than dataConnQueueBytes are not read, sctp will retransmit it.
+// The drops are logged at the power of two counts to avoid flooding.
+func (c *dataConn) push(data []byte) {
+	c.mutex.Lock()
+	if c.queued+len(data) > dataConnQueueBytes {
+		c.mutex.Unlock()
+		if n := c.dropped.Add(1); n&(n-1) == 0 {
+			logger.Warn("dtls data queue full, dropped:", n)
+		}
+		return
+	}
+	b := make([]byte, len(data))
+	copy(b, data)
+	c.queue = append(c.queue, b)
+	c.queued += len(b)
+	c.mutex.Unlock()
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Read returns io.ErrShortBuffer if b could not hold the record, the record is kept for the next Read.
+func (c *dataConn) Read(b []byte) (int, error) {
+	for {
+		c.mutex.Lock()
+		if len(c.queue) != 0 {
+			data := c.queue[0]
+			if len(b) < len(data) {
+				c.mutex.Unlock()
+				return 0, io.ErrShortBuffer
+			}
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.queued -= len(data)
+			c.mutex.Unlock()
+			return copy(b, data), nil
+		}
+		c.mutex.Unlock()
+		select {
+		case <-c.notify:
+		case <-c.closeCh:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (c *dataConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closeCh:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	return c.conn.Write(b)
+}
+
+// Close only stops the reader, the dtls conn is managed by transport.
+func (c *dataConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+	return nil
+}
+
+func (c *dataConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *dataConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *dataConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *dataConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *dataConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
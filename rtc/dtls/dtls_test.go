@@ -1 +1,239 @@
 package dtls
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// newTestTransports returns two transports talk to each other.
+func newTestTransports(t *testing.T, roleA, roleB string) (*Transport, *Transport, chan int, chan int) {
+	cg, err := NewCertManager(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	t.Cleanup(func() {
+		_ = w1.Close()
+		_ = w2.Close()
+	})
+	stateA, stateB := make(chan int, 10), make(chan int, 10)
+	a := NewDtlsTransport(Option{
+		Reader:      r2,
+		Writer:      w1,
+		Role:        roleA,
+		Certificate: cg.GenerateCertificate(),
+		OnState:     func(state int) { stateA <- state },
+	})
+	b := NewDtlsTransport(Option{
+		Reader:      r1,
+		Writer:      w2,
+		Role:        roleB,
+		Certificate: cg.GenerateCertificate(),
+		OnState:     func(state int) { stateB <- state },
+	})
+	return a, b, stateA, stateB
+}
+
+func waitState(t *testing.T, ch chan int, expected int) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case state := <-ch:
+			if state == expected {
+				return
+			}
+			if state == Failed {
+				t.Fatal("transport failed")
+			}
+		case <-timeout:
+			t.Fatal("wait state timeout:", expected)
+		}
+	}
+}
+
+func TestNegotiateRole(t *testing.T) {
+	tests := []struct {
+		remote   string
+		expected string
+		err      error
+	}{
+		{Actpass, Active, nil},
+		{Active, Passive, nil},
+		{Passive, Active, nil},
+		{"holdconn", "", ErrInvalidRole},
+		{"", "", ErrInvalidRole},
+	}
+	for _, test := range tests {
+		role, err := NegotiateRole(test.remote)
+		if role != test.expected || !errors.Is(err, test.err) {
+			t.Errorf("remote %s: expected %s %v, got %s %v", test.remote, test.expected, test.err, role, err)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name   string
+		method func(*testing.T)
+	}{
+		{
+			name: "set remote role",
+			method: func(t *testing.T) {
+				a, b, _, _ := newTestTransports(t, Actpass, Active)
+				if err := a.SetRemoteRole(Actpass); !errors.Is(err, ErrInvalidRole) {
+					t.Error("answer must not be actpass:", err)
+				}
+				if err := a.SetRemoteRole(Passive); err != nil || a.Role() != Active {
+					t.Error("we should be active:", err, a.Role())
+				}
+				if err := a.SetRemoteRole(Active); !errors.Is(err, ErrRoleNegotiated) {
+					t.Error("role already negotiated:", err)
+				}
+				if err := b.SetRemoteRole(Passive); !errors.Is(err, ErrRoleNegotiated) {
+					t.Error("only actpass could be negotiated:", err)
+				}
+			},
+		},
+		{
+			name: "set remote fingerprint",
+			method: func(t *testing.T) {
+				a, b, stateA, stateB := newTestTransports(t, Passive, Active)
+				if err := b.SetRemoteFingerprint(&a.GetLocalFingerprints()[0]); err != nil {
+					t.Fatal(err)
+				}
+				a.TryRun()
+				b.TryRun()
+				waitState(t, stateA, Connected)
+				waitState(t, stateB, Connected)
+				if err := b.SetRemoteFingerprint(nil); !errors.Is(err, ErrHandshakeStart) {
+					t.Error("handshake already started:", err)
+				}
+
+				c, d, _, stateD := newTestTransports(t, Passive, Active)
+				if err := d.SetRemoteFingerprint(&Fingerprint{Algorithm: "sha-256", Value: "00"}); err != nil {
+					t.Fatal(err)
+				}
+				c.TryRun()
+				d.TryRun()
+				for state := range stateD {
+					if state == Failed {
+						break
+					}
+					if state == Connected {
+						t.Fatal("the fingerprint should not match")
+					}
+				}
+			},
+		},
+		{
+			name: "actpass without answer",
+			method: func(t *testing.T) {
+				a, b, stateA, stateB := newTestTransports(t, Actpass, Active)
+				if err := a.TryRun(); !errors.Is(err, ErrRoleUnresolved) {
+					t.Fatal("handshake should wait for the answer:", err)
+				}
+				if a.GetState() != New || a.Role() != Actpass {
+					t.Fatal("role should not be guessed:", a.GetState(), a.Role())
+				}
+				if err := a.SetRemoteRole(Active); err != nil {
+					t.Fatal(err)
+				}
+				if err := a.TryRun(); err != nil {
+					t.Fatal(err)
+				}
+				if err := b.TryRun(); err != nil {
+					t.Fatal(err)
+				}
+				waitState(t, stateA, Connected)
+				waitState(t, stateB, Connected)
+				if a.Role() != Passive {
+					t.Error("actpass should be passive:", a.Role())
+				}
+			},
+		},
+		{
+			name: "application data",
+			method: func(t *testing.T) {
+				a, b, stateA, stateB := newTestTransports(t, Passive, Active)
+				if a.DataConn() != nil {
+					t.Error("data conn should be nil before connected")
+				}
+				a.TryRun()
+				b.TryRun()
+				waitState(t, stateA, Connected)
+				waitState(t, stateB, Connected)
+				if _, err := a.DataConn().Write([]byte("hello")); err != nil {
+					t.Fatal(err)
+				}
+				// the record is kept if the buffer is too small.
+				if _, err := b.DataConn().Read(make([]byte, 2)); !errors.Is(err, io.ErrShortBuffer) {
+					t.Fatal("expected short buffer:", err)
+				}
+				buf := make([]byte, 100)
+				n, err := b.DataConn().Read(buf)
+				if err != nil || string(buf[:n]) != "hello" {
+					t.Fatal("read fail:", err, string(buf[:n]))
+				}
+				_ = a.Close()
+				if _, err = b.DataConn().Read(buf); !errors.Is(err, io.EOF) {
+					t.Error("data conn should be closed after close_notify:", err)
+				}
+			},
+		},
+		{
+			name: "data dropped",
+			method: func(t *testing.T) {
+				a, b, stateA, stateB := newTestTransports(t, Passive, Active)
+				a.TryRun()
+				b.TryRun()
+				waitState(t, stateA, Connected)
+				waitState(t, stateB, Connected)
+				// nobody reads the data conn of b.
+				record := make([]byte, 1000)
+				for i := 0; i < dataConnQueueBytes/len(record)+10; i++ {
+					if _, err := a.DataConn().Write(record); err != nil {
+						t.Fatal(err)
+					}
+				}
+				deadline := time.Now().Add(5 * time.Second)
+				for b.DataDropped() != 10 {
+					if time.Now().After(deadline) {
+						t.Fatal("the drops should be counted:", b.DataDropped())
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			},
+		},
+		{
+			name: "close notify",
+			method: func(t *testing.T) {
+				a, b, stateA, stateB := newTestTransports(t, Passive, Active)
+				a.TryRun()
+				b.TryRun()
+				waitState(t, stateA, Connected)
+				waitState(t, stateB, Connected)
+				if err := b.Close(); err != nil {
+					t.Fatal(err)
+				}
+				waitState(t, stateA, Closed)
+				if a.GetState() != Closed || b.GetState() != Closed {
+					t.Error("both should be closed")
+				}
+				if err := b.Close(); !errors.Is(err, ErrTransportClosed) {
+					t.Error("close twice:", err)
+				}
+				select {
+				case state := <-stateB:
+					t.Error("closed by us should not emit state:", state)
+				default:
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, test.method)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/gotolive/sfu/rtc/logger"
 	"github.com/pion/dtls/v2"
@@ -27,6 +28,28 @@ const (
 	Active  = "active"  // client
 )
 
+var (
+	ErrInvalidRole     = errors.New("invalid dtls role")
+	ErrRoleNegotiated  = errors.New("dtls role already negotiated")
+	ErrTransportClosed = errors.New("dtls transport closed")
+	ErrHandshakeStart  = errors.New("dtls handshake already started")
+	ErrRoleUnresolved  = errors.New("dtls role not negotiated")
+)
+
+// NegotiateRole returns our role when we answer an offer with the remote role, see rfc8842#section-5.
+// The offerer must use actpass, but we still accept the legacy active/passive offers.
+// We prefer active when the offer leaves the choice to us, as rfc8842#section-5.3 recommended.
+func NegotiateRole(remote string) (string, error) {
+	switch remote {
+	case Actpass, Passive:
+		return Active, nil
+	case Active:
+		return Passive, nil
+	default:
+		return "", ErrInvalidRole
+	}
+}
+
 type Transport struct {
 	state                 int
 	dtlsConn              *dtls.Conn
@@ -36,29 +59,128 @@ type Transport struct {
 	conn                  net.Conn
 	onState               func(int)
 	cert                  *Certificate
+	srtpOption            SrtpOption
+	data                  *dataConn
+	mutex                 sync.Mutex // protect state, role and remoteFingerprint
 }
 
 // it could be called more than once, that is the reason try.
-// but clearly we did not handle it yet.
-func (t *Transport) TryRun() {
+// If we offered actpass, it returns ErrRoleUnresolved until the answer given by SetRemoteRole,
+// guessing the role deadlocks with the answerer picked the same one.
+func (t *Transport) TryRun() error {
+	t.mutex.Lock()
 	if t.state != New {
-		return
+		t.mutex.Unlock()
+		return nil
+	}
+	if t.role == Actpass {
+		t.mutex.Unlock()
+		return ErrRoleUnresolved
 	}
 	t.state = Connecting
+	t.mutex.Unlock()
 	t.onState(Connecting)
 
 	// we can not wait handshake done or make handshake sync.
 	// This method will be called in read packet goroutine, it will block next dtls data read.
 	go func() {
 		err := t.handshake()
+		t.mutex.Lock()
+		if t.state == Closed {
+			t.mutex.Unlock()
+			// closed during handshake
+			if err == nil {
+				_ = t.dtlsConn.Close()
+			}
+			return
+		}
 		if err != nil {
+			t.state = Failed
+			t.mutex.Unlock()
 			logger.Error("Something wrong:", err)
 			t.onState(Failed)
-		} else {
-			t.state = Connected
-			t.onState(Connected)
+			return
 		}
+		t.state = Connected
+		t.data = newDataConn(t.dtlsConn)
+		t.mutex.Unlock()
+		t.onState(Connected)
+		go t.readLoop()
 	}()
+	return nil
+}
+
+// SetRemoteRole resolves our role by the answer, it only works when we offered actpass,
+// and must be called before the handshake start.
+func (t *Transport) SetRemoteRole(remote string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.role != Actpass || t.state != New {
+		return ErrRoleNegotiated
+	}
+	switch remote {
+	case Active:
+		t.role = Passive
+	case Passive:
+		t.role = Active
+	default:
+		// the answerer must not answer actpass, rfc8842#section-5.3
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+// SetRemoteFingerprint sets the fingerprint of the answer, it must be called before the handshake start.
+func (t *Transport) SetRemoteFingerprint(fingerprint *Fingerprint) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.state != New {
+		return ErrHandshakeStart
+	}
+	t.remoteFingerprint = fingerprint
+	return nil
+}
+
+// readLoop dispatches the application data and waits for close_notify.
+// the read fails after the remote close_notify, or the underlying reader closed.
+func (t *Transport) readLoop() {
+	buf := make([]byte, 8192)
+	for {
+		n, err := t.dtlsConn.Read(buf)
+		if err != nil {
+			logger.Debug("dtls read stop:", err)
+			break
+		}
+		t.data.push(buf[:n])
+	}
+	_ = t.data.Close()
+	t.mutex.Lock()
+	if t.state != Connected {
+		t.mutex.Unlock()
+		return
+	}
+	t.state = Closed
+	t.mutex.Unlock()
+	t.onState(Closed)
+}
+
+// Close sends close_notify to remote, the Closed state won't be emitted since we close it.
+func (t *Transport) Close() error {
+	t.mutex.Lock()
+	if t.state == Closed {
+		t.mutex.Unlock()
+		return ErrTransportClosed
+	}
+	t.state = Closed
+	conn, data := t.dtlsConn, t.data
+	t.mutex.Unlock()
+	if data != nil {
+		_ = data.Close()
+	}
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
 }
 
 func (t *Transport) handshake() error {
@@ -66,8 +188,8 @@ func (t *Transport) handshake() error {
 		dtlsConn *dtls.Conn
 		err      error
 	)
-	switch t.role {
-	case Active, Actpass:
+	switch t.Role() {
+	case Active:
 		dtlsConn, err = dtls.Client(t.conn, &dtls.Config{
 			Certificates: []tls.Certificate{
 				{
@@ -82,7 +204,7 @@ func (t *Transport) handshake() error {
 			LoggerFactory:      logging.NewDefaultLoggerFactory(),
 			InsecureSkipVerify: true,
 		})
-	case Passive:
+	default:
 		dtlsConn, err = dtls.Server(t.conn, &dtls.Config{
 			Certificates: []tls.Certificate{
 				{
@@ -102,7 +224,9 @@ func (t *Transport) handshake() error {
 		logger.Error("something wrong:", err)
 		return err
 	}
+	t.mutex.Lock()
 	t.dtlsConn = dtlsConn
+	t.mutex.Unlock()
 	// to here handshake already done
 	srtpProfile, ok := dtlsConn.SelectedSRTPProtectionProfile()
 	if !ok {
@@ -127,17 +251,6 @@ func (t *Transport) handshake() error {
 		return err
 	}
 
-	t.dtlsConn = dtlsConn
-
-	go func() {
-		// it will be called when dtls receive close notify, but not always,
-		buf := make([]byte, 2048)
-		_, err := t.dtlsConn.Read(buf)
-		if err != nil {
-			t.onState(Closed)
-		}
-	}()
-
 	return nil
 }
 
@@ -175,6 +288,8 @@ func (t *Transport) GetLocalFingerprints() []Fingerprint {
 }
 
 func (t *Transport) GetState() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	return t.state
 }
 
@@ -182,7 +297,29 @@ func (t *Transport) DtlsConn() *dtls.Conn {
 	return t.dtlsConn
 }
 
+// DataConn is the application data channel over dtls, it's nil before connected.
+func (t *Transport) DataConn() net.Conn {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.data == nil {
+		return nil
+	}
+	return t.data
+}
+
+// DataDropped counts the application data dropped since the reader of DataConn is too slow.
+func (t *Transport) DataDropped() int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.data == nil {
+		return 0
+	}
+	return t.data.dropped.Load()
+}
+
 func (t *Transport) Role() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	return t.role
 }
 
@@ -193,6 +330,7 @@ type Option struct {
 	OnState      func(int)
 	Fingerprints *Fingerprint
 	Certificate  *Certificate
+	Srtp         SrtpOption
 }
 
 // normally if chrome generate offer it will be actpass
@@ -207,6 +345,7 @@ func NewDtlsTransport(option Option) *Transport {
 		state:             New,
 		role:              option.Role,
 		remoteFingerprint: option.Fingerprints,
+		srtpOption:        option.Srtp,
 	}
 	return t
 }
@@ -0,0 +1,139 @@
+package dtls
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/srtp/v2"
+)
+
+// the crypto suites of a=crypto, see rfc4568#section-6.2 and rfc7714#section-14.2.
+const (
+	SuiteAES128CMHmacSha1_80 = "AES_CM_128_HMAC_SHA1_80"
+	SuiteAES128CMHmacSha1_32 = "AES_CM_128_HMAC_SHA1_32"
+	SuiteAEADAES128GCM       = "AEAD_AES_128_GCM"
+)
+
+// the session params of a=crypto we know, see rfc4568#section-6.3.
+const (
+	sdesParamKDR = "KDR"
+	sdesParamWSH = "WSH"
+)
+
+var (
+	ErrUnsupportedSuite     = errors.New("unsupported sdes crypto suite")
+	ErrInvalidSdesKey       = errors.New("invalid sdes key length")
+	ErrUnsupportedSdesParam = errors.New("unsupported sdes parameter")
+)
+
+// SdesOption keys the srtp session by sdes instead of dtls, see rfc4568.
+// The keys are the inline key of a=crypto, master key concatenated with master salt.
+type SdesOption struct {
+	Suite     string
+	LocalKey  []byte // we send it in our a=crypto
+	RemoteKey []byte // remote sends it in its a=crypto
+	// RemoteMKI and RemoteParams are the mki and the session params of the remote a=crypto,
+	// the session fails if they are not supported, see CheckSdesCrypto.
+	RemoteMKI    string
+	RemoteParams []string
+	SrtpOption
+}
+
+type sdesSuite struct {
+	profile srtp.ProtectionProfile
+	keyLen  int
+	saltLen int
+}
+
+var sdesSuites = map[string]sdesSuite{
+	SuiteAES128CMHmacSha1_80: {profile: srtp.ProtectionProfileAes128CmHmacSha1_80, keyLen: 16, saltLen: 14},
+	SuiteAES128CMHmacSha1_32: {profile: srtp.ProtectionProfileAes128CmHmacSha1_32, keyLen: 16, saltLen: 14},
+	SuiteAEADAES128GCM:       {profile: srtp.ProtectionProfileAeadAes128Gcm, keyLen: 16, saltLen: 12},
+}
+
+// GenerateSdesKey returns a random inline key for the suite.
+func GenerateSdesKey(suite string) ([]byte, error) {
+	s, ok := sdesSuites[suite]
+	if !ok {
+		return nil, ErrUnsupportedSuite
+	}
+	key := make([]byte, s.keyLen+s.saltLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// CheckSdesCrypto returns an error if the a=crypto of remote is not supported, so the answerer could
+// pick another one or reject it instead of failing the authentication of every packet.
+// We support neither mki nor the key derivation rate, nor the unencrypted or unauthenticated srtp.
+func CheckSdesCrypto(suite, mki string, params []string) error {
+	if _, ok := sdesSuites[suite]; !ok {
+		return ErrUnsupportedSuite
+	}
+	_, err := parseSdesParams(mki, params)
+	return err
+}
+
+// parseSdesParams returns the window size hint of the params, see rfc4568#section-6.3.7.
+func parseSdesParams(mki string, params []string) (uint, error) {
+	// the mki is in every packet, pion could not parse it.
+	if mki != "" {
+		return 0, fmt.Errorf("%w: mki %s", ErrUnsupportedSdesParam, mki)
+	}
+	var wsh uint
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		switch name {
+		case sdesParamKDR:
+			// zero is the default, the session keys are derived once.
+			if value != "0" {
+				return 0, fmt.Errorf("%w: %s", ErrUnsupportedSdesParam, param)
+			}
+		case sdesParamWSH:
+			size, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || size < DefaultSrtpReplayWindow {
+				return 0, fmt.Errorf("%w: %s", ErrUnsupportedSdesParam, param)
+			}
+			wsh = uint(size)
+		default:
+			// UNENCRYPTED_SRTCP, UNENCRYPTED_SRTP, UNAUTHENTICATED_SRTP, FEC_ORDER, FEC_KEY and the unknown ones.
+			return 0, fmt.Errorf("%w: %s", ErrUnsupportedSdesParam, param)
+		}
+	}
+	return wsh, nil
+}
+
+// NewSdesSrtpSession starts a srtp session from the keys exchanged in sdp.
+// The window size hint of the remote enlarges the srtp replay window.
+func NewSdesSrtpSession(option *SdesOption) (*SrtpSession, error) {
+	s, ok := sdesSuites[option.Suite]
+	if !ok {
+		return nil, ErrUnsupportedSuite
+	}
+	if len(option.LocalKey) != s.keyLen+s.saltLen || len(option.RemoteKey) != s.keyLen+s.saltLen {
+		return nil, ErrInvalidSdesKey
+	}
+	wsh, err := parseSdesParams(option.RemoteMKI, option.RemoteParams)
+	if err != nil {
+		return nil, err
+	}
+	srtpOption := option.SrtpOption
+	if wsh > srtpOption.replayWindow() && !srtpOption.DisableReplayProtection {
+		srtpOption.SrtpReplayWindow = wsh
+	}
+	return newSrtpSession(&srtpConfig{Cryptex: option.Cryptex, ReplayWindow: srtpOption.replayWindow(), RtcpReplayWindow: srtpOption.rtcpReplayWindow(), Config: srtp.Config{
+		Profile: s.profile,
+		Keys: srtp.SessionKeys{
+			LocalMasterKey:   option.LocalKey[:s.keyLen],
+			LocalMasterSalt:  option.LocalKey[s.keyLen:],
+			RemoteMasterKey:  option.RemoteKey[:s.keyLen],
+			RemoteMasterSalt: option.RemoteKey[s.keyLen:],
+		},
+		LocalOptions:  srtpOption.localOptions(),
+		RemoteOptions: srtpOption.remoteOptions(),
+	}})
+}
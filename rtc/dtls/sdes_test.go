@@ -0,0 +1,123 @@
+package dtls
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+func TestSdesSrtpSession(t *testing.T) {
+	raw, err := (&rtp.Packet{
+		Header:  rtp.Header{Version: 2, SSRC: 1000, SequenceNumber: 1, PayloadType: 96},
+		Payload: []byte{1, 2, 3, 4},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawRtcp, err := (&rtcp.PictureLossIndication{MediaSSRC: 1000}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, suite := range []string{SuiteAES128CMHmacSha1_80, SuiteAES128CMHmacSha1_32, SuiteAEADAES128GCM} {
+		t.Run(suite, func(t *testing.T) {
+			localKey, err := GenerateSdesKey(suite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			remoteKey, err := GenerateSdesKey(suite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := NewSdesSrtpSession(&SdesOption{Suite: suite, LocalKey: localKey, RemoteKey: remoteKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewSdesSrtpSession(&SdesOption{Suite: suite, LocalKey: remoteKey, RemoteKey: localKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted, _, err := a.EncryptRtp(nil, raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := b.DecryptSrtp(nil, encrypted)
+			if err != nil || !bytes.Equal(decrypted, raw) {
+				t.Fatal("decrypt rtp fail:", err)
+			}
+			encrypted, _, err = b.EncryptRtcp(nil, rawRtcp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err = a.DecryptSrtcp(nil, encrypted)
+			if err != nil || !bytes.Equal(decrypted, rawRtcp) {
+				t.Fatal("decrypt rtcp fail:", err)
+			}
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := GenerateSdesKey("F8_128_HMAC_SHA1_80"); !errors.Is(err, ErrUnsupportedSuite) {
+			t.Fatal("expected unsupported suite, got:", err)
+		}
+		key, _ := GenerateSdesKey(SuiteAEADAES128GCM)
+		if _, err := NewSdesSrtpSession(&SdesOption{Suite: SuiteAES128CMHmacSha1_80, LocalKey: key, RemoteKey: key}); !errors.Is(err, ErrInvalidSdesKey) {
+			t.Fatal("expected invalid key, got:", err)
+		}
+		if _, err := NewSdesSrtpSession(&SdesOption{Suite: SuiteAEADAES128GCM, LocalKey: key, RemoteKey: key, RemoteMKI: "1:4"}); !errors.Is(err, ErrUnsupportedSdesParam) {
+			t.Fatal("expected unsupported mki, got:", err)
+		}
+	})
+	t.Run("params", func(t *testing.T) {
+		tests := []struct {
+			suite  string
+			mki    string
+			params []string
+			err    error
+		}{
+			{SuiteAES128CMHmacSha1_80, "", nil, nil},
+			{SuiteAES128CMHmacSha1_80, "", []string{"KDR=0", "WSH=128"}, nil},
+			{"F8_128_HMAC_SHA1_80", "", nil, ErrUnsupportedSuite},
+			{SuiteAES128CMHmacSha1_80, "1:4", nil, ErrUnsupportedSdesParam},
+			{SuiteAES128CMHmacSha1_80, "", []string{"KDR=24"}, ErrUnsupportedSdesParam},
+			{SuiteAES128CMHmacSha1_80, "", []string{"WSH=32"}, ErrUnsupportedSdesParam},
+			{SuiteAES128CMHmacSha1_80, "", []string{"UNENCRYPTED_SRTCP"}, ErrUnsupportedSdesParam},
+			{SuiteAES128CMHmacSha1_80, "", []string{"UNENCRYPTED_SRTP"}, ErrUnsupportedSdesParam},
+			{SuiteAES128CMHmacSha1_80, "", []string{"UNAUTHENTICATED_SRTP"}, ErrUnsupportedSdesParam},
+		}
+		for _, test := range tests {
+			if err := CheckSdesCrypto(test.suite, test.mki, test.params); !errors.Is(err, test.err) {
+				t.Errorf("%s %s %v: expected %v, got %v", test.suite, test.mki, test.params, test.err, err)
+			}
+		}
+	})
+	t.Run("window size hint", func(t *testing.T) {
+		localKey, _ := GenerateSdesKey(SuiteAES128CMHmacSha1_80)
+		remoteKey, _ := GenerateSdesKey(SuiteAES128CMHmacSha1_80)
+		sender, err := NewSdesSrtpSession(&SdesOption{Suite: SuiteAES128CMHmacSha1_80, LocalKey: localKey, RemoteKey: remoteKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiver, err := NewSdesSrtpSession(&SdesOption{
+			Suite: SuiteAES128CMHmacSha1_80, LocalKey: remoteKey, RemoteKey: localKey, RemoteParams: []string{"WSH=256"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the packet 100 behind is out of the default window, but in the hinted one.
+		for _, seq := range []uint16{200, 100} {
+			packet, err := (&rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1000, SequenceNumber: seq}, Payload: []byte{1}}).Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted, _, err := sender.EncryptRtp(nil, packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = receiver.DecryptSrtp(nil, encrypted); err != nil {
+				t.Fatal("decrypt fail:", seq, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,534 @@
+package dtls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // srtp requires sha1
+	"encoding/binary"
+	"errors"
+	"hash"
+	"sync/atomic"
+
+	"github.com/pion/srtp/v2"
+	"github.com/pion/transport/v2/replaydetector"
+)
+
+// key derivation labels, see rfc3711#section-4.3.2 and rfc6904#section-4.3
+const (
+	labelRTPEncryption       = 0x00
+	labelRTPAuthentication   = 0x01
+	labelRTPSalt             = 0x02
+	labelRTPHeaderEncryption = 0x06
+	labelRTPHeaderSalt       = 0x07
+)
+
+const (
+	rtpHeaderLength   = 12
+	rtpExtensionHead  = 4
+	maxROC            = 0xffffffff
+	maxSequenceNumber = 0xffff
+	maxSrtpStreams    = 1024 // the ssrcs kept of a direction
+	hmacTagLength     = 10   // SRTP_AES128_CM_HMAC_SHA1_80
+	aeadTagLength     = 16   // SRTP_AEAD_AES_128_GCM
+
+	oneByteProfile = 0xbede
+	twoByteProfile = 0x1000 // low 4 bits are appbits
+
+	// rfc9335#section-5
+	cryptexOneByteProfile = 0xc0de
+	cryptexTwoByteProfile = 0xc2de
+)
+
+var (
+	ErrUnsupportedProfile = errors.New("unsupported srtp profile for header encryption")
+	errShortPacket        = errors.New("rtp packet too short")
+)
+
+// headerExtensionSet is the set of header extension ids need to be encrypted by rfc6904.
+type headerExtensionSet [256]bool
+
+// rtpCipher is a srtp context for rtp only. pion does not support rfc6904 nor rfc9335,
+// so the rtp goes here instead once they are negotiated. The keys and the wire format
+// are exactly the same as pion without the header encryption. rtcp still uses pion.
+type rtpCipher struct {
+	profile srtp.ProtectionProfile
+	block   cipher.Block
+	aead    cipher.AEAD
+	auth    hash.Hash
+	salt    []byte
+
+	headerBlock cipher.Block
+	headerSalt  []byte
+
+	// cryptex is only for encrypt, decrypt follows the extension profile of the packet.
+	cryptex atomic.Bool
+	// encrypted is nil if no header extension need to be encrypted.
+	encrypted atomic.Pointer[headerExtensionSet]
+
+	states *rocStates
+}
+
+type rocState struct {
+	roc      uint32
+	seq      uint16
+	started  bool
+	used     uint64
+	detector replaydetector.ReplayDetector
+}
+
+// guess the roc of given seq, see rfc3711#appendix-A
+func (s *rocState) guess(seq uint16) uint32 {
+	if !s.started {
+		return s.roc
+	}
+	diff := int32(seq) - int32(s.seq)
+	switch {
+	case diff < -(maxSequenceNumber/2) && s.roc != maxROC:
+		return s.roc + 1
+	case diff > maxSequenceNumber/2 && s.roc != 0:
+		return s.roc - 1
+	}
+	return s.roc
+}
+
+func (s *rocState) update(seq uint16, roc uint32) {
+	if !s.started || roc > s.roc || (roc == s.roc && seq > s.seq) {
+		s.roc, s.seq, s.started = roc, seq, true
+	}
+}
+
+// check returns false if the packet is replayed, accept should be called after it's authenticated.
+func (s *rocState) check(seq uint16, roc uint32) (accept func(), ok bool) {
+	if s.detector == nil {
+		return func() {}, true
+	}
+	return s.detector.Check(uint64(roc)<<16 | uint64(seq))
+}
+
+// rocStates are the rocs and the replay detectors of the ssrcs of a direction. They are shared by pion
+// and rtpCipher, so the rtp switched to rtpCipher keeps them. Only the authenticated ssrcs are kept,
+// at most maxSrtpStreams, the forged packets could not grow it.
+type rocStates struct {
+	states      map[uint32]*rocState
+	clock       uint64
+	newDetector func() replaydetector.ReplayDetector
+}
+
+func newRocStates(replayWindow uint) *rocStates {
+	r := &rocStates{states: map[uint32]*rocState{}}
+	if replayWindow != 0 {
+		r.newDetector = func() replaydetector.ReplayDetector {
+			return replaydetector.New(replayWindow, maxROC<<16|maxSequenceNumber)
+		}
+	}
+	return r
+}
+
+// get returns the state of ssrc, or a new one which is not kept until update.
+func (r *rocStates) get(ssrc uint32) (*rocState, bool) {
+	if s, ok := r.states[ssrc]; ok {
+		return s, true
+	}
+	s := &rocState{}
+	if r.newDetector != nil {
+		s.detector = r.newDetector()
+	}
+	return s, false
+}
+
+// update is called after the packet is authenticated, the new state is kept
+// and the least recently used one is dropped if there are too many.
+func (r *rocStates) update(ssrc uint32, s *rocState, found bool, seq uint16, roc uint32) {
+	s.update(seq, roc)
+	r.clock++
+	s.used = r.clock
+	if found {
+		return
+	}
+	if len(r.states) >= maxSrtpStreams {
+		oldest, used := uint32(0), ^uint64(0)
+		for id, state := range r.states {
+			if state.used < used {
+				oldest, used = id, state.used
+			}
+		}
+		delete(r.states, oldest)
+	}
+	r.states[ssrc] = s
+}
+
+func newRTPCipher(profile srtp.ProtectionProfile, masterKey, masterSalt []byte, states *rocStates) (*rtpCipher, error) {
+	c := &rtpCipher{
+		profile: profile,
+		states:  states,
+	}
+	key, err := deriveKey(labelRTPEncryption, masterKey, masterSalt, len(masterKey))
+	if err != nil {
+		return nil, err
+	}
+	if c.block, err = aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	if c.salt, err = deriveKey(labelRTPSalt, masterKey, masterSalt, len(masterSalt)); err != nil {
+		return nil, err
+	}
+	switch profile {
+	case srtp.ProtectionProfileAes128CmHmacSha1_80:
+		authKey, err := deriveKey(labelRTPAuthentication, masterKey, masterSalt, sha1.Size)
+		if err != nil {
+			return nil, err
+		}
+		c.auth = hmac.New(sha1.New, authKey)
+	case srtp.ProtectionProfileAeadAes128Gcm:
+		if c.aead, err = cipher.NewGCM(c.block); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedProfile
+	}
+	headerKey, err := deriveKey(labelRTPHeaderEncryption, masterKey, masterSalt, len(masterKey))
+	if err != nil {
+		return nil, err
+	}
+	if c.headerBlock, err = aes.NewCipher(headerKey); err != nil {
+		return nil, err
+	}
+	if c.headerSalt, err = deriveKey(labelRTPHeaderSalt, masterKey, masterSalt, len(masterSalt)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setEncryptedHeaderExtensions could be called any time, the next packet will use it.
+func (c *rtpCipher) setEncryptedHeaderExtensions(ids []uint8) {
+	if len(ids) == 0 {
+		c.encrypted.Store(nil)
+		return
+	}
+	set := new(headerExtensionSet)
+	for _, id := range ids {
+		set[id] = true
+	}
+	c.encrypted.Store(set)
+}
+
+func (c *rtpCipher) tagLength() int {
+	if c.aead != nil {
+		return aeadTagLength
+	}
+	return hmacTagLength
+}
+
+// rtpLayout is the position of each part in a rtp packet.
+type rtpLayout struct {
+	csrcEnd   int // end of csrc list, rtpHeaderLength if none
+	extStart  int // start of extension data, after the 4 bytes extension header
+	extEnd    int // end of extension data, equal to extStart if none
+	extension bool
+	profile   uint16
+}
+
+func parseLayout(packet []byte) (rtpLayout, error) {
+	var l rtpLayout
+	if len(packet) < rtpHeaderLength {
+		return l, errShortPacket
+	}
+	l.csrcEnd = rtpHeaderLength + int(packet[0]&0x0f)*4
+	l.extStart, l.extEnd = l.csrcEnd, l.csrcEnd
+	if packet[0]&0x10 == 0 {
+		return l, nil
+	}
+	if len(packet) < l.csrcEnd+rtpExtensionHead {
+		return l, errShortPacket
+	}
+	l.extension = true
+	l.profile = binary.BigEndian.Uint16(packet[l.csrcEnd:])
+	l.extStart = l.csrcEnd + rtpExtensionHead
+	l.extEnd = l.extStart + int(binary.BigEndian.Uint16(packet[l.csrcEnd+2:]))*4
+	if len(packet) < l.extEnd {
+		return l, errShortPacket
+	}
+	return l, nil
+}
+
+// cryptex returns true if the extension profile is of rfc9335.
+func (l rtpLayout) cryptex() bool {
+	return l.extension && (l.profile == cryptexOneByteProfile || l.profile&0xfff0 == cryptexTwoByteProfile&0xfff0)
+}
+
+// isCryptex returns true if the srtp packet is encrypted by rfc9335.
+func isCryptex(packet []byte) bool {
+	l, err := parseLayout(packet)
+	return err == nil && l.cryptex()
+}
+
+// encryptedRegions are the parts need to be encrypted, in keystream order.
+func (l rtpLayout) encryptedRegions(packet []byte, end int, cryptex bool) [][]byte {
+	if !cryptex {
+		return [][]byte{packet[l.extEnd:end]}
+	}
+	return [][]byte{packet[rtpHeaderLength:l.csrcEnd], packet[l.extStart:l.extEnd], packet[l.extEnd:end]}
+}
+
+func (c *rtpCipher) encrypt(dst, plaintext []byte) ([]byte, error) {
+	l, err := parseLayout(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	cryptex := c.cryptex.Load() && (l.extension || l.csrcEnd > rtpHeaderLength)
+	size := len(plaintext) + c.tagLength()
+	if cryptex && !l.extension {
+		// csrc only, we need an empty extension to carry the cryptex profile.
+		size += rtpExtensionHead
+	}
+	out := growBuffer(dst, size)
+	if cryptex && !l.extension {
+		copy(out, plaintext[:l.csrcEnd])
+		out[0] |= 0x10
+		binary.BigEndian.PutUint32(out[l.csrcEnd:], cryptexOneByteProfile<<16)
+		copy(out[l.csrcEnd+rtpExtensionHead:], plaintext[l.csrcEnd:])
+		l.extension, l.profile = true, oneByteProfile
+		l.extStart = l.csrcEnd + rtpExtensionHead
+		l.extEnd = l.extStart
+	} else {
+		copy(out, plaintext)
+	}
+	end := size - c.tagLength()
+	seq := binary.BigEndian.Uint16(out[2:])
+	ssrc := binary.BigEndian.Uint32(out[8:])
+	state, found := c.states.get(ssrc)
+	roc := state.guess(seq)
+
+	switch {
+	case cryptex:
+		if err = setCryptexProfile(out, l); err != nil {
+			return nil, err
+		}
+	case l.extension:
+		c.xorHeaderExtensions(out, l, ssrc, roc, seq)
+	}
+
+	if c.aead != nil {
+		regions := l.encryptedRegions(out, end, cryptex)
+		aad := c.aad(out, l, cryptex)
+		sealed := c.aead.Seal(nil, c.aeadIV(ssrc, roc, seq), concat(regions), aad)
+		scatter(regions, sealed)
+		copy(out[end:], sealed[len(sealed)-aeadTagLength:])
+	} else {
+		stream := cipher.NewCTR(c.block, counter(c.salt, ssrc, roc, seq))
+		for _, r := range l.encryptedRegions(out, end, cryptex) {
+			stream.XORKeyStream(r, r)
+		}
+		copy(out[end:], c.hmacTag(out[:end], roc))
+	}
+	c.states.update(ssrc, state, found, seq, roc)
+	return out, nil
+}
+
+func (c *rtpCipher) decrypt(dst, encrypted []byte) ([]byte, error) {
+	end := len(encrypted) - c.tagLength()
+	if end < rtpHeaderLength {
+		return nil, errShortPacket
+	}
+	l, err := parseLayout(encrypted[:end])
+	if err != nil {
+		return nil, err
+	}
+	cryptex := l.cryptex()
+	seq := binary.BigEndian.Uint16(encrypted[2:])
+	ssrc := binary.BigEndian.Uint32(encrypted[8:])
+	// the state is kept only after the packet is authenticated.
+	state, found := c.states.get(ssrc)
+	roc := state.guess(seq)
+	accept, ok := state.check(seq, roc)
+	if !ok {
+		return nil, ErrSrtpReplayed
+	}
+
+	out := growBuffer(dst, end)
+	copy(out, encrypted[:end])
+	if c.aead != nil {
+		regions := l.encryptedRegions(out, end, cryptex)
+		sealed := append(concat(regions), encrypted[end:]...)
+		opened, err := c.aead.Open(nil, c.aeadIV(ssrc, roc, seq), sealed, c.aad(encrypted, l, cryptex))
+		if err != nil {
+			return nil, ErrSrtpAuthFailed
+		}
+		scatter(regions, opened)
+	} else {
+		if !hmac.Equal(c.hmacTag(encrypted[:end], roc), encrypted[end:]) {
+			return nil, ErrSrtpAuthFailed
+		}
+		stream := cipher.NewCTR(c.block, counter(c.salt, ssrc, roc, seq))
+		for _, r := range l.encryptedRegions(out, end, cryptex) {
+			stream.XORKeyStream(r, r)
+		}
+	}
+	accept()
+	c.states.update(ssrc, state, found, seq, roc)
+
+	switch {
+	case cryptex:
+		restoreProfile(out, l)
+	case l.extension:
+		c.xorHeaderExtensions(out, l, ssrc, roc, seq)
+	}
+	return out, nil
+}
+
+// aad for gcm, rfc7714#section-8.2 and rfc9335#section-5.2
+func (c *rtpCipher) aad(packet []byte, l rtpLayout, cryptex bool) []byte {
+	if !cryptex {
+		return packet[:l.extEnd]
+	}
+	aad := make([]byte, 0, rtpHeaderLength+rtpExtensionHead)
+	aad = append(aad, packet[:rtpHeaderLength]...)
+	return append(aad, packet[l.csrcEnd:l.extStart]...)
+}
+
+// rfc7714#section-8.1
+func (c *rtpCipher) aeadIV(ssrc, roc uint32, seq uint16) []byte {
+	iv := make([]byte, 12)
+	binary.BigEndian.PutUint32(iv[2:], ssrc)
+	binary.BigEndian.PutUint32(iv[6:], roc)
+	binary.BigEndian.PutUint16(iv[10:], seq)
+	for i := range iv {
+		iv[i] ^= c.salt[i]
+	}
+	return iv
+}
+
+func (c *rtpCipher) hmacTag(authenticated []byte, roc uint32) []byte {
+	c.auth.Reset()
+	c.auth.Write(authenticated)
+	var rocRaw [4]byte
+	binary.BigEndian.PutUint32(rocRaw[:], roc)
+	c.auth.Write(rocRaw[:])
+	return c.auth.Sum(nil)[:hmacTagLength]
+}
+
+// xorHeaderExtensions encrypts or decrypts the elements in the set, rfc6904#section-4.
+// Only the element data is encrypted, the ids, lengths and paddings stay in the clear.
+func (c *rtpCipher) xorHeaderExtensions(packet []byte, l rtpLayout, ssrc, roc uint32, seq uint16) {
+	set := c.encrypted.Load()
+	if set == nil {
+		return
+	}
+	data := packet[l.extStart:l.extEnd]
+	mask := make([]byte, len(data))
+	var masked bool
+	for i := 0; i < len(data); {
+		var id, length, head int
+		switch {
+		case l.profile == oneByteProfile:
+			id, length, head = int(data[i]>>4), int(data[i]&0x0f)+1, 1
+			if id == 15 {
+				i = len(data)
+				continue
+			}
+		case l.profile&0xfff0 == twoByteProfile && i+1 < len(data):
+			id, length, head = int(data[i]), int(data[i+1]), 2
+		default:
+			// unknown profile, we don't touch it.
+			return
+		}
+		if id == 0 {
+			// padding
+			i++
+			continue
+		}
+		if set[id] {
+			for j := i + head; j < i+head+length && j < len(data); j++ {
+				mask[j] = 0xff
+				masked = true
+			}
+		}
+		i += head + length
+	}
+	if !masked {
+		return
+	}
+	keystream := make([]byte, len(data))
+	cipher.NewCTR(c.headerBlock, counter(c.headerSalt, ssrc, roc, seq)).XORKeyStream(keystream, keystream)
+	for i := range data {
+		data[i] ^= keystream[i] & mask[i]
+	}
+}
+
+func setCryptexProfile(packet []byte, l rtpLayout) error {
+	switch {
+	case l.profile == oneByteProfile:
+		binary.BigEndian.PutUint16(packet[l.csrcEnd:], cryptexOneByteProfile)
+	case l.profile&0xfff0 == twoByteProfile:
+		binary.BigEndian.PutUint16(packet[l.csrcEnd:], cryptexTwoByteProfile)
+	default:
+		return ErrUnsupportedProfile
+	}
+	return nil
+}
+
+func restoreProfile(packet []byte, l rtpLayout) {
+	if l.profile == cryptexOneByteProfile {
+		binary.BigEndian.PutUint16(packet[l.csrcEnd:], oneByteProfile)
+	} else {
+		binary.BigEndian.PutUint16(packet[l.csrcEnd:], twoByteProfile)
+	}
+}
+
+// counter is the aes-cm iv, rfc3711#section-4.1.1
+// IV = (k_s * 2^16) XOR (SSRC * 2^64) XOR (i * 2^16)
+func counter(salt []byte, ssrc, roc uint32, seq uint16) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, salt)
+	var index [12]byte
+	binary.BigEndian.PutUint32(index[0:], ssrc)
+	binary.BigEndian.PutUint32(index[4:], roc)
+	binary.BigEndian.PutUint16(index[8:], seq)
+	for i := 0; i < 10; i++ {
+		iv[4+i] ^= index[i]
+	}
+	return iv
+}
+
+// deriveKey is the aes-cm prf of rfc3711#section-4.3.3, kdr is always zero.
+func deriveKey(label byte, masterKey, masterSalt []byte, outLen int) ([]byte, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	in := make([]byte, aes.BlockSize)
+	copy(in, masterSalt)
+	in[7] ^= label
+	out := make([]byte, (outLen+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	for i := 0; i*aes.BlockSize < outLen; i++ {
+		binary.BigEndian.PutUint16(in[aes.BlockSize-2:], uint16(i))
+		block.Encrypt(out[i*aes.BlockSize:], in)
+	}
+	return out[:outLen], nil
+}
+
+func growBuffer(buf []byte, size int) []byte {
+	if cap(buf) >= size {
+		return buf[:size]
+	}
+	return make([]byte, size)
+}
+
+func concat(regions [][]byte) []byte {
+	var n int
+	for _, r := range regions {
+		n += len(r)
+	}
+	out := make([]byte, 0, n+aeadTagLength)
+	for _, r := range regions {
+		out = append(out, r...)
+	}
+	return out
+}
+
+func scatter(regions [][]byte, data []byte) {
+	for _, r := range regions {
+		data = data[copy(r, data):]
+	}
+}
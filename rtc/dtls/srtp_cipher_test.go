@@ -0,0 +1,352 @@
+package dtls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
+)
+
+func testPacket(t *testing.T, seq uint16, csrc []uint32, extensions map[uint8][]byte, twoByte bool) []byte {
+	header := rtp.Header{Version: 2, SSRC: 1000, SequenceNumber: seq, PayloadType: 96, CSRC: csrc}
+	for id, payload := range extensions {
+		if header.ExtensionProfile == 0 {
+			header.Extension = true
+			header.ExtensionProfile = oneByteProfile
+			if twoByte {
+				header.ExtensionProfile = twoByteProfile
+			}
+		}
+		if err := header.SetExtension(id, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := (&rtp.Packet{Header: header, Payload: []byte{1, 2, 3, 4, 5, 6, 7, 8}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func newTestCiphers(t *testing.T, profile srtp.ProtectionProfile) (*rtpCipher, *rtpCipher, *srtp.Context) {
+	key := bytes.Repeat([]byte{5}, 16)
+	salt := bytes.Repeat([]byte{6}, 14)
+	if profile == srtp.ProtectionProfileAeadAes128Gcm {
+		salt = salt[:12]
+	}
+	local, err := newRTPCipher(profile, key, salt, newRocStates(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := newRTPCipher(profile, key, salt, newRocStates(DefaultSrtpReplayWindow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	context, err := srtp.CreateContext(key, salt, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return local, remote, context
+}
+
+func TestRTPCipher(t *testing.T) {
+	profiles := map[string]srtp.ProtectionProfile{
+		"hmac": srtp.ProtectionProfileAes128CmHmacSha1_80,
+		"aead": srtp.ProtectionProfileAeadAes128Gcm,
+	}
+	extensions := map[uint8][]byte{1: {0xaa}, 3: {0xbb, 0xbb, 0xbb}}
+	tests := []struct {
+		name   string
+		method func(*testing.T, srtp.ProtectionProfile)
+	}{
+		{
+			name: "same as pion without header encryption",
+			method: func(t *testing.T, profile srtp.ProtectionProfile) {
+				local, remote, context := newTestCiphers(t, profile)
+				for _, seq := range []uint16{65534, 65535, 0, 1} {
+					raw := testPacket(t, seq, []uint32{1}, extensions, false)
+					ours, err := local.encrypt(nil, raw)
+					if err != nil {
+						t.Fatal(err)
+					}
+					theirs, err := context.EncryptRTP(nil, raw, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(ours, theirs) {
+						t.Fatal("encrypted packet should be the same as pion, seq:", seq)
+					}
+					decrypted, err := remote.decrypt(nil, theirs)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decrypted, raw) {
+						t.Fatal("decrypted packet mismatch, seq:", seq)
+					}
+				}
+			},
+		},
+		{
+			name: "rfc6904",
+			method: func(t *testing.T, profile srtp.ProtectionProfile) {
+				for _, twoByte := range []bool{false, true} {
+					local, remote, _ := newTestCiphers(t, profile)
+					local.setEncryptedHeaderExtensions([]uint8{3})
+					remote.setEncryptedHeaderExtensions([]uint8{3})
+					raw := testPacket(t, 1, nil, extensions, twoByte)
+					encrypted, err := local.encrypt(nil, raw)
+					if err != nil {
+						t.Fatal(err)
+					}
+					packet := &rtp.Packet{}
+					if err = packet.Unmarshal(encrypted[:len(encrypted)-local.tagLength()]); err != nil {
+						t.Fatal("header should be parsable:", err)
+					}
+					if !bytes.Equal(packet.GetExtension(1), []byte{0xaa}) {
+						t.Fatal("extension 1 should be in the clear")
+					}
+					if bytes.Equal(packet.GetExtension(3), []byte{0xbb, 0xbb, 0xbb}) {
+						t.Fatal("extension 3 should be encrypted")
+					}
+					decrypted, err := remote.decrypt(nil, encrypted)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decrypted, raw) {
+						t.Fatal("decrypted packet mismatch")
+					}
+				}
+			},
+		},
+		{
+			name: "cryptex",
+			method: func(t *testing.T, profile srtp.ProtectionProfile) {
+				cases := []struct {
+					csrc       []uint32
+					extensions map[uint8][]byte
+					twoByte    bool
+					profile    uint16
+				}{
+					{nil, extensions, false, cryptexOneByteProfile},
+					{[]uint32{7, 8}, extensions, true, cryptexTwoByteProfile},
+					{[]uint32{7}, nil, false, cryptexOneByteProfile},
+				}
+				for _, c := range cases {
+					local, remote, _ := newTestCiphers(t, profile)
+					local.cryptex.Store(true)
+					raw := testPacket(t, 1, c.csrc, c.extensions, c.twoByte)
+					encrypted, err := local.encrypt(nil, raw)
+					if err != nil {
+						t.Fatal(err)
+					}
+					l, err := parseLayout(encrypted)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !l.extension || l.profile != c.profile {
+						t.Fatalf("profile should be %x, got %x", c.profile, l.profile)
+					}
+					if len(c.csrc) > 0 && bytes.Equal(encrypted[rtpHeaderLength:l.csrcEnd], raw[rtpHeaderLength:l.csrcEnd]) {
+						t.Fatal("csrc should be encrypted")
+					}
+					decrypted, err := remote.decrypt(nil, encrypted)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if c.extensions == nil {
+						// the empty extension we added stays there.
+						packet := &rtp.Packet{}
+						if err = packet.Unmarshal(decrypted); err != nil {
+							t.Fatal(err)
+						}
+						if !packet.Extension || len(packet.CSRC) != 1 || !bytes.Equal(packet.Payload, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+							t.Fatal("decrypted packet mismatch")
+						}
+						continue
+					}
+					if !bytes.Equal(decrypted, raw) {
+						t.Fatal("decrypted packet mismatch")
+					}
+				}
+			},
+		},
+		{
+			name: "replay and auth",
+			method: func(t *testing.T, profile srtp.ProtectionProfile) {
+				local, remote, _ := newTestCiphers(t, profile)
+				local.cryptex.Store(true)
+				encrypted, err := local.encrypt(nil, testPacket(t, 1, nil, extensions, false))
+				if err != nil {
+					t.Fatal(err)
+				}
+				tampered := append([]byte{}, encrypted...)
+				tampered[len(tampered)-1] ^= 0xff
+				if _, err = remote.decrypt(nil, tampered); !errors.Is(err, ErrSrtpAuthFailed) {
+					t.Fatal("expected auth error, got:", err)
+				}
+				if _, err = remote.decrypt(nil, encrypted); err != nil {
+					t.Fatal(err)
+				}
+				if _, err = remote.decrypt(nil, encrypted); !errors.Is(err, ErrSrtpReplayed) {
+					t.Fatal("expected replay error, got:", err)
+				}
+			},
+		},
+		{
+			name: "only authenticated ssrcs kept",
+			method: func(t *testing.T, profile srtp.ProtectionProfile) {
+				local, remote, _ := newTestCiphers(t, profile)
+				raw := testPacket(t, 1, nil, nil, false)
+				for ssrc := uint32(0); ssrc <= maxSrtpStreams; ssrc++ {
+					binary.BigEndian.PutUint32(raw[8:], ssrc)
+					encrypted, err := local.encrypt(nil, raw)
+					if err != nil {
+						t.Fatal(err)
+					}
+					forged := append([]byte{}, encrypted...)
+					binary.BigEndian.PutUint32(forged[8:], ssrc+maxSrtpStreams+1)
+					if _, err = remote.decrypt(nil, forged); !errors.Is(err, ErrSrtpAuthFailed) {
+						t.Fatal("expected auth error, got:", err)
+					}
+					if _, err = remote.decrypt(nil, encrypted); err != nil {
+						t.Fatal(err)
+					}
+				}
+				// the least recently used one is dropped.
+				if len(remote.states.states) != maxSrtpStreams || remote.states.states[0] != nil {
+					t.Fatal("states mismatch:", len(remote.states.states))
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		for name, profile := range profiles {
+			profile := profile
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				test.method(t, profile)
+			})
+		}
+	}
+}
+
+func fromHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestRTPCipherVectors checks the test vectors of rfc6904 appendix A and rfc9335 appendix A.
+func TestRTPCipherVectors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method func(*testing.T)
+	}{
+		{
+			name: "rfc6904 key derivation",
+			method: func(t *testing.T) {
+				key, salt := fromHex(t, "e1f97a0d3e018be0d64fa32c06de4139"), fromHex(t, "0ec675ad498afeebb6960b3aabe6")
+				vectors := []struct {
+					label    byte
+					expected string
+				}{
+					{labelRTPEncryption, "c61e7a93744f39ee10734afe3ff7a087"},
+					{labelRTPAuthentication, "cebe321f6ff7716b6fd4ab49af256a156d38baa4"},
+					{labelRTPSalt, "30cbbc08863d8c85d49db34a9ae1"},
+					{labelRTPHeaderEncryption, "549752054d6fb708622c4a2e596a1b93"},
+					{labelRTPHeaderSalt, "ab01818174c40d39a3781f7c2d27"},
+				}
+				for _, v := range vectors {
+					derived, err := deriveKey(v.label, key, salt, len(v.expected)/2)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if hex.EncodeToString(derived) != v.expected {
+						t.Fatalf("label %d expected %s, got %x", v.label, v.expected, derived)
+					}
+				}
+			},
+		},
+		{
+			name: "rfc9335",
+			method: func(t *testing.T) {
+				suites := []struct {
+					profile   srtp.ProtectionProfile
+					key, salt string
+					packets   [][2]string // decrypted, encrypted
+				}{
+					// A.1, AES-CTR
+					{srtp.ProtectionProfileAes128CmHmacSha1_80, "e1f97a0d3e018be0d64fa32c06de4139", "0ec675ad498afeebb6960b3aabe6", [][2]string{
+						{"900f1235decafbadcafebabebede000151000200abababababababababababababababab", "900f1235decafbadcafebabec0de0001eb92365251c3e036f8de27e9c27ee3e0b4651d9fbc4218a70244522f34a5"},
+						{"900f1236decafbadcafebabe1000000105020002abababababababababababababababab", "900f1236decafbadcafebabec2de00014ed9cc4e6a712b3096c5ca77339d4204ce0d77396cab69585fbce38194a5"},
+						{"920f1238decafbadcafebabe0001e2400000b26ebede000151000200abababababababababababababababab", "920f1238decafbadcafebabe8bb6e12b5cff16ddc0de000192838c8c09e58393e1de3a9a74734d6745671338c3acf11da2df8423bee0"},
+						{"920f1239decafbadcafebabe0001e2400000b26e1000000105020002abababababababababababababababab", "920f1239decafbadcafebabef70e513eb90b9b25c2de0001bbed4848faa644665f3d7f34125914e9f4d0ae923c6f479b95a0f7b53133"},
+						{"920f123adecafbadcafebabe0001e2400000b26ebede0000abababababababababababababababab", "920f123adecafbadcafebabe7130b6abfe2ab0e3c0de0000e3d9f64b25c9e74cb4cf8e43fb92e3781c2c0ceab6b3a499a14c"},
+						{"920f123bdecafbadcafebabe0001e2400000b26e10000000abababababababababababababababab", "920f123bdecafbadcafebabecbf24c124330e1c8c2de0000599dd45bc9d687b603e8b59d771fd38e88b170e0cd31e125eabe"},
+					}},
+					// A.2, AES-GCM
+					{srtp.ProtectionProfileAeadAes128Gcm, "000102030405060708090a0b0c0d0e0f", "a0a1a2a3a4a5a6a7a8a9aaab", [][2]string{
+						{"900f1235decafbadcafebabebede000151000200abababababababababababababababab", "900f1235decafbadcafebabec0de000139972dc9572c4d99e8fc355de743fb2e94f9d8ff54e72f4193bbc5c74ffab0fa9fa0fbeb"},
+						{"900f1236decafbadcafebabe1000000105020002abababababababababababababababab", "900f1236decafbadcafebabec2de0001bb75a4c545cd1f413bdb7daa2b1e3263de313667c963249081b35a65f5cb6c88b394235f"},
+						{"920f1238decafbadcafebabe0001e2400000b26ebede000151000200abababababababababababababababab", "920f1238decafbadcafebabe63bbccc4a7f695c4c0de00018ad7c71fac70a80c92866b4c6ba98546ef913586e95ffaaffe956885bb0647a8bc094ac8"},
+						{"920f1239decafbadcafebabe0001e2400000b26e1000000105020002abababababababababababababababab", "920f1239decafbadcafebabe3680524f8d312b00c2de0001c78d120038422bc111a7187a18246f980c059cc6bc9df8b626394eca344e4b05d80fea83"},
+						{"920f123adecafbadcafebabe0001e2400000b26ebede0000abababababababababababababababab", "920f123adecafbadcafebabe15b6bb4337906fffc0de0000b7b964537a2b03ab7ba5389ce93317126b5d974df30c6884dcb651c5e120c1da"},
+						{"920f123bdecafbadcafebabe0001e2400000b26e10000000abababababababababababababababab", "920f123bdecafbadcafebabedcb38c9e48bf95f4c2de000061ee432cf920317076613258d3ce4236c06ac429681ad08413512dc98b5207d8"},
+					}},
+				}
+				for _, suite := range suites {
+					for i, p := range suite.packets {
+						local, err := newRTPCipher(suite.profile, fromHex(t, suite.key), fromHex(t, suite.salt), newRocStates(0))
+						if err != nil {
+							t.Fatal(err)
+						}
+						local.cryptex.Store(true)
+						remote, err := newRTPCipher(suite.profile, fromHex(t, suite.key), fromHex(t, suite.salt), newRocStates(DefaultSrtpReplayWindow))
+						if err != nil {
+							t.Fatal(err)
+						}
+						decrypted, expected := fromHex(t, p[0]), fromHex(t, p[1])
+						encrypted, err := local.encrypt(nil, decrypted)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(encrypted, expected) {
+							t.Fatalf("profile %d packet %d encrypted mismatch: %x", suite.profile, i, encrypted)
+						}
+						result, err := remote.decrypt(nil, expected)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(result, decrypted) {
+							t.Fatalf("profile %d packet %d decrypted mismatch: %x", suite.profile, i, result)
+						}
+					}
+					// the packet with csrcs but no extension gets the empty one-byte extension of A.x.5.
+					local, err := newRTPCipher(suite.profile, fromHex(t, suite.key), fromHex(t, suite.salt), newRocStates(0))
+					if err != nil {
+						t.Fatal(err)
+					}
+					local.cryptex.Store(true)
+					withExtension := fromHex(t, suite.packets[4][0])
+					withoutExtension := append([]byte{withExtension[0] &^ 0x10}, withExtension[1:20]...)
+					withoutExtension = append(withoutExtension, withExtension[24:]...)
+					encrypted, err := local.encrypt(nil, withoutExtension)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(encrypted, fromHex(t, suite.packets[4][1])) {
+						t.Fatalf("profile %d encrypted mismatch without extension: %x", suite.profile, encrypted)
+					}
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, test.method)
+	}
+}
@@ -1,32 +1,199 @@
 package dtls
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/pion/srtp/v2"
 )
 
+const (
+	srtcpHeaderLength   = 8 // the header and the ssrc are not encrypted
+	srtcpIndexLength    = 4
+	srtcpEncryptionFlag = 1 << 31
+)
+
+const (
+	// DefaultSrtpReplayWindow is the replay window used when SrtpOption.SrtpReplayWindow is zero.
+	DefaultSrtpReplayWindow = 64
+	// DefaultSrtcpReplayWindow is the replay window used when SrtpOption.SrtcpReplayWindow is zero.
+	DefaultSrtcpReplayWindow = 64
+)
+
+var (
+	ErrSrtpAuthFailed = errors.New("srtp auth failed")
+	ErrSrtpReplayed   = errors.New("srtp packet replayed")
+)
+
+// SrtpOption controls how the srtp contexts are created.
+// Replay protection only applies to the remote context, we never decrypt what we encrypted.
+type SrtpOption struct {
+	DisableReplayProtection bool
+	SrtpReplayWindow        uint // zero means DefaultSrtpReplayWindow
+	SrtcpReplayWindow       uint // zero means DefaultSrtcpReplayWindow
+	// Cryptex encrypts the csrcs and the whole header extensions by rfc9335,
+	// it should only be set when both sides negotiated a=cryptex, see also SrtpSession.SetCryptex.
+	Cryptex bool
+}
+
+// remoteOptions disables the replay protection of pion, SrtpSession checks it by the replay windows.
+func (o SrtpOption) remoteOptions() []srtp.ContextOption {
+	return []srtp.ContextOption{srtp.SRTPNoReplayProtection(), srtp.SRTCPNoReplayProtection()}
+}
+
+func (o SrtpOption) replayWindow() uint {
+	if o.DisableReplayProtection {
+		return 0
+	}
+	if o.SrtpReplayWindow == 0 {
+		return DefaultSrtpReplayWindow
+	}
+	return o.SrtpReplayWindow
+}
+
+func (o SrtpOption) rtcpReplayWindow() uint {
+	if o.DisableReplayProtection {
+		return 0
+	}
+	if o.SrtcpReplayWindow == 0 {
+		return DefaultSrtcpReplayWindow
+	}
+	return o.SrtcpReplayWindow
+}
+
+func (o SrtpOption) localOptions() []srtp.ContextOption {
+	return []srtp.ContextOption{srtp.SRTPNoReplayProtection(), srtp.SRTCPNoReplayProtection()}
+}
+
 type SrtpSession struct {
 	remoteContext *srtp.Context
 	localContext  *srtp.Context
+
+	// the rocs and the replay windows of the rtp, they are shared by pion and the ciphers.
+	remoteStates *rocStates
+	localStates  *rocStates
+	// the replay windows of the rtcp, the index is split to the roc and the seq.
+	remoteRtcpStates *rocStates
+
+	rtpTag, rtcpTag, rtcpAeadTag int
+
+	// remoteCipher and localCipher take the rtp from pion once the header encryption or cryptex
+	// is enabled, the states are kept. They are nil if the profile doesn't support it.
+	remoteCipher     *rtpCipher
+	localCipher      *rtpCipher
+	headerEncryption atomic.Bool
+}
+
+// SetEncryptedHeaderExtensions set the header extension ids negotiated with
+// urn:ietf:params:rtp-hdrext:encrypt, see rfc6904. It's safe to call in concurrent.
+func (s *SrtpSession) SetEncryptedHeaderExtensions(ids []uint8) error {
+	if s.localCipher == nil {
+		if len(ids) == 0 {
+			return nil
+		}
+		return ErrUnsupportedProfile
+	}
+	s.localCipher.setEncryptedHeaderExtensions(ids)
+	s.remoteCipher.setEncryptedHeaderExtensions(ids)
+	if len(ids) != 0 {
+		s.headerEncryption.Store(true)
+	}
+	return nil
+}
+
+// SetCryptex enables rfc9335 for the rtp we send, it's called once a=cryptex is negotiated
+// and safe to call in concurrent. The received rtp is decrypted by its extension profile either way.
+func (s *SrtpSession) SetCryptex(enabled bool) error {
+	if s.localCipher == nil {
+		if !enabled {
+			return nil
+		}
+		return ErrUnsupportedProfile
+	}
+	s.localCipher.cryptex.Store(enabled)
+	if enabled {
+		s.headerEncryption.Store(true)
+	}
+	return nil
 }
 
 // DecryptSrtp is not concurrent-safe, but it won't be called in concurrent.
+// The cryptex packets are decrypted by the cipher even if we have not enabled it.
 func (s *SrtpSession) DecryptSrtp(dst, data []byte) ([]byte, error) {
-	decrypted, err := s.remoteContext.DecryptRTP(dst, data, nil)
+	if s.remoteCipher != nil && (s.headerEncryption.Load() || isCryptex(data)) {
+		return s.remoteCipher.decrypt(dst, data)
+	}
+	header := rtp.Header{}
+	headerLength, err := header.Unmarshal(data)
 	if err != nil {
 		return nil, err
 	}
-	return decrypted, err
+	if len(data) < headerLength+s.rtpTag {
+		return nil, errShortPacket
+	}
+	seq := header.SequenceNumber
+	state, found := s.remoteStates.get(header.SSRC)
+	roc := state.guess(seq)
+	accept, ok := state.check(seq, roc)
+	if !ok {
+		return nil, ErrSrtpReplayed
+	}
+	decrypted, err := s.remoteContext.DecryptRTP(dst, data, &header)
+	if err != nil {
+		return nil, authFailed(err)
+	}
+	accept()
+	s.remoteStates.update(header.SSRC, state, found, seq, roc)
+	return decrypted, nil
 }
 
 // EncryptRtp is not concurrent-safe, but it won't be called in concurrent.
 func (s *SrtpSession) EncryptRtp(dst, packet []byte) ([]byte, int, error) {
-	data, err := s.localContext.EncryptRTP(dst, packet, nil)
-	return data, len(data), err
+	if s.localCipher != nil && s.headerEncryption.Load() {
+		data, err := s.localCipher.encrypt(dst, packet)
+		return data, len(data), err
+	}
+	header := rtp.Header{}
+	data, err := s.localContext.EncryptRTP(dst, packet, &header)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.localCipher != nil {
+		// the roc of pion, in case of the cipher takes it later.
+		state, found := s.localStates.get(header.SSRC)
+		s.localStates.update(header.SSRC, state, found, header.SequenceNumber, state.guess(header.SequenceNumber))
+	}
+	return data, len(data), nil
 }
 
 // DecryptSrtcp is not concurrent-safe, but it won't be called in concurrent.
 func (s *SrtpSession) DecryptSrtcp(dst, data []byte) ([]byte, error) {
-	return s.remoteContext.DecryptRTCP(dst, data, nil)
+	header := rtcp.Header{}
+	if err := header.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	if len(data) < srtcpHeaderLength+s.rtcpAeadTag+srtcpIndexLength+s.rtcpTag {
+		return nil, errShortPacket
+	}
+	ssrc := binary.BigEndian.Uint32(data[4:])
+	index := binary.BigEndian.Uint32(data[len(data)-s.rtcpTag-srtcpIndexLength:]) &^ srtcpEncryptionFlag
+	seq, roc := uint16(index), index>>16
+	state, found := s.remoteRtcpStates.get(ssrc)
+	accept, ok := state.check(seq, roc)
+	if !ok {
+		return nil, ErrSrtpReplayed
+	}
+	decrypted, err := s.remoteContext.DecryptRTCP(dst, data, &header)
+	if err != nil {
+		return nil, authFailed(err)
+	}
+	accept()
+	s.remoteRtcpStates.update(ssrc, state, found, seq, roc)
+	return decrypted, nil
 }
 
 // EncryptRtcp is not concurrent-safe, but it won't be called in concurrent.
@@ -35,23 +202,79 @@ func (s *SrtpSession) EncryptRtcp(dst, packet []byte) ([]byte, int, error) {
 	return data, len(data), err
 }
 
+// authFailed wraps the error of pion after the header, the length and the replay are checked by us.
+// pion/srtp v2 exports none of its errors, but then it fails only if the tag is not verified,
+// TestSrtpSession "pion errors" fails if it's no longer true.
+func authFailed(err error) error {
+	return fmt.Errorf("%w: %v", ErrSrtpAuthFailed, err)
+}
+
+// trailerLengths returns the lengths of the srtp trailers, rfc3711#section-3.1 and rfc7714#section-9.
+// The rtcp tag is after the index, the aead tag of rtcp is before it.
+func trailerLengths(profile srtp.ProtectionProfile) (rtpTag, rtcpTag, rtcpAeadTag int) {
+	switch profile {
+	case srtp.ProtectionProfileAes128CmHmacSha1_32:
+		return 4, hmacTagLength, 0
+	case srtp.ProtectionProfileAeadAes128Gcm, srtp.ProtectionProfileAeadAes256Gcm:
+		return aeadTagLength, 0, aeadTagLength
+	}
+	return hmacTagLength, hmacTagLength, 0
+}
+
 // NewSrtpSession Start a new srtp session from dtls transport key.
 func NewSrtpSession(transport *Transport) (*SrtpSession, error) {
-	config := srtp.Config{
-		Profile: transport.srtpProtectionProfile,
-	}
+	config := srtpConfig{Cryptex: transport.srtpOption.Cryptex, Config: srtp.Config{
+		Profile:       transport.srtpProtectionProfile,
+		LocalOptions:  transport.srtpOption.localOptions(),
+		RemoteOptions: transport.srtpOption.remoteOptions(),
+	}, ReplayWindow: transport.srtpOption.replayWindow(), RtcpReplayWindow: transport.srtpOption.rtcpReplayWindow()}
 	state := transport.dtlsConn.ConnectionState()
-	err := config.ExtractSessionKeysFromDTLS(&state, transport.role == Active)
+	err := config.ExtractSessionKeysFromDTLS(&state, transport.Role() == Active)
+	if err != nil {
+		return nil, err
+	}
+	return newSrtpSession(&config)
+}
+
+// srtpConfig is srtp.Config with the options pion doesn't have.
+type srtpConfig struct {
+	srtp.Config
+	Cryptex          bool
+	ReplayWindow     uint // zero means no replay protection
+	RtcpReplayWindow uint // zero means no replay protection
+}
+
+func newSrtpSession(config *srtpConfig) (*SrtpSession, error) {
+	remoteContext, err := srtp.CreateContext(config.Keys.RemoteMasterKey, config.Keys.RemoteMasterSalt, config.Profile, config.RemoteOptions...)
 	if err != nil {
 		return nil, err
 	}
-	remoteContext, err := srtp.CreateContext(config.Keys.RemoteMasterKey, config.Keys.RemoteMasterSalt, transport.srtpProtectionProfile, config.RemoteOptions...)
+	localContext, err := srtp.CreateContext(config.Keys.LocalMasterKey, config.Keys.LocalMasterSalt, config.Profile, config.LocalOptions...)
 	if err != nil {
 		return nil, err
 	}
-	localContext, err := srtp.CreateContext(config.Keys.LocalMasterKey, config.Keys.LocalMasterSalt, transport.srtpProtectionProfile, config.RemoteOptions...)
+	session := &SrtpSession{
+		remoteContext:    remoteContext,
+		localContext:     localContext,
+		remoteStates:     newRocStates(config.ReplayWindow),
+		localStates:      newRocStates(0),
+		remoteRtcpStates: newRocStates(config.RtcpReplayWindow),
+	}
+	session.rtpTag, session.rtcpTag, session.rtcpAeadTag = trailerLengths(config.Profile)
+	session.remoteCipher, err = newRTPCipher(config.Profile, config.Keys.RemoteMasterKey, config.Keys.RemoteMasterSalt, session.remoteStates)
+	if errors.Is(err, ErrUnsupportedProfile) {
+		if config.Cryptex {
+			return nil, err
+		}
+		return session, nil
+	} else if err != nil {
+		return nil, err
+	}
+	session.localCipher, err = newRTPCipher(config.Profile, config.Keys.LocalMasterKey, config.Keys.LocalMasterSalt, session.localStates)
 	if err != nil {
 		return nil, err
 	}
-	return &SrtpSession{remoteContext: remoteContext, localContext: localContext}, nil
+	session.localCipher.cryptex.Store(config.Cryptex)
+	session.headerEncryption.Store(config.Cryptex)
+	return session, nil
 }
@@ -0,0 +1,302 @@
+package dtls
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
+)
+
+// newTestSessions returns two sessions with mirrored keys, what a encrypts b could decrypt.
+func newTestSessions(t *testing.T, option SrtpOption) (*SrtpSession, *SrtpSession) {
+	key := bytes.Repeat([]byte{1}, 16)
+	salt := bytes.Repeat([]byte{2}, 14)
+	remoteKey := bytes.Repeat([]byte{3}, 16)
+	remoteSalt := bytes.Repeat([]byte{4}, 14)
+	a, err := newSrtpSession(&srtpConfig{Cryptex: option.Cryptex, ReplayWindow: option.replayWindow(), RtcpReplayWindow: option.rtcpReplayWindow(), Config: srtp.Config{
+		Profile: srtp.ProtectionProfileAes128CmHmacSha1_80,
+		Keys: srtp.SessionKeys{
+			LocalMasterKey: key, LocalMasterSalt: salt,
+			RemoteMasterKey: remoteKey, RemoteMasterSalt: remoteSalt,
+		},
+		LocalOptions:  option.localOptions(),
+		RemoteOptions: option.remoteOptions(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newSrtpSession(&srtpConfig{Cryptex: option.Cryptex, ReplayWindow: option.replayWindow(), RtcpReplayWindow: option.rtcpReplayWindow(), Config: srtp.Config{
+		Profile: srtp.ProtectionProfileAes128CmHmacSha1_80,
+		Keys: srtp.SessionKeys{
+			LocalMasterKey: remoteKey, LocalMasterSalt: remoteSalt,
+			RemoteMasterKey: key, RemoteMasterSalt: salt,
+		},
+		LocalOptions:  option.localOptions(),
+		RemoteOptions: option.remoteOptions(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
+
+func TestSrtpSession(t *testing.T) {
+	raw, err := (&rtp.Packet{
+		Header:  rtp.Header{Version: 2, SSRC: 1000, SequenceNumber: 1, PayloadType: 96},
+		Payload: []byte{1, 2, 3, 4},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		method func(*testing.T)
+	}{
+		{
+			name: "replayed packet",
+			method: func(t *testing.T) {
+				a, b := newTestSessions(t, SrtpOption{})
+				encrypted, _, err := a.EncryptRtp(nil, raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = b.DecryptSrtp(nil, encrypted); err != nil {
+					t.Fatal("first decrypt should be fine:", err)
+				}
+				if _, err = b.DecryptSrtp(nil, encrypted); !errors.Is(err, ErrSrtpReplayed) {
+					t.Fatal("expected replay error, got:", err)
+				}
+			},
+		},
+		{
+			name: "replay protection disabled",
+			method: func(t *testing.T) {
+				a, b := newTestSessions(t, SrtpOption{DisableReplayProtection: true})
+				encrypted, _, err := a.EncryptRtp(nil, raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < 2; i++ {
+					if _, err = b.DecryptSrtp(nil, encrypted); err != nil {
+						t.Fatal("decrypt should be fine:", err)
+					}
+				}
+			},
+		},
+		{
+			name: "auth failed",
+			method: func(t *testing.T) {
+				a, b := newTestSessions(t, SrtpOption{})
+				encrypted, _, err := a.EncryptRtp(nil, raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				encrypted[len(encrypted)-1] ^= 0xff
+				if _, err = b.DecryptSrtp(nil, encrypted); !errors.Is(err, ErrSrtpAuthFailed) {
+					t.Fatal("expected auth error, got:", err)
+				}
+			},
+		},
+		{
+			name: "srtcp errors",
+			method: func(t *testing.T) {
+				rawRtcp, err := (&rtcp.PictureLossIndication{MediaSSRC: 1000}).Marshal()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, suite := range []string{SuiteAES128CMHmacSha1_80, SuiteAEADAES128GCM} {
+					key, _ := GenerateSdesKey(suite)
+					a, err := NewSdesSrtpSession(&SdesOption{Suite: suite, LocalKey: key, RemoteKey: key})
+					if err != nil {
+						t.Fatal(err)
+					}
+					encrypted, _, err := a.EncryptRtcp(nil, rawRtcp)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err = a.DecryptSrtcp(nil, encrypted); err != nil {
+						t.Fatal("first decrypt should be fine:", suite, err)
+					}
+					if _, err = a.DecryptSrtcp(nil, encrypted); !errors.Is(err, ErrSrtpReplayed) {
+						t.Fatal("expected replay error:", suite, err)
+					}
+					if encrypted, _, err = a.EncryptRtcp(nil, rawRtcp); err != nil {
+						t.Fatal(err)
+					}
+					encrypted[8] ^= 0xff
+					if _, err = a.DecryptSrtcp(nil, encrypted); !errors.Is(err, ErrSrtpAuthFailed) {
+						t.Fatal("expected auth error:", suite, err)
+					}
+				}
+			},
+		},
+		{
+			name: "pion errors",
+			method: func(t *testing.T) {
+				// we check the replay and the length before pion, so its errors are auth failures.
+				rawRtcp, err := (&rtcp.PictureLossIndication{MediaSSRC: 1000}).Marshal()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, suite := range []string{SuiteAES128CMHmacSha1_80, SuiteAES128CMHmacSha1_32, SuiteAEADAES128GCM} {
+					key, _ := GenerateSdesKey(suite)
+					a, err := NewSdesSrtpSession(&SdesOption{Suite: suite, LocalKey: key, RemoteKey: key})
+					if err != nil {
+						t.Fatal(err)
+					}
+					encrypted, _, err := a.EncryptRtp(nil, raw)
+					if err != nil {
+						t.Fatal(err)
+					}
+					encryptedRtcp, _, err := a.EncryptRtcp(nil, rawRtcp)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for i := 0; i < 2; i++ {
+						if _, err = a.remoteContext.DecryptRTP(nil, encrypted, nil); err != nil {
+							t.Fatal("pion should not check the replay:", suite, err)
+						}
+						if _, err = a.remoteContext.DecryptRTCP(nil, encryptedRtcp, nil); err != nil {
+							t.Fatal("pion should not check the replay:", suite, err)
+						}
+					}
+					if _, err = a.DecryptSrtp(nil, encrypted[:rtpHeaderLength+2]); !errors.Is(err, errShortPacket) {
+						t.Fatal("expected short packet, got:", suite, err)
+					}
+					if _, err = a.DecryptSrtcp(nil, encryptedRtcp[:srtcpHeaderLength+srtcpIndexLength]); !errors.Is(err, errShortPacket) {
+						t.Fatal("expected short packet, got:", suite, err)
+					}
+					encrypted[len(encrypted)-1] ^= 0xff
+					if _, err = a.DecryptSrtp(nil, encrypted); !errors.Is(err, ErrSrtpAuthFailed) {
+						t.Fatal("expected auth error, got:", suite, err)
+					}
+				}
+			},
+		},
+		{
+			name: "replay window kept after header encryption changed",
+			method: func(t *testing.T) {
+				a, b := newTestSessions(t, SrtpOption{})
+				encrypted, _, err := a.EncryptRtp(nil, raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = b.DecryptSrtp(nil, encrypted); err != nil {
+					t.Fatal("first decrypt should be fine:", err)
+				}
+				if err = b.SetEncryptedHeaderExtensions([]uint8{1}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err = b.DecryptSrtp(nil, encrypted); !errors.Is(err, ErrSrtpReplayed) {
+					t.Fatal("expected replay error, got:", err)
+				}
+			},
+		},
+		{
+			name: "roc kept after header encryption enabled",
+			method: func(t *testing.T) {
+				a, b := newTestSessions(t, SrtpOption{})
+				for _, seq := range []uint16{65534, 65535, 0, 1} {
+					if seq == 0 {
+						// the rtp before goes through pion, after through the ciphers.
+						if err := a.SetEncryptedHeaderExtensions([]uint8{1}); err != nil {
+							t.Fatal(err)
+						}
+						if err := b.SetEncryptedHeaderExtensions([]uint8{1}); err != nil {
+							t.Fatal(err)
+						}
+					}
+					packet, err := (&rtp.Packet{
+						Header:  rtp.Header{Version: 2, SSRC: 1000, SequenceNumber: seq, PayloadType: 96},
+						Payload: []byte{1, 2, 3, 4},
+					}).Marshal()
+					if err != nil {
+						t.Fatal(err)
+					}
+					encrypted, _, err := a.EncryptRtp(nil, packet)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err = b.DecryptSrtp(nil, encrypted); err != nil {
+						t.Fatal("decrypt should be fine:", seq, err)
+					}
+				}
+				if state := b.remoteStates.states[1000]; state.roc != 1 || state.seq != 1 {
+					t.Fatal("roc mismatch:", state.roc, state.seq)
+				}
+			},
+		},
+		{
+			name: "pion without header encryption",
+			method: func(t *testing.T) {
+				a, b := newTestSessions(t, SrtpOption{})
+				if err := a.SetEncryptedHeaderExtensions(nil); err != nil {
+					t.Fatal(err)
+				}
+				if err := a.SetCryptex(false); err != nil {
+					t.Fatal(err)
+				}
+				if a.headerEncryption.Load() || b.headerEncryption.Load() {
+					t.Fatal("the ciphers should not be used")
+				}
+				c, _ := newTestSessions(t, SrtpOption{Cryptex: true})
+				if !c.headerEncryption.Load() {
+					t.Fatal("the ciphers should be used with cryptex")
+				}
+			},
+		},
+		{
+			name: "cryptex negotiated later",
+			method: func(t *testing.T) {
+				a, b := newTestSessions(t, SrtpOption{})
+				if err := a.SetCryptex(true); err != nil {
+					t.Fatal(err)
+				}
+				packet, err := (&rtp.Packet{
+					Header:  rtp.Header{Version: 2, SSRC: 1000, SequenceNumber: 2, PayloadType: 96, CSRC: []uint32{1}},
+					Payload: []byte{1, 2, 3, 4},
+				}).Marshal()
+				if err != nil {
+					t.Fatal(err)
+				}
+				encrypted, _, err := a.EncryptRtp(nil, packet)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the csrc is encrypted, b decrypts it by the profile without the option.
+				if bytes.Equal(encrypted[rtpHeaderLength:rtpHeaderLength+4], []byte{0, 0, 0, 1}) {
+					t.Fatal("csrc should be encrypted")
+				}
+				decrypted, err := b.DecryptSrtp(nil, encrypted)
+				if err != nil {
+					t.Fatal(err)
+				}
+				result := &rtp.Packet{}
+				if err = result.Unmarshal(decrypted); err != nil {
+					t.Fatal(err)
+				}
+				if len(result.CSRC) != 1 || result.CSRC[0] != 1 {
+					t.Fatal("csrc mismatch:", result.CSRC)
+				}
+			},
+		},
+		{
+			name: "local context never rejects",
+			method: func(t *testing.T) {
+				a, _ := newTestSessions(t, SrtpOption{SrtpReplayWindow: 1})
+				// we may send the same seq twice, e.g. retransmit without rtx.
+				for i := 0; i < 2; i++ {
+					if _, _, err := a.EncryptRtp(nil, raw); err != nil {
+						t.Fatal("encrypt should be fine:", err)
+					}
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, test.method)
+	}
+}
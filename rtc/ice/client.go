@@ -1,18 +1,275 @@
 package ice
 
-// Dial creates a new ICE transport.
-//	func Dial(candidates []string, ufrag, password string) (Transport, error) {
-//		transport := iceTransport{
-//			userFragment: ufrag,
-//			password:     password,
-//			onData:       nil,
-//			onState:      nil,
-//			role:         RoleControlling,
-//		}
-//		// for _, c := range candidates {
-//		// transport.addRemoteCandidate()
-//		// }
-//		transport.connect()
-//
-//		return &transport, nil
-//	}
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/pion/stun"
+)
+
+const (
+	checkInterval     = 200 * time.Millisecond
+	keepaliveInterval = time.Second
+	dialTimeout       = 3 * time.Second
+	// transactionTimeout drops the requests not answered, the checks are sent again anyway.
+	transactionTimeout = 5 * time.Second
+)
+
+// NewClient creates the controlling transport with local ufrag and password, the checks start by Connect.
+// The remote is expected to be lite, e.g. a sfu, so the first succeeded pair is nominated.
+func NewClient(ufrag, password string, onData OnData, onState OnState) *Client {
+	if onState == nil {
+		onState = func(ConnectionState) {}
+	}
+	tieBreaker := make([]byte, 8)
+	_, _ = rand.Read(tieBreaker)
+	return &Client{
+		iceTransport: &iceTransport{
+			userFragment:      ufrag,
+			password:          password,
+			onData:            onData,
+			onState:           onState,
+			role:              RoleControlling,
+			connected:         make(chan bool),
+			disconnected:      make(chan bool),
+			failTimeout:       defaultFailedTimeout,
+			disconnectTimeout: defaultDisconnectedTimeout,
+		},
+		tieBreaker:   tieBreaker,
+		transactions: map[[stun.TransactionIDSize]byte]transaction{},
+	}
+}
+
+// transaction is a binding request sent, the response must come from the same connection.
+type transaction struct {
+	conn Connection
+	sent time.Time
+}
+
+// Client is ice client side, it dials the candidates of remote and sends the checks.
+type Client struct {
+	*iceTransport
+	tieBreaker []byte
+
+	// protect the remote credentials, selected and transactions.
+	mutex          sync.Mutex
+	remoteUfrag    string
+	remotePassword string
+	selected       Connection
+	transactions   map[[stun.TransactionIDSize]byte]transaction
+}
+
+// Connect dials the remote candidates and starts the checks, it could be called only once.
+// Only udp and passive tcp candidates are dialed, the tcp one is framed by rfc4571.
+func (c *Client) Connect(ufrag, password string, candidates []Candidate) error {
+	if len(c.connections) != 0 || c.State() != ConnectionNew {
+		return fmt.Errorf("%w: %v", ErrInvalidState, c.State())
+	}
+	c.mutex.Lock()
+	c.remoteUfrag, c.remotePassword = ufrag, password
+	c.mutex.Unlock()
+
+	candidates = append([]Candidate(nil), candidates...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority > candidates[j].Priority
+	})
+	for _, candidate := range candidates {
+		conn, err := dialCandidate(candidate)
+		if err != nil {
+			logger.Warnf("dial candidate %s:%d fail: %v", candidate.IP, candidate.Port, err)
+			continue
+		}
+		c.addConnection(conn)
+		conn.setCallback(c.onReceive)
+		host, port, _ := net.SplitHostPort(conn.conn.LocalAddr().String())
+		p, _ := strconv.Atoi(port)
+		c.addCandidate(conn.Protocol(), host, uint16(p))
+		go conn.readLoop()
+	}
+	if len(c.connections) == 0 {
+		return ErrNoAvailableCandidate
+	}
+	c.start()
+	go c.check()
+	return nil
+}
+
+// onReceive takes the responses of checks, the others are handled as the server side.
+func (c *Client) onReceive(data []byte, conn Connection) {
+	if stun.IsMessage(data) {
+		m := stun.New()
+		if err := stun.Decode(data, m); err == nil && m.Type == stun.BindingSuccess {
+			c.processResponse(m, conn)
+			return
+		}
+	}
+	c.iceTransport.onReceive(data, conn)
+}
+
+// processResponse accepts the response of an outstanding request only, the others are dropped,
+// they could be spoofed or of the requests timeout.
+func (c *Client) processResponse(m *stun.Message, conn Connection) {
+	c.mutex.Lock()
+	tx, ok := c.transactions[m.TransactionID]
+	if !ok || tx.conn != conn {
+		c.mutex.Unlock()
+		logger.Debug("unknown stun transaction from:", conn.RemoteAddr())
+		return
+	}
+	if err := stun.NewShortTermIntegrity(c.remotePassword).Check(m); err != nil {
+		c.mutex.Unlock()
+		logger.Warn("validate stun response fail:", err)
+		return
+	}
+	delete(c.transactions, m.TransactionID)
+	nominated := c.selected == nil
+	if nominated {
+		c.selected = conn
+	}
+	c.mutex.Unlock()
+	c.updateTimestamp()
+	if nominated {
+		c.updateState(conn, true)
+	}
+}
+
+// check sends the checks with use-candidate until one succeeded, then it keeps the selected one alive.
+func (c *Client) check() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		select {
+		case <-c.disconnected:
+			return
+		case now := <-ticker.C:
+			c.mutex.Lock()
+			selected := c.selected
+			c.mutex.Unlock()
+			if selected == nil {
+				for _, conn := range c.connections {
+					c.sendBinding(conn, true)
+				}
+				continue
+			}
+			if now.Sub(last) >= keepaliveInterval {
+				last = now
+				c.sendBinding(selected, false)
+			}
+		}
+	}
+}
+
+func (c *Client) sendBinding(conn Connection, useCandidate bool) {
+	ufrag, _ := c.credentials()
+	c.mutex.Lock()
+	remoteUfrag, remotePassword := c.remoteUfrag, c.remotePassword
+	c.mutex.Unlock()
+
+	priority := make([]byte, attrPrioritySize)
+	binary.BigEndian.PutUint32(priority, uint32(c.candidates[0].Priority))
+	setters := []stun.Setter{
+		stun.TransactionID,
+		stun.BindingRequest,
+		stun.NewUsername(remoteUfrag + ":" + ufrag),
+		stun.RawAttribute{Type: stun.AttrICEControlling, Value: c.tieBreaker},
+		stun.RawAttribute{Type: stun.AttrPriority, Value: priority},
+	}
+	if useCandidate {
+		setters = append(setters, stun.RawAttribute{Type: stun.AttrUseCandidate})
+	}
+	setters = append(setters, stun.NewShortTermIntegrity(remotePassword), stun.Fingerprint)
+	m, err := stun.Build(setters...)
+	if err != nil {
+		logger.Error("build stun request fail:", err)
+		return
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	for id, tx := range c.transactions {
+		if now.Sub(tx.sent) > transactionTimeout {
+			delete(c.transactions, id)
+		}
+	}
+	c.transactions[m.TransactionID] = transaction{conn: conn, sent: now}
+	c.mutex.Unlock()
+	if _, err = conn.Write(m.Raw); err != nil {
+		logger.Debugf("send stun request with conn %v fail: %v", conn, err)
+	}
+}
+
+func dialCandidate(candidate Candidate) (*clientConnection, error) {
+	switch candidate.Protocol {
+	case UDP, TCP:
+	default:
+		return nil, ErrUnknownProtocol
+	}
+	address := net.JoinHostPort(candidate.IP, strconv.Itoa(int(candidate.Port)))
+	conn, err := net.DialTimeout(candidate.Protocol, address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &clientConnection{conn: conn, protocol: candidate.Protocol}, nil
+}
+
+// clientConnection is a connection dialed by client, one for each remote candidate.
+type clientConnection struct {
+	conn     net.Conn
+	protocol string
+	callback connectionCallback
+}
+
+func (c *clientConnection) String() string {
+	return fmt.Sprintf("%s: %s<->%s", c.protocol, c.conn.LocalAddr().String(), c.RemoteAddr().String())
+}
+
+func (c *clientConnection) Write(data []byte) (int, error) {
+	if c.protocol == TCP {
+		return writeStreamingPacket(c.conn, data)
+	}
+	return c.conn.Write(data)
+}
+
+func (c *clientConnection) Protocol() string {
+	return c.protocol
+}
+
+func (c *clientConnection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *clientConnection) Close() error {
+	return c.conn.Close()
+}
+
+func (c *clientConnection) setCallback(receive connectionCallback) {
+	c.callback = receive
+}
+
+// readLoop will exit when conn closed.
+func (c *clientConnection) readLoop() {
+	buf := make([]byte, defaultMTU)
+	for {
+		var (
+			n   int
+			err error
+		)
+		if c.protocol == TCP {
+			n, err = readStreamingPacket(c.conn, buf)
+		} else {
+			n, err = c.conn.Read(buf)
+		}
+		if err != nil {
+			logger.Debugf("read from %v fail: %v", c, err)
+			return
+		}
+		c.callback(buf[:n], c)
+	}
+}
@@ -1 +1,119 @@
 package ice
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+func TestClient(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "connect_server",
+			description: "the client nominates the candidate of server and exchanges data",
+			method: func(t *testing.T) {
+				for _, option := range []Option{
+					{IPs: []string{"127.0.0.1"}, DisconnectTimeout: 2},
+					{IPs: []string{"127.0.0.1"}, DisconnectTimeout: 2, EnableTCP: true, DisableUDP: true},
+				} {
+					server, err := NewServer(option)
+					assert(t, err, nil)
+					serverData, serverState := make(chan []byte, 10), make(chan ConnectionState, 10)
+					transport, err := server.NewTransport("serv", "serverpassword", nil, func(data []byte) {
+						serverData <- append([]byte(nil), data...)
+					}, func(state ConnectionState) {
+						serverState <- state
+					})
+					assert(t, err, nil)
+
+					clientData, clientState := make(chan []byte, 10), make(chan ConnectionState, 10)
+					client := NewClient("clie", "clientpassword", func(data []byte) {
+						clientData <- append([]byte(nil), data...)
+					}, func(state ConnectionState) {
+						clientState <- state
+					})
+					p := transport.Parameters()
+					assert(t, client.Connect(p.UsernameFragment, p.Password, p.Candidates), nil)
+					assert(t, <-clientState, ConnectionCompleted)
+					assert(t, <-serverState, ConnectionCompleted)
+					assert(t, client.Parameters().Role, RoleControlling)
+					assert(t, client.Parameters().Lite, false)
+					assert(t, client.Parameters().Candidates[0].Protocol, p.Candidates[0].Protocol)
+
+					_, err = client.Write([]byte{0x80, 1, 2, 3})
+					assert(t, err, nil)
+					assert(t, <-serverData, []byte{0x80, 1, 2, 3})
+					_, err = transport.Write([]byte{0x80, 4, 5, 6})
+					assert(t, err, nil)
+					assert(t, <-clientData, []byte{0x80, 4, 5, 6})
+
+					// the keepalive prevents the server from timeout.
+					select {
+					case state := <-serverState:
+						t.Log("unexpected state:", state)
+						t.FailNow()
+					case <-time.After(time.Duration(option.DisconnectTimeout+1) * time.Second):
+					}
+					client.Close()
+					assert(t, <-clientState, ConnectionDisconnected)
+					server.Close()
+				}
+			},
+		},
+		{
+			name:        "connect_without_candidate",
+			description: "Connect should fail if no candidate could be dialed",
+			method: func(t *testing.T) {
+				client := NewClient("clie", "clientpassword", nil, nil)
+				err := client.Connect("serv", "serverpassword", []Candidate{{Protocol: "sctp", IP: "127.0.0.1", Port: 9}})
+				assert(t, errors.Is(err, ErrNoAvailableCandidate), true)
+				client.Close()
+				assert(t, client.State(), ConnectionDisconnected)
+				assert(t, errors.Is(client.Connect("serv", "serverpassword", nil), ErrInvalidState), true)
+			},
+		},
+		{
+			name:        "transaction",
+			description: "only the response of the request sent on the same connection is accepted",
+			method: func(t *testing.T) {
+				states := make(chan ConnectionState, 10)
+				client := NewClient("clie", "clientpassword", nil, func(state ConnectionState) {
+					states <- state
+				})
+				defer client.Close()
+				client.remoteUfrag, client.remotePassword = "serv", "serverpassword"
+				client.addCandidate(UDP, "127.0.0.1", 10000)
+				conn, other := newFaceConnection(UDP, 10), newFaceConnection(UDP, 10)
+				response := func(id [stun.TransactionIDSize]byte, password string) []byte {
+					m, err := stun.Build(stun.NewTransactionIDSetter(id), stun.BindingSuccess,
+						stun.NewShortTermIntegrity(password), stun.Fingerprint)
+					assert(t, err, nil)
+					return m.Raw
+				}
+
+				client.onReceive(response(stun.NewTransactionID(), "serverpassword"), conn)
+				client.sendBinding(conn, true)
+				request := stun.New()
+				assert(t, stun.Decode(<-conn.buf, request), nil)
+				client.onReceive(response(request.TransactionID, "serverpassword"), other)
+				client.onReceive(response(request.TransactionID, "wrongpassword"), conn)
+				assert(t, client.State(), ConnectionNew)
+
+				client.onReceive(response(request.TransactionID, "serverpassword"), conn)
+				assert(t, client.State(), ConnectionCompleted)
+				assert(t, <-states, ConnectionCompleted)
+				// the transaction is done.
+				client.mutex.Lock()
+				assert(t, len(client.transactions), 0)
+				client.mutex.Unlock()
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -22,4 +22,6 @@ var (
 	ErrInvalidState   = errors.New("transport state is invalid") // ErrInvalidState will raise if transport state is invalid.
 
 	ErrTCPReadTimeout = errors.New("tcp conn read timeout") // ErrTCPReadTimeout will raise if tcp conn read timeout.
+
+	ErrNoAvailableCandidate = errors.New("no available remote candidate") // ErrNoAvailableCandidate will raise if no remote candidate could be dialed.
 )
@@ -130,12 +130,12 @@ func (s *Server) NewTransport(ufrag, password string, ips []string, onData OnDat
 		password:          password,
 		onData:            onData,
 		role:              RoleControlled,
-		onState:           s.wrapOnState(ufrag, onState),
 		connected:         make(chan bool),
 		disconnected:      make(chan bool),
 		failTimeout:       s.failTimeout,
 		disconnectTimeout: s.disconnectTimeout,
 	}
+	transport.onState = s.wrapOnState(transport, onState)
 
 	if !s.disableUDP {
 		for _, ip := range ips {
@@ -212,11 +212,37 @@ func (s *Server) onConnection(ufrag string, conn Connection) error {
 	return ErrTransportNotExist
 }
 
-func (s *Server) wrapOnState(ufrag string, onState OnState) func(state ConnectionState) {
+// Restart changes the credentials of the transport for ice restart, the connections are kept
+// and the checks with the new credentials are accepted.
+func (s *Server) Restart(transport Transport, ufrag, password string) error {
+	t, ok := transport.(*iceTransport)
+	if !ok {
+		return ErrTransportNotExist
+	}
+	s.transportsMutex.Lock()
+	defer s.transportsMutex.Unlock()
+	old, _ := t.credentials()
+	if s.transports[old] != t {
+		return ErrTransportNotExist
+	}
+	if _, ok := s.transports[ufrag]; ok {
+		return ErrTransportExist
+	}
+	delete(s.transports, old)
+	s.transports[ufrag] = t
+	t.setCredentials(ufrag, password)
+	return nil
+}
+
+func (s *Server) wrapOnState(t *iceTransport, onState OnState) func(state ConnectionState) {
 	return func(state ConnectionState) {
 		if state == ConnectionDisconnected || state == ConnectionFailed {
+			// the ufrag may be changed by restart.
+			ufrag, _ := t.credentials()
 			s.transportsMutex.Lock()
-			delete(s.transports, ufrag)
+			if s.transports[ufrag] == t {
+				delete(s.transports, ufrag)
+			}
 			s.transportsMutex.Unlock()
 		}
 		if onState != nil {
@@ -176,6 +176,44 @@ func TestServer(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:        "restart_transport",
+			description: "Restart should change the credentials and the ufrag of connections",
+			method: func(t *testing.T) {
+				server, err := NewServer(Option{
+					IPs: []string{"127.0.0.1"},
+				})
+				if err != nil {
+					t.FailNow()
+				}
+				transport, err := server.NewTransport("ufrag", "pwd", nil, nil, nil)
+				if err != nil {
+					t.FailNow()
+				}
+				other, err := server.NewTransport("other", "pwd", nil, nil, nil)
+				if err != nil {
+					t.FailNow()
+				}
+				if err = server.Restart(transport, "other", "pwd1"); !errors.Is(err, ErrTransportExist) {
+					t.FailNow()
+				}
+				if err = server.Restart(transport, "ufrag1", "pwd1"); err != nil {
+					t.FailNow()
+				}
+				p := transport.Parameters()
+				if p.UsernameFragment != "ufrag1" || p.Password != "pwd1" {
+					t.FailNow()
+				}
+				if !errors.Is(server.onConnection("ufrag", &fakeConnection{}), ErrTransportNotExist) ||
+					server.onConnection("ufrag1", &fakeConnection{}) != nil {
+					t.FailNow()
+				}
+				other.Close()
+				if err = server.Restart(other, "other1", "pwd1"); !errors.Is(err, ErrTransportNotExist) {
+					t.FailNow()
+				}
+			},
+		},
 		{
 			name:        "create_transport_with_tcp_support",
 			description: "NewTransport should success with tcp enable",
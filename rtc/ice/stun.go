@@ -14,8 +14,13 @@ const (
 	attrPrioritySize = 4
 )
 
-func validateBindingStun(m *stun.Message, ufrag, pwd string) stun.ErrorCode {
-	if errCode := validateRequestStun(m); errCode != 0 {
+func validateBindingStun(m *stun.Message, ufrag, pwd, role string) stun.ErrorCode {
+	errCode := validateRequestStun(m)
+	// the controlling agent expects the checks from a controlled remote.
+	if errCode == stun.CodeRoleConflict && role == RoleControlling {
+		errCode = 0
+	}
+	if errCode != 0 {
 		return errCode
 	}
 	return checkAuthentication(m, ufrag, pwd)
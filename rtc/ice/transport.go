@@ -73,17 +73,19 @@ type (
 )
 
 type iceTransport struct {
-	userFragment string
-	password     string
-	connections  []Connection
-	connection   Connection
-	candidates   []Candidate
-	onData       OnData
-	onState      OnState
+	// protect userFragment and password, they are changed by ice restart.
+	credentialMutex sync.Mutex
+	userFragment    string
+	password        string
+	connections     []Connection
+	connection      Connection
+	candidates      []Candidate
+	onData          OnData
+	onState         OnState
 	// if we don't use atomic, the race test will complain, in fact,
 	//  no atomic is totally fine in here but anyway.
 	state                       int32
-	role                        string // for now, it's always controlled
+	role                        string // controlled for server, controlling for client
 	lastReceiveTimestamp        int64
 	iceLocalPreferenceDecrement int
 
@@ -127,15 +129,28 @@ func (t *iceTransport) State() ConnectionState {
 
 // Parameters
 func (t *iceTransport) Parameters() Parameters {
+	ufrag, password := t.credentials()
 	return Parameters{
-		UsernameFragment: t.userFragment,
-		Password:         t.password,
+		UsernameFragment: ufrag,
+		Password:         password,
 		Candidates:       t.Candidates(),
 		Role:             t.role,
-		Lite:             true, // always true
+		Lite:             t.role == RoleControlled, // the server is always lite
 	}
 }
 
+func (t *iceTransport) credentials() (string, string) {
+	t.credentialMutex.Lock()
+	defer t.credentialMutex.Unlock()
+	return t.userFragment, t.password
+}
+
+func (t *iceTransport) setCredentials(ufrag, password string) {
+	t.credentialMutex.Lock()
+	defer t.credentialMutex.Unlock()
+	t.userFragment, t.password = ufrag, password
+}
+
 func (t *iceTransport) addCandidate(protocol, ip string, port uint16) {
 	candidate := buildCandidate(protocol, ip, port, t.iceLocalPreferenceDecrement)
 	t.candidates = append(t.candidates, candidate)
@@ -175,7 +190,8 @@ func (t *iceTransport) processStun(data []byte, conn Connection) {
 		return
 	}
 
-	code := validateBindingStun(m, t.userFragment, t.password)
+	ufrag, password := t.credentials()
+	code := validateBindingStun(m, ufrag, password, t.role)
 	if code != 0 {
 		logger.Error("validate stun fail:", code)
 		response, err = createErrorResponse(m, code)
@@ -183,7 +199,7 @@ func (t *iceTransport) processStun(data []byte, conn Connection) {
 			logger.Error("create stun response fail:", err)
 		}
 	} else {
-		response, err = createBindSuccessResponse(m, conn.Protocol(), conn.RemoteAddr(), t.password)
+		response, err = createBindSuccessResponse(m, conn.Protocol(), conn.RemoteAddr(), password)
 		if err != nil {
 			logger.Error("create stun response fail:", err)
 			return
@@ -196,8 +212,8 @@ func (t *iceTransport) processStun(data []byte, conn Connection) {
 		logger.Warnf("Send with conn %v fail: %v", conn, err)
 		return
 	}
-	if code == 0 {
-		// only update it with success response
+	// only update it with success response, the controlling agent nominates by itself.
+	if code == 0 && t.role == RoleControlled {
 		t.updateState(conn, m.Contains(stun.AttrUseCandidate))
 	}
 }
@@ -0,0 +1,149 @@
+package media
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec/h264"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+const (
+	naluTypeIDR = 5
+	naluTypeSEI = 6
+	naluTypeSPS = 7
+	naluTypePPS = 8
+	naluTypeAUD = 9
+)
+
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// AnnexBReader reads access units from a raw h264 stream, the stream has no timing,
+// so the frames are timestamped by the given frame rate.
+type AnnexBReader struct {
+	reader    io.ReadSeeker
+	buffer    *bufio.Reader
+	frameRate int
+	started   bool
+	pending   []byte
+	count     uint64
+	profile   string
+}
+
+func NewAnnexBReader(reader io.ReadSeeker, frameRate int) (*AnnexBReader, error) {
+	if frameRate <= 0 {
+		frameRate = defaultFrameRate
+	}
+	r := &AnnexBReader{reader: reader, buffer: bufio.NewReader(reader), frameRate: frameRate}
+	// find the profile-level-id from sps before any frame.
+	for r.profile == "" {
+		nalu, err := r.nextNALU()
+		if err != nil {
+			return nil, ErrInvalidFile
+		}
+		if nalu[0]&0x1f == naluTypeSPS && len(nalu) >= 4 {
+			r.profile = hex.EncodeToString(nalu[1:4])
+		}
+	}
+	if err := r.Reset(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// nextNALU returns the next nal unit without start code.
+func (r *AnnexBReader) nextNALU() ([]byte, error) {
+	var nalu []byte
+	zeros := 0
+	for {
+		b, err := r.buffer.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(nalu) != 0 {
+				return nalu, nil
+			}
+			return nil, err
+		}
+		if b == 0 {
+			zeros++
+			continue
+		}
+		if b == 1 && zeros >= 2 {
+			zeros = 0
+			if !r.started {
+				r.started = true
+				continue
+			}
+			if len(nalu) == 0 {
+				continue
+			}
+			return nalu, nil
+		}
+		for ; zeros > 0; zeros-- {
+			nalu = append(nalu, 0)
+		}
+		nalu = append(nalu, b)
+	}
+}
+
+func (r *AnnexBReader) MediaType() string {
+	return rtc.MediaTypeVideo
+}
+
+func (r *AnnexBReader) Codec() *peer.Codec {
+	return &peer.Codec{
+		EncoderName: h264.CodecName,
+		ClockRate:   videoClockRate,
+		Parameters: map[string]string{
+			"level-asymmetry-allowed": "1",
+			"packetization-mode":      "1",
+			"profile-level-id":        r.profile,
+		},
+	}
+}
+
+// Next returns an access unit in annex-b format, the access unit delimiters are dropped.
+func (r *AnnexBReader) Next() (*Frame, error) {
+	var data []byte
+	vcl := false
+	for {
+		nalu := r.pending
+		r.pending = nil
+		if nalu == nil {
+			var err error
+			if nalu, err = r.nextNALU(); err != nil {
+				if err == io.EOF && vcl {
+					break
+				}
+				return nil, err
+			}
+		}
+		typ := nalu[0] & 0x1f
+		isVCL := typ >= 1 && typ <= naluTypeIDR
+		// the first slice of next picture has first_mb_in_slice 0, which is a single 1 bit in ue(v).
+		if vcl && (typ == naluTypeAUD || typ == naluTypeSEI || typ == naluTypeSPS || typ == naluTypePPS ||
+			(isVCL && len(nalu) > 1 && nalu[1]&0x80 != 0)) {
+			r.pending = nalu
+			break
+		}
+		if typ == naluTypeAUD {
+			continue
+		}
+		data = append(data, annexBStartCode...)
+		data = append(data, nalu...)
+		vcl = vcl || isVCL
+	}
+	frame := &Frame{Data: data, Timestamp: uint32(r.count * videoClockRate / uint64(r.frameRate))}
+	r.count++
+	return frame, nil
+}
+
+func (r *AnnexBReader) Reset() error {
+	if _, err := r.reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r.buffer.Reset(r.reader)
+	r.started, r.pending, r.count = false, nil, 0
+	return nil
+}
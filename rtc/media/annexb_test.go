@@ -0,0 +1,82 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"testing"
+)
+
+// newTestAnnexB returns sps, pps, an idr and a non-idr slice with start codes, and an aud before each picture.
+func newTestAnnexB(t *testing.T) ([]byte, [][]byte) {
+	file, err := os.Open("../../testdata/codec/h264/stream")
+	assert(t, err, nil)
+	defer file.Close()
+	var nalus [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		nalu, err := hex.DecodeString(scanner.Text())
+		assert(t, err, nil)
+		nalus = append(nalus, nalu)
+	}
+	assert(t, len(nalus), 4)
+	stream := new(bytes.Buffer)
+	for i, nalu := range nalus {
+		if i == 0 || i == 3 {
+			stream.Write([]byte{0, 0, 0, 1, 0x09, 0xf0})
+		}
+		// both 3 and 4 bytes start code.
+		if i%2 == 0 {
+			stream.Write([]byte{0, 0, 1})
+		} else {
+			stream.Write(annexBStartCode)
+		}
+		stream.Write(nalu)
+	}
+	return stream.Bytes(), nalus
+}
+
+func TestAnnexBReader(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "access units",
+			description: "nal units are grouped into access units by the first slice",
+			method: func(t *testing.T) {
+				stream, nalus := newTestAnnexB(t)
+				r, err := NewAnnexBReader(bytes.NewReader(stream), 25)
+				assert(t, err, nil)
+				assert(t, r.Codec().Parameters["profile-level-id"], "42c015")
+				for round := 0; round < 2; round++ {
+					frame, err := r.Next()
+					assert(t, err, nil)
+					expected := bytes.Join(nalus[:3], annexBStartCode)
+					assert(t, frame.Data, append(append([]byte{}, annexBStartCode...), expected...))
+					assert(t, frame.Timestamp, uint32(0))
+					frame, err = r.Next()
+					assert(t, err, nil)
+					assert(t, frame.Data, append(append([]byte{}, annexBStartCode...), nalus[3]...))
+					assert(t, frame.Timestamp, uint32(3600))
+					_, err = r.Next()
+					assert(t, err, io.EOF)
+					assert(t, r.Reset(), nil)
+				}
+			},
+		},
+		{
+			name:        "no sps",
+			description: "a stream without sps is invalid",
+			method: func(t *testing.T) {
+				_, err := NewAnnexBReader(bytes.NewReader([]byte{0, 0, 1, 0x65, 0x88}), 0)
+				assert(t, err, ErrInvalidFile)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package media
+
+import (
+	"encoding/binary"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec/av1"
+	"github.com/gotolive/sfu/rtc/codec/h264"
+)
+
+const (
+	mp4TrunDataOffset   = 0x000001
+	mp4TrunDuration     = 0x000100
+	mp4TrunSize         = 0x000200
+	mp4TrunFlags        = 0x000400
+	mp4DefaultBaseMoof  = 0x020000
+	mp4SampleSync       = 0x02000000
+	mp4SampleNonSync    = 0x01010000
+	mp4LanguageUnd      = 0x55c4
+	mp4MovieTimescale   = 1000
+	mp4TrackEnabled     = 0x000003
+	mp4VolumeFull       = 0x0100
+	mp4Resolution72DPI  = 0x00480000
+	mp4AudioSampleSize  = 16
+	mp4NALULengthSize   = 4
+	mp4AV1ConfigVersion = 0x81
+)
+
+var mp4Matrix = []byte{
+	0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0, 0, 0,
+}
+
+// FMP4Track describes a track of fragmented mp4, the codec is H264, AV1 or opus.
+type FMP4Track struct {
+	ID        uint32 // starts from 1
+	MediaType string
+	Codec     string
+	Timescale uint32
+	Width     int // parsed from the parameter sets if zero
+	Height    int
+	Channels  int
+	// SPS and PPS are the nal units of h264, SequenceHeader is the obu of av1.
+	SPS            []byte
+	PPS            []byte
+	SequenceHeader []byte
+}
+
+// FMP4Sample is a sample of a fragment, the h264 is in avc format, and the av1 is a temporal unit
+// without temporal delimiter.
+type FMP4Sample struct {
+	Data     []byte
+	DTS      uint64 // in track timescale
+	Duration uint32
+	KeyFrame bool
+}
+
+// FMP4Muxer generates the init segment and fragments, which could be written to one file,
+// or served as cmaf segments.
+type FMP4Muxer struct {
+	tracks   []*FMP4Track
+	entries  [][]byte
+	sequence uint32
+}
+
+func NewFMP4Muxer(tracks ...*FMP4Track) (*FMP4Muxer, error) {
+	m := &FMP4Muxer{tracks: tracks}
+	for _, t := range tracks {
+		entry, err := t.sampleEntry()
+		if err != nil {
+			return nil, err
+		}
+		m.entries = append(m.entries, entry)
+	}
+	return m, nil
+}
+
+func mp4Box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	box := make([]byte, 0, size)
+	box = binary.BigEndian.AppendUint32(box, uint32(size))
+	box = append(box, typ...)
+	for _, p := range payloads {
+		box = append(box, p...)
+	}
+	return box
+}
+
+func mp4FullBox(typ string, version byte, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(typ, append([][]byte{header}, payloads...)...)
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func u64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func (t *FMP4Track) sampleEntry() ([]byte, error) {
+	switch t.Codec {
+	case h264.CodecName:
+		sps, err := h264.ParseSPS(t.SPS)
+		if err != nil || len(t.PPS) == 0 {
+			return nil, ErrInvalidFile
+		}
+		if t.Width == 0 {
+			t.Width, t.Height = sps.Width(), sps.Height()
+		}
+		config := []byte{1, t.SPS[1], t.SPS[2], t.SPS[3], 0xfc | (mp4NALULengthSize - 1), 0xe0 | 1}
+		config = append(append(config, u16(uint16(len(t.SPS)))...), t.SPS...)
+		config = append(append(append(config, 1), u16(uint16(len(t.PPS)))...), t.PPS...)
+		if sps.ProfileIdc != 66 && sps.ProfileIdc != 77 && sps.ProfileIdc != 88 {
+			config = append(config, 0xfc|sps.ChromaFormatIdc, 0xf8|sps.BitDepthLumaMinus8, 0xf8|sps.BitDepthChromaMinus8, 0)
+		}
+		return t.visualSampleEntry("avc1", mp4Box("avcC", config)), nil
+	case av1.CodecName:
+		s, err := av1.ParseSequenceHeader(t.SequenceHeader)
+		if err != nil {
+			return nil, err
+		}
+		if t.Width == 0 {
+			t.Width, t.Height = s.MaxWidth, s.MaxHeight
+		}
+		flags := s.Tier<<7 | bit(s.HighBitdepth)<<6 | bit(s.TwelveBit)<<5 | bit(s.Monochrome)<<4 |
+			bit(s.ChromaSubsamplingX)<<3 | bit(s.ChromaSubsamplingY)<<2 | s.ChromaSamplePosition
+		config := []byte{mp4AV1ConfigVersion, s.Profile<<5 | s.Level, flags, 0}
+		return t.visualSampleEntry("av01", mp4Box("av1C", config, t.SequenceHeader)), nil
+	case CodecNameOpus:
+		channels := t.Channels
+		if channels == 0 {
+			channels = 2
+		}
+		dops := []byte{0, byte(channels)}
+		dops = append(dops, u16(opusPreSkip)...)
+		dops = append(dops, u32(opusClockRate)...)
+		dops = append(dops, 0, 0, 0)
+		entry := append(make([]byte, 6), u16(1)...)
+		entry = append(entry, make([]byte, 8)...)
+		entry = append(entry, u16(uint16(channels))...)
+		entry = append(entry, u16(mp4AudioSampleSize)...)
+		entry = append(entry, make([]byte, 4)...)
+		entry = append(entry, u32(opusClockRate<<16)...)
+		return mp4Box("Opus", entry, mp4Box("dOps", dops)), nil
+	}
+	return nil, ErrUnsupportedCodec
+}
+
+func bit(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (t *FMP4Track) visualSampleEntry(typ string, config []byte) []byte {
+	entry := append(make([]byte, 6), u16(1)...)
+	entry = append(entry, make([]byte, 16)...)
+	entry = append(entry, u16(uint16(t.Width))...)
+	entry = append(entry, u16(uint16(t.Height))...)
+	entry = append(entry, u32(mp4Resolution72DPI)...)
+	entry = append(entry, u32(mp4Resolution72DPI)...)
+	entry = append(entry, make([]byte, 4)...)
+	entry = append(entry, u16(1)...)
+	entry = append(entry, make([]byte, 32)...)
+	entry = append(entry, u16(0x18)...)
+	entry = append(entry, 0xff, 0xff)
+	return mp4Box(typ, entry, config)
+}
+
+// Init returns the ftyp and moov.
+func (m *FMP4Muxer) Init() []byte {
+	ftyp := mp4Box("ftyp", []byte("iso5"), u32(512), []byte("iso5iso6mp41"))
+	mvhd := mp4FullBox("mvhd", 0, 0, make([]byte, 8), u32(mp4MovieTimescale), u32(0), u32(0x00010000),
+		u16(mp4VolumeFull), make([]byte, 10), mp4Matrix, make([]byte, 24), u32(uint32(len(m.tracks)+1)))
+	moov := [][]byte{mvhd}
+	var trex [][]byte
+	for i, t := range m.tracks {
+		moov = append(moov, t.trak(m.entries[i]))
+		trex = append(trex, mp4FullBox("trex", 0, 0, u32(t.ID), u32(1), u32(0), u32(0), u32(0)))
+	}
+	moov = append(moov, mp4Box("mvex", trex...))
+	return append(ftyp, mp4Box("moov", moov...)...)
+}
+
+func (t *FMP4Track) trak(entry []byte) []byte {
+	var volume uint16
+	handler, name := "vide", "VideoHandler"
+	header := mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+	if t.MediaType == rtc.MediaTypeAudio {
+		volume = mp4VolumeFull
+		handler, name = "soun", "SoundHandler"
+		header = mp4FullBox("smhd", 0, 0, make([]byte, 4))
+	}
+	tkhd := mp4FullBox("tkhd", 0, mp4TrackEnabled, make([]byte, 8), u32(t.ID), make([]byte, 4), u32(0),
+		make([]byte, 8), make([]byte, 4), u16(volume), make([]byte, 2), mp4Matrix,
+		u32(uint32(t.Width)<<16), u32(uint32(t.Height)<<16))
+	mdhd := mp4FullBox("mdhd", 0, 0, make([]byte, 8), u32(t.Timescale), u32(0), u16(mp4LanguageUnd), u16(0))
+	hdlr := mp4FullBox("hdlr", 0, 0, make([]byte, 4), []byte(handler), make([]byte, 12), append([]byte(name), 0))
+	dinf := mp4Box("dinf", mp4FullBox("dref", 0, 0, u32(1), mp4FullBox("url ", 0, 1)))
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, u32(1), entry),
+		mp4FullBox("stts", 0, 0, u32(0)),
+		mp4FullBox("stsc", 0, 0, u32(0)),
+		mp4FullBox("stsz", 0, 0, u32(0), u32(0)),
+		mp4FullBox("stco", 0, 0, u32(0)),
+	)
+	minf := mp4Box("minf", header, dinf, stbl)
+	return mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, minf))
+}
+
+// Fragment returns a moof and mdat of the samples, one slice for each track in order.
+func (m *FMP4Muxer) Fragment(samples ...[]FMP4Sample) []byte {
+	m.sequence++
+	moof := m.moof(samples, 0)
+	moof = m.moof(samples, len(moof))
+	var data [][]byte
+	for _, s := range samples {
+		for _, sample := range s {
+			data = append(data, sample.Data)
+		}
+	}
+	return append(moof, mp4Box("mdat", data...)...)
+}
+
+// moof is built twice, the data offset is known after the size of moof known.
+func (m *FMP4Muxer) moof(samples [][]FMP4Sample, size int) []byte {
+	boxes := [][]byte{mp4FullBox("mfhd", 0, 0, u32(m.sequence))}
+	offset := size + 8
+	for i, s := range samples {
+		if len(s) == 0 || i >= len(m.tracks) {
+			continue
+		}
+		entries := make([]byte, 0, len(s)*12)
+		for _, sample := range s {
+			flags := uint32(mp4SampleNonSync)
+			if sample.KeyFrame || m.tracks[i].MediaType == rtc.MediaTypeAudio {
+				flags = mp4SampleSync
+			}
+			entries = append(entries, u32(sample.Duration)...)
+			entries = append(entries, u32(uint32(len(sample.Data)))...)
+			entries = append(entries, u32(flags)...)
+		}
+		boxes = append(boxes, mp4Box("traf",
+			mp4FullBox("tfhd", 0, mp4DefaultBaseMoof, u32(m.tracks[i].ID)),
+			mp4FullBox("tfdt", 1, 0, u64(s[0].DTS)),
+			mp4FullBox("trun", 0, mp4TrunDataOffset|mp4TrunDuration|mp4TrunSize|mp4TrunFlags,
+				u32(uint32(len(s))), u32(uint32(offset)), entries),
+		))
+		for _, sample := range s {
+			offset += len(sample.Data)
+		}
+	}
+	return mp4Box("moof", boxes...)
+}
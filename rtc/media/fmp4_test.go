@@ -0,0 +1,150 @@
+package media
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/gotolive/sfu/rtc"
+)
+
+// findTestBox returns the payload of the first box of the path.
+func findTestBox(data []byte, path ...string) []byte {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			return nil
+		}
+		if string(data[4:8]) == path[0] {
+			if len(path) == 1 {
+				return data[8:size]
+			}
+			payload := data[8:size]
+			// skip the fields before the children.
+			switch path[0] {
+			case "stsd", "dref":
+				payload = payload[8:]
+			case "avc1", "av01":
+				payload = payload[78:]
+			case "Opus":
+				payload = payload[28:]
+			}
+			return findTestBox(payload, path[1:]...)
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// testBoxTypes returns the types of top level boxes.
+func testBoxTypes(data []byte) []string {
+	var types []string
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		types = append(types, string(data[4:8]))
+		data = data[size:]
+	}
+	return types
+}
+
+func TestFMP4Muxer(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "init",
+			description: "the init segment has a track of each codec",
+			method: func(t *testing.T) {
+				_, nalus := newTestAnnexB(t)
+				muxer, err := NewFMP4Muxer(
+					&FMP4Track{ID: 1, MediaType: rtc.MediaTypeVideo, Codec: "H264", Timescale: 90000, SPS: nalus[0], PPS: nalus[1]},
+					&FMP4Track{ID: 2, MediaType: rtc.MediaTypeAudio, Codec: CodecNameOpus, Timescale: 48000, Channels: 2},
+				)
+				assert(t, err, nil)
+				init := muxer.Init()
+				assert(t, testBoxTypes(init), []string{"ftyp", "moov"})
+				avcC := findTestBox(init, "moov", "trak", "mdia", "minf", "stbl", "stsd", "avc1", "avcC")
+				assert(t, avcC[:6], []byte{1, nalus[0][1], nalus[0][2], nalus[0][3], 0xff, 0xe1})
+				tkhd := findTestBox(init, "moov", "trak", "tkhd")
+				assert(t, binary.BigEndian.Uint32(tkhd[76:])>>16, uint32(320))
+				assert(t, binary.BigEndian.Uint32(tkhd[80:])>>16, uint32(240))
+				mdhd := findTestBox(init, "moov", "trak", "mdia", "mdhd")
+				assert(t, binary.BigEndian.Uint32(mdhd[12:]), uint32(90000))
+
+				moov := findTestBox(init, "moov")
+				audio := findTestBox(moov[len(findTestBox(moov, "mvhd"))+8+len(findTestBox(moov, "trak"))+8:], "trak")
+				dops := findTestBox(audio, "mdia", "minf", "stbl", "stsd", "Opus", "dOps")
+				assert(t, dops, []byte{0, 2, 0, 0, 0, 0, 0xbb, 0x80, 0, 0, 0})
+				assert(t, string(findTestBox(audio, "mdia", "hdlr")[8:12]), "soun")
+				trex := findTestBox(init, "moov", "mvex", "trex")
+				assert(t, binary.BigEndian.Uint32(trex[4:]), uint32(1))
+			},
+		},
+		{
+			name:        "av1",
+			description: "the av1 config has the sequence header",
+			method: func(t *testing.T) {
+				header := []byte{0x0a, 0x0a, 0x00, 0x00, 0x00, 0x04, 0x3c, 0xff, 0xbc, 0x01, 0xa0, 0x08}
+				muxer, err := NewFMP4Muxer(&FMP4Track{ID: 1, MediaType: rtc.MediaTypeVideo, Codec: "AV1", Timescale: 90000, SequenceHeader: header})
+				assert(t, err, nil)
+				av1C := findTestBox(muxer.Init(), "moov", "trak", "mdia", "minf", "stbl", "stsd", "av01", "av1C")
+				assert(t, av1C[:4], []byte{0x81, 0x00, 0x0c, 0x00})
+				assert(t, av1C[4:], header)
+			},
+		},
+		{
+			name:        "invalid",
+			description: "the track without codec config is rejected",
+			method: func(t *testing.T) {
+				_, nalus := newTestAnnexB(t)
+				_, err := NewFMP4Muxer(&FMP4Track{ID: 1, MediaType: rtc.MediaTypeVideo, Codec: "H264", Timescale: 90000, SPS: nalus[0]})
+				assert(t, err, ErrInvalidFile)
+				_, err = NewFMP4Muxer(&FMP4Track{ID: 1, MediaType: rtc.MediaTypeVideo, Codec: "VP8", Timescale: 90000})
+				assert(t, err, ErrUnsupportedCodec)
+			},
+		},
+		{
+			name:        "fragment",
+			description: "the data offsets of the tracks point to the samples in mdat",
+			method: func(t *testing.T) {
+				muxer, err := NewFMP4Muxer(
+					&FMP4Track{ID: 1, MediaType: rtc.MediaTypeAudio, Codec: CodecNameOpus, Timescale: 48000},
+					&FMP4Track{ID: 2, MediaType: rtc.MediaTypeAudio, Codec: CodecNameOpus, Timescale: 48000},
+				)
+				assert(t, err, nil)
+				fragment := muxer.Fragment(
+					[]FMP4Sample{{Data: []byte{1, 2}, DTS: 960, Duration: 960}, {Data: []byte{3}, DTS: 1920, Duration: 960}},
+					[]FMP4Sample{{Data: []byte{4, 5, 6}, DTS: 4800, Duration: 480}},
+				)
+				assert(t, testBoxTypes(fragment), []string{"moof", "mdat"})
+				assert(t, findTestBox(fragment, "moof", "mfhd"), []byte{0, 0, 0, 0, 0, 0, 0, 1})
+				moof := findTestBox(fragment, "moof")
+				first := findTestBox(moof, "traf")
+				second := findTestBox(moof[len(findTestBox(moof, "mfhd"))+8+len(first)+8:], "traf")
+
+				tests := []struct {
+					traf     []byte
+					id       uint32
+					dts      uint64
+					data     []byte
+					duration uint32
+				}{
+					{first, 1, 960, []byte{1, 2, 3}, 960},
+					{second, 2, 4800, []byte{4, 5, 6}, 480},
+				}
+				for _, test := range tests {
+					assert(t, binary.BigEndian.Uint32(findTestBox(test.traf, "tfhd")[4:]), test.id)
+					assert(t, binary.BigEndian.Uint64(findTestBox(test.traf, "tfdt")[4:]), test.dts)
+					trun := findTestBox(test.traf, "trun")
+					offset := binary.BigEndian.Uint32(trun[8:])
+					assert(t, fragment[offset:int(offset)+len(test.data)], test.data)
+					assert(t, binary.BigEndian.Uint32(trun[12:]), test.duration)
+					assert(t, binary.BigEndian.Uint32(trun[20:]), uint32(mp4SampleSync))
+				}
+				assert(t, findTestBox(muxer.Fragment(nil, []FMP4Sample{{Data: []byte{1}, Duration: 1}}), "moof", "mfhd"), []byte{0, 0, 0, 0, 0, 0, 0, 2})
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
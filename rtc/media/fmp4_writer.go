@@ -0,0 +1,314 @@
+package media
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/gotolive/sfu/rtc/codec/av1"
+	"github.com/gotolive/sfu/rtc/codec/h264"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtp"
+)
+
+const defaultAudioFrameRate = 50
+
+type mp4Track struct {
+	*rtpTrack
+	connectionID string
+	fmp4         *FMP4Track
+	anchored     bool
+	offset       int64 // the dts is the frame timestamp plus offset
+	// samples of current fragment, the duration of the last one is unknown until next one comes.
+	samples []FMP4Sample
+}
+
+// fmp4Writer consumes a video and an audio receiver and muxes them to mp4 fragments.
+// It starts with a video keyframe, the tracks are aligned by the sender reports,
+// so the audio captured at the same time has the same decode time. The arrival time is used
+// if the first track has no sender report.
+// It has no lock, the owner guards it.
+type fmp4Writer struct {
+	video  *mp4Track
+	audio  *mp4Track
+	tracks []*mp4Track
+	muxer  *FMP4Muxer
+	// start is the sender's clock of the first sample if synced, and arrival is the local clock.
+	start    time.Time
+	arrival  time.Time
+	synced   bool
+	duration time.Duration
+
+	// cut reports whether the samples of the first track are written as a fragment before a new frame,
+	// the span is the duration of the samples and next is the guessed duration of the new frame.
+	cut        func(keyframe bool, span, next time.Duration) bool
+	onInit     func(data []byte) error
+	onFragment func(fragment *fmp4Fragment) error
+}
+
+type fmp4Fragment struct {
+	Data     []byte
+	Duration time.Duration
+	// KeyFrame is true if the fragment could be decoded independently.
+	KeyFrame bool
+}
+
+type fmp4Source struct {
+	VideoConnectionID string
+	VideoReceiverID   string
+	AudioConnectionID string
+	AudioReceiverID   string
+	Path              string
+}
+
+func newFMP4Writer(broker *peer.Broker, source fmp4Source) (*fmp4Writer, error) {
+	if source.VideoReceiverID == "" && source.AudioReceiverID == "" {
+		return nil, peer.ErrReceiverNotExist
+	}
+	w := &fmp4Writer{}
+	var err error
+	if source.VideoReceiverID != "" {
+		if w.video, err = w.newTrack(broker, source.VideoConnectionID, source.VideoReceiverID, rtc.MediaTypeVideo, source.Path); err != nil {
+			return nil, err
+		}
+		w.video.connectionID = source.VideoConnectionID
+		w.tracks = append(w.tracks, w.video)
+	}
+	if source.AudioReceiverID != "" {
+		if w.audio, err = w.newTrack(broker, source.AudioConnectionID, source.AudioReceiverID, rtc.MediaTypeAudio, source.Path); err != nil {
+			return nil, err
+		}
+		w.audio.connectionID = source.AudioConnectionID
+		w.tracks = append(w.tracks, w.audio)
+	}
+	return w, nil
+}
+
+func (w *fmp4Writer) newTrack(broker *peer.Broker, connectionID, receiverID, mediaType, path string) (*mp4Track, error) {
+	receiver, err := findReceiver(broker, connectionID, receiverID)
+	if err != nil {
+		return nil, err
+	}
+	if receiver.MediaType() != mediaType {
+		return nil, ErrUnsupportedCodec
+	}
+	switch receiver.Codec().EncoderName {
+	case h264.CodecName, av1.CodecName, CodecNameOpus:
+	default:
+		return nil, ErrUnsupportedCodec
+	}
+	track, err := newRTPTrack(receiver, path)
+	if err != nil {
+		return nil, err
+	}
+	t := &mp4Track{
+		rtpTrack: track,
+		fmp4: &FMP4Track{
+			ID:        uint32(len(w.tracks) + 1),
+			MediaType: mediaType,
+			Codec:     track.codec.EncoderName,
+			Timescale: uint32(track.codec.ClockRate),
+			Channels:  track.codec.Channels,
+		},
+	}
+	t.onFrame = func(frame *codec.Frame) error {
+		return w.writeFrame(t, frame)
+	}
+	return t, nil
+}
+
+// subscribe creates a direct connection for each track, the id is the prefix of them.
+func (w *fmp4Writer) subscribe(broker *peer.Broker, id string, onRTP func(t *mp4Track, packet *rtp.Packet)) error {
+	for _, t := range w.tracks {
+		t := t
+		err := t.subscribe(broker, id+"-"+t.fmp4.MediaType, t.connectionID, func(packet *rtp.Packet) {
+			onRTP(t, packet)
+		})
+		if err != nil {
+			w.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// onClose is called when any receiver closed.
+func (w *fmp4Writer) onClose(callback func()) {
+	for _, t := range w.tracks {
+		t.receiver.OnClose(callback)
+	}
+}
+
+func (w *fmp4Writer) writeFrame(t *mp4Track, frame *codec.Frame) error {
+	ts := frame.ExtendedTimestamp
+	if w.muxer == nil {
+		if err := w.begin(t, frame); err != nil {
+			return err
+		}
+	}
+	if !t.anchored {
+		// the first frame of other track, it's dropped if captured before the recording started.
+		d := time.Since(w.arrival)
+		if !frame.CaptureTime.IsZero() && w.synced {
+			d = frame.CaptureTime.Sub(w.start)
+		}
+		if d < 0 {
+			return errFrameSkipped
+		}
+		t.anchored = true
+		t.offset = int64(d/time.Millisecond)*int64(t.codec.ClockRate)/1000 - int64(ts)
+	}
+	dts := int64(ts) + t.offset
+	if n := len(t.samples); n != 0 {
+		last := &t.samples[n-1]
+		if dts <= int64(last.DTS) {
+			return errFrameSkipped
+		}
+		last.Duration = uint32(dts - int64(last.DTS))
+		// the first track drives the fragments.
+		if t == w.tracks[0] && w.cut(frame.KeyFrame && t.video, t.duration(uint64(dts)-t.samples[0].DTS), t.duration(uint64(last.Duration))) {
+			if err := w.writeFragment(false); err != nil {
+				return err
+			}
+		}
+	}
+	data := frame.Data
+	if t.fmp4.Codec == av1.CodecName {
+		data = av1StripTemporalDelimiter(data)
+	}
+	t.samples = append(t.samples, FMP4Sample{Data: data, DTS: uint64(dts), KeyFrame: frame.KeyFrame})
+	if d := t.duration(uint64(dts)); d > w.duration {
+		w.duration = d
+	}
+	return nil
+}
+
+// begin writes the init segment with the first video keyframe, or the first audio frame if no video.
+func (w *fmp4Writer) begin(t *mp4Track, frame *codec.Frame) error {
+	if w.video != nil && (t != w.video || !frame.KeyFrame) {
+		return errFrameSkipped
+	}
+	switch t.fmp4.Codec {
+	case h264.CodecName:
+		t.fmp4.SPS, t.fmp4.PPS = h264ParameterSets(frame.Data)
+	case av1.CodecName:
+		t.fmp4.SequenceHeader = av1.FindOBU(frame.Data, av1.ObuTypeSequenceHeader)
+	}
+	var tracks []*FMP4Track
+	for _, track := range w.tracks {
+		tracks = append(tracks, track.fmp4)
+	}
+	muxer, err := NewFMP4Muxer(tracks...)
+	if err != nil {
+		// the keyframe has no parameter sets, wait for next one.
+		t.requestKeyframe()
+		return errFrameSkipped
+	}
+	if err = w.onInit(muxer.Init()); err != nil {
+		return err
+	}
+	w.muxer = muxer
+	w.arrival = time.Now()
+	w.start, w.synced = frame.CaptureTime, !frame.CaptureTime.IsZero()
+	t.anchored = true
+	t.offset = -int64(frame.ExtendedTimestamp)
+	return nil
+}
+
+// startTime is the wall clock of the sender when the first sample captured, or the arrival time if no sender report.
+func (w *fmp4Writer) startTime() time.Time {
+	if w.synced {
+		return w.start
+	}
+	return w.arrival
+}
+
+// writeFragment writes the samples with known duration, or all samples if final.
+func (w *fmp4Writer) writeFragment(final bool) error {
+	samples := make([][]FMP4Sample, len(w.tracks))
+	fragment := &fmp4Fragment{}
+	empty := true
+	for i, t := range w.tracks {
+		n := len(t.samples)
+		if n != 0 && t.samples[n-1].Duration == 0 {
+			if final {
+				t.samples[n-1].Duration = t.lastDuration()
+			} else {
+				n--
+			}
+		}
+		samples[i] = t.samples[:n]
+		t.samples = t.samples[n:]
+		empty = empty && n == 0
+		var duration uint64
+		for _, sample := range samples[i] {
+			duration += uint64(sample.Duration)
+		}
+		if d := t.duration(duration); d > fragment.Duration {
+			fragment.Duration = d
+		}
+	}
+	if empty {
+		return nil
+	}
+	first := samples[0]
+	fragment.KeyFrame = !w.tracks[0].video || len(first) != 0 && first[0].KeyFrame
+	fragment.Data = w.muxer.Fragment(samples...)
+	return w.onFragment(fragment)
+}
+
+// flush writes the pending samples, it's called before close.
+func (w *fmp4Writer) flush() error {
+	for _, t := range w.tracks {
+		t.flush()
+	}
+	if w.muxer == nil {
+		return nil
+	}
+	return w.writeFragment(true)
+}
+
+func (w *fmp4Writer) close() {
+	for _, t := range w.tracks {
+		t.close()
+	}
+}
+
+// lastDuration guesses the duration of the last sample by the previous one.
+func (t *mp4Track) lastDuration() uint32 {
+	if n := len(t.samples); n > 1 {
+		return t.samples[n-2].Duration
+	}
+	if t.video {
+		return t.fmp4.Timescale / defaultFrameRate
+	}
+	return t.fmp4.Timescale / defaultAudioFrameRate
+}
+
+// h264ParameterSets finds the sps and pps in the nal units of avc format.
+func h264ParameterSets(data []byte) (sps, pps []byte) {
+	for len(data) > mp4NALULengthSize {
+		size := int(binary.BigEndian.Uint32(data))
+		data = data[mp4NALULengthSize:]
+		if size == 0 || size > len(data) {
+			break
+		}
+		switch data[0] & 0x1f {
+		case naluTypeSPS:
+			sps = data[:size]
+		case naluTypePPS:
+			pps = data[:size]
+		}
+		data = data[size:]
+	}
+	return sps, pps
+}
+
+// av1StripTemporalDelimiter removes the leading temporal delimiter, mp4 samples have no one.
+func av1StripTemporalDelimiter(data []byte) []byte {
+	if len(data) >= 2 && (data[0]>>3)&0x0f == av1.ObuTypeTemporalDelimiter && data[1] == 0 {
+		return data[2:]
+	}
+	return data
+}
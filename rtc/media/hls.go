@@ -0,0 +1,399 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtp"
+)
+
+const (
+	defaultSegmentDuration = 4 * time.Second
+	defaultPlaylistSize    = 6
+	// the parts are listed for the last segments only.
+	hlsPartSegments = 3
+
+	HLSPlaylistName = "index.m3u8"
+	HLSInitName     = "init.mp4"
+)
+
+var ErrHLSClosed = errors.New("hls closed")
+
+type HLSOption struct {
+	ID string // the prefix of the direct connection ids, random if empty
+	// The receivers could be of different connections, one of them could be empty.
+	VideoConnectionID string
+	VideoReceiverID   string
+	AudioConnectionID string
+	AudioReceiverID   string
+	// Directory is optional, the playlist and segments are written to it as well.
+	Directory string
+	// SegmentDuration is the target duration of segments, 4s by default.
+	// A segment starts with a video keyframe, so it could be longer.
+	SegmentDuration time.Duration
+	// PartDuration enables the partial segments of LL-HLS if not zero, it should be much less than the segment duration.
+	PartDuration time.Duration
+	// PlaylistSize is the max number of segments in the playlist, 6 by default.
+	PlaylistSize int
+}
+
+type hlsPart struct {
+	data        []byte
+	duration    time.Duration
+	independent bool
+}
+
+type hlsSegment struct {
+	sequence int
+	parts    []*hlsPart
+	data     []byte
+	duration time.Duration
+	complete bool
+}
+
+func (s *hlsSegment) name() string {
+	return fmt.Sprintf("segment%d.m4s", s.sequence)
+}
+
+func (s *hlsSegment) partName(i int) string {
+	return fmt.Sprintf("segment%d.%d.m4s", s.sequence, i)
+}
+
+// HLS serves a video and an audio receiver as HLS, the segments are CMAF, so the opus
+// could be carried, and they are cut on the video keyframes.
+// The playlist and segments are served by ServeHTTP, the path of request is ignored except the file name.
+// It's finished when any receiver closed.
+type HLS struct {
+	option HLSOption
+	writer *fmp4Writer
+
+	mutex    sync.Mutex
+	closed   bool
+	init     []byte
+	segments []*hlsSegment // the last one could be in progress
+	sequence int
+	// segmentEnd is set when the fragment being cut ends current segment.
+	segmentEnd bool
+	// updated is closed and renewed when the playlist changed.
+	updated chan struct{}
+}
+
+func NewHLS(broker *peer.Broker, option *HLSOption) (*HLS, error) {
+	h := &HLS{option: *option, updated: make(chan struct{})}
+	if h.option.ID == "" {
+		h.option.ID = peer.RandomString(12)
+	}
+	if h.option.SegmentDuration == 0 {
+		h.option.SegmentDuration = defaultSegmentDuration
+	}
+	if h.option.PlaylistSize == 0 {
+		h.option.PlaylistSize = defaultPlaylistSize
+	}
+	var err error
+	h.writer, err = newFMP4Writer(broker, fmp4Source{
+		VideoConnectionID: option.VideoConnectionID,
+		VideoReceiverID:   option.VideoReceiverID,
+		AudioConnectionID: option.AudioConnectionID,
+		AudioReceiverID:   option.AudioReceiverID,
+		Path:              option.Directory,
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.writer.cut = h.cut
+	h.writer.onInit = func(data []byte) error {
+		h.init = data
+		return h.writeFile(HLSInitName, data)
+	}
+	h.writer.onFragment = h.onFragment
+	if option.Directory != "" {
+		if err = os.MkdirAll(option.Directory, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if err = h.writer.subscribe(broker, h.option.ID, h.onRTP); err != nil {
+		return nil, err
+	}
+	h.writer.onClose(func() {
+		_ = h.Close()
+	})
+	return h, nil
+}
+
+func (h *HLS) onRTP(t *mp4Track, packet *rtp.Packet) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return
+	}
+	t.onRTP(packet)
+}
+
+// cut starts a new segment at the keyframe if current one is long enough, and a new part if the next frame exceeds it.
+func (h *HLS) cut(keyframe bool, span, next time.Duration) bool {
+	var segment time.Duration
+	if s := h.current(); s != nil {
+		segment = s.duration
+	}
+	if (keyframe || h.writer.video == nil) && segment+span >= h.option.SegmentDuration {
+		h.segmentEnd = true
+		return true
+	}
+	return h.option.PartDuration != 0 && span+next > h.option.PartDuration
+}
+
+// current returns the segment in progress, it's nil if all are complete.
+func (h *HLS) current() *hlsSegment {
+	if n := len(h.segments); n != 0 && !h.segments[n-1].complete {
+		return h.segments[n-1]
+	}
+	return nil
+}
+
+func (h *HLS) onFragment(fragment *fmp4Fragment) error {
+	s := h.current()
+	if s == nil {
+		s = &hlsSegment{sequence: h.sequence}
+		h.sequence++
+		h.segments = append(h.segments, s)
+	}
+	s.data = append(s.data, fragment.Data...)
+	s.duration += fragment.Duration
+	if h.option.PartDuration != 0 {
+		s.parts = append(s.parts, &hlsPart{data: fragment.Data, duration: fragment.Duration, independent: fragment.KeyFrame})
+		if err := h.writeFile(s.partName(len(s.parts)-1), fragment.Data); err != nil {
+			return err
+		}
+	}
+	if h.segmentEnd || h.option.PartDuration == 0 {
+		h.segmentEnd = false
+		return h.complete(s)
+	}
+	return h.update()
+}
+
+// complete writes the segment and removes the ones out of the playlist.
+func (h *HLS) complete(s *hlsSegment) error {
+	s.complete = true
+	if err := h.writeFile(s.name(), s.data); err != nil {
+		return err
+	}
+	for len(h.segments) > h.option.PlaylistSize {
+		h.removeFiles(h.segments[0])
+		h.segments = h.segments[1:]
+	}
+	return h.update()
+}
+
+// update writes the playlist and wakes up the blocking requests.
+func (h *HLS) update() error {
+	close(h.updated)
+	h.updated = make(chan struct{})
+	if h.option.Directory == "" {
+		return nil
+	}
+	// rename it, so the reader never sees a partial playlist.
+	name := filepath.Join(h.option.Directory, HLSPlaylistName)
+	if err := os.WriteFile(name+".tmp", []byte(h.playlist()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func (h *HLS) writeFile(name string, data []byte) error {
+	if h.option.Directory == "" {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(h.option.Directory, name), data, 0o644)
+}
+
+func (h *HLS) removeFiles(s *hlsSegment) {
+	if h.option.Directory == "" {
+		return
+	}
+	_ = os.Remove(filepath.Join(h.option.Directory, s.name()))
+	for i := range s.parts {
+		_ = os.Remove(filepath.Join(h.option.Directory, s.partName(i)))
+	}
+}
+
+func (h *HLS) playlist() string {
+	target := h.option.SegmentDuration
+	for _, s := range h.segments {
+		if s.duration > target {
+			target = s.duration
+		}
+	}
+	b := &strings.Builder{}
+	b.WriteString("#EXTM3U\n")
+	if h.option.PartDuration != 0 {
+		fmt.Fprintf(b, "#EXT-X-VERSION:9\n#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+		fmt.Fprintf(b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*h.option.PartDuration.Seconds())
+		fmt.Fprintf(b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", h.option.PartDuration.Seconds())
+	} else {
+		fmt.Fprintf(b, "#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	}
+	sequence := h.sequence
+	if len(h.segments) != 0 {
+		sequence = h.segments[0].sequence
+	}
+	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-MAP:URI=\"%s\"\n", sequence, HLSInitName)
+	for i, s := range h.segments {
+		if i >= len(h.segments)-hlsPartSegments {
+			for j, part := range s.parts {
+				fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", part.duration.Seconds(), s.partName(j))
+				if part.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if s.complete {
+			fmt.Fprintf(b, "#EXTINF:%.3f,\n%s\n", s.duration.Seconds(), s.name())
+		}
+	}
+	if h.closed {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
+
+// ready reports whether the segment of msn, or its part if not negative, is in the playlist.
+func (h *HLS) ready(msn, part int) bool {
+	if h.closed || len(h.segments) == 0 {
+		return h.closed
+	}
+	last := h.segments[len(h.segments)-1]
+	if msn != last.sequence {
+		return msn < last.sequence
+	}
+	return last.complete || part >= 0 && part < len(last.parts)
+}
+
+// file returns the content of the init segment, segment or part by name, it's nil if not found.
+func (h *HLS) file(name string) []byte {
+	if name == HLSInitName {
+		return h.init
+	}
+	for _, s := range h.segments {
+		if s.complete && name == s.name() {
+			return s.data
+		}
+		for i, part := range s.parts {
+			if name == s.partName(i) {
+				return part.data
+			}
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the playlist and segments, the blocking playlist reload of LL-HLS
+// is supported by the _HLS_msn and _HLS_part parameters.
+func (h *HLS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	if name != HLSPlaylistName {
+		h.mutex.Lock()
+		data := h.file(name)
+		h.mutex.Unlock()
+		if data == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		_, _ = w.Write(data)
+		return
+	}
+	if msn := r.URL.Query().Get("_HLS_msn"); msn != "" {
+		if !h.wait(w, r, msn) {
+			return
+		}
+	}
+	h.mutex.Lock()
+	var playlist string
+	if h.init != nil {
+		playlist = h.playlist()
+	}
+	h.mutex.Unlock()
+	if playlist == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := w.Write([]byte(playlist)); err != nil {
+		logger.Debug("write playlist fail:", err)
+	}
+}
+
+// wait blocks the request until the segment or part is ready, or timeout.
+// It returns false if the request is finished.
+func (h *HLS) wait(w http.ResponseWriter, r *http.Request, value string) bool {
+	msn, err := strconv.Atoi(value)
+	if err != nil {
+		http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+		return false
+	}
+	part := -1
+	if value := r.URL.Query().Get("_HLS_part"); value != "" {
+		if part, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+			return false
+		}
+	}
+	timeout := time.NewTimer(3 * h.option.SegmentDuration)
+	defer timeout.Stop()
+	for {
+		h.mutex.Lock()
+		ready, updated, next := h.ready(msn, part), h.updated, h.sequence
+		h.mutex.Unlock()
+		if ready {
+			return true
+		}
+		// the msn is more than two segments ahead, the request is invalid for ll-hls.
+		if msn > next+2 {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return false
+		}
+		select {
+		case <-updated:
+		case <-timeout.C:
+			return true
+		case <-r.Context().Done():
+			return false
+		}
+	}
+}
+
+// Close writes the pending samples as the last segment and ends the playlist.
+func (h *HLS) Close() error {
+	h.mutex.Lock()
+	if h.closed {
+		h.mutex.Unlock()
+		return ErrHLSClosed
+	}
+	err := h.writer.flush()
+	h.closed = true
+	if s := h.current(); s != nil {
+		if e := h.complete(s); err == nil {
+			err = e
+		}
+	} else if e := h.update(); err == nil {
+		err = e
+	}
+	h.mutex.Unlock()
+
+	h.writer.close()
+	return err
+}
@@ -0,0 +1,136 @@
+package media
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtp"
+)
+
+// newTestHLS returns a hls of a h264 receiver, and a function writes the keyframe or not to it.
+func newTestHLS(t *testing.T, option *HLSOption) (*HLS, *peer.Connection, func(ts uint32, keyframe bool)) {
+	_, nalus := newTestAnnexB(t)
+	broker := newTestBroker(t)
+	conn, err := broker.NewDirectConnection(&peer.DirectOption{ID: "pub"})
+	assert(t, err, nil)
+	_, err = conn.NewReceiver(&peer.ReceiverOption{
+		ID:        "video",
+		MID:       "0",
+		MediaType: rtc.MediaTypeVideo,
+		Codec:     &peer.Codec{PayloadType: 102, EncoderName: "H264", ClockRate: 90000},
+		Streams:   []peer.StreamOption{{SSRC: 1111, PayloadType: 102}},
+	})
+	assert(t, err, nil)
+	transport := conn.Transport().(*peer.DirectTransport)
+	assert(t, transport.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1111, PayloadType: 102, Marker: true}, Payload: nalus[3]}), nil)
+	option.VideoConnectionID = "pub"
+	option.VideoReceiverID = "video"
+	h, err := NewHLS(broker, option)
+	assert(t, err, nil)
+	var seq uint16
+	write := func(ts uint32, keyframe bool) {
+		payloads := nalus[3:]
+		if keyframe {
+			payloads = nalus[:3]
+		}
+		for i, payload := range payloads {
+			seq++
+			h.onRTP(h.writer.video, &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts, Marker: i == len(payloads)-1}, Payload: payload})
+		}
+	}
+	return h, conn, write
+}
+
+func testGet(h http.Handler, url string) (int, string) {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestHLS(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "segments",
+			description: "the segments are cut on keyframes, and written to the directory",
+			method: func(t *testing.T) {
+				dir := t.TempDir()
+				h, conn, write := newTestHLS(t, &HLSOption{Directory: dir, SegmentDuration: 100 * time.Millisecond, PlaylistSize: 2})
+				code, _ := testGet(h, "/live/"+HLSPlaylistName)
+				assert(t, code, http.StatusNotFound)
+				// 50ms each frame, the keyframe at 50ms is too close to the start.
+				for i := uint32(0); i < 8; i++ {
+					write(i*4500, i == 0 || i == 1 || i == 3 || i == 5)
+				}
+				code, playlist := testGet(h, "/live/"+HLSPlaylistName)
+				assert(t, code, http.StatusOK)
+				assert(t, playlist, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-MAP:URI=\"init.mp4\"\n"+
+					"#EXTINF:0.150,\nsegment0.m4s\n#EXTINF:0.100,\nsegment1.m4s\n")
+				_, init := testGet(h, "/live/"+HLSInitName)
+				assert(t, testBoxTypes([]byte(init)), []string{"ftyp", "moov"})
+				_, segment := testGet(h, "/live/segment0.m4s")
+				assert(t, testBoxTypes([]byte(segment)), []string{"moof", "mdat"})
+				assert(t, testFragments([]byte(segment))[1], [][2]uint64{{0, 3}})
+				code, _ = testGet(h, "/live/segment2.m4s")
+				assert(t, code, http.StatusNotFound)
+
+				conn.Close()
+				assert(t, h.Close(), ErrHLSClosed)
+				_, playlist = testGet(h, "/live/"+HLSPlaylistName)
+				assert(t, strings.HasSuffix(playlist, "#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:0.100,\nsegment1.m4s\n#EXTINF:0.150,\nsegment2.m4s\n#EXT-X-ENDLIST\n"), true)
+				content, err := os.ReadFile(filepath.Join(dir, HLSPlaylistName))
+				assert(t, err, nil)
+				assert(t, string(content), playlist)
+				_, err = os.Stat(filepath.Join(dir, "segment0.m4s"))
+				assert(t, os.IsNotExist(err), true)
+				content, err = os.ReadFile(filepath.Join(dir, "segment2.m4s"))
+				assert(t, err, nil)
+				_, segment = testGet(h, "/live/segment2.m4s")
+				assert(t, string(content), segment)
+			},
+		},
+		{
+			name:        "parts",
+			description: "the parts are listed for ll-hls, and the playlist request is blocked until the part is ready",
+			method: func(t *testing.T) {
+				h, _, write := newTestHLS(t, &HLSOption{SegmentDuration: 100 * time.Millisecond, PartDuration: 40 * time.Millisecond})
+				defer h.Close()
+				write(0, true)
+				code, _ := testGet(h, "/live/"+HLSPlaylistName+"?_HLS_msn=5")
+				assert(t, code, http.StatusBadRequest)
+				// two segments ahead is valid, it's blocked until the timeout.
+				code, _ = testGet(h, "/live/"+HLSPlaylistName+"?_HLS_msn=2")
+				assert(t, code, http.StatusOK)
+				done := make(chan string)
+				go func() {
+					_, playlist := testGet(h, "/live/"+HLSPlaylistName+"?_HLS_msn=0&_HLS_part=1")
+					done <- playlist
+				}()
+				// 20ms each frame, so a part has two frames.
+				for i := uint32(1); i < 7; i++ {
+					write(i*1800, i == 5)
+				}
+				playlist := <-done
+				assert(t, strings.Contains(playlist, "#EXT-X-PART:DURATION=0.040,URI=\"segment0.0.m4s\",INDEPENDENT=YES\n#EXT-X-PART:DURATION=0.040,URI=\"segment0.1.m4s\"\n"), true)
+				assert(t, strings.Contains(playlist, "#EXT-X-PART-INF:PART-TARGET=0.040\n"), true)
+
+				_, playlist = testGet(h, "/live/"+HLSPlaylistName)
+				assert(t, strings.HasSuffix(playlist, "URI=\"segment0.1.m4s\"\n#EXT-X-PART:DURATION=0.020,URI=\"segment0.2.m4s\"\n#EXTINF:0.100,\nsegment0.m4s\n"), true)
+				_, part := testGet(h, "/live/segment0.1.m4s")
+				_, segment := testGet(h, "/live/segment0.m4s")
+				assert(t, strings.Contains(segment, part), true)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
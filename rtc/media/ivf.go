@@ -0,0 +1,197 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec/av1"
+	"github.com/gotolive/sfu/rtc/codec/vp8"
+	"github.com/gotolive/sfu/rtc/codec/vp9"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+const (
+	ivfSignature       = "DKIF"
+	ivfFileHeaderSize  = 32
+	ivfFrameHeaderSize = 12
+	videoClockRate     = 90000
+)
+
+var ivfFourccs = map[string]string{
+	"VP80": vp8.CodecName,
+	"VP90": vp9.CodecName,
+	"AV01": av1.CodecName,
+}
+
+// IVFHeader is the file header of ivf, the timestamps of frames are in timebase units,
+// which is Numerator/Denominator seconds.
+type IVFHeader struct {
+	Fourcc      string
+	Width       uint16
+	Height      uint16
+	Denominator uint32
+	Numerator   uint32
+	FrameCount  uint32
+}
+
+// IVFReader reads VP8, VP9 and AV1 frames from an ivf file.
+type IVFReader struct {
+	reader io.ReadSeeker
+	header IVFHeader
+	codec  string
+	first  uint64
+	start  bool
+}
+
+func NewIVFReader(reader io.ReadSeeker) (*IVFReader, error) {
+	r := &IVFReader{reader: reader}
+	if err := r.readHeader(); err != nil {
+		return nil, err
+	}
+	codec, ok := ivfFourccs[r.header.Fourcc]
+	if !ok {
+		return nil, ErrUnsupportedCodec
+	}
+	r.codec = codec
+	return r, nil
+}
+
+func (r *IVFReader) readHeader() error {
+	buf := make([]byte, ivfFileHeaderSize)
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return ErrInvalidFile
+	}
+	if string(buf[:4]) != ivfSignature {
+		return ErrInvalidFile
+	}
+	headerSize := binary.LittleEndian.Uint16(buf[6:])
+	r.header = IVFHeader{
+		Fourcc:      string(buf[8:12]),
+		Width:       binary.LittleEndian.Uint16(buf[12:]),
+		Height:      binary.LittleEndian.Uint16(buf[14:]),
+		Denominator: binary.LittleEndian.Uint32(buf[16:]),
+		Numerator:   binary.LittleEndian.Uint32(buf[20:]),
+		FrameCount:  binary.LittleEndian.Uint32(buf[24:]),
+	}
+	if r.header.Denominator == 0 || r.header.Numerator == 0 {
+		return ErrInvalidFile
+	}
+	if headerSize > ivfFileHeaderSize {
+		if _, err := r.reader.Seek(int64(headerSize-ivfFileHeaderSize), io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *IVFReader) Header() IVFHeader {
+	return r.header
+}
+
+func (r *IVFReader) MediaType() string {
+	return rtc.MediaTypeVideo
+}
+
+func (r *IVFReader) Codec() *peer.Codec {
+	return &peer.Codec{EncoderName: r.codec, ClockRate: videoClockRate}
+}
+
+func (r *IVFReader) Next() (*Frame, error) {
+	buf := make([]byte, ivfFrameHeaderSize)
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(buf)
+	pts := binary.LittleEndian.Uint64(buf[4:])
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		// a truncated frame is the end of a file being written.
+		return nil, io.EOF
+	}
+	if !r.start {
+		r.start = true
+		r.first = pts
+	}
+	// pts * numerator / denominator seconds
+	ts := (pts - r.first) * videoClockRate * uint64(r.header.Numerator) / uint64(r.header.Denominator)
+	return &Frame{Data: data, Timestamp: uint32(ts)}, nil
+}
+
+func (r *IVFReader) Reset() error {
+	if _, err := r.reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r.start = false
+	return r.readHeader()
+}
+
+// IVFWriter writes frames to an ivf file, the timebase is 1/90000 so the rtp timestamp could be used.
+type IVFWriter struct {
+	writer io.WriteSeeker
+	header IVFHeader
+}
+
+func NewIVFWriter(writer io.WriteSeeker, encoderName string) (*IVFWriter, error) {
+	w := &IVFWriter{writer: writer, header: IVFHeader{Denominator: videoClockRate, Numerator: 1}}
+	for fourcc, name := range ivfFourccs {
+		if name == encoderName {
+			w.header.Fourcc = fourcc
+		}
+	}
+	if w.header.Fourcc == "" {
+		return nil, ErrUnsupportedCodec
+	}
+	if err := w.writeHeader(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *IVFWriter) writeHeader() error {
+	buf := make([]byte, ivfFileHeaderSize)
+	copy(buf, ivfSignature)
+	binary.LittleEndian.PutUint16(buf[6:], ivfFileHeaderSize)
+	copy(buf[8:], w.header.Fourcc)
+	binary.LittleEndian.PutUint16(buf[12:], w.header.Width)
+	binary.LittleEndian.PutUint16(buf[14:], w.header.Height)
+	binary.LittleEndian.PutUint32(buf[16:], w.header.Denominator)
+	binary.LittleEndian.PutUint32(buf[20:], w.header.Numerator)
+	binary.LittleEndian.PutUint32(buf[24:], w.header.FrameCount)
+	_, err := w.writer.Write(buf)
+	return err
+}
+
+// SetSize sets the video size in header, it's written when closed.
+func (w *IVFWriter) SetSize(width, height uint16) {
+	w.header.Width, w.header.Height = width, height
+}
+
+// WriteFrame writes a frame, the pts is in 90khz.
+func (w *IVFWriter) WriteFrame(data []byte, pts uint64) error {
+	buf := make([]byte, ivfFrameHeaderSize, ivfFrameHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	binary.LittleEndian.PutUint64(buf[4:], pts)
+	if _, err := w.writer.Write(append(buf, data...)); err != nil {
+		return err
+	}
+	w.header.FrameCount++
+	return nil
+}
+
+// Close rewrites the header with the frame count and size, the writer is closed if it's an io.Closer.
+func (w *IVFWriter) Close() error {
+	_, err := w.writer.Seek(0, io.SeekStart)
+	if err == nil {
+		err = w.writeHeader()
+	}
+	if closer, ok := w.writer.(io.Closer); ok {
+		if e := closer.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
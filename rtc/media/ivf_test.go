@@ -0,0 +1,86 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// newTestIVF returns an ivf file of the frames, the timebase is 1/1000 and frames are 10ms apart.
+func newTestIVF(fourcc string, frames ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	header := make([]byte, ivfFileHeaderSize)
+	copy(header, ivfSignature)
+	binary.LittleEndian.PutUint16(header[6:], ivfFileHeaderSize)
+	copy(header[8:], fourcc)
+	binary.LittleEndian.PutUint16(header[12:], 640)
+	binary.LittleEndian.PutUint16(header[14:], 480)
+	binary.LittleEndian.PutUint32(header[16:], 1000)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	binary.LittleEndian.PutUint32(header[24:], uint32(len(frames)))
+	buf.Write(header)
+	for i, frame := range frames {
+		h := make([]byte, ivfFrameHeaderSize)
+		binary.LittleEndian.PutUint32(h, uint32(len(frame)))
+		binary.LittleEndian.PutUint64(h[4:], uint64(100+i*10))
+		buf.Write(h)
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+func TestIVFReader(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "frames",
+			description: "read frames with timestamps in 90khz from the first frame",
+			method: func(t *testing.T) {
+				r, err := NewIVFReader(bytes.NewReader(newTestIVF("VP80", []byte{1, 2}, []byte{3})))
+				assert(t, err, nil)
+				assert(t, r.Header().Width, uint16(640))
+				assert(t, r.Codec().EncoderName, "VP8")
+				assert(t, r.Codec().ClockRate, 90000)
+				for round := 0; round < 2; round++ {
+					frame, err := r.Next()
+					assert(t, err, nil)
+					assert(t, frame.Data, []byte{1, 2})
+					assert(t, frame.Timestamp, uint32(0))
+					frame, err = r.Next()
+					assert(t, err, nil)
+					assert(t, frame.Data, []byte{3})
+					assert(t, frame.Timestamp, uint32(900))
+					_, err = r.Next()
+					assert(t, err, io.EOF)
+					assert(t, r.Reset(), nil)
+				}
+			},
+		},
+		{
+			name:        "invalid",
+			description: "unknown fourcc and bad signature are rejected",
+			method: func(t *testing.T) {
+				_, err := NewIVFReader(bytes.NewReader(newTestIVF("H264")))
+				assert(t, err, ErrUnsupportedCodec)
+				_, err = NewIVFReader(bytes.NewReader([]byte("OggS")))
+				assert(t, err, ErrInvalidFile)
+			},
+		},
+		{
+			name:        "truncated",
+			description: "a truncated frame ends the file",
+			method: func(t *testing.T) {
+				file := newTestIVF("VP90", []byte{1, 2, 3})
+				r, err := NewIVFReader(bytes.NewReader(file[:len(file)-1]))
+				assert(t, err, nil)
+				_, err = r.Next()
+				assert(t, err, io.EOF)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
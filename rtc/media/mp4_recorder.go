@@ -0,0 +1,159 @@
+package media
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtp"
+)
+
+const defaultFragmentDuration = 2 * time.Second
+
+type MP4RecorderOption struct {
+	ID string // the prefix of the direct connection ids, random if empty
+	// The receivers could be of different connections, one of them could be empty.
+	VideoConnectionID string
+	VideoReceiverID   string
+	AudioConnectionID string
+	AudioReceiverID   string
+	Path              string
+	// SummaryPath is optional, the summary is written to it as json when closed.
+	SummaryPath string
+	// FragmentDuration is the max duration of a fragment, 2s by default,
+	// and a fragment is started on each video keyframe.
+	FragmentDuration time.Duration
+}
+
+// MP4RecordSummary describes a mp4 recording, the start time is the wall clock of the sender
+// when the first sample captured, or the arrival time if no sender report.
+type MP4RecordSummary struct {
+	Path      string         `json:"path"`
+	StartTime time.Time      `json:"startTime"`
+	Duration  time.Duration  `json:"duration"`
+	Fragments int            `json:"fragments"`
+	Bytes     int64          `json:"bytes"`
+	Video     *RecordSummary `json:"video,omitempty"`
+	Audio     *RecordSummary `json:"audio,omitempty"`
+}
+
+// MP4Recorder writes a video and an audio receiver to a fragmented mp4 file.
+// The recording starts with a video keyframe, the tracks are aligned by the sender reports,
+// so the audio captured at the same time has the same decode time. The arrival time is used
+// if the first track has no sender report.
+type MP4Recorder struct {
+	option MP4RecorderOption
+	file   *os.File
+	writer *fmp4Writer
+
+	mutex   sync.Mutex
+	closed  bool
+	summary MP4RecordSummary
+}
+
+func NewMP4Recorder(broker *peer.Broker, option *MP4RecorderOption) (*MP4Recorder, error) {
+	r := &MP4Recorder{option: *option, summary: MP4RecordSummary{Path: option.Path}}
+	if r.option.ID == "" {
+		r.option.ID = peer.RandomString(12)
+	}
+	if r.option.FragmentDuration == 0 {
+		r.option.FragmentDuration = defaultFragmentDuration
+	}
+	var err error
+	r.writer, err = newFMP4Writer(broker, fmp4Source{
+		VideoConnectionID: option.VideoConnectionID,
+		VideoReceiverID:   option.VideoReceiverID,
+		AudioConnectionID: option.AudioConnectionID,
+		AudioReceiverID:   option.AudioReceiverID,
+		Path:              option.Path,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.writer.cut = func(keyframe bool, span, _ time.Duration) bool {
+		return keyframe || span >= r.option.FragmentDuration
+	}
+	r.writer.onInit = r.write
+	r.writer.onFragment = func(fragment *fmp4Fragment) error {
+		r.summary.Fragments++
+		return r.write(fragment.Data)
+	}
+	if r.file, err = os.Create(option.Path); err != nil {
+		return nil, err
+	}
+	if err = r.writer.subscribe(broker, r.option.ID, r.onRTP); err != nil {
+		_ = r.file.Close()
+		return nil, err
+	}
+	r.writer.onClose(func() {
+		_ = r.Close()
+	})
+	return r, nil
+}
+
+func (r *MP4Recorder) onRTP(t *mp4Track, packet *rtp.Packet) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	t.onRTP(packet)
+}
+
+func (r *MP4Recorder) write(data []byte) error {
+	if _, err := r.file.Write(data); err != nil {
+		return err
+	}
+	r.summary.Bytes += int64(len(data))
+	return nil
+}
+
+func (r *MP4Recorder) Summary() MP4RecordSummary {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.summaryLocked()
+}
+
+func (r *MP4Recorder) summaryLocked() MP4RecordSummary {
+	summary := r.summary
+	if r.writer.muxer != nil {
+		summary.StartTime = r.writer.startTime()
+		summary.Duration = r.writer.duration
+	}
+	if r.writer.video != nil {
+		video := r.writer.video.recordSummary()
+		summary.Video = &video
+	}
+	if r.writer.audio != nil {
+		audio := r.writer.audio.recordSummary()
+		summary.Audio = &audio
+	}
+	return summary
+}
+
+// Close writes the pending samples and the summary, it's called when any receiver closed.
+func (r *MP4Recorder) Close() error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return ErrRecorderClosed
+	}
+	r.closed = true
+	err := r.writer.flush()
+	summary := r.summaryLocked()
+	r.mutex.Unlock()
+
+	r.writer.close()
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+	if r.option.SummaryPath != "" {
+		data, _ := json.MarshalIndent(summary, "", "  ")
+		if e := os.WriteFile(r.option.SummaryPath, data, 0o644); err == nil {
+			err = e
+		}
+	}
+	return err
+}
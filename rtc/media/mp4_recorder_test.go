@@ -0,0 +1,166 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// testFragments returns the tfdt and sample count of each traf by the track id.
+func testFragments(data []byte) map[uint32][][2]uint64 {
+	fragments := map[uint32][][2]uint64{}
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if string(data[4:8]) == "moof" {
+			moof := data[8:size]
+			for len(moof) >= 8 {
+				boxSize := int(binary.BigEndian.Uint32(moof))
+				if string(moof[4:8]) == "traf" {
+					traf := moof[:boxSize]
+					id := binary.BigEndian.Uint32(findTestBox(traf, "traf", "tfhd")[4:])
+					dts := binary.BigEndian.Uint64(findTestBox(traf, "traf", "tfdt")[4:])
+					count := binary.BigEndian.Uint32(findTestBox(traf, "traf", "trun")[4:])
+					fragments[id] = append(fragments[id], [2]uint64{dts, uint64(count)})
+				}
+				moof = moof[boxSize:]
+			}
+		}
+		data = data[size:]
+	}
+	return fragments
+}
+
+func TestMP4Recorder(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "sync",
+			description: "the audio is aligned to the video by sender reports, and the file is finished when the publisher closed",
+			method: func(t *testing.T) {
+				_, nalus := newTestAnnexB(t)
+				broker := newTestBroker(t)
+				conn, err := broker.NewDirectConnection(&peer.DirectOption{ID: "pub"})
+				assert(t, err, nil)
+				_, err = conn.NewReceiver(&peer.ReceiverOption{
+					ID:        "video",
+					MID:       "0",
+					MediaType: rtc.MediaTypeVideo,
+					Codec: &peer.Codec{
+						PayloadType: 102, EncoderName: "H264", ClockRate: 90000,
+						FeedbackParams: []peer.RtcpFeedback{{Type: "nack", Parameter: "pli"}},
+					},
+					Streams: []peer.StreamOption{{SSRC: 1111, PayloadType: 102}},
+				})
+				assert(t, err, nil)
+				_, err = conn.NewReceiver(&peer.ReceiverOption{
+					ID:        "audio",
+					MID:       "1",
+					MediaType: rtc.MediaTypeAudio,
+					Codec:     &peer.Codec{PayloadType: 111, EncoderName: CodecNameOpus, ClockRate: 48000, Channels: 2},
+					Streams:   []peer.StreamOption{{SSRC: 2222, PayloadType: 111}},
+				})
+				assert(t, err, nil)
+				transport := conn.Transport().(*peer.DirectTransport)
+				// the receiver knows the stream after the first packet.
+				assert(t, transport.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1111, PayloadType: 102, Timestamp: 90000, Marker: true}, Payload: nalus[3]}), nil)
+				assert(t, transport.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 2222, PayloadType: 111, Timestamp: 48000, Marker: true}, Payload: []byte{0xf8}}), nil)
+				// both are captured at the base time.
+				base := time.Unix(1700000000, 0)
+				assert(t, transport.WriteRtcp(
+					&rtcp.SenderReport{SSRC: 1111, NTPTime: rtc.ToNtpTime(base), RTPTime: 90000},
+					&rtcp.SenderReport{SSRC: 2222, NTPTime: rtc.ToNtpTime(base), RTPTime: 48000},
+				), nil)
+
+				dir := t.TempDir()
+				path := filepath.Join(dir, "test.mp4")
+				recorder, err := NewMP4Recorder(broker, &MP4RecorderOption{
+					VideoConnectionID: "pub",
+					VideoReceiverID:   "video",
+					AudioConnectionID: "pub",
+					AudioReceiverID:   "audio",
+					Path:              path,
+					SummaryPath:       filepath.Join(dir, "test.json"),
+				})
+				assert(t, err, nil)
+				video := func(seq uint16, ts uint32, marker bool, payload []byte) {
+					recorder.onRTP(recorder.writer.video, &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts, Marker: marker}, Payload: payload})
+				}
+				audio := func(seq uint16, ts uint32) {
+					recorder.onRTP(recorder.writer.audio, &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts, Marker: true}, Payload: []byte{0xf8, byte(seq)}})
+				}
+				// before the first keyframe at 100ms.
+				audio(1, 48000+2400)
+				video(1, 99000, false, nalus[0])
+				video(2, 99000, false, nalus[1])
+				video(3, 99000, true, nalus[2])
+				// 150ms
+				audio(2, 48000+7200)
+				audio(3, 48000+8160)
+				audio(4, 48000+9120)
+				video(4, 102000, true, nalus[3])
+				video(5, 108000, false, nalus[0])
+				video(6, 108000, false, nalus[1])
+				video(7, 108000, true, nalus[2])
+				audio(5, 48000+10080)
+				conn.Close()
+				assert(t, recorder.Close(), ErrRecorderClosed)
+
+				data, err := os.ReadFile(path)
+				assert(t, err, nil)
+				assert(t, testBoxTypes(data), []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"})
+				fragments := testFragments(data)
+				assert(t, fragments[1], [][2]uint64{{0, 2}, {9000, 1}})
+				assert(t, fragments[2], [][2]uint64{{2400, 2}, {4320, 2}})
+				// the first sample is the avc keyframe with parameter sets.
+				mdat := findTestBox(data, "mdat")
+				assert(t, mdat[:4], []byte{0, 0, 0, byte(len(nalus[0]))})
+				assert(t, mdat[4:4+len(nalus[0])], nalus[0])
+
+				summary := recorder.Summary()
+				assert(t, summary.StartTime.Equal(base.Add(100*time.Millisecond)), true)
+				assert(t, summary.Fragments, 2)
+				assert(t, summary.Bytes, int64(len(data)))
+				assert(t, summary.Duration, 110*time.Millisecond)
+				assert(t, summary.Video.Frames, 3)
+				assert(t, summary.Video.KeyFrames, 2)
+				assert(t, summary.Audio.Frames, 4)
+				assert(t, summary.Audio.DroppedFrames, 1)
+				content, err := os.ReadFile(filepath.Join(dir, "test.json"))
+				assert(t, err, nil)
+				var saved MP4RecordSummary
+				assert(t, json.Unmarshal(content, &saved), nil)
+				assert(t, saved.Fragments, 2)
+				assert(t, saved.Audio.Frames, 4)
+			},
+		},
+		{
+			name:        "unsupported",
+			description: "the codec should be supported by mp4",
+			method: func(t *testing.T) {
+				broker := newTestBroker(t)
+				reader, err := NewIVFReader(bytes.NewReader(newTestIVF("VP80", []byte{1, 2})))
+				assert(t, err, nil)
+				publisher, err := NewPublisher(broker, &PublisherOption{ID: "pub", Reader: reader})
+				assert(t, err, nil)
+				defer publisher.Close()
+				_, err = NewMP4Recorder(broker, &MP4RecorderOption{VideoConnectionID: "pub", VideoReceiverID: "pub", Path: filepath.Join(t.TempDir(), "test.mp4")})
+				assert(t, err, ErrUnsupportedCodec)
+				_, err = NewMP4Recorder(broker, &MP4RecorderOption{Path: filepath.Join(t.TempDir(), "test.mp4")})
+				assert(t, err, peer.ErrReceiverNotExist)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
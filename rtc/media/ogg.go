@@ -0,0 +1,285 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+const (
+	oggSignature      = "OggS"
+	oggPageHeaderSize = 27
+	opusClockRate     = 48000
+)
+
+var (
+	opusHeadSignature = []byte("OpusHead")
+	opusTagsSignature = []byte("OpusTags")
+)
+
+// OggReader reads opus packets from an ogg file, only the first logical stream is read.
+type OggReader struct {
+	reader   io.ReadSeeker
+	channels int
+	serial   uint32
+	// packets completed in the current page, and the part continued to next page.
+	packets [][]byte
+	partial []byte
+	samples uint64
+}
+
+func NewOggReader(reader io.ReadSeeker) (*OggReader, error) {
+	r := &OggReader{reader: reader}
+	if err := r.readHeader(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readHeader reads the OpusHead and the OpusTags packets.
+func (r *OggReader) readHeader() error {
+	head, err := r.nextPacket()
+	if err != nil || len(head) < 19 || !bytes.HasPrefix(head, opusHeadSignature) {
+		return ErrInvalidFile
+	}
+	r.channels = int(head[9])
+	tags, err := r.nextPacket()
+	if err != nil || !bytes.HasPrefix(tags, opusTagsSignature) {
+		return ErrInvalidFile
+	}
+	return nil
+}
+
+// readPage reads the packets of next page, the crc is not verified.
+func (r *OggReader) readPage() error {
+	header := make([]byte, oggPageHeaderSize)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return io.EOF
+		}
+		return err
+	}
+	if string(header[:4]) != oggSignature {
+		return ErrInvalidFile
+	}
+	serial := binary.LittleEndian.Uint32(header[14:])
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(r.reader, segments); err != nil {
+		return io.EOF
+	}
+	size := 0
+	for _, s := range segments {
+		size += int(s)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return io.EOF
+	}
+	// beginning of stream
+	if header[5]&0x02 != 0 && r.serial == 0 {
+		r.serial = serial
+	}
+	if serial != r.serial {
+		return nil
+	}
+	offset := 0
+	for _, s := range segments {
+		r.partial = append(r.partial, data[offset:offset+int(s)]...)
+		offset += int(s)
+		// a segment less than 255 ends the packet.
+		if s < 255 {
+			r.packets = append(r.packets, r.partial)
+			r.partial = nil
+		}
+	}
+	return nil
+}
+
+func (r *OggReader) nextPacket() ([]byte, error) {
+	for len(r.packets) == 0 {
+		if err := r.readPage(); err != nil {
+			return nil, err
+		}
+	}
+	packet := r.packets[0]
+	r.packets = r.packets[1:]
+	return packet, nil
+}
+
+func (r *OggReader) MediaType() string {
+	return rtc.MediaTypeAudio
+}
+
+// Channels is the channel count of the file, the codec is always 2 channels as webrtc required.
+func (r *OggReader) Channels() int {
+	return r.channels
+}
+
+func (r *OggReader) Codec() *peer.Codec {
+	return &peer.Codec{
+		EncoderName: CodecNameOpus,
+		ClockRate:   opusClockRate,
+		Channels:    2,
+		Parameters:  map[string]string{"minptime": "10", "useinbandfec": "1"},
+	}
+}
+
+func (r *OggReader) Next() (*Frame, error) {
+	for {
+		packet, err := r.nextPacket()
+		if err != nil {
+			return nil, err
+		}
+		if len(packet) == 0 {
+			continue
+		}
+		frame := &Frame{Data: packet, Timestamp: uint32(r.samples)}
+		r.samples += uint64(OpusSamples(packet))
+		return frame, nil
+	}
+}
+
+func (r *OggReader) Reset() error {
+	if _, err := r.reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r.packets, r.partial, r.samples, r.serial = nil, nil, 0, 0
+	return r.readHeader()
+}
+
+// OpusSamples returns the samples of the opus packet at 48khz, see rfc6716 section 3.1.
+func OpusSamples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	toc := packet[0]
+	config := toc >> 3
+	var size int // in 1/10 ms
+	switch {
+	case config < 12: // silk
+		size = []int{100, 200, 400, 600}[config%4]
+	case config < 16: // hybrid
+		size = []int{100, 200}[config%2]
+	default: // celt
+		size = []int{25, 50, 100, 200}[config%4]
+	}
+	var count int
+	switch toc & 0x03 {
+	case 0:
+		count = 1
+	case 1, 2:
+		count = 2
+	default:
+		if len(packet) < 2 {
+			return 0
+		}
+		count = int(packet[1] & 0x3f)
+	}
+	return count * size * opusClockRate / 10000
+}
+
+const (
+	oggFlagBOS    = 0x02
+	oggFlagEOS    = 0x04
+	opusPreSkip   = 0
+	opusVendor    = "gotolive/sfu"
+	oggCrcPolynom = 0x04c11db7
+)
+
+var oggCrcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ oggCrcPolynom
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggChecksum(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCrcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// OggWriter writes opus packets to an ogg file, one packet per page.
+type OggWriter struct {
+	writer   io.Writer
+	serial   uint32
+	sequence uint32
+	granule  uint64
+}
+
+func NewOggWriter(writer io.Writer, channels int) (*OggWriter, error) {
+	w := &OggWriter{writer: writer, serial: rtc.GenerateSSRC()}
+	head := make([]byte, 19)
+	copy(head, opusHeadSignature)
+	head[8] = 1
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:], opusPreSkip)
+	binary.LittleEndian.PutUint32(head[12:], opusClockRate)
+	if err := w.writePage(head, 0, oggFlagBOS); err != nil {
+		return nil, err
+	}
+	tags := make([]byte, 0, 16+len(opusVendor))
+	tags = append(tags, opusTagsSignature...)
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(opusVendor)))
+	tags = append(tags, opusVendor...)
+	tags = binary.LittleEndian.AppendUint32(tags, 0)
+	if err := w.writePage(tags, 0, 0); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *OggWriter) writePage(packet []byte, granule uint64, flag byte) error {
+	segments := len(packet)/255 + 1
+	page := make([]byte, oggPageHeaderSize+segments, oggPageHeaderSize+segments+len(packet))
+	copy(page, oggSignature)
+	page[5] = flag
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], w.serial)
+	binary.LittleEndian.PutUint32(page[18:], w.sequence)
+	page[26] = byte(segments)
+	for i := 0; i < segments-1; i++ {
+		page[oggPageHeaderSize+i] = 255
+	}
+	page[oggPageHeaderSize+segments-1] = byte(len(packet) % 255)
+	page = append(page, packet...)
+	binary.LittleEndian.PutUint32(page[22:], oggChecksum(page))
+	w.sequence++
+	_, err := w.writer.Write(page)
+	return err
+}
+
+// WritePacket writes an opus packet, pts is the samples at 48khz from the beginning,
+// the gap of pts is kept as silence by the player.
+func (w *OggWriter) WritePacket(packet []byte, pts uint64) error {
+	if len(packet) > 255*254 {
+		return ErrFrameTooLarge
+	}
+	w.granule = pts + uint64(OpusSamples(packet))
+	return w.writePage(packet, w.granule+opusPreSkip, 0)
+}
+
+// Close writes the end of stream, the writer is closed if it's an io.Closer.
+func (w *OggWriter) Close() error {
+	err := w.writePage(nil, w.granule+opusPreSkip, oggFlagEOS)
+	if closer, ok := w.writer.(io.Closer); ok {
+		if e := closer.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// newTestOggPage returns a page of the packets, the last packet is continued to next page if partial.
+func newTestOggPage(serial, sequence uint32, flag byte, partial bool, packets ...[]byte) []byte {
+	var segments, data []byte
+	for i, p := range packets {
+		size := len(p)
+		for ; size >= 255; size -= 255 {
+			segments = append(segments, 255)
+		}
+		if !partial || i != len(packets)-1 {
+			segments = append(segments, byte(size))
+		}
+		data = append(data, p...)
+	}
+	header := make([]byte, oggPageHeaderSize)
+	copy(header, oggSignature)
+	header[5] = flag
+	binary.LittleEndian.PutUint32(header[14:], serial)
+	binary.LittleEndian.PutUint32(header[18:], sequence)
+	header[26] = byte(len(segments))
+	return append(append(header, segments...), data...)
+}
+
+func newTestOpusHead(channels byte) []byte {
+	head := make([]byte, 19)
+	copy(head, opusHeadSignature)
+	head[8] = 1
+	head[9] = channels
+	binary.LittleEndian.PutUint32(head[12:], opusClockRate)
+	return head
+}
+
+func TestOggReader(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "packets",
+			description: "read packets across pages, timestamps from toc",
+			method: func(t *testing.T) {
+				// 20ms celt, 2 frames of 10ms celt, a 600 bytes packet continued to next page.
+				large := append([]byte{0xf8}, make([]byte, 599)...)
+				file := new(bytes.Buffer)
+				file.Write(newTestOggPage(7, 0, 0x02, false, newTestOpusHead(1)))
+				file.Write(newTestOggPage(7, 1, 0, false, []byte("OpusTags")))
+				// another logical stream is ignored.
+				file.Write(newTestOggPage(8, 0, 0x02, false, []byte{0xfc, 1}))
+				file.Write(newTestOggPage(7, 2, 0, true, []byte{0xfc, 1}, []byte{0xf1, 2}, large[:255]))
+				file.Write(newTestOggPage(7, 3, 0x01, false, large[255:]))
+
+				r, err := NewOggReader(bytes.NewReader(file.Bytes()))
+				assert(t, err, nil)
+				assert(t, r.Channels(), 1)
+				assert(t, r.Codec().Channels, 2)
+				for round := 0; round < 2; round++ {
+					frame, err := r.Next()
+					assert(t, err, nil)
+					assert(t, frame.Data, []byte{0xfc, 1})
+					assert(t, frame.Timestamp, uint32(0))
+					frame, err = r.Next()
+					assert(t, err, nil)
+					assert(t, frame.Timestamp, uint32(960))
+					frame, err = r.Next()
+					assert(t, err, nil)
+					assert(t, frame.Data, large)
+					assert(t, frame.Timestamp, uint32(1920))
+					_, err = r.Next()
+					assert(t, err, io.EOF)
+					assert(t, r.Reset(), nil)
+				}
+			},
+		},
+		{
+			name:        "invalid",
+			description: "the first packet must be opus head",
+			method: func(t *testing.T) {
+				_, err := NewOggReader(bytes.NewReader(newTestOggPage(1, 0, 0x02, false, []byte("OpusTags"))))
+				assert(t, err, ErrInvalidFile)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
+
+func TestOpusSamples(t *testing.T) {
+	tests := []struct {
+		packet  []byte
+		samples int
+	}{
+		{nil, 0},
+		{[]byte{0x00}, 480},        // silk 10ms
+		{[]byte{0x18}, 2880},       // silk 60ms
+		{[]byte{0x60}, 480},        // hybrid 10ms
+		{[]byte{0x80}, 120},        // celt 2.5ms
+		{[]byte{0xfc}, 960},        // celt 20ms
+		{[]byte{0xfd}, 1920},       // 2 frames
+		{[]byte{0xf3, 0x03}, 1440}, // 3 frames of 10ms
+	}
+	for _, test := range tests {
+		assert(t, OpusSamples(test.packet), test.samples)
+	}
+}
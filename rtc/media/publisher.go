@@ -0,0 +1,208 @@
+package media
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtcp"
+)
+
+const (
+	defaultMTU           = 1200
+	defaultVideoPT       = 96
+	defaultAudioPT       = 111
+	senderReportInterval = time.Second
+)
+
+type PublisherOption struct {
+	ID          string // the id of connection and receiver, random if empty
+	Reader      FrameReader
+	Loop        bool
+	SSRC        uint32          // random if zero
+	PayloadType rtc.PayloadType // 96 for video and 111 for audio if zero
+	MTU         int             // 1200 if zero
+}
+
+// Publisher publishes a media file as a receiver of a direct connection, in real time,
+// the subscribers consume it by Connection.NewSender as any other receiver.
+// The keyframe request is ignored since the file is encoded.
+type Publisher struct {
+	option     PublisherOption
+	connection *peer.Connection
+	transport  *peer.DirectTransport
+	receiver   *peer.Receiver
+	packetizer *codec.FramePacketizer
+	clockRate  uint64
+
+	base      uint32
+	packets   uint32
+	octets    uint32
+	lastSR    time.Time
+	startOnce sync.Once
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	done      chan struct{}
+}
+
+func NewPublisher(broker *peer.Broker, option *PublisherOption) (*Publisher, error) {
+	p := &Publisher{
+		option:  *option,
+		base:    rtc.GenerateSSRC(),
+		closeCh: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if p.option.ID == "" {
+		p.option.ID = peer.RandomString(12)
+	}
+	if p.option.SSRC == 0 {
+		p.option.SSRC = rtc.GenerateSSRC()
+	}
+	if p.option.MTU == 0 {
+		p.option.MTU = defaultMTU
+	}
+	mediaType := option.Reader.MediaType()
+	if p.option.PayloadType == 0 {
+		p.option.PayloadType = defaultVideoPT
+		if mediaType == rtc.MediaTypeAudio {
+			p.option.PayloadType = defaultAudioPT
+		}
+	}
+	mediaCodec := option.Reader.Codec()
+	mediaCodec.PayloadType = p.option.PayloadType
+	p.clockRate = uint64(mediaCodec.ClockRate)
+	var err error
+	p.packetizer, err = codec.NewFramePacketizer(mediaCodec.EncoderName, uint8(p.option.PayloadType), p.option.SSRC, p.option.MTU)
+	if err != nil {
+		return nil, ErrUnsupportedCodec
+	}
+
+	p.connection, err = broker.NewDirectConnection(&peer.DirectOption{ID: p.option.ID})
+	if err != nil {
+		return nil, err
+	}
+	p.transport = p.connection.Transport().(*peer.DirectTransport)
+	p.receiver, err = p.connection.NewReceiver(&peer.ReceiverOption{
+		ID:        p.option.ID,
+		MID:       "0",
+		MediaType: mediaType,
+		Codec:     mediaCodec,
+		Streams:   []peer.StreamOption{{SSRC: p.option.SSRC, Cname: p.option.ID, PayloadType: mediaCodec.PayloadType}},
+	})
+	if err != nil {
+		p.connection.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Publisher) Connection() *peer.Connection {
+	return p.connection
+}
+
+func (p *Publisher) Receiver() *peer.Receiver {
+	return p.receiver
+}
+
+// Start publishing in a goroutine, it stops at the end of file if not loop.
+func (p *Publisher) Start() {
+	p.startOnce.Do(func() {
+		go p.run()
+	})
+}
+
+// Done is closed when publishing stopped.
+func (p *Publisher) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+	start := time.Now()
+	// position is the media time of the current loop start, last and delta are the
+	// timestamp and duration of last frame, the next loop starts after the last frame.
+	var position, last, delta uint64
+	for {
+		frame, err := p.option.Reader.Next()
+		if err == io.EOF && p.option.Loop {
+			if err = p.option.Reader.Reset(); err != nil {
+				logger.Error("reset file fail:", err)
+				return
+			}
+			position += last + delta
+			last = 0
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				logger.Error("read frame fail:", err)
+			}
+			return
+		}
+		if ts := uint64(frame.Timestamp); ts > last {
+			delta = ts - last
+			last = ts
+		}
+		mediaTime := position + uint64(frame.Timestamp)
+		due := start.Add(p.duration(mediaTime))
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-p.closeCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		p.writeFrame(frame.Data, p.base+uint32(mediaTime))
+		p.writeSenderReport(due, p.base+uint32(mediaTime))
+	}
+}
+
+func (p *Publisher) duration(mediaTime uint64) time.Duration {
+	return time.Duration(mediaTime/p.clockRate)*time.Second + time.Duration(mediaTime%p.clockRate)*time.Second/time.Duration(p.clockRate)
+}
+
+func (p *Publisher) writeFrame(data []byte, timestamp uint32) {
+	for _, packet := range p.packetizer.Packetize(data, timestamp) {
+		if err := p.transport.WriteRTP(packet); err != nil {
+			logger.Debug("write rtp fail:", err)
+			return
+		}
+		p.packets++
+		p.octets += uint32(len(packet.Payload))
+	}
+}
+
+// writeSenderReport the subscribers need it for lip sync.
+func (p *Publisher) writeSenderReport(now time.Time, timestamp uint32) {
+	if now.Sub(p.lastSR) < senderReportInterval {
+		return
+	}
+	p.lastSR = now
+	_ = p.transport.WriteRtcp(&rtcp.SenderReport{
+		SSRC:        p.option.SSRC,
+		NTPTime:     rtc.ToNtpTime(now),
+		RTPTime:     timestamp,
+		PacketCount: p.packets,
+		OctetCount:  p.octets,
+	})
+}
+
+// Close stops publishing and closes the connection, the reader is closed if it's an io.Closer.
+func (p *Publisher) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closeCh)
+		// never started
+		p.startOnce.Do(func() {
+			close(p.done)
+		})
+		<-p.done
+		p.connection.Close()
+		if closer, ok := p.option.Reader.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	})
+}
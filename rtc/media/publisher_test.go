@@ -0,0 +1,111 @@
+package media
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtp"
+)
+
+func newTestBroker(t *testing.T) *peer.Broker {
+	broker, err := peer.NewBroker(peer.BrokerOption{})
+	assert(t, err, nil)
+	t.Cleanup(broker.Close)
+	return broker
+}
+
+// subscribe returns the packets sent to a direct connection consumes the receiver.
+func subscribe(t *testing.T, broker *peer.Broker, connectionID, receiverID string) chan *rtp.Packet {
+	packets := make(chan *rtp.Packet, 100)
+	conn, err := broker.NewDirectConnection(&peer.DirectOption{})
+	assert(t, err, nil)
+	conn.Transport().(*peer.DirectTransport).OnRTP(func(p *rtp.Packet) {
+		packets <- p
+	})
+	_, err = conn.NewSender(&peer.SenderOption{ConnectionID: connectionID, ReceiverID: receiverID})
+	assert(t, err, nil)
+	return packets
+}
+
+func readTestPacket(t *testing.T, packets chan *rtp.Packet) *rtp.Packet {
+	select {
+	case p := <-packets:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet timeout")
+	}
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "vp8",
+			description: "frames are packetized by mtu and paced by timestamps",
+			method: func(t *testing.T) {
+				broker := newTestBroker(t)
+				reader, err := NewIVFReader(bytes.NewReader(newTestIVF("VP80", make([]byte, 1500), []byte{0x00, 1})))
+				assert(t, err, nil)
+				publisher, err := NewPublisher(broker, &PublisherOption{ID: "file", Reader: reader})
+				assert(t, err, nil)
+				assert(t, publisher.Receiver().Codec().PayloadType, rtc.PayloadType(96))
+				packets := subscribe(t, broker, "file", "file")
+				start := time.Now()
+				publisher.Start()
+				<-publisher.Done()
+				assert(t, time.Since(start) >= 10*time.Millisecond, true)
+
+				first, second, third := readTestPacket(t, packets), readTestPacket(t, packets), readTestPacket(t, packets)
+				assert(t, first.Marker, false)
+				assert(t, second.Marker, true)
+				assert(t, first.Timestamp, second.Timestamp)
+				assert(t, second.SequenceNumber, first.SequenceNumber+1)
+				assert(t, third.Marker, true)
+				assert(t, third.Timestamp, second.Timestamp+900)
+				publisher.Close()
+				assert(t, broker.Connection("file"), (*peer.Connection)(nil))
+			},
+		},
+		{
+			name:        "loop",
+			description: "the timestamp keeps going after the file rewinds",
+			method: func(t *testing.T) {
+				broker := newTestBroker(t)
+				reader, err := NewIVFReader(bytes.NewReader(newTestIVF("VP80", []byte{0x00, 1}, []byte{0x01, 2})))
+				assert(t, err, nil)
+				publisher, err := NewPublisher(broker, &PublisherOption{Reader: reader, Loop: true})
+				assert(t, err, nil)
+				packets := subscribe(t, broker, publisher.Connection().ID(), publisher.Receiver().ID())
+				publisher.Start()
+				last := readTestPacket(t, packets)
+				for i := 0; i < 4; i++ {
+					p := readTestPacket(t, packets)
+					assert(t, p.Timestamp, last.Timestamp+900)
+					last = p
+				}
+				publisher.Close()
+				publisher.Close()
+			},
+		},
+		{
+			name:        "opus",
+			description: "audio gets the audio payload type",
+			method: func(t *testing.T) {
+				broker := newTestBroker(t)
+				publisher, err := NewPublisher(broker, &PublisherOption{Reader: &OggReader{}})
+				assert(t, err, nil)
+				assert(t, publisher.Receiver().Codec().PayloadType, rtc.PayloadType(111))
+				// never started
+				publisher.Close()
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
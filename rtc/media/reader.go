@@ -0,0 +1,73 @@
+package media
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotolive/sfu/rtc/codec/opus"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+var (
+	ErrInvalidFile      = errors.New("invalid media file")
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	ErrUnknownFileType  = errors.New("unknown file type")
+	ErrFrameTooLarge    = errors.New("frame too large")
+)
+
+const (
+	CodecNameOpus = opus.CodecName
+
+	defaultFrameRate = 30
+)
+
+// Frame is one encoded video frame or audio packet.
+type Frame struct {
+	Data []byte
+	// Timestamp in codec clock rate, relative to the first frame.
+	Timestamp uint32
+}
+
+// FrameReader reads frames from a media container.
+type FrameReader interface {
+	MediaType() string
+	// Codec has no payload type, it's decided by who sends it.
+	Codec() *peer.Codec
+	// Next returns io.EOF after the last frame.
+	Next() (*Frame, error)
+	// Reset rewinds to the first frame.
+	Reset() error
+}
+
+type fileReader struct {
+	FrameReader
+	*os.File
+}
+
+// OpenFile opens a frame reader by the file extension: .ivf, .ogg/.opus, .h264/.264,
+// the h264 file is considered as 30 fps, use NewAnnexBReader for other frame rate.
+// The returned reader is an io.Closer.
+func OpenFile(path string) (FrameReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var reader FrameReader
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ivf":
+		reader, err = NewIVFReader(file)
+	case ".ogg", ".opus":
+		reader, err = NewOggReader(file)
+	case ".h264", ".264":
+		reader, err = NewAnnexBReader(file, defaultFrameRate)
+	default:
+		err = ErrUnknownFileType
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &fileReader{FrameReader: reader, File: file}, nil
+}
@@ -0,0 +1,59 @@
+package media
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+func TestOpenFile(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "by extension",
+			description: "the reader is chosen by extension and could be closed",
+			method: func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "test.IVF")
+				assert(t, os.WriteFile(path, newTestIVF("AV01", []byte{1}), 0o600), nil)
+				r, err := OpenFile(path)
+				assert(t, err, nil)
+				assert(t, r.Codec().EncoderName, "AV1")
+				frame, err := r.Next()
+				assert(t, err, nil)
+				assert(t, frame.Data, []byte{1})
+				assert(t, r.(io.Closer).Close(), nil)
+			},
+		},
+		{
+			name:        "unknown",
+			description: "unknown extension or missing file",
+			method: func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "test.mp4")
+				assert(t, os.WriteFile(path, nil, 0o600), nil)
+				_, err := OpenFile(path)
+				assert(t, err, ErrUnknownFileType)
+				_, err = OpenFile(filepath.Join(t.TempDir(), "missing.ivf"))
+				assert(t, os.IsNotExist(err), true)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
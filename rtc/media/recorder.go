@@ -0,0 +1,163 @@
+package media
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/gotolive/sfu/rtc/codec/vp8"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtp"
+)
+
+var ErrRecorderClosed = errors.New("recorder closed")
+
+type RecorderOption struct {
+	ID           string // the id of the direct connection, random if empty
+	ConnectionID string
+	ReceiverID   string
+	// Path of the file, video is written as ivf and audio as ogg.
+	Path string
+	// SummaryPath is optional, the summary is written to it as json when closed.
+	SummaryPath string
+}
+
+// RecordSummary describes a recording, the duration is by the rtp timestamps.
+type RecordSummary struct {
+	Path             string        `json:"path"`
+	Codec            string        `json:"codec"`
+	StartTime        time.Time     `json:"startTime"`
+	Duration         time.Duration `json:"duration"`
+	Frames           int           `json:"frames"`
+	KeyFrames        int           `json:"keyFrames"`
+	Gaps             int           `json:"gaps"`
+	LostPackets      int           `json:"lostPackets"`
+	DroppedFrames    int           `json:"droppedFrames"`
+	KeyframeRequests int           `json:"keyframeRequests"`
+	Bytes            int64         `json:"bytes"`
+}
+
+type frameWriter interface {
+	io.Closer
+	write(data []byte, pts uint64) error
+}
+
+type ivfFrameWriter struct {
+	*IVFWriter
+}
+
+func (w ivfFrameWriter) write(data []byte, pts uint64) error {
+	return w.WriteFrame(data, pts)
+}
+
+type oggFrameWriter struct {
+	*OggWriter
+}
+
+func (w oggFrameWriter) write(data []byte, pts uint64) error {
+	return w.WritePacket(data, pts)
+}
+
+// Recorder consumes a receiver by a direct connection and writes it to file.
+type Recorder struct {
+	option RecorderOption
+	track  *rtpTrack
+	writer frameWriter
+	ivf    *IVFWriter
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+func NewRecorder(broker *peer.Broker, option *RecorderOption) (*Recorder, error) {
+	receiver, err := findReceiver(broker, option.ConnectionID, option.ReceiverID)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{option: *option}
+	if r.track, err = newRTPTrack(receiver, option.Path); err != nil {
+		return nil, err
+	}
+	r.track.onFrame = r.writeFrame
+	encoderName := r.track.codec.EncoderName
+	file, err := os.Create(option.Path)
+	if err != nil {
+		return nil, err
+	}
+	if r.track.video {
+		r.ivf, err = NewIVFWriter(file, encoderName)
+		r.writer = ivfFrameWriter{r.ivf}
+	} else {
+		var ogg *OggWriter
+		ogg, err = NewOggWriter(file, r.track.codec.Channels)
+		r.writer = oggFrameWriter{ogg}
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	if r.option.ID == "" {
+		r.option.ID = peer.RandomString(12)
+	}
+	if err = r.track.subscribe(broker, r.option.ID, option.ConnectionID, r.onRTP); err != nil {
+		_ = r.writer.Close()
+		return nil, err
+	}
+	receiver.OnClose(func() {
+		_ = r.Close()
+	})
+	return r, nil
+}
+
+func (r *Recorder) onRTP(packet *rtp.Packet) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	r.track.onRTP(packet)
+}
+
+func (r *Recorder) writeFrame(frame *codec.Frame) error {
+	data := frame.Data
+	if frame.KeyFrame && r.track.codec.EncoderName == vp8.CodecName && len(data) >= 10 {
+		// the size is in the vp8 keyframe header.
+		r.ivf.SetSize(binary.LittleEndian.Uint16(data[6:])&0x3fff, binary.LittleEndian.Uint16(data[8:])&0x3fff)
+	}
+	return r.writer.write(data, frame.ExtendedTimestamp)
+}
+
+func (r *Recorder) Summary() RecordSummary {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.track.recordSummary()
+}
+
+// Close finishes the file and writes the summary, it's called when the receiver closed.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return ErrRecorderClosed
+	}
+	r.closed = true
+	r.track.flush()
+	summary := r.track.recordSummary()
+	r.mutex.Unlock()
+
+	r.track.close()
+	err := r.writer.Close()
+	if r.option.SummaryPath != "" {
+		data, _ := json.MarshalIndent(summary, "", "  ")
+		if e := os.WriteFile(r.option.SummaryPath, data, 0o644); err == nil {
+			err = e
+		}
+	}
+	return err
+}
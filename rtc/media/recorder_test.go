@@ -0,0 +1,181 @@
+package media
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+func TestRecorder(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "vp8",
+			description: "the recorded ivf has the same frames and timestamps as the published",
+			method: func(t *testing.T) {
+				broker := newTestBroker(t)
+				keyframe := append([]byte{0x50, 0x00, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01}, make([]byte, 1500)...)
+				frames := [][]byte{keyframe, {0x01, 1}, {0x01, 2}}
+				reader, err := NewIVFReader(bytes.NewReader(newTestIVF("VP80", frames...)))
+				assert(t, err, nil)
+				publisher, err := NewPublisher(broker, &PublisherOption{ID: "pub", Reader: reader})
+				assert(t, err, nil)
+				dir := t.TempDir()
+				recorder, err := NewRecorder(broker, &RecorderOption{
+					ConnectionID: "pub",
+					ReceiverID:   "pub",
+					Path:         filepath.Join(dir, "test.ivf"),
+					SummaryPath:  filepath.Join(dir, "test.json"),
+				})
+				assert(t, err, nil)
+				publisher.Start()
+				<-publisher.Done()
+				assert(t, recorder.Close(), nil)
+
+				file, err := os.Open(filepath.Join(dir, "test.ivf"))
+				assert(t, err, nil)
+				defer file.Close()
+				ivf, err := NewIVFReader(file)
+				assert(t, err, nil)
+				assert(t, ivf.Header().Width, uint16(640))
+				assert(t, ivf.Header().Height, uint16(480))
+				assert(t, ivf.Header().FrameCount, uint32(3))
+				for i, expected := range frames {
+					frame, err := ivf.Next()
+					assert(t, err, nil)
+					assert(t, frame.Data, expected)
+					assert(t, frame.Timestamp, uint32(i*900))
+				}
+				_, err = ivf.Next()
+				assert(t, err, io.EOF)
+
+				data, err := os.ReadFile(filepath.Join(dir, "test.json"))
+				assert(t, err, nil)
+				var summary RecordSummary
+				assert(t, json.Unmarshal(data, &summary), nil)
+				assert(t, summary.Frames, 3)
+				assert(t, summary.KeyFrames, 1)
+				assert(t, summary.Duration, 20*time.Millisecond)
+			},
+		},
+		{
+			name:        "opus",
+			description: "the recorded ogg has the same packets, and it's closed with the receiver",
+			method: func(t *testing.T) {
+				broker := newTestBroker(t)
+				file := new(bytes.Buffer)
+				file.Write(newTestOggPage(1, 0, 0x02, false, newTestOpusHead(2)))
+				file.Write(newTestOggPage(1, 1, 0, false, []byte("OpusTags")))
+				file.Write(newTestOggPage(1, 2, 0, false, []byte{0xf8, 1}, []byte{0xf8, 2}, []byte{0xf8, 3}))
+				reader, err := NewOggReader(bytes.NewReader(file.Bytes()))
+				assert(t, err, nil)
+				publisher, err := NewPublisher(broker, &PublisherOption{ID: "pub", Reader: reader})
+				assert(t, err, nil)
+				path := filepath.Join(t.TempDir(), "test.ogg")
+				recorder, err := NewRecorder(broker, &RecorderOption{ConnectionID: "pub", ReceiverID: "pub", Path: path})
+				assert(t, err, nil)
+				publisher.Start()
+				<-publisher.Done()
+				publisher.Close()
+				assert(t, recorder.Close(), ErrRecorderClosed)
+
+				data, err := os.ReadFile(path)
+				assert(t, err, nil)
+				ogg, err := NewOggReader(bytes.NewReader(data))
+				assert(t, err, nil)
+				assert(t, ogg.Channels(), 2)
+				for i := 0; i < 3; i++ {
+					frame, err := ogg.Next()
+					assert(t, err, nil)
+					assert(t, frame.Data, []byte{0xf8, byte(i + 1)})
+					assert(t, frame.Timestamp, uint32(i*960))
+				}
+				_, err = ogg.Next()
+				assert(t, err, io.EOF)
+				// every page has a valid checksum.
+				for offset := 0; offset < len(data); {
+					segments := int(data[offset+26])
+					size := oggPageHeaderSize + segments
+					for _, s := range data[offset+oggPageHeaderSize : offset+size] {
+						size += int(s)
+					}
+					page := append([]byte{}, data[offset:offset+size]...)
+					checksum := page[22:26]
+					expected := append([]byte{}, checksum...)
+					copy(checksum, []byte{0, 0, 0, 0})
+					actual := oggChecksum(page)
+					assert(t, []byte{byte(actual), byte(actual >> 8), byte(actual >> 16), byte(actual >> 24)}, expected)
+					offset += size
+				}
+			},
+		},
+		{
+			name:        "loss",
+			description: "the frames after loss are dropped until a keyframe, which is requested from the publisher",
+			method: func(t *testing.T) {
+				broker := newTestBroker(t)
+				conn, err := broker.NewDirectConnection(&peer.DirectOption{ID: "pub"})
+				assert(t, err, nil)
+				_, err = conn.NewReceiver(&peer.ReceiverOption{
+					ID:        "pub",
+					MID:       "0",
+					MediaType: rtc.MediaTypeVideo,
+					Codec: &peer.Codec{
+						PayloadType: 96, EncoderName: "VP8", ClockRate: 90000,
+						FeedbackParams: []peer.RtcpFeedback{{Type: "nack", Parameter: "pli"}},
+					},
+					Streams: []peer.StreamOption{{SSRC: 1234, PayloadType: 96}},
+				})
+				assert(t, err, nil)
+				transport := conn.Transport().(*peer.DirectTransport)
+				// the receiver knows the stream after the first packet.
+				assert(t, transport.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1234, PayloadType: 96, Marker: true}, Payload: []byte{0x10, 0x01}}), nil)
+				requests := make(chan rtcp.Packet, 10)
+				transport.OnRtcp(func(p rtcp.Packet) {
+					if _, ok := p.(*rtcp.PictureLossIndication); ok {
+						requests <- p
+					}
+				})
+				recorder, err := NewRecorder(broker, &RecorderOption{ConnectionID: "pub", ReceiverID: "pub", Path: filepath.Join(t.TempDir(), "test.ivf")})
+				assert(t, err, nil)
+				defer recorder.Close()
+
+				send := func(seq uint16, ts uint32, payload ...byte) {
+					recorder.onRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts, Marker: true}, Payload: payload})
+				}
+				send(1, 0, 0x10, 0x00)
+				send(2, 900, 0x10, 0x01)
+				send(4, 2700, 0x10, 0x01)
+				send(5, 3600, 0x10, 0x01)
+				send(6, 4500, 0x10, 0x00)
+				send(6, 4500, 0x10, 0x00)
+				summary := recorder.Summary()
+				assert(t, summary.Frames, 3)
+				assert(t, summary.KeyFrames, 2)
+				assert(t, summary.Gaps, 1)
+				assert(t, summary.LostPackets, 1)
+				assert(t, summary.DroppedFrames, 2)
+				assert(t, summary.KeyframeRequests, 1)
+				select {
+				case p := <-requests:
+					assert(t, p.DestinationSSRC()[0], uint32(1234))
+				case <-time.After(5 * time.Second):
+					t.Fatal("keyframe request timeout")
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
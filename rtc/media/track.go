@@ -0,0 +1,145 @@
+package media
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const keyframeRequestInterval = time.Second
+
+var errFrameSkipped = errors.New("frame skipped")
+
+func findReceiver(broker *peer.Broker, connectionID, receiverID string) (*peer.Receiver, error) {
+	producer := broker.Connection(connectionID)
+	if producer == nil {
+		return nil, peer.ErrConnNotExist
+	}
+	for _, r := range producer.Receivers() {
+		if r.ID() == receiverID {
+			return r, nil
+		}
+	}
+	return nil, peer.ErrReceiverNotExist
+}
+
+// rtpTrack consumes a receiver by a direct connection and assembles the frames.
+// After packets lost, the frames are dropped until next keyframe, which is requested
+// by pli and goes to the keyframe manager of the receiver.
+// It has no lock, the owner guards it.
+type rtpTrack struct {
+	receiver   *peer.Receiver
+	codec      *peer.Codec
+	connection *peer.Connection
+	transport  *peer.DirectTransport
+	sender     peer.Sender
+	assembler  *codec.FrameAssembler
+	video      bool
+	// onFrame is called with the frames in order, the frame is counted as dropped if it returns errFrameSkipped.
+	onFrame func(frame *codec.Frame) error
+
+	lastRequest time.Time
+	// summary has the written frames, the others are counted by the assembler.
+	summary RecordSummary
+}
+
+func newRTPTrack(receiver *peer.Receiver, path string) (*rtpTrack, error) {
+	t := &rtpTrack{receiver: receiver, codec: receiver.Codec(), video: receiver.MediaType() == rtc.MediaTypeVideo}
+	var err error
+	if t.assembler, err = codec.NewFrameAssembler(t.codec.EncoderName, t.codec.ClockRate); err != nil {
+		return nil, ErrUnsupportedCodec
+	}
+	t.assembler.OnKeyFrameNeeded(t.requestKeyframe)
+	t.summary = RecordSummary{Path: path, Codec: t.codec.EncoderName, StartTime: time.Now()}
+	return t, nil
+}
+
+// subscribe creates the direct connection and the sender, the packets go to onRTP.
+func (t *rtpTrack) subscribe(broker *peer.Broker, id, connectionID string, onRTP func(*rtp.Packet)) error {
+	var err error
+	t.connection, err = broker.NewDirectConnection(&peer.DirectOption{ID: id})
+	if err != nil {
+		return err
+	}
+	t.transport = t.connection.Transport().(*peer.DirectTransport)
+	t.sender, err = t.connection.NewSender(&peer.SenderOption{ConnectionID: connectionID, ReceiverID: t.receiver.ID()})
+	if err != nil {
+		t.connection.Close()
+		t.connection = nil
+		return err
+	}
+	t.transport.OnRTP(onRTP)
+	return nil
+}
+
+func (t *rtpTrack) onRTP(packet *rtp.Packet) {
+	// the capture time of frames is mapped by the last sender report.
+	for _, stream := range t.receiver.GetRTPStreams() {
+		if ntp := stream.GetSenderReportNtpMs(); ntp != 0 {
+			t.assembler.SetSenderReport(ntp, uint32(stream.GetSenderReportTS()))
+			break
+		}
+	}
+	for _, frame := range t.assembler.Push(packet) {
+		t.writeFrame(frame)
+	}
+}
+
+// flush writes the pending frame.
+func (t *rtpTrack) flush() {
+	if frame := t.assembler.Flush(); frame != nil {
+		t.writeFrame(frame)
+	}
+}
+
+func (t *rtpTrack) writeFrame(frame *codec.Frame) {
+	if err := t.onFrame(frame); err != nil {
+		if err == errFrameSkipped {
+			t.summary.DroppedFrames++
+		} else {
+			logger.Error("write frame fail:", err)
+		}
+		return
+	}
+	t.summary.Frames++
+	if frame.KeyFrame {
+		t.summary.KeyFrames++
+	}
+	t.summary.Bytes += int64(len(frame.Data))
+	t.summary.Duration = t.duration(frame.ExtendedTimestamp)
+}
+
+func (t *rtpTrack) recordSummary() RecordSummary {
+	summary := t.summary
+	stats := t.assembler.Stats()
+	summary.Gaps = stats.Gaps
+	summary.LostPackets = stats.LostPackets
+	summary.DroppedFrames += stats.DroppedFrames
+	return summary
+}
+
+func (t *rtpTrack) duration(ts uint64) time.Duration {
+	return time.Duration(ts) * time.Second / time.Duration(t.codec.ClockRate)
+}
+
+// requestKeyframe sends pli to the connection, it goes to the receiver through the sender.
+func (t *rtpTrack) requestKeyframe() {
+	if t.transport == nil || time.Since(t.lastRequest) < keyframeRequestInterval {
+		return
+	}
+	t.lastRequest = time.Now()
+	t.summary.KeyframeRequests++
+	_ = t.transport.WriteRtcp(&rtcp.PictureLossIndication{MediaSSRC: t.sender.Stream().SSRC})
+}
+
+func (t *rtpTrack) close() {
+	if t.connection != nil {
+		t.connection.Close()
+	}
+}
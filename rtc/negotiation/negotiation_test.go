@@ -0,0 +1,134 @@
+package negotiation
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/bwe"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+func readOffer(t *testing.T, name string) *sdp.SessionDescription {
+	b, err := os.ReadFile("../../testdata/sdp/" + name)
+	assert(t, err, nil)
+	offer, err := sdp.Unmarshal(string(b))
+	assert(t, err, nil)
+	return offer
+}
+
+func TestNegotiation(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "receiver option",
+			description: "the first offered codec of the names is selected, the feedback of other bwe is dropped",
+			method: func(t *testing.T) {
+				offer := readOffer(t, "sdp-3")
+				audio, video := offer.MediaDescription[0], offer.MediaDescription[1]
+				option := ReceiverOption(video, []string{"H264", "VP8"}, bwe.TransportCC)
+				assert(t, option.ID, video.MID)
+				assert(t, option.Codec.EncoderName, "VP8")
+				for _, f := range option.Codec.FeedbackParams {
+					assert(t, f.Type == "goog-remb", false)
+				}
+				assert(t, len(option.Streams) > 0, true)
+				assert(t, ReceiverOption(audio, []string{"VP8"}, bwe.TransportCC) == nil, true)
+
+				audio.Direction = "recvonly"
+				assert(t, Publishable(audio), false)
+				assert(t, ReceiverOption(audio, DefaultCodecs, bwe.TransportCC) == nil, true)
+			},
+		},
+		{
+			name:        "header",
+			description: "the bundle group is omitted without mids, cryptex is written if it's set",
+			method: func(t *testing.T) {
+				b := &strings.Builder{}
+				WriteHeader(b, &Header{Lite: true, ExtmapAllowMixed: true, Mids: []string{"0", "1"}})
+				assert(t, strings.Contains(b.String(), "a=ice-lite\r\na=group:BUNDLE 0 1\r\na=extmap-allow-mixed\r\n"), true)
+				assert(t, strings.Contains(b.String(), "a=cryptex"), false)
+				b.Reset()
+				WriteHeader(b, &Header{Cryptex: true})
+				assert(t, strings.Contains(b.String(), "a=cryptex\r\n"), true)
+				b.Reset()
+				WriteHeader(b, &Header{})
+				assert(t, strings.Contains(b.String(), "a=group:BUNDLE"), false)
+				assert(t, strings.Contains(b.String(), "a=ice-lite"), false)
+			},
+		},
+		{
+			name:        "media",
+			description: "the codec, the rtx and the encrypted header extensions are written",
+			method: func(t *testing.T) {
+				b := &strings.Builder{}
+				WriteRejected(b, rtc.MediaTypeVideo, "1", nil)
+				assert(t, b.String(), "m=video 0 UDP/TLS/RTP/SAVPF 0\r\nc=IN IP4 0.0.0.0\r\na=mid:1\r\na=inactive\r\n")
+
+				b.Reset()
+				codec := &peer.Codec{
+					EncoderName:    "VP8",
+					PayloadType:    96,
+					ClockRate:      90000,
+					RTX:            97,
+					Parameters:     map[string]string{"b": "2", "a": "1"},
+					FeedbackParams: []peer.RtcpFeedback{{Type: "nack"}, {Type: "nack", Parameter: "pli"}},
+				}
+				WriteMline(b, rtc.MediaTypeVideo, codec)
+				WriteExtmaps(b, []rtc.HeaderExtension{{URI: rtc.HeaderExtensionMid, ID: 3, Encrypt: true}, {URI: rtc.HeaderExtensionAbsSendTime, ID: 2}})
+				WriteCodec(b, codec)
+				assert(t, b.String(), "m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\nc=IN IP4 0.0.0.0\r\na=rtcp:9 IN IP4 0.0.0.0\r\n"+
+					"a=extmap:2 "+rtc.HeaderExtensionAbsSendTime+"\r\n"+
+					"a=extmap:3 "+EncryptURI+" "+rtc.HeaderExtensionMid+"\r\n"+
+					"a=rtpmap:96 VP8/90000\r\na=fmtp:96 a=1;b=2\r\na=rtcp-fb:96 nack\r\na=rtcp-fb:96 nack pli\r\n"+
+					"a=rtpmap:97 rtx/90000\r\na=fmtp:97 apt=96\r\n")
+			},
+		},
+		{
+			name:        "receiver",
+			description: "the receiver is written recvonly with the rids of its simulcast streams",
+			method: func(t *testing.T) {
+				broker, err := peer.NewBroker(peer.BrokerOption{})
+				assert(t, err, nil)
+				defer broker.Close()
+				conn, err := broker.NewDirectConnection(&peer.DirectOption{ID: "direct"})
+				assert(t, err, nil)
+				receiver, err := conn.NewReceiver(&peer.ReceiverOption{
+					ID:               "video",
+					MID:              "video",
+					MediaType:        rtc.MediaTypeVideo,
+					Codec:            &peer.Codec{PayloadType: 96, EncoderName: "VP8", ClockRate: 90000},
+					HeaderExtensions: []rtc.HeaderExtension{{URI: rtc.HeaderExtensionMid, ID: 4, Encrypt: true}},
+					Streams:          []peer.StreamOption{{SSRC: 1111, RID: "h", PayloadType: 96}, {SSRC: 2222, RID: "l", PayloadType: 96}},
+				})
+				assert(t, err, nil)
+				b := &strings.Builder{}
+				WriteReceiver(b, peer.TransportInfo{}, receiver)
+				assert(t, strings.Contains(b.String(), "a=mid:video\r\n"), true)
+				assert(t, strings.Contains(b.String(), "a=extmap:4 "+EncryptURI+" "+rtc.HeaderExtensionMid+"\r\n"), true)
+				assert(t, strings.Contains(b.String(), "a=recvonly\r\n"), true)
+				assert(t, strings.HasSuffix(b.String(), "a=rid:h recv\r\na=rid:l recv\r\na=simulcast:recv h;l\r\n"), true)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
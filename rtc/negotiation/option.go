@@ -0,0 +1,147 @@
+// Package negotiation has the offer/answer helpers shared by whip, signaling and the examples,
+// it converts the parsed sdp to the options of peer and writes the sdp of connections.
+package negotiation
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/bwe"
+	"github.com/gotolive/sfu/rtc/dtls"
+	"github.com/gotolive/sfu/rtc/ice"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+// DefaultCodecs are the encoder names accepted by default.
+var DefaultCodecs = []string{"opus", "VP8", "VP9", "H264", "AV1"}
+
+// SelectCodec returns the first offered codec of the encoder names, in the order of the offer.
+func SelectCodec(media *sdp.MediaDescription, names []string) *sdp.Codec {
+	for _, pt := range media.Formats {
+		codec := media.Codecs[pt]
+		if codec == nil {
+			continue
+		}
+		for _, name := range names {
+			if strings.EqualFold(codec.EncoderName, name) {
+				return codec
+			}
+		}
+	}
+	return nil
+}
+
+// Feedback converts the rtcp feedback, the ones of other bwe are dropped.
+func Feedback(params []sdp.FeedbackParams, bweType string) []peer.RtcpFeedback {
+	var result []peer.RtcpFeedback
+	for _, f := range params {
+		if (f.ID == "transport-cc" && bweType != bwe.TransportCC) || (f.ID == "goog-remb" && bweType != bwe.Remb) {
+			continue
+		}
+		result = append(result, peer.RtcpFeedback{Type: f.ID, Parameter: f.Params})
+	}
+	return result
+}
+
+// Publishable returns true if the m-line sends media to us.
+func Publishable(media *sdp.MediaDescription) bool {
+	return (media.MediaType == rtc.MediaTypeAudio || media.MediaType == rtc.MediaTypeVideo) &&
+		media.Direction != "recvonly" && media.Direction != "inactive" && len(media.Streams) != 0
+}
+
+// ReceiverOption returns the option of the offered m-line, nil if it's not acceptable.
+// The receiver id is the mid, so it's unique in the connection.
+func ReceiverOption(media *sdp.MediaDescription, names []string, bweType string) *peer.ReceiverOption {
+	if !Publishable(media) {
+		return nil
+	}
+	codec := SelectCodec(media, names)
+	if codec == nil {
+		return nil
+	}
+	option := &peer.ReceiverOption{
+		ID:        media.MID,
+		MID:       media.MID,
+		MediaType: media.MediaType,
+		Codec: &peer.Codec{
+			EncoderName:    codec.EncoderName,
+			PayloadType:    rtc.PayloadType(codec.PayloadType),
+			ClockRate:      codec.ClockRate,
+			Channels:       codec.Channel,
+			Parameters:     codec.Parameters,
+			RTX:            rtc.PayloadType(codec.RTX),
+			FeedbackParams: Feedback(codec.FeedbackParams, bweType),
+		},
+	}
+	for _, h := range media.HeaderExtensions {
+		option.HeaderExtensions = append(option.HeaderExtensions, rtc.HeaderExtension{
+			URI:     h.URI,
+			ID:      rtc.HeaderExtensionID(h.ID),
+			Encrypt: h.Encrypt,
+		})
+	}
+	for _, s := range media.Streams {
+		option.Streams = append(option.Streams, peer.StreamOption{
+			SSRC:        s.SSRC,
+			RID:         s.RID,
+			RTX:         s.RTX,
+			PayloadType: option.Codec.PayloadType,
+			Cname:       s.Cname,
+		})
+	}
+	return option
+}
+
+// NegotiateCryptex enables cryptex of the connection if the remote signals it or disables it if not,
+// and returns whether it's enabled, so the answer only accepts what the transport supports.
+func NegotiateCryptex(connection *peer.Connection, remote *sdp.SessionDescription) bool {
+	enabled := remote.CryptexEnabled()
+	if err := connection.SetCryptex(enabled); err != nil {
+		logger.Warn("enable cryptex fail:", connection.ID(), err)
+		return false
+	}
+	return enabled
+}
+
+// RemoteInfo returns the transport of the answer, the candidates are deduplicated since they are in every m-line.
+func RemoteInfo(answer *sdp.SessionDescription) (peer.TransportInfo, error) {
+	transport := answer.TransportInfo
+	info := peer.TransportInfo{}
+	info.IceInfo.Ufrag = transport.IceUfrag
+	info.IceInfo.Pwd = transport.IcePwd
+	info.IceInfo.Lite = transport.IceMode == sdp.IceModeLite
+	info.DtlsInfo.Role = transport.ConnectionRole
+	if transport.FingerPrint != nil {
+		info.DtlsInfo.Fingerprints = []dtls.Fingerprint{{Algorithm: transport.FingerPrint.Algorithm, Value: transport.FingerPrint.Value}}
+	}
+	found := map[string]bool{}
+	for _, c := range transport.Candidates {
+		protocol := strings.ToLower(c.Protocol)
+		// we could only dial the passive tcp candidates.
+		if protocol == ice.TCP && c.TCPType != "passive" || found[protocol+c.Address] {
+			continue
+		}
+		found[protocol+c.Address] = true
+		host, port, err := net.SplitHostPort(c.Address)
+		if err != nil {
+			return info, err
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return info, err
+		}
+		info.IceInfo.Candidates = append(info.IceInfo.Candidates, ice.Candidate{
+			Type:       c.Type,
+			Protocol:   protocol,
+			IP:         host,
+			Port:       uint16(p),
+			Priority:   int(c.Priority),
+			Foundation: c.Foundation,
+		})
+	}
+	return info, nil
+}
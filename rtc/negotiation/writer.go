@@ -0,0 +1,230 @@
+package negotiation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/ice"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+const (
+	MediaProtocol = "UDP/TLS/RTP/SAVPF"
+	EncryptURI    = "urn:ietf:params:rtp-hdrext:encrypt"
+)
+
+// Header is the session level lines, the m-lines of Mids are bundled.
+type Header struct {
+	Mids             []string
+	Lite             bool
+	ExtmapAllowMixed bool
+	// Cryptex writes a=cryptex of rfc9335, it's offered by us or accepted in the answer.
+	Cryptex bool
+}
+
+func WriteHeader(b *strings.Builder, header *Header) {
+	fmt.Fprintf(b, "v=0\r\no=- %d 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n", time.Now().UnixNano())
+	if header.Lite {
+		b.WriteString("a=ice-lite\r\n")
+	}
+	if len(header.Mids) != 0 {
+		fmt.Fprintf(b, "a=group:BUNDLE %s\r\n", strings.Join(header.Mids, " "))
+	}
+	if header.ExtmapAllowMixed {
+		b.WriteString("a=extmap-allow-mixed\r\n")
+	}
+	if header.Cryptex {
+		b.WriteString("a=cryptex\r\n")
+	}
+	b.WriteString("a=msid-semantic: WMS *\r\n")
+}
+
+// WriteAnswerMedia writes the accepted m-line of the offer, either the receiver or the sender is set.
+// The header extensions not offered are omitted.
+func WriteAnswerMedia(b *strings.Builder, info peer.TransportInfo, offer *sdp.MediaDescription, receiver *peer.Receiver, sender peer.Sender) {
+	var (
+		codec     *peer.Codec
+		headers   []rtc.HeaderExtension
+		direction string
+	)
+	if receiver != nil {
+		codec, headers, direction = receiver.Codec(), receiver.HeaderExtensions(), "recvonly"
+	} else {
+		codec, headers, direction = sender.Codec(), sender.HeaderExtensions(), "sendonly"
+	}
+	WriteMline(b, offer.MediaType, codec)
+	WriteTransport(b, info, offer.MID)
+	offered := map[string]bool{}
+	for _, h := range offer.HeaderExtensions {
+		offered[h.URI] = true
+	}
+	var accepted []rtc.HeaderExtension
+	for _, h := range headers {
+		if offered[h.URI] {
+			accepted = append(accepted, h)
+		}
+	}
+	WriteExtmaps(b, accepted)
+	fmt.Fprintf(b, "a=%s\r\na=rtcp-mux\r\n", direction)
+	if offer.RtcpReducedSize {
+		b.WriteString("a=rtcp-rsize\r\n")
+	}
+	WriteCodec(b, codec)
+	WriteCandidates(b, info.IceInfo.Candidates)
+	if receiver == nil {
+		WriteSSRC(b, sender)
+		return
+	}
+	var rids []string
+	for _, s := range offer.Streams {
+		if s.RID != "" {
+			rids = append(rids, s.RID)
+			fmt.Fprintf(b, "a=rid:%s recv\r\n", s.RID)
+		}
+	}
+	if len(rids) != 0 {
+		fmt.Fprintf(b, "a=simulcast:recv %s\r\n", strings.Join(rids, ";"))
+	}
+}
+
+// WriteReceiver writes the recvonly m-line of receiver, the rids of its streams are listed if it's simulcast.
+func WriteReceiver(b *strings.Builder, info peer.TransportInfo, receiver *peer.Receiver) {
+	writeMedia(b, info, receiver.MediaType(), receiver.MID(), "recvonly", receiver.Codec(), receiver.HeaderExtensions())
+	streams := receiver.GetRTPStreams()
+	if len(streams) < 2 {
+		return
+	}
+	rids := make([]string, 0, len(streams))
+	for _, s := range streams {
+		rids = append(rids, s.RID())
+		fmt.Fprintf(b, "a=rid:%s recv\r\n", s.RID())
+	}
+	fmt.Fprintf(b, "a=simulcast:recv %s\r\n", strings.Join(rids, ";"))
+}
+
+// WriteSender writes the sendonly m-line of sender with its ssrcs.
+func WriteSender(b *strings.Builder, info peer.TransportInfo, sender peer.Sender) {
+	writeMedia(b, info, sender.MediaType(), sender.MID(), "sendonly", sender.Codec(), sender.HeaderExtensions())
+	WriteSSRC(b, sender)
+}
+
+func writeMedia(b *strings.Builder, info peer.TransportInfo, mediaType, mid, direction string, codec *peer.Codec, headers []rtc.HeaderExtension) {
+	WriteMline(b, mediaType, codec)
+	WriteTransport(b, info, mid)
+	WriteExtmaps(b, headers)
+	fmt.Fprintf(b, "a=%s\r\na=rtcp-mux\r\n", direction)
+	WriteCodec(b, codec)
+	WriteCandidates(b, info.IceInfo.Candidates)
+}
+
+// WriteRejected writes the m-line with port 0, the first format is kept as the m-line requires one.
+func WriteRejected(b *strings.Builder, mediaType, mid string, formats []uint8) {
+	format := "0"
+	if len(formats) != 0 {
+		format = fmt.Sprint(formats[0])
+	}
+	fmt.Fprintf(b, "m=%s 0 %s %s\r\nc=IN IP4 0.0.0.0\r\n", mediaType, MediaProtocol, format)
+	if mid != "" {
+		fmt.Fprintf(b, "a=mid:%s\r\n", mid)
+	}
+	b.WriteString("a=inactive\r\n")
+}
+
+// WriteMline writes the m-line with the payload types of codecs and their rtx.
+func WriteMline(b *strings.Builder, mediaType string, codecs ...*peer.Codec) {
+	formats := make([]string, 0, len(codecs)*2)
+	for _, codec := range codecs {
+		formats = append(formats, fmt.Sprint(codec.PayloadType))
+		if codec.RTX != 0 {
+			formats = append(formats, fmt.Sprint(codec.RTX))
+		}
+	}
+	fmt.Fprintf(b, "m=%s 9 %s %s\r\nc=IN IP4 0.0.0.0\r\na=rtcp:9 IN IP4 0.0.0.0\r\n", mediaType, MediaProtocol, strings.Join(formats, " "))
+}
+
+func WriteTransport(b *strings.Builder, info peer.TransportInfo, mid string) {
+	fmt.Fprintf(b, "a=ice-ufrag:%s\r\na=ice-pwd:%s\r\n", info.IceInfo.Ufrag, info.IceInfo.Pwd)
+	for _, f := range info.DtlsInfo.Fingerprints {
+		fmt.Fprintf(b, "a=fingerprint:%s %s\r\n", f.Algorithm, f.Value)
+	}
+	fmt.Fprintf(b, "a=setup:%s\r\na=mid:%s\r\n", info.DtlsInfo.Role, mid)
+}
+
+// WriteExtmaps writes the header extensions in the order of id.
+func WriteExtmaps(b *strings.Builder, headers []rtc.HeaderExtension) {
+	headers = append([]rtc.HeaderExtension(nil), headers...)
+	sort.Slice(headers, func(i, j int) bool {
+		return headers[i].ID < headers[j].ID
+	})
+	for _, h := range headers {
+		if h.Encrypt {
+			fmt.Fprintf(b, "a=extmap:%d %s %s\r\n", h.ID, EncryptURI, h.URI)
+			continue
+		}
+		fmt.Fprintf(b, "a=extmap:%d %s\r\n", h.ID, h.URI)
+	}
+}
+
+func WriteCodec(b *strings.Builder, codec *peer.Codec) {
+	fmt.Fprintf(b, "a=rtpmap:%d %s/%d", codec.PayloadType, codec.EncoderName, codec.ClockRate)
+	if codec.Channels > 0 {
+		fmt.Fprintf(b, "/%d", codec.Channels)
+	}
+	b.WriteString("\r\n")
+	if parameters := formatParameters(codec.Parameters); parameters != "" {
+		fmt.Fprintf(b, "a=fmtp:%d %s\r\n", codec.PayloadType, parameters)
+	}
+	for _, fb := range codec.FeedbackParams {
+		if fb.Parameter == "" {
+			fmt.Fprintf(b, "a=rtcp-fb:%d %s\r\n", codec.PayloadType, fb.Type)
+			continue
+		}
+		fmt.Fprintf(b, "a=rtcp-fb:%d %s %s\r\n", codec.PayloadType, fb.Type, fb.Parameter)
+	}
+	if codec.RTX != 0 {
+		fmt.Fprintf(b, "a=rtpmap:%d rtx/%d\r\na=fmtp:%d apt=%d\r\n", codec.RTX, codec.ClockRate, codec.RTX, codec.PayloadType)
+	}
+}
+
+// WriteSSRC writes the msid and ssrcs of the sender, the stream id is the cname of the source.
+func WriteSSRC(b *strings.Builder, sender peer.Sender) {
+	stream := sender.Stream()
+	cname := stream.Cname
+	if cname == "" {
+		cname = sender.ReceiverID()
+	}
+	fmt.Fprintf(b, "a=msid:%s %s\r\n", cname, sender.ID())
+	if stream.RTX != 0 {
+		fmt.Fprintf(b, "a=ssrc-group:FID %d %d\r\n", stream.SSRC, stream.RTX)
+		fmt.Fprintf(b, "a=ssrc:%d cname:%s\r\na=ssrc:%d cname:%s\r\n", stream.SSRC, cname, stream.RTX, cname)
+		return
+	}
+	fmt.Fprintf(b, "a=ssrc:%d cname:%s\r\n", stream.SSRC, cname)
+}
+
+func WriteCandidates(b *strings.Builder, candidates []ice.Candidate) {
+	for _, c := range candidates {
+		fmt.Fprintf(b, "a=candidate:%s 1 %s %d %s %d typ %s", c.Foundation, c.Protocol, c.Priority, c.IP, c.Port, c.Type)
+		if c.Protocol == ice.TCP {
+			b.WriteString(" tcptype passive")
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString("a=end-of-candidates\r\n")
+}
+
+func formatParameters(parameters map[string]string) string {
+	keys := make([]string, 0, len(parameters))
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = key + "=" + parameters[key]
+	}
+	return strings.Join(keys, ";")
+}
@@ -0,0 +1,20 @@
+package rtc
+
+import "time"
+
+// ntpEpochOffset is the seconds from 1900, the ntp epoch, to 1970, the unix epoch.
+const ntpEpochOffset = 2208988800
+
+// ToNtpTime returns the 64 bits ntp timestamp of the time, as the sender report carries, see rfc3550#section-4.
+func ToNtpTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fractional := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fractional
+}
+
+// FromNtpTime returns the time of the 64 bits ntp timestamp.
+func FromNtpTime(ntp uint64) time.Time {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanoseconds := (ntp & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(seconds, int64(nanoseconds))
+}
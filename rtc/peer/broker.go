@@ -11,8 +11,13 @@ import (
 var _ connectionListener = new(Broker)
 
 func NewBroker(option BrokerOption) (*Broker, error) {
-	cm, err := dtls.NewCertManager(false)
-	if err != nil {
+	var (
+		cm  dtls.CertificateGenerator
+		err error
+	)
+	if option.Certificate != nil {
+		cm = dtls.NewStaticCertManager(option.Certificate)
+	} else if cm, err = dtls.NewCertManager(false); err != nil {
 		return nil, err
 	}
 	iceServer, err := ice.NewServer(option.ICE)
@@ -32,6 +37,8 @@ func NewBroker(option BrokerOption) (*Broker, error) {
 
 type BrokerOption struct {
 	ICE ice.Option
+	// Certificate is the dtls certificate of all connections, a self-signed one is generated if nil.
+	Certificate *dtls.Certificate
 }
 
 // Broker is a sfu node, with global setting in it.
@@ -71,6 +78,75 @@ func (b *Broker) NewWebRTCConnection(options *WebRTCOption) (*Connection, error)
 	return connection, nil
 }
 
+// NewWebRTCClientConnection creates a webrtc connection as the offerer, it connects by Connection.SetRemote.
+func (b *Broker) NewWebRTCClientConnection(options *WebRTCOption) (*Connection, error) {
+	if options.ID == "" {
+		options.ID = RandomString(12)
+	}
+	t := NewWebRTCClientTransport(options, b.certManager)
+	connection, err := b.NewConnection(options.ID, options.BweType, t)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return connection, nil
+}
+
+// NewPlainConnection creates a connection over plain rtp, it connects to remote if the remote given.
+// It's disconnected like ice failed once the remote is dead, see PlainOption.Timeout, the owner closes it.
+func (b *Broker) NewPlainConnection(options *PlainOption) (*Connection, error) {
+	t, err := NewPlainTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.ID == "" {
+		options.ID = RandomString(12)
+	}
+	connection, err := b.NewConnection(options.ID, options.BweType, t)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	if !options.Comedia && options.RemoteIP != "" {
+		if err = t.Connect(options.RemoteIP, options.RemotePort, options.RemoteRtcpPort); err != nil {
+			connection.Close()
+			return nil, err
+		}
+	}
+	return connection, nil
+}
+
+// NewPipeConnection creates a connection to another broker, the senders on it are PipeSender.
+func (b *Broker) NewPipeConnection(options *PipeOption) (*Connection, error) {
+	t, err := NewPipeTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.ID == "" {
+		options.ID = RandomString(12)
+	}
+	connection, err := b.NewConnection(options.ID, "", t)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	if options.RemoteIP != "" {
+		if err = t.Connect(options.RemoteIP, options.RemotePort); err != nil {
+			connection.Close()
+			return nil, err
+		}
+	}
+	return connection, nil
+}
+
+// NewDirectConnection creates a connection exchanges rtp in process, see DirectTransport.
+func (b *Broker) NewDirectConnection(options *DirectOption) (*Connection, error) {
+	if options.ID == "" {
+		options.ID = RandomString(12)
+	}
+	return b.NewConnection(options.ID, options.BweType, NewDirectTransport())
+}
+
 func (b *Broker) NewConnection(id string, bweType string, transport Transport) (*Connection, error) {
 	connection := newConnection(id, bweType, transport, b)
 	b.cm.Lock()
@@ -91,8 +167,8 @@ func (b *Broker) Connection(id string) *Connection {
 // Close clean before stop, if someone still call new connection, it's their fault, we don't care.
 func (b *Broker) Close() {
 	// copy it to avoid dead lock.
-	connections := make([]*Connection, 0, len(b.connections))
 	b.cm.Lock()
+	connections := make([]*Connection, 0, len(b.connections))
 	// this may have error, but we don't care.
 	for _, c := range b.connections {
 		connections = append(connections, c)
@@ -112,3 +188,21 @@ func (b *Broker) Connections() []*Connection {
 	}
 	return result
 }
+
+// NewDataProducer creates a data producer on the connection, see Connection.NewDataProducer.
+func (b *Broker) NewDataProducer(connectionID string, option *DataProducerOption) (*DataProducer, error) {
+	connection := b.Connection(connectionID)
+	if connection == nil {
+		return nil, ErrConnNotExist
+	}
+	return connection.NewDataProducer(option)
+}
+
+// NewDataConsumer creates a data consumer on the connection, see Connection.NewDataConsumer.
+func (b *Broker) NewDataConsumer(connectionID string, option *DataConsumerOption) (*DataConsumer, error) {
+	connection := b.Connection(connectionID)
+	if connection == nil {
+		return nil, ErrConnNotExist
+	}
+	return connection.NewDataConsumer(option)
+}
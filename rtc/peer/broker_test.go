@@ -1,7 +1,10 @@
 package peer
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/gotolive/sfu/rtc/dtls"
 )
 
 func TestNewBroker(t *testing.T) {
@@ -21,5 +24,48 @@ func TestNewBroker(t *testing.T) {
 	if c == nil || err != nil {
 		t.Error("Fail to create connection")
 	}
+	ufrag := c.Transport().Info().IceInfo.Ufrag
+	if err = c.RestartIce(); err != nil || c.Transport().Info().IceInfo.Ufrag == ufrag {
+		t.Error("Fail to restart ice")
+	}
+	d, err := broker.NewDirectConnection(&DirectOption{ID: "test-direct"})
+	if err != nil || !errors.Is(d.RestartIce(), ErrIceRestartNotSupported) {
+		t.Error("Direct connection should not restart ice")
+	}
+	if !errors.Is(d.SetRemote(c.Transport().Info()), ErrSetRemoteNotSupported) {
+		t.Error("Direct connection should not set remote")
+	}
+	// the server connection answered the offer, there is nothing to resolve.
+	if err = c.SetRemote(TransportInfo{}); err != nil {
+		t.Error("Answerer should ignore the remote:", err)
+	}
+	// the server connection offered actpass, the answer resolves the role.
+	offerer, err := broker.NewWebRTCConnection(&WebRTCOption{ID: "test-offerer", DtlsOption: dtls.Option{Role: dtls.Actpass}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := TransportInfo{}
+	answer.DtlsInfo.Role = dtls.Actpass
+	if !errors.Is(offerer.SetRemote(answer), dtls.ErrInvalidRole) {
+		t.Error("Answer must not be actpass")
+	}
+	answer.DtlsInfo.Role = dtls.Active
+	if err = offerer.SetRemote(answer); err != nil || offerer.Transport().Info().DtlsInfo.Role != dtls.Passive {
+		t.Error("Fail to resolve the role:", err, offerer.Transport().Info().DtlsInfo.Role)
+	}
+	client, err := broker.NewWebRTCClientConnection(&WebRTCOption{ID: "test-client"})
+	if err != nil || !errors.Is(client.RestartIce(), ErrIceRestartNotSupported) {
+		t.Error("Fail to create client connection")
+	}
+	info := client.Transport().Info()
+	if info.DtlsInfo.Role != "actpass" || info.IceInfo.Role != "controlling" || info.IceInfo.Lite {
+		t.Error("Client connection should offer actpass as controlling:", info)
+	}
+	// the remote is answered with the server connection, the dtls role is resolved by its active.
+	remote := c.Transport().Info()
+	remote.DtlsInfo.Role = "active"
+	if err = client.SetRemote(remote); err != nil {
+		t.Error("Fail to set remote:", err)
+	}
 	broker.Close()
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/gotolive/sfu/rtc/dtls"
 	"github.com/gotolive/sfu/rtc/ice"
 	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/pion/datachannel"
 	"github.com/pion/rtcp"
 )
 
@@ -31,6 +32,13 @@ type TransportInfo struct {
 		Fingerprints []dtls.Fingerprint
 		Role         string
 	}
+	// PlainInfo is the local address of plain transport.
+	PlainInfo struct {
+		IP       string
+		Port     int
+		RtcpPort int
+		RtcpMux  bool
+	}
 }
 
 // Transport should respond for read and write pkt.
@@ -43,12 +51,45 @@ type Transport interface {
 	Close()
 }
 
+// headerEncrypter is implemented by the transports support rfc6904 header extension encryption.
+type headerEncrypter interface {
+	SetEncryptedHeaderExtensions(ids []rtc.HeaderExtensionID)
+}
+
+// cryptexSetter is implemented by the transports support rfc9335 cryptex.
+type cryptexSetter interface {
+	SetCryptex(enabled bool) error
+}
+
+// remoteSetter is implemented by the transports apply the answer of their offer, e.g. the webrtc transport.
+type remoteSetter interface {
+	SetRemote(remote TransportInfo) error
+}
+
+// iceRestarter is implemented by the transports support ice restart.
+type iceRestarter interface {
+	RestartIce() error
+}
+
+// senderCreator is implemented by the transports create their own senders, e.g. the pipe transport.
+type senderCreator interface {
+	newSender(options *SenderOption, listener ConsumerListener, receiver *Receiver, stats *Stats) (Sender, error)
+}
+
 // connectionListener  is cross-connection communication.
 type connectionListener interface {
 	removeConnection(id string)
 	Connection(id string) *Connection
 }
 
+// Authorizer checks the receivers and senders before they are created, the error is returned by
+// NewReceiver and NewSender. It's called without the lock of connection.
+// The producer of AuthorizeSender is the connection of the receiver.
+type Authorizer interface {
+	AuthorizeReceiver(connection *Connection, option *ReceiverOption) error
+	AuthorizeSender(connection *Connection, option *SenderOption, producer *Connection, receiver *Receiver) error
+}
+
 func newConnection(id, bwe string, transport Transport, l connectionListener) *Connection {
 	t := Connection{
 		id:            id,
@@ -60,9 +101,12 @@ func newConnection(id, bwe string, transport Transport, l connectionListener) *C
 		rtxSsrcSender: map[uint32]Sender{},
 		rtpTable:      newRTPTable(),
 		rtpHeaders:    map[string]rtc.HeaderExtensionID{},
+		encrypted:     map[rtc.HeaderExtensionID]bool{},
 		codec:         map[rtc.PayloadType]*Codec{},
 		stats:         newStats(),
 		closeCh:       make(chan struct{}),
+		dataProducers: map[string]*DataProducer{},
+		dataConsumers: map[string]*DataConsumer{},
 	}
 	transport.SetConnection(&t)
 	return &t
@@ -83,6 +127,7 @@ type Connection struct {
 	// this will replace the header in producer and connection
 	// we must maintain a uri->id mapping in connection
 	rtpHeaders map[string]rtc.HeaderExtensionID
+	encrypted  map[rtc.HeaderExtensionID]bool // header extensions negotiated with rfc6904
 	codec      map[rtc.PayloadType]*Codec
 
 	// allow user custom receiver and sender bwe type
@@ -99,8 +144,21 @@ type Connection struct {
 	transportWideCcSeq int
 	connected          bool
 
+	// receiveMutex serializes the incoming packets, some transports read rtp and rtcp in different
+	// goroutines, and the direct transport is written by the user.
+	receiveMutex sync.Mutex
+
 	onStateChange func(int)
 	closeCh       chan struct{}
+	closeOnce     sync.Once
+	authorizer    Authorizer
+
+	// data channels created before sctp connected will be opened later.
+	sctp          *sctpTransport
+	dataChannels  []*DataChannel
+	onDataChannel func(*DataChannel)
+	dataProducers map[string]*DataProducer
+	dataConsumers map[string]*DataConsumer
 }
 
 func (c *Connection) ID() string {
@@ -116,6 +174,62 @@ func (c *Connection) Transport() Transport {
 	return c.transport
 }
 
+// SetAuthorizer sets the authorizer of the receivers and senders created later, nil allows all.
+func (c *Connection) SetAuthorizer(authorizer Authorizer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.authorizer = authorizer
+}
+
+// Authorizer returns the authorizer set by SetAuthorizer.
+func (c *Connection) Authorizer() Authorizer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.authorizer
+}
+
+// SetMaxIncomingBitrate limits the bitrate of receivers by the bwe feedback, 0 is unlimited.
+func (c *Connection) SetMaxIncomingBitrate(bitrate uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.maxIncomingBitrate = bitrate
+	if c.bweReceiver != nil {
+		c.bweReceiver.SetMaxIncomingBitrate(bitrate)
+	}
+}
+
+// RestartIce changes the local ice credentials of the transport, the new ones are in Transport().Info().
+func (c *Connection) RestartIce() error {
+	t, ok := c.transport.(iceRestarter)
+	if !ok {
+		return ErrIceRestartNotSupported
+	}
+	return t.RestartIce()
+}
+
+// SetCryptex encrypts the csrcs and the header extensions of the rtp sent by rfc9335,
+// it should be called once both sides negotiated a=cryptex. The rtp received is decrypted either way.
+func (c *Connection) SetCryptex(enabled bool) error {
+	t, ok := c.transport.(cryptexSetter)
+	if !ok {
+		if !enabled {
+			return nil
+		}
+		return ErrCryptexNotSupported
+	}
+	return t.SetCryptex(enabled)
+}
+
+// SetRemote applies the remote parsed from the answer of our offer, it connects the client transport
+// and resolves the dtls role of the actpass offered.
+func (c *Connection) SetRemote(remote TransportInfo) error {
+	t, ok := c.transport.(remoteSetter)
+	if !ok {
+		return ErrSetRemoteNotSupported
+	}
+	return t.SetRemote(remote)
+}
+
 const (
 	payloadBottom = rtc.PayloadType(100)
 	payloadTop    = rtc.PayloadType(150)
@@ -191,22 +305,46 @@ func (c *Connection) getHeaderExtensions(headers []rtc.HeaderExtension) rtc.Head
 		// we already know the header
 		if id, ok := c.rtpHeaders[h.URI]; ok {
 			result = append(result, rtc.HeaderExtension{
-				URI: h.URI,
-				ID:  id,
+				URI:     h.URI,
+				ID:      id,
+				Encrypt: c.encrypted[id],
 			})
 		} else {
 			// default id may duplicate with other
 			defaultID := c.generateHeaderID()
 			result = append(result, rtc.HeaderExtension{
-				URI: h.URI,
-				ID:  defaultID,
+				URI:     h.URI,
+				ID:      defaultID,
+				Encrypt: h.Encrypt,
 			})
 			c.rtpHeaders[h.URI] = defaultID
+			c.setHeaderEncrypted(defaultID, h.Encrypt)
 		}
 	}
 	return rtc.NewHerderExtensionIDs(result)
 }
 
+// setHeaderEncrypted tell the transport which header extensions need encryption.
+func (c *Connection) setHeaderEncrypted(id rtc.HeaderExtensionID, encrypt bool) {
+	if c.encrypted[id] == encrypt {
+		return
+	}
+	if encrypt {
+		c.encrypted[id] = true
+	} else {
+		delete(c.encrypted, id)
+	}
+	t, ok := c.transport.(headerEncrypter)
+	if !ok {
+		return
+	}
+	ids := make([]rtc.HeaderExtensionID, 0, len(c.encrypted))
+	for id := range c.encrypted {
+		ids = append(ids, id)
+	}
+	t.SetEncryptedHeaderExtensions(ids)
+}
+
 func (c *Connection) generateHeaderID() rtc.HeaderExtensionID {
 	var defaultID rtc.HeaderExtensionID
 	for i := headerBottom; i <= headerTop; i++ {
@@ -231,12 +369,18 @@ func (c *Connection) updateHeaderExtensions(headers []rtc.HeaderExtension) error
 			return ErrHeaderIDNotMatch
 		}
 		c.rtpHeaders[h.URI] = h.ID
+		c.setHeaderEncrypted(h.ID, h.Encrypt)
 	}
 
 	return nil
 }
 
 func (c *Connection) NewReceiver(req *ReceiverOption) (*Receiver, error) {
+	if authorizer := c.Authorizer(); authorizer != nil {
+		if err := authorizer.AuthorizeReceiver(c, req); err != nil {
+			return nil, err
+		}
+	}
 	c.mutex.Lock()
 	headers := make([]rtc.HeaderExtension, 0, len(req.HeaderExtensions))
 	for _, h := range req.HeaderExtensions {
@@ -313,11 +457,6 @@ func (c *Connection) removeReceiver(id string) {
 }
 
 func (c *Connection) NewSender(req *SenderOption) (Sender, error) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	if req.ID == "" {
-		req.ID = RandomString(12)
-	}
 	conn := c.listener.Connection(req.ConnectionID)
 	if conn == nil {
 		return nil, ErrReceiverNotExist
@@ -326,7 +465,35 @@ func (c *Connection) NewSender(req *SenderOption) (Sender, error) {
 	if receiver == nil {
 		return nil, ErrReceiverNotExist
 	}
-	sender, err := NewSender(req, c, receiver, c.stats)
+	if authorizer := c.Authorizer(); authorizer != nil {
+		if err := authorizer.AuthorizeSender(c, req, conn, receiver); err != nil {
+			return nil, err
+		}
+	}
+	sender, err := c.newSender(req, receiver)
+	if err != nil {
+		return nil, err
+	}
+	// it requests the keyframe of the receiver, so it's called without the lock.
+	if c.transport.IsConnected() {
+		sender.TransportConnected()
+	}
+	return sender, nil
+}
+
+func (c *Connection) newSender(req *SenderOption, receiver *Receiver) (Sender, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if req.ID == "" {
+		req.ID = RandomString(12)
+	}
+	var sender Sender
+	var err error
+	if t, ok := c.transport.(senderCreator); ok {
+		sender, err = t.newSender(req, c, receiver, c.stats)
+	} else {
+		sender, err = NewSender(req, c, receiver, c.stats)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -349,10 +516,6 @@ func (c *Connection) NewSender(req *SenderOption) (Sender, error) {
 	if c.bweSender != nil {
 		sender.SetExternallyManagedBitrate()
 	}
-
-	if c.transport.IsConnected() {
-		sender.TransportConnected()
-	}
 	return sender, nil
 }
 
@@ -372,11 +535,29 @@ func (c *Connection) removeSender(id string) {
 	for i, v := range c.senders {
 		if v.ID() == id {
 			c.senders = append(c.senders[:i], c.senders[i+1:]...)
-			delete(c.ssrcSenders, v.Stream().SSRC)
-			delete(c.rtxSsrcSender, v.Stream().RTX)
 			break
 		}
+	}
+	// a pipe sender may map more than one ssrc.
+	for ssrc, s := range c.ssrcSenders {
+		if s.ID() == id {
+			delete(c.ssrcSenders, ssrc)
+		}
+	}
+	for ssrc, s := range c.rtxSsrcSender {
+		if s.ID() == id {
+			delete(c.rtxSsrcSender, ssrc)
+		}
+	}
+}
 
+// mapSenderSSRC is for the senders know their ssrcs after created.
+func (c *Connection) mapSenderSSRC(ssrc, rtx uint32, s Sender) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ssrcSenders[ssrc] = s
+	if rtx != 0 {
+		c.rtxSsrcSender[rtx] = s
 	}
 }
 
@@ -388,13 +569,19 @@ func (c *Connection) getSenderBySSRC(ssrc uint32) Sender {
 
 // receiveRTPPacket process incoming rtp packet and update stats.
 func (c *Connection) receiveRTPPacket(packet rtc.Packet) {
-	if c.bweReceiver != nil {
-		c.bweReceiver.IncomingPacket(time.Now().UnixMilli(), packet)
+	c.receiveMutex.Lock()
+	defer c.receiveMutex.Unlock()
+	c.mutex.Lock()
+	bweReceiver := c.bweReceiver
+	producer := c.rtpTable.GetProducer(packet, c.rtpHeaders)
+	c.mutex.Unlock()
+	if bweReceiver != nil {
+		bweReceiver.IncomingPacket(time.Now().UnixMilli(), packet)
 	}
 	c.stats.IncomingRTP(packet)
-	producer := c.rtpTable.GetProducer(packet, c.rtpHeaders)
 	if producer == nil {
-		logger.Warn("cant find producer for rtpPacket:", packet)
+		c.stats.UnknownSsrc()
+		logger.Debug("cant find producer for rtpPacket:", packet.SSRC())
 		return
 	}
 	result := producer.ReceiveRTPPacket(packet)
@@ -407,6 +594,8 @@ func (c *Connection) receiveRTPPacket(packet rtc.Packet) {
 
 // receiveRtcpPacket process incoming rtcp packet and dispatch it.
 func (c *Connection) receiveRtcpPacket(p []rtcp.Packet) {
+	c.receiveMutex.Lock()
+	defer c.receiveMutex.Unlock()
 	for _, packet := range p {
 		c.handleRtcpPacket(packet)
 	}
@@ -423,16 +612,18 @@ func (c *Connection) handleRtcpPacket(p rtcp.Packet) {
 	case *rtcp.TransportLayerNack:
 		c.handleRtcpNack(report)
 	case *rtcp.TransportLayerCC:
-		if c.bweSender != nil && c.bweSender.BweType() == bwe.TransportCC {
-			c.bweSender.ReceiveRTCP(report)
+		if bweSender := c.getBweSender(); bweSender != nil && bweSender.BweType() == bwe.TransportCC {
+			bweSender.ReceiveRTCP(report)
 		}
 	case *rtcp.ReceiverEstimatedMaximumBitrate:
-		if c.bweSender != nil && c.bweSender.BweType() == bwe.Remb {
-			c.bweSender.ReceiveRTCP(report)
+		if bweSender := c.getBweSender(); bweSender != nil && bweSender.BweType() == bwe.Remb {
+			bweSender.ReceiveRTCP(report)
 		}
 	// receiver
 	case *rtcp.SenderReport:
+		c.mutex.Lock()
 		producer := c.rtpTable.GetProducerBySsrc(report.SSRC)
+		c.mutex.Unlock()
 		if producer != nil {
 			producer.ReceiveRtcpSenderReport(report)
 		}
@@ -444,6 +635,12 @@ func (c *Connection) handleRtcpPacket(p rtcp.Packet) {
 	}
 }
 
+func (c *Connection) getBweSender() bwe.Sender {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.bweSender
+}
+
 func (c *Connection) handleRtcpNack(report *rtcp.TransportLayerNack) {
 	consumer := c.getSenderBySSRC(report.MediaSSRC)
 	if consumer != nil && report.MediaSSRC != RTPProbationSsrc {
@@ -457,6 +654,8 @@ func (c *Connection) handleRtcpKeyframe(report rtcp.Packet) {
 
 		if consumer == nil && ssrc != RTPProbationSsrc {
 			logger.Warn("unknown key frame request:", ssrc, report)
+		} else if r, ok := consumer.(ssrcKeyframeRequester); ok {
+			r.requestKeyframeBySSRC(ssrc)
 		} else {
 			consumer.RequestKeyframe()
 		}
@@ -475,13 +674,16 @@ func (c *Connection) handleRtcpRR(report *rtcp.ReceiverReport) {
 	}
 }
 
+// sendRTPPacket is called by the senders in the goroutines of receivers.
 func (c *Connection) sendRTPPacket(packet rtc.Packet) {
+	c.mutex.Lock()
 	packet.UpdateAbsSendTime(c.rtpHeaders[rtc.HeaderExtensionAbsSendTime], time.Now())
 	if !packet.IsRTX() {
 		if c.bweSender != nil && c.bweSender.BweType() == bwe.TransportCC && packet.UpdateTransportWideCc01(c.transportWideCcSeq+1) {
 			c.transportWideCcSeq++
 		}
 	}
+	c.mutex.Unlock()
 	c.stats.OutcomePacket(packet)
 	c.transport.SendRTPPacket(packet)
 }
@@ -492,7 +694,7 @@ func (c *Connection) sendRtcpPacket(packet rtcp.Packet) {
 
 func (c *Connection) OnConsumerNeedBitrateChange(s Sender) {
 	var bitrate int64
-	bitrate = int64(c.bweSender.EstimateBitrate())
+	bitrate = int64(c.getBweSender().EstimateBitrate())
 	simulcast := map[Sender]int{}
 
 	for _, c := range c.Senders() {
@@ -536,8 +738,230 @@ func (c *Connection) OnConsumerNeedBitrateChange(s Sender) {
 	}
 }
 
+// CreateDataChannel creates a data channel, it opens once the sctp connected.
+func (c *Connection) CreateDataChannel(option *DataChannelOption) (*DataChannel, error) {
+	if option.Negotiated && option.ID == nil {
+		return nil, ErrDataChannelIDInvalid
+	}
+	d := newDataChannel(*option)
+	d.release = func() { c.removeDataChannel(d) }
+	c.mutex.Lock()
+	c.dataChannels = append(c.dataChannels, d)
+	s := c.sctp
+	c.mutex.Unlock()
+	if s == nil {
+		return d, nil
+	}
+	if err := s.dial(d); err != nil {
+		c.removeDataChannel(d)
+		return nil, err
+	}
+	return d, nil
+}
+
+// OnDataChannel is called when remote opens a data channel, set the callbacks of channel inside it.
+func (c *Connection) OnDataChannel(callback func(*DataChannel)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onDataChannel = callback
+}
+
+func (c *Connection) DataChannels() []*DataChannel {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := make([]*DataChannel, 0, len(c.dataChannels))
+	s = append(s, c.dataChannels...)
+	return s
+}
+
+func (c *Connection) removeDataChannel(d *DataChannel) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, v := range c.dataChannels {
+		if v == d {
+			c.dataChannels = append(c.dataChannels[:i], c.dataChannels[i+1:]...)
+			return
+		}
+	}
+}
+
+// sctpConnected is called by transport once the sctp association established.
+func (c *Connection) sctpConnected(s *sctpTransport) {
+	c.mutex.Lock()
+	c.sctp = s
+	pending := make([]*DataChannel, 0, len(c.dataChannels))
+	pending = append(pending, c.dataChannels...)
+	c.mutex.Unlock()
+
+	for _, d := range pending {
+		if err := s.dial(d); err != nil {
+			logger.Warn("open data channel fail:", d.Label(), err)
+			c.removeDataChannel(d)
+		}
+	}
+	go s.accept(c.openedDataChannels, func(d *DataChannel) {
+		d.release = func() { c.removeDataChannel(d) }
+		c.mutex.Lock()
+		c.dataChannels = append(c.dataChannels, d)
+		callback := c.onDataChannel
+		c.mutex.Unlock()
+		if callback != nil {
+			callback(d)
+		}
+	})
+}
+
+// NewDataProducer relays the messages from a data channel of this connection.
+func (c *Connection) NewDataProducer(req *DataProducerOption) (*DataProducer, error) {
+	if req.DataChannel == nil {
+		return nil, ErrDataChannelCantBeNil
+	}
+	option := *req
+	if option.ID == "" {
+		option.ID = RandomString(12)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.dataProducers[option.ID]; ok {
+		return nil, ErrDataProducerExist
+	}
+	owned := false
+	for _, d := range c.dataChannels {
+		if d == req.DataChannel {
+			owned = true
+			break
+		}
+	}
+	if !owned {
+		return nil, ErrDataChannelNotOwned
+	}
+	producer := newDataProducer(c.id, &option)
+	producer.onClose = func() {
+		c.mutex.Lock()
+		delete(c.dataProducers, producer.id)
+		c.mutex.Unlock()
+	}
+	// remote closed the channel, the consumers have nothing to relay.
+	req.DataChannel.OnClose(producer.Close)
+	c.dataProducers[option.ID] = producer
+	return producer, nil
+}
+
+// NewDataConsumer creates a data channel on this connection with the same options as the producer,
+// and relays the producer messages to it.
+func (c *Connection) NewDataConsumer(req *DataConsumerOption) (*DataConsumer, error) {
+	option := *req
+	if option.ID == "" {
+		option.ID = RandomString(12)
+	}
+	conn := c.listener.Connection(option.ConnectionID)
+	if conn == nil {
+		return nil, ErrDataProducerNotExist
+	}
+	producer := conn.DataProducer(option.DataProducerID)
+	if producer == nil {
+		return nil, ErrDataProducerNotExist
+	}
+	// the id is reserved by nil until the consumer created, the concurrent ones of the same id fail.
+	c.mutex.Lock()
+	if _, exist := c.dataConsumers[option.ID]; exist {
+		c.mutex.Unlock()
+		return nil, ErrDataConsumerExist
+	}
+	c.dataConsumers[option.ID] = nil
+	c.mutex.Unlock()
+	channelOption := producer.DataChannel().Option()
+	// the id is chosen by our side, the producer side one may conflict.
+	channelOption.ID, channelOption.Negotiated = nil, false
+	channel, err := c.CreateDataChannel(&channelOption)
+	if err != nil {
+		c.mutex.Lock()
+		delete(c.dataConsumers, option.ID)
+		c.mutex.Unlock()
+		return nil, err
+	}
+	consumer := newDataConsumer(&option, producer, channel)
+	consumer.onClose = func() {
+		c.mutex.Lock()
+		if c.dataConsumers[consumer.id] == consumer {
+			delete(c.dataConsumers, consumer.id)
+		}
+		c.mutex.Unlock()
+	}
+	c.mutex.Lock()
+	c.dataConsumers[option.ID] = consumer
+	c.mutex.Unlock()
+	if err = producer.addConsumer(consumer); err != nil {
+		c.mutex.Lock()
+		delete(c.dataConsumers, option.ID)
+		c.mutex.Unlock()
+		channel.Close()
+		c.removeDataChannel(channel)
+		return nil, err
+	}
+	// remote closed the channel, no need to relay any more.
+	channel.OnClose(consumer.Close)
+	return consumer, nil
+}
+
+func (c *Connection) DataProducer(id string) *DataProducer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.dataProducers[id]
+}
+
+func (c *Connection) DataProducers() []*DataProducer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := make([]*DataProducer, 0, len(c.dataProducers))
+	for _, v := range c.dataProducers {
+		s = append(s, v)
+	}
+	return s
+}
+
+func (c *Connection) DataConsumers() []*DataConsumer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := make([]*DataConsumer, 0, len(c.dataConsumers))
+	for _, v := range c.dataConsumers {
+		if v != nil {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+func (c *Connection) openedDataChannels() []*datachannel.DataChannel {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	channels := make([]*datachannel.DataChannel, 0, len(c.dataChannels))
+	for _, d := range c.dataChannels {
+		d.mutex.Lock()
+		if d.channel != nil {
+			channels = append(channels, d.channel)
+		}
+		d.mutex.Unlock()
+	}
+	return channels
+}
+
+// Close closes the connection once, the owner and the broker may close it at the same time.
 func (c *Connection) Close() {
+	c.closeOnce.Do(c.close)
+}
+
+func (c *Connection) close() {
 	c.listener.removeConnection(c.id)
+	for _, p := range c.DataProducers() {
+		p.Close()
+	}
+	for _, d := range c.DataConsumers() {
+		d.Close()
+	}
+	for _, d := range c.DataChannels() {
+		d.Close()
+	}
 	for _, r := range c.Receivers() {
 		r.Close()
 	}
@@ -571,7 +995,7 @@ func (c *Connection) Disconnected() {
 	for _, r := range c.Receivers() {
 		r.Close()
 	}
-	for _, s := range c.senders {
+	for _, s := range c.Senders() {
 		s.TransportDisconnected()
 	}
 }
@@ -597,13 +1021,13 @@ func (c *Connection) loop() {
 
 func (c *Connection) onTimeSendRTCP(ms int64) {
 	// We sent it separately, avoid MTU size.
-	for _, s := range c.senders {
+	for _, s := range c.Senders() {
 		packet := s.GetRtcp(ms)
 		if packet != nil {
 			c.sendRtcpPacket(packet)
 		}
 	}
-	for _, p := range c.receivers {
+	for _, p := range c.Receivers() {
 		packet := p.GetRtcp(ms)
 		if packet != nil {
 			c.sendRtcpPacket(packet)
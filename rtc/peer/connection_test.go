@@ -68,6 +68,28 @@ func TestConnection(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:        "encrypted header",
+			description: "transport should know which header extensions need encryption",
+			method: func(t *testing.T) {
+				transport := &mockEncryptTransport{}
+				conn := newConnection("test-id", "", transport, listener)
+				err := conn.updateHeaderExtensions([]rtc.HeaderExtension{
+					{URI: rtc.HeaderExtensionAudioLevel, ID: 1, Encrypt: true},
+					{URI: rtc.HeaderExtensionMid, ID: 4},
+				})
+				assert(t, err, nil)
+				assert(t, len(transport.ids), 1)
+				assert(t, transport.ids[0], rtc.HeaderExtensionID(1))
+
+				headers := conn.getHeaderExtensions([]rtc.HeaderExtension{
+					{URI: rtc.HeaderExtensionAudioLevel, ID: 10},
+				})
+				if !headers[rtc.HeaderExtensionAudioLevel].Encrypt {
+					t.Error("should keep encrypt")
+				}
+			},
+		},
 		{
 			name:        "default codec",
 			description: "",
@@ -191,6 +213,29 @@ func TestConnectionReceiver(t *testing.T) {
 				assert(t, len(conn.Receivers()), 1)
 			},
 		},
+		{
+			name:        "close a receiver",
+			description: "the close callbacks are called once",
+			method: func(t *testing.T) {
+				conn := newConnection("test-id", "", &MockTransport{}, &MockConnectionListener{conns: map[string]*Connection{}})
+				receiver, err := conn.NewReceiver(&ReceiverOption{
+					ID:        "test-receiver",
+					MID:       "1",
+					MediaType: rtc.MediaTypeAudio,
+					Codec:     &Codec{PayloadType: 111, EncoderName: "opus", ClockRate: 48000},
+					Streams:   []StreamOption{{SSRC: 1000, PayloadType: 111}},
+				})
+				assert(t, err, nil)
+				var closed int
+				receiver.OnClose(func() {
+					closed++
+				})
+				receiver.Close()
+				receiver.Close()
+				assert(t, closed, 1)
+				assert(t, len(conn.Receivers()), 0)
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -320,6 +365,10 @@ func TestConnectionReceiveRTP(t *testing.T) {
 				if stats.ReceiveBPS(1000) != 80000 {
 					t.Error("bps wrong", stats.ReceiveBPS(1000))
 				}
+				// none of them carries the receiver's ssrc
+				if stats.UnknownSsrcPackets() != 10 {
+					t.Error("unknown ssrc count wrong", stats.UnknownSsrcPackets())
+				}
 			},
 		},
 	}
@@ -357,6 +406,15 @@ func (t *MockTransport) Info() TransportInfo {
 	return TransportInfo{}
 }
 
+type mockEncryptTransport struct {
+	MockTransport
+	ids []rtc.HeaderExtensionID
+}
+
+func (t *mockEncryptTransport) SetEncryptedHeaderExtensions(ids []rtc.HeaderExtensionID) {
+	t.ids = ids
+}
+
 type MockConnectionListener struct {
 	conns map[string]*Connection
 }
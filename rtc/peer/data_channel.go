@@ -0,0 +1,363 @@
+package peer
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/pion/datachannel"
+	"github.com/pion/logging"
+	"github.com/pion/sctp"
+)
+
+const (
+	// the max message size we send, as browser does.
+	dataChannelMaxMessageSize = 65536
+	// the sctp receive window, no message could be larger than it.
+	sctpMaxReceiveBufferSize = 1024 * 1024
+)
+
+var (
+	ErrDataChannelNotOpen   = errors.New("data channel not open")
+	ErrDataChannelClosed    = errors.New("data channel closed")
+	ErrDataChannelIDInvalid = errors.New("negotiated data channel requires id")
+	ErrDataChannelIDUsed    = errors.New("data channel id already used")
+)
+
+// DataChannelOption is the RTCDataChannelInit, see rfc8831#section-6.
+type DataChannelOption struct {
+	Label    string
+	Protocol string
+	// Unordered delivers the message as soon as it arrives.
+	Unordered bool
+	// MaxRetransmits and MaxPacketLifeTime make the channel partial reliable, only one of them could be set.
+	MaxRetransmits    *uint16
+	MaxPacketLifeTime *uint16 // ms
+	// Negotiated channels are agreed out of band with the same ID, no DCEP open.
+	Negotiated bool
+	ID         *uint16
+}
+
+func (o *DataChannelOption) config() *datachannel.Config {
+	config := &datachannel.Config{
+		ChannelType:   datachannel.ChannelTypeReliable,
+		Negotiated:    o.Negotiated,
+		Label:         o.Label,
+		Protocol:      o.Protocol,
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	}
+	switch {
+	case o.MaxRetransmits != nil && o.Unordered:
+		config.ChannelType = datachannel.ChannelTypePartialReliableRexmitUnordered
+		config.ReliabilityParameter = uint32(*o.MaxRetransmits)
+	case o.MaxRetransmits != nil:
+		config.ChannelType = datachannel.ChannelTypePartialReliableRexmit
+		config.ReliabilityParameter = uint32(*o.MaxRetransmits)
+	case o.MaxPacketLifeTime != nil && o.Unordered:
+		config.ChannelType = datachannel.ChannelTypePartialReliableTimedUnordered
+		config.ReliabilityParameter = uint32(*o.MaxPacketLifeTime)
+	case o.MaxPacketLifeTime != nil:
+		config.ChannelType = datachannel.ChannelTypePartialReliableTimed
+		config.ReliabilityParameter = uint32(*o.MaxPacketLifeTime)
+	case o.Unordered:
+		config.ChannelType = datachannel.ChannelTypeReliableUnordered
+	}
+	return config
+}
+
+func optionFromConfig(config datachannel.Config) DataChannelOption {
+	option := DataChannelOption{
+		Label:    config.Label,
+		Protocol: config.Protocol,
+	}
+	parameter := uint16(config.ReliabilityParameter)
+	switch config.ChannelType {
+	case datachannel.ChannelTypeReliableUnordered:
+		option.Unordered = true
+	case datachannel.ChannelTypePartialReliableRexmitUnordered:
+		option.Unordered = true
+		option.MaxRetransmits = &parameter
+	case datachannel.ChannelTypePartialReliableRexmit:
+		option.MaxRetransmits = &parameter
+	case datachannel.ChannelTypePartialReliableTimedUnordered:
+		option.Unordered = true
+		option.MaxPacketLifeTime = &parameter
+	case datachannel.ChannelTypePartialReliableTimed:
+		option.MaxPacketLifeTime = &parameter
+	}
+	return option
+}
+
+// DataChannelMessage is a message received from data channel.
+type DataChannelMessage struct {
+	IsString bool
+	Data     []byte
+}
+
+// DataChannel is a bidirectional message channel over sctp.
+type DataChannel struct {
+	mutex     sync.Mutex
+	option    DataChannelOption
+	id        uint16
+	channel   *datachannel.DataChannel
+	closed    bool
+	onOpen    func()
+	onMessage func(DataChannelMessage)
+	onClose   func()
+	release   func() // remove from connection
+}
+
+func newDataChannel(option DataChannelOption) *DataChannel {
+	d := &DataChannel{option: option}
+	if option.ID != nil {
+		d.id = *option.ID
+	}
+	return d
+}
+
+func (d *DataChannel) Label() string {
+	return d.option.Label
+}
+
+func (d *DataChannel) Protocol() string {
+	return d.option.Protocol
+}
+
+// ID is the sctp stream id, it's zero before open unless negotiated.
+func (d *DataChannel) ID() uint16 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.id
+}
+
+// Option returns the reliability of the channel, the remote opened one is parsed from DCEP.
+func (d *DataChannel) Option() DataChannelOption {
+	return d.option
+}
+
+// BufferedAmount is the bytes queued in sctp but not sent yet.
+func (d *DataChannel) BufferedAmount() uint64 {
+	d.mutex.Lock()
+	channel := d.channel
+	d.mutex.Unlock()
+	if channel == nil {
+		return 0
+	}
+	return channel.BufferedAmount()
+}
+
+func (d *DataChannel) IsOpen() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.channel != nil && !d.closed
+}
+
+// OnOpen is called when the channel is ready to send, the channel created before sctp connected will wait.
+func (d *DataChannel) OnOpen(callback func()) {
+	d.mutex.Lock()
+	open := d.channel != nil
+	d.onOpen = callback
+	d.mutex.Unlock()
+	if open {
+		callback()
+	}
+}
+
+func (d *DataChannel) OnMessage(callback func(DataChannelMessage)) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.onMessage = callback
+}
+
+func (d *DataChannel) OnClose(callback func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.onClose = callback
+}
+
+// Send sends a binary message.
+func (d *DataChannel) Send(data []byte) error {
+	return d.send(data, false)
+}
+
+// SendText sends a string message.
+func (d *DataChannel) SendText(text string) error {
+	return d.send([]byte(text), true)
+}
+
+func (d *DataChannel) send(data []byte, isString bool) error {
+	d.mutex.Lock()
+	channel, closed := d.channel, d.closed
+	d.mutex.Unlock()
+	if closed {
+		return ErrDataChannelClosed
+	}
+	if channel == nil {
+		return ErrDataChannelNotOpen
+	}
+	_, err := channel.WriteDataChannel(data, isString)
+	return err
+}
+
+func (d *DataChannel) Close() {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return
+	}
+	d.closed = true
+	channel := d.channel
+	d.mutex.Unlock()
+	if channel != nil {
+		_ = channel.Close()
+	}
+}
+
+func (d *DataChannel) open(channel *datachannel.DataChannel) {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		_ = channel.Close()
+		return
+	}
+	d.channel = channel
+	d.id = channel.StreamIdentifier()
+	onOpen := d.onOpen
+	d.mutex.Unlock()
+	if onOpen != nil {
+		onOpen()
+	}
+	go d.readLoop(channel)
+}
+
+// readLoop reads the messages until the channel closed. The buffer grows for the message larger than
+// the one we send, the remote may ignore our max-message-size, and the message is kept in sctp until read.
+func (d *DataChannel) readLoop(channel *datachannel.DataChannel) {
+	buf := make([]byte, dataChannelMaxMessageSize)
+	for {
+		n, isString, err := channel.ReadDataChannel(buf)
+		if errors.Is(err, io.ErrShortBuffer) && len(buf) < sctpMaxReceiveBufferSize {
+			logger.Debug("data channel message larger than:", d.option.Label, len(buf))
+			buf = make([]byte, len(buf)*2)
+			continue
+		}
+		if err != nil {
+			logger.Debug("data channel read stop:", d.option.Label, err)
+			break
+		}
+		d.mutex.Lock()
+		onMessage := d.onMessage
+		d.mutex.Unlock()
+		if onMessage != nil {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			onMessage(DataChannelMessage{IsString: isString, Data: data})
+		}
+	}
+	d.mutex.Lock()
+	d.closed = true
+	onClose := d.onClose
+	d.mutex.Unlock()
+	if d.release != nil {
+		d.release()
+	}
+	if onClose != nil {
+		onClose()
+	}
+}
+
+// sctpTransport is the sctp association over dtls, all data channels of a connection share it.
+type sctpTransport struct {
+	association *sctp.Association
+	// the dtls client uses even stream id, and the server uses odd, rfc8832#section-4
+	isClient bool
+	mutex    sync.Mutex
+	nextID   uint16
+	used     map[uint16]bool
+}
+
+// newSctpTransport starts a sctp association, it blocks until the association established.
+func newSctpTransport(conn net.Conn, isClient bool) (*sctpTransport, error) {
+	association, err := sctp.Client(sctp.Config{
+		NetConn:              conn,
+		MaxReceiveBufferSize: sctpMaxReceiveBufferSize,
+		MaxMessageSize:       dataChannelMaxMessageSize,
+		LoggerFactory:        logging.NewDefaultLoggerFactory(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &sctpTransport{
+		association: association,
+		isClient:    isClient,
+		used:        map[uint16]bool{},
+	}
+	if !isClient {
+		s.nextID = 1
+	}
+	return s, nil
+}
+
+func (s *sctpTransport) generateID() uint16 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.used[s.nextID] {
+		s.nextID += 2
+	}
+	id := s.nextID
+	s.used[id] = true
+	s.nextID += 2
+	return id
+}
+
+func (s *sctpTransport) reserveID(id uint16) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.used[id] {
+		return ErrDataChannelIDUsed
+	}
+	s.used[id] = true
+	return nil
+}
+
+func (s *sctpTransport) dial(d *DataChannel) error {
+	id := d.ID()
+	if d.option.ID != nil {
+		if err := s.reserveID(id); err != nil {
+			return err
+		}
+	} else {
+		id = s.generateID()
+	}
+	channel, err := datachannel.Dial(s.association, id, d.option.config())
+	if err != nil {
+		return err
+	}
+	d.open(channel)
+	return nil
+}
+
+// accept returns the channels opened by remote, until the association closed.
+func (s *sctpTransport) accept(existing func() []*datachannel.DataChannel, callback func(*DataChannel)) {
+	for {
+		channel, err := datachannel.Accept(s.association, &datachannel.Config{
+			LoggerFactory: logging.NewDefaultLoggerFactory(),
+		}, existing()...)
+		if err != nil {
+			logger.Debug("sctp accept stop:", err)
+			return
+		}
+		s.mutex.Lock()
+		s.used[channel.StreamIdentifier()] = true
+		s.mutex.Unlock()
+		d := newDataChannel(optionFromConfig(channel.Config))
+		callback(d)
+		d.open(channel)
+	}
+}
+
+func (s *sctpTransport) Close() {
+	_ = s.association.Close()
+}
@@ -0,0 +1,180 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestSctpConnections returns two connections and the func to connect their sctp.
+func newTestSctpConnections(t *testing.T) (*Connection, *Connection, func()) {
+	return newTestSctpConnectionsWith(t, &MockConnectionListener{conns: map[string]*Connection{}}, "a", "b")
+}
+
+func newTestSctpConnectionsWith(t *testing.T, listener *MockConnectionListener, idA, idB string) (*Connection, *Connection, func()) {
+	a := newConnection(idA, "", &MockTransport{}, listener)
+	b := newConnection(idB, "", &MockTransport{}, listener)
+	listener.conns[idA], listener.conns[idB] = a, b
+	connA, connB := net.Pipe()
+	t.Cleanup(func() {
+		_ = connA.Close()
+		_ = connB.Close()
+	})
+	type result struct {
+		s   *sctpTransport
+		err error
+	}
+	chA, chB := make(chan result), make(chan result)
+	go func() {
+		s, err := newSctpTransport(connA, true)
+		chA <- result{s, err}
+	}()
+	go func() {
+		s, err := newSctpTransport(connB, false)
+		chB <- result{s, err}
+	}()
+	ra, rb := <-chA, <-chB
+	if ra.err != nil || rb.err != nil {
+		t.Fatal("sctp connect fail:", ra.err, rb.err)
+	}
+	t.Cleanup(func() {
+		ra.s.Close()
+		rb.s.Close()
+	})
+	return a, b, func() {
+		a.sctpConnected(ra.s)
+		b.sctpConnected(rb.s)
+	}
+}
+
+func TestDataChannel(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "create before sctp connected",
+			description: "the channel opens once sctp connected, and remote accepts it",
+			method: func(t *testing.T) {
+				a, b, connect := newTestSctpConnections(t)
+				accepted := make(chan *DataChannel, 1)
+				b.OnDataChannel(func(d *DataChannel) {
+					accepted <- d
+				})
+				d, err := a.CreateDataChannel(&DataChannelOption{Label: "chat"})
+				assert(t, err, nil)
+				if err = d.SendText("hello"); !errors.Is(err, ErrDataChannelNotOpen) {
+					t.Fatal("should not open yet:", err)
+				}
+				opened := make(chan struct{})
+				d.OnOpen(func() { close(opened) })
+				connect()
+				<-opened
+				assert(t, d.ID()%2, uint16(0))
+
+				var remote *DataChannel
+				select {
+				case remote = <-accepted:
+				case <-time.After(5 * time.Second):
+					t.Fatal("accept timeout")
+				}
+				assert(t, remote.Label(), "chat")
+				messages := make(chan DataChannelMessage, 1)
+				remote.OnMessage(func(msg DataChannelMessage) {
+					messages <- msg
+				})
+				assert(t, d.SendText("hello"), nil)
+				select {
+				case msg := <-messages:
+					assert(t, msg.IsString, true)
+					assert(t, string(msg.Data), "hello")
+				case <-time.After(5 * time.Second):
+					t.Fatal("message timeout")
+				}
+				assert(t, len(b.DataChannels()), 1)
+			},
+		},
+		{
+			name:        "negotiated channel",
+			description: "both sides create the channel with the same id, no DCEP",
+			method: func(t *testing.T) {
+				a, b, connect := newTestSctpConnections(t)
+				connect()
+				_, err := a.CreateDataChannel(&DataChannelOption{Label: "n", Negotiated: true})
+				if !errors.Is(err, ErrDataChannelIDInvalid) {
+					t.Fatal("negotiated requires id:", err)
+				}
+				id := uint16(10)
+				da, err := a.CreateDataChannel(&DataChannelOption{Label: "n", Negotiated: true, ID: &id})
+				assert(t, err, nil)
+				db, err := b.CreateDataChannel(&DataChannelOption{Label: "n", Negotiated: true, ID: &id})
+				assert(t, err, nil)
+				assert(t, da.ID(), id)
+				messages := make(chan DataChannelMessage, 1)
+				db.OnMessage(func(msg DataChannelMessage) {
+					messages <- msg
+				})
+				assert(t, da.Send([]byte{1, 2, 3}), nil)
+				select {
+				case msg := <-messages:
+					assert(t, msg.IsString, false)
+					assert(t, len(msg.Data), 3)
+				case <-time.After(5 * time.Second):
+					t.Fatal("message timeout")
+				}
+				if _, err = a.CreateDataChannel(&DataChannelOption{Label: "n", Negotiated: true, ID: &id}); !errors.Is(err, ErrDataChannelIDUsed) {
+					t.Fatal("id should be used:", err)
+				}
+			},
+		},
+		{
+			name:        "large message",
+			description: "the message larger than the one we send is read, the channel is kept",
+			method: func(t *testing.T) {
+				a, b, connect := newTestSctpConnections(t)
+				connect()
+				// the remote ignores our max-message-size.
+				a.sctp.association.SetMaxMessageSize(4 * dataChannelMaxMessageSize)
+				id := uint16(20)
+				da, err := a.CreateDataChannel(&DataChannelOption{Label: "large", Negotiated: true, ID: &id})
+				assert(t, err, nil)
+				db, err := b.CreateDataChannel(&DataChannelOption{Label: "large", Negotiated: true, ID: &id})
+				assert(t, err, nil)
+				messages := make(chan DataChannelMessage, 2)
+				db.OnMessage(func(msg DataChannelMessage) {
+					messages <- msg
+				})
+				assert(t, da.Send(make([]byte, 3*dataChannelMaxMessageSize)), nil)
+				assert(t, da.SendText("after"), nil)
+				for _, size := range []int{3 * dataChannelMaxMessageSize, len("after")} {
+					select {
+					case msg := <-messages:
+						assert(t, len(msg.Data), size)
+					case <-time.After(5 * time.Second):
+						t.Fatal("message timeout")
+					}
+				}
+			},
+		},
+		{
+			name:        "channel type",
+			description: "the reliability and order are kept through the datachannel config",
+			method: func(t *testing.T) {
+				retransmits, lifetime := uint16(3), uint16(100)
+				for _, option := range []DataChannelOption{
+					{Label: "reliable"},
+					{Label: "unordered", Unordered: true},
+					{Label: "rexmit", MaxRetransmits: &retransmits},
+					{Label: "rexmit unordered", MaxRetransmits: &retransmits, Unordered: true},
+					{Label: "timed", MaxPacketLifeTime: &lifetime},
+					{Label: "timed unordered", MaxPacketLifeTime: &lifetime, Unordered: true},
+				} {
+					assert(t, optionFromConfig(*option.config()), option)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package peer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultDataConsumerMaxBufferedAmount is used when DataConsumerOption.MaxBufferedAmount is zero.
+const DefaultDataConsumerMaxBufferedAmount = 1024 * 1024
+
+type DataProducerOption struct {
+	ID string
+	// DataChannel is the channel of this connection the messages come from, the producer takes over its OnMessage
+	// and OnClose, it's closed when the remote closes the channel.
+	DataChannel *DataChannel
+}
+
+type DataConsumerOption struct {
+	ID             string
+	ConnectionID   string // the connection of data producer
+	DataProducerID string
+	// MaxBufferedAmount drops the message when sctp buffered more than it, a slow subscriber won't block others.
+	MaxBufferedAmount uint64
+}
+
+// DataProducer is the Receiver of data channel, it relays the messages to all its consumers.
+type DataProducer struct {
+	id           string
+	connectionID string
+	channel      *DataChannel
+
+	onClose   func()
+	mutex     sync.Mutex
+	consumers map[string]*DataConsumer
+	closed    bool
+
+	messagesReceived int64
+}
+
+func newDataProducer(connectionID string, option *DataProducerOption) *DataProducer {
+	p := &DataProducer{
+		id:           option.ID,
+		connectionID: connectionID,
+		channel:      option.DataChannel,
+		consumers:    map[string]*DataConsumer{},
+	}
+	p.channel.OnMessage(p.Send)
+	return p
+}
+
+func (p *DataProducer) ID() string {
+	return p.id
+}
+
+func (p *DataProducer) Label() string {
+	return p.channel.Label()
+}
+
+func (p *DataProducer) DataChannel() *DataChannel {
+	return p.channel
+}
+
+func (p *DataProducer) MessagesReceived() int64 {
+	return atomic.LoadInt64(&p.messagesReceived)
+}
+
+// Send relays a message to all consumers, it could be used to inject messages from server side.
+func (p *DataProducer) Send(msg DataChannelMessage) {
+	atomic.AddInt64(&p.messagesReceived, 1)
+	for _, c := range p.Consumers() {
+		c.send(msg)
+	}
+}
+
+func (p *DataProducer) Consumers() []*DataConsumer {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	consumers := make([]*DataConsumer, 0, len(p.consumers))
+	for _, c := range p.consumers {
+		consumers = append(consumers, c)
+	}
+	return consumers
+}
+
+func (p *DataProducer) addConsumer(c *DataConsumer) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return ErrDataProducerNotExist
+	}
+	if _, ok := p.consumers[c.id]; ok {
+		return ErrDataConsumerExist
+	}
+	p.consumers[c.id] = c
+	return nil
+}
+
+func (p *DataProducer) removeConsumer(id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.consumers, id)
+}
+
+// Close closes all consumers and detaches from the connection, the data channel is left to the connection.
+func (p *DataProducer) Close() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	p.mutex.Unlock()
+	p.channel.OnMessage(nil)
+	p.channel.OnClose(nil)
+	for _, c := range p.Consumers() {
+		c.Close()
+	}
+	if p.onClose != nil {
+		p.onClose()
+	}
+}
+
+// DataConsumer is the Sender of data channel, it owns a channel created with the same options as producer.
+type DataConsumer struct {
+	id                string
+	producer          *DataProducer
+	channel           *DataChannel
+	maxBufferedAmount uint64
+	onClose           func()
+	closeOnce         sync.Once
+
+	messagesSent    int64
+	messagesDropped int64
+}
+
+func newDataConsumer(option *DataConsumerOption, producer *DataProducer, channel *DataChannel) *DataConsumer {
+	c := &DataConsumer{
+		id:                option.ID,
+		producer:          producer,
+		channel:           channel,
+		maxBufferedAmount: option.MaxBufferedAmount,
+	}
+	if c.maxBufferedAmount == 0 {
+		c.maxBufferedAmount = DefaultDataConsumerMaxBufferedAmount
+	}
+	return c
+}
+
+func (c *DataConsumer) ID() string {
+	return c.id
+}
+
+func (c *DataConsumer) DataProducerID() string {
+	return c.producer.ID()
+}
+
+func (c *DataConsumer) DataChannel() *DataChannel {
+	return c.channel
+}
+
+func (c *DataConsumer) MessagesSent() int64 {
+	return atomic.LoadInt64(&c.messagesSent)
+}
+
+// MessagesDropped counts the messages dropped by full sctp buffer, or channel not open yet.
+func (c *DataConsumer) MessagesDropped() int64 {
+	return atomic.LoadInt64(&c.messagesDropped)
+}
+
+func (c *DataConsumer) send(msg DataChannelMessage) {
+	if c.channel.BufferedAmount() > c.maxBufferedAmount {
+		atomic.AddInt64(&c.messagesDropped, 1)
+		return
+	}
+	if err := c.channel.send(msg.Data, msg.IsString); err != nil {
+		atomic.AddInt64(&c.messagesDropped, 1)
+		return
+	}
+	atomic.AddInt64(&c.messagesSent, 1)
+}
+
+// Close closes the data channel and detaches from producer.
+func (c *DataConsumer) Close() {
+	c.closeOnce.Do(func() {
+		c.producer.removeConsumer(c.id)
+		c.channel.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package peer
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDataProducer(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "relay",
+			description: "messages from publisher reach subscriber with the same options",
+			method: func(t *testing.T) {
+				listener := &MockConnectionListener{conns: map[string]*Connection{}}
+				publisher, pubConn, connectPub := newTestSctpConnectionsWith(t, listener, "publisher", "pub-remote")
+				subscriber, subConn, connectSub := newTestSctpConnectionsWith(t, listener, "subscriber", "sub-remote")
+				connectPub()
+				connectSub()
+
+				accepted := make(chan *DataChannel, 1)
+				pubConn.OnDataChannel(func(d *DataChannel) { accepted <- d })
+				retransmits := uint16(3)
+				source, err := publisher.CreateDataChannel(&DataChannelOption{Label: "chat", Unordered: true, MaxRetransmits: &retransmits})
+				assert(t, err, nil)
+				var channel *DataChannel
+				select {
+				case channel = <-accepted:
+				case <-time.After(5 * time.Second):
+					t.Fatal("accept timeout")
+				}
+
+				_, err = pubConn.NewDataProducer(&DataProducerOption{})
+				assert(t, err, ErrDataChannelCantBeNil)
+				_, err = subConn.NewDataProducer(&DataProducerOption{DataChannel: channel})
+				assert(t, err, ErrDataChannelNotOwned)
+				producer, err := pubConn.NewDataProducer(&DataProducerOption{ID: "p", DataChannel: channel})
+				assert(t, err, nil)
+
+				received := make(chan *DataChannel, 1)
+				subscriber.OnDataChannel(func(d *DataChannel) { received <- d })
+				_, err = subConn.NewDataConsumer(&DataConsumerOption{ConnectionID: "pub-remote", DataProducerID: "x"})
+				if !errors.Is(err, ErrDataProducerNotExist) {
+					t.Fatal("producer should not exist:", err)
+				}
+				consumer, err := subConn.NewDataConsumer(&DataConsumerOption{ConnectionID: "pub-remote", DataProducerID: "p"})
+				assert(t, err, nil)
+				var sink *DataChannel
+				select {
+				case sink = <-received:
+				case <-time.After(5 * time.Second):
+					t.Fatal("accept timeout")
+				}
+				option := sink.Option()
+				assert(t, option.Label, "chat")
+				assert(t, option.Unordered, true)
+				assert(t, *option.MaxRetransmits, retransmits)
+
+				messages := make(chan DataChannelMessage, 1)
+				sink.OnMessage(func(msg DataChannelMessage) { messages <- msg })
+				assert(t, source.SendText("hi"), nil)
+				select {
+				case msg := <-messages:
+					assert(t, string(msg.Data), "hi")
+				case <-time.After(5 * time.Second):
+					t.Fatal("message timeout")
+				}
+				assert(t, producer.MessagesReceived(), int64(1))
+				assert(t, consumer.MessagesSent(), int64(1))
+
+				producer.Close()
+				assert(t, len(subConn.DataConsumers()), 0)
+				assert(t, pubConn.DataProducer("p") == nil, true)
+			},
+		},
+		{
+			name:        "remote close",
+			description: "the producer is closed with its consumers when the remote closes the channel",
+			method: func(t *testing.T) {
+				listener := &MockConnectionListener{conns: map[string]*Connection{}}
+				publisher, pubConn, connect := newTestSctpConnectionsWith(t, listener, "publisher", "pub-remote")
+				connect()
+				accepted := make(chan *DataChannel, 1)
+				pubConn.OnDataChannel(func(d *DataChannel) { accepted <- d })
+				source, err := publisher.CreateDataChannel(&DataChannelOption{Label: "chat"})
+				assert(t, err, nil)
+				var channel *DataChannel
+				select {
+				case channel = <-accepted:
+				case <-time.After(5 * time.Second):
+					t.Fatal("accept timeout")
+				}
+				producer, err := pubConn.NewDataProducer(&DataProducerOption{ID: "p", DataChannel: channel})
+				assert(t, err, nil)
+				sub := newConnection("sub", "", &MockTransport{}, listener)
+				consumer, err := sub.NewDataConsumer(&DataConsumerOption{ConnectionID: "pub-remote", DataProducerID: producer.ID()})
+				assert(t, err, nil)
+
+				source.Close()
+				deadline := time.Now().Add(5 * time.Second)
+				for pubConn.DataProducer("p") != nil {
+					if time.Now().After(deadline) {
+						t.Fatal("producer not closed")
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				assert(t, len(producer.Consumers()), 0)
+				assert(t, sub.DataConsumers(), []*DataConsumer{})
+				assert(t, consumer.DataProducerID(), "p")
+			},
+		},
+		{
+			name:        "drop when not open",
+			description: "a subscriber not ready drops message instead of blocking",
+			method: func(t *testing.T) {
+				listener := &MockConnectionListener{conns: map[string]*Connection{}}
+				a, _, connect := newTestSctpConnectionsWith(t, listener, "a", "b")
+				connect()
+				channel, err := a.CreateDataChannel(&DataChannelOption{Label: "source"})
+				assert(t, err, nil)
+				producer, err := a.NewDataProducer(&DataProducerOption{DataChannel: channel})
+				assert(t, err, nil)
+				// the subscriber without sctp, the channel never open.
+				sub := newConnection("sub", "", &MockTransport{}, listener)
+				consumer, err := sub.NewDataConsumer(&DataConsumerOption{ConnectionID: "a", DataProducerID: producer.ID()})
+				assert(t, err, nil)
+				producer.Send(DataChannelMessage{Data: []byte{1}})
+				assert(t, consumer.MessagesDropped(), int64(1))
+				assert(t, consumer.MessagesSent(), int64(0))
+			},
+		},
+		{
+			name:        "consumer id",
+			description: "only one of the concurrent consumers of the same id is created, the option is not changed",
+			method: func(t *testing.T) {
+				listener := &MockConnectionListener{conns: map[string]*Connection{}}
+				a, _, connect := newTestSctpConnectionsWith(t, listener, "a", "b")
+				connect()
+				channel, err := a.CreateDataChannel(&DataChannelOption{Label: "source"})
+				assert(t, err, nil)
+				producer, err := a.NewDataProducer(&DataProducerOption{DataChannel: channel})
+				assert(t, err, nil)
+				sub := newConnection("sub", "", &MockTransport{}, listener)
+				option := &DataConsumerOption{ConnectionID: "a", DataProducerID: producer.ID()}
+				consumer, err := sub.NewDataConsumer(option)
+				assert(t, err, nil)
+				assert(t, option.ID, "")
+				assert(t, len(consumer.ID()), 12)
+
+				results := make(chan error, 8)
+				for i := 0; i < cap(results); i++ {
+					go func() {
+						_, err := sub.NewDataConsumer(&DataConsumerOption{ID: "c", ConnectionID: "a", DataProducerID: producer.ID()})
+						results <- err
+					}()
+				}
+				created := 0
+				for i := 0; i < cap(results); i++ {
+					if err := <-results; err == nil {
+						created++
+					} else {
+						assert(t, errors.Is(err, ErrDataConsumerExist), true)
+					}
+				}
+				assert(t, created, 1)
+				assert(t, len(sub.DataConsumers()), 2)
+				assert(t, len(producer.Consumers()), 2)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package peer
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+var ErrDirectTransportClosed = errors.New("direct transport closed")
+
+var _ Transport = new(DirectTransport)
+
+type DirectOption struct {
+	ID      string
+	BweType string
+}
+
+// DirectTransport exchanges rtp with go code in the same process, no socket at all.
+// The packets sent by the connection go to the callbacks or the channels, and Write injects packets
+// to the connection, it's safe to write from any goroutine.
+type DirectTransport struct {
+	connection *Connection
+
+	mutex   sync.RWMutex
+	closed  bool
+	onRTP   func(*rtp.Packet)
+	onRtcp  func(rtcp.Packet)
+	rtpCh   chan *rtp.Packet
+	rtcpCh  chan rtcp.Packet
+	dropped atomic.Int64
+}
+
+func NewDirectTransport() *DirectTransport {
+	return &DirectTransport{}
+}
+
+// SetConnection connects immediately, there is nothing to wait.
+func (t *DirectTransport) SetConnection(connection *Connection) {
+	t.connection = connection
+	connection.Connected()
+}
+
+func (t *DirectTransport) IsConnected() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return !t.closed
+}
+
+// OnRTP is called with a copy of the packet, it could be kept or sent to a channel.
+// It's called in the goroutine of the receiver, so it should not block.
+func (t *DirectTransport) OnRTP(callback func(*rtp.Packet)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onRTP = callback
+}
+
+// OnRtcp is called with the rtcp the connection sends, like receiver reports and keyframe requests.
+func (t *DirectTransport) OnRtcp(callback func(rtcp.Packet)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onRtcp = callback
+}
+
+// RTPChannel returns a channel of size receives the rtp instead of the callback, it's closed when the
+// transport closed. The packet is dropped if the channel is full, the sender must not be blocked.
+// It returns the same channel if called again.
+func (t *DirectTransport) RTPChannel(size int) <-chan *rtp.Packet {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.rtpCh == nil {
+		t.rtpCh = make(chan *rtp.Packet, size)
+		if t.closed {
+			close(t.rtpCh)
+		}
+	}
+	return t.rtpCh
+}
+
+// RtcpChannel is RTPChannel of rtcp.
+func (t *DirectTransport) RtcpChannel(size int) <-chan rtcp.Packet {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.rtcpCh == nil {
+		t.rtcpCh = make(chan rtcp.Packet, size)
+		if t.closed {
+			close(t.rtcpCh)
+		}
+	}
+	return t.rtcpCh
+}
+
+// Dropped returns the count of packets dropped since the channels were full.
+func (t *DirectTransport) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+// WriteRTP injects a rtp packet as it's received from remote.
+func (t *DirectTransport) WriteRTP(packet *rtp.Packet) error {
+	if !t.IsConnected() {
+		return ErrDirectTransportClosed
+	}
+	raw, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+	p := new(rtpPacket)
+	if err = p.Parse(raw); err != nil {
+		return err
+	}
+	t.connection.receiveRTPPacket(p)
+	return nil
+}
+
+// WriteRtcp injects rtcp packets as they're received from remote.
+func (t *DirectTransport) WriteRtcp(packets ...rtcp.Packet) error {
+	if !t.IsConnected() {
+		return ErrDirectTransportClosed
+	}
+	t.connection.receiveRtcpPacket(packets)
+	return nil
+}
+
+func (t *DirectTransport) SendRTPPacket(packet rtc.Packet) {
+	t.mutex.RLock()
+	callback, closed, hasCh := t.onRTP, t.closed, t.rtpCh != nil
+	t.mutex.RUnlock()
+	if (callback == nil && !hasCh) || closed {
+		return
+	}
+	// the sender restores the packet after sent, so the callback gets a copy.
+	raw, err := packet.Marshal()
+	if err != nil {
+		logger.Debug("marshal rtp fail:", err)
+		return
+	}
+	p := new(rtp.Packet)
+	if err = p.Unmarshal(raw); err != nil {
+		return
+	}
+	if callback != nil {
+		callback(p)
+	}
+	if hasCh {
+		// the lock makes sure the channel is not closed while sending.
+		t.mutex.RLock()
+		if !t.closed {
+			select {
+			case t.rtpCh <- p:
+			default:
+				t.dropped.Add(1)
+			}
+		}
+		t.mutex.RUnlock()
+	}
+}
+
+func (t *DirectTransport) SendRtcpPacket(packet rtcp.Packet) {
+	t.mutex.RLock()
+	callback, closed := t.onRtcp, t.closed
+	if !closed && t.rtcpCh != nil {
+		select {
+		case t.rtcpCh <- packet:
+		default:
+			t.dropped.Add(1)
+		}
+	}
+	t.mutex.RUnlock()
+	if callback == nil || closed {
+		return
+	}
+	callback(packet)
+}
+
+func (t *DirectTransport) Info() TransportInfo {
+	return TransportInfo{ID: t.connection.ID()}
+}
+
+func (t *DirectTransport) Close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if t.rtpCh != nil {
+		close(t.rtpCh)
+	}
+	if t.rtcpCh != nil {
+		close(t.rtcpCh)
+	}
+}
@@ -0,0 +1,139 @@
+package peer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+func TestDirectTransport(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "publish and consume",
+			description: "the injected rtp goes through receiver and sender to the callback",
+			method: func(t *testing.T) {
+				listener := &MockConnectionListener{conns: map[string]*Connection{}}
+				publisher, subscriber := NewDirectTransport(), NewDirectTransport()
+				pub := newConnection("pub", "", publisher, listener)
+				sub := newConnection("sub", "", subscriber, listener)
+				listener.conns["pub"], listener.conns["sub"] = pub, sub
+				_, err := pub.NewReceiver(&ReceiverOption{
+					ID:        "audio",
+					MID:       "0",
+					MediaType: rtc.MediaTypeAudio,
+					Codec:     &Codec{PayloadType: 111, EncoderName: "opus", ClockRate: 48000, Channels: 2},
+					Streams:   []StreamOption{{SSRC: 1234, PayloadType: 111}},
+				})
+				assert(t, err, nil)
+
+				packets := make(chan *rtp.Packet, 1)
+				subscriber.OnRTP(func(p *rtp.Packet) {
+					packets <- p
+				})
+				sender, err := sub.NewSender(&SenderOption{ConnectionID: "pub", ReceiverID: "audio"})
+				assert(t, err, nil)
+
+				assert(t, publisher.WriteRTP(&rtp.Packet{
+					Header:  rtp.Header{Version: 2, PayloadType: 111, SSRC: 1234, SequenceNumber: 1},
+					Payload: []byte{1, 2, 3},
+				}), nil)
+				select {
+				case p := <-packets:
+					assert(t, p.SSRC, sender.Stream().SSRC)
+					assert(t, p.Payload, []byte{1, 2, 3})
+				case <-time.After(time.Second):
+					t.Fatal("packet timeout")
+				}
+			},
+		},
+		{
+			name:        "rtcp",
+			description: "the rtcp sent by connection goes to the callback, and write fails after closed",
+			method: func(t *testing.T) {
+				transport := NewDirectTransport()
+				conn := newConnection("direct", "", transport, &MockConnectionListener{conns: map[string]*Connection{}})
+				reports := make(chan rtcp.Packet, 1)
+				transport.OnRtcp(func(p rtcp.Packet) {
+					reports <- p
+				})
+				conn.sendRtcpPacket(&rtcp.PictureLossIndication{MediaSSRC: 1})
+				assert(t, (<-reports).DestinationSSRC()[0], uint32(1))
+				assert(t, transport.WriteRtcp(&rtcp.ReceiverReport{}), nil)
+
+				transport.Close()
+				assert(t, transport.IsConnected(), false)
+				if err := transport.WriteRTP(&rtp.Packet{}); !errors.Is(err, ErrDirectTransportClosed) {
+					t.Fatal("should be closed:", err)
+				}
+			},
+		},
+		{
+			name:        "channel",
+			description: "the packets go to the channel, the writes of goroutines are serialized, and the channel closed after closed",
+			method: func(t *testing.T) {
+				listener := &MockConnectionListener{conns: map[string]*Connection{}}
+				publisher, subscriber := NewDirectTransport(), NewDirectTransport()
+				pub := newConnection("pub", "", publisher, listener)
+				sub := newConnection("sub", "", subscriber, listener)
+				listener.conns["pub"], listener.conns["sub"] = pub, sub
+				_, err := pub.NewReceiver(&ReceiverOption{
+					ID:        "video",
+					MID:       "0",
+					MediaType: rtc.MediaTypeVideo,
+					Codec:     &Codec{PayloadType: 96, EncoderName: "vp8", ClockRate: 90000},
+					Streams:   []StreamOption{{SSRC: 1234, PayloadType: 96}},
+				})
+				assert(t, err, nil)
+				packets := subscriber.RTPChannel(100)
+				assert(t, subscriber.RTPChannel(1) == packets, true)
+				reports := publisher.RtcpChannel(100)
+				sender, err := sub.NewSender(&SenderOption{ConnectionID: "pub", ReceiverID: "video"})
+				assert(t, err, nil)
+
+				var wg sync.WaitGroup
+				for i := 0; i < 4; i++ {
+					wg.Add(2)
+					go func(i int) {
+						defer wg.Done()
+						for seq := 0; seq < 10; seq++ {
+							_ = publisher.WriteRTP(&rtp.Packet{
+								// vp8 key frame.
+								Header:  rtp.Header{Version: 2, PayloadType: 96, SSRC: 1234, SequenceNumber: uint16(i*10 + seq)},
+								Payload: []byte{0x10, 0, 0x9d, 0x01, 0x2a},
+							})
+						}
+					}(i)
+					go func() {
+						defer wg.Done()
+						_ = publisher.WriteRtcp(&rtcp.SenderReport{SSRC: 1234, NTPTime: 1})
+					}()
+				}
+				wg.Wait()
+				select {
+				case p := <-packets:
+					assert(t, p.SSRC, sender.Stream().SSRC)
+				case <-time.After(time.Second):
+					t.Fatal("packet timeout")
+				}
+				pub.sendRtcpPacket(&rtcp.PictureLossIndication{MediaSSRC: 1234})
+				assert(t, (<-reports).DestinationSSRC()[0], uint32(1234))
+
+				subscriber.Close()
+				for range packets {
+				}
+				pub.Close()
+				sub.Close()
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -20,4 +20,18 @@ var (
 	ErrCodecCantBeNil     = errors.New("codec cant be nil")
 	ErrStreamCantBeEmpty  = errors.New("streams cant be empty")
 	ErrConnExist          = errors.New("connection already exists")
+	ErrConnNotExist       = errors.New("connection not exist")
+
+	ErrCodecNotSupported           = errors.New("codec not supported")
+	ErrHeaderExtensionNotSupported = errors.New("header extension not supported")
+
+	ErrIceRestartNotSupported = errors.New("ice restart not supported by transport")
+	ErrSetRemoteNotSupported  = errors.New("set remote not supported by transport")
+	ErrCryptexNotSupported    = errors.New("cryptex not supported by transport")
+
+	ErrDataProducerExist    = errors.New("data producer already exist")
+	ErrDataProducerNotExist = errors.New("data producer not exist")
+	ErrDataConsumerExist    = errors.New("data consumer already exist")
+	ErrDataChannelCantBeNil = errors.New("data channel cant be nil")
+	ErrDataChannelNotOwned  = errors.New("data channel not belongs to connection")
 )
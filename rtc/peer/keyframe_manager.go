@@ -41,14 +41,22 @@ type keyframeManager struct {
 
 func (k *keyframeManager) keyFrameNeeded(ssrc uint32) {
 	if k.delay != 0 {
-		k.ssrcDelayCh <- ssrc
+		k.send(k.ssrcDelayCh, ssrc)
 	} else {
-		k.ssrcNeedCh <- ssrc
+		k.send(k.ssrcNeedCh, ssrc)
 	}
 }
 
 func (k *keyframeManager) keyFrameReceived(ssrc uint32) {
-	k.ssrcReceived <- ssrc
+	k.send(k.ssrcReceived, ssrc)
+}
+
+// send drops the ssrc once closed, the senders of other connections may still request.
+func (k *keyframeManager) send(ch chan uint32, ssrc uint32) {
+	select {
+	case ch <- ssrc:
+	case <-k.closeCh:
+	}
 }
 
 func (k *keyframeManager) close() {
@@ -71,7 +79,9 @@ func (k *keyframeManager) loop() {
 				info.Retry()
 				continue
 			}
-			k.pendingKeyframe[ssrc] = newPending(ssrc, k.callback, k.ssrcTimeout)
+			k.pendingKeyframe[ssrc] = newPending(ssrc, k.callback, func(ssrc uint32) {
+				k.send(k.ssrcTimeout, ssrc)
+			})
 			k.callback(ssrc)
 		case ssrc := <-k.ssrcDelayCh:
 			// we already have one, skip.
@@ -82,7 +92,7 @@ func (k *keyframeManager) loop() {
 				continue
 			}
 			k.delayKeyframe[ssrc] = newDelay(k.delay, ssrc, func(ssrc uint32) {
-				k.ssrcNeedCh <- ssrc
+				k.send(k.ssrcNeedCh, ssrc)
 			})
 		case ssrc := <-k.ssrcReceived:
 			if info, ok := k.delayKeyframe[ssrc]; ok {
@@ -106,7 +116,7 @@ func (k *keyframeManager) loop() {
 	}
 }
 
-func newPending(ssrc uint32, needed func(ssrc uint32), timeoutCh chan uint32) *pendingInfo {
+func newPending(ssrc uint32, needed func(ssrc uint32), timeout func(ssrc uint32)) *pendingInfo {
 	//  default retry once, but if we have more request, we will retry more
 	info := pendingInfo{retry: 1, stopCh: make(chan struct{})}
 	go func() {
@@ -114,7 +124,7 @@ func newPending(ssrc uint32, needed func(ssrc uint32), timeoutCh chan uint32) *p
 		for {
 			retry := atomic.AddInt32(&info.retry, -1)
 			if retry < 0 {
-				timeoutCh <- ssrc
+				timeout(ssrc)
 				return
 			}
 			select {
@@ -0,0 +1,307 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/dtls"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+var (
+	_ Transport     = new(PipeTransport)
+	_ senderCreator = new(PipeTransport)
+	_ Sender        = new(PipeSender)
+)
+
+// PipeOption is the option of PipeTransport, both nodes create one and connect to each other.
+type PipeOption struct {
+	ID         string
+	ListenIP   string // default 0.0.0.0
+	Port       int    // zero means random
+	RemoteIP   string // could be given by Connect later
+	RemotePort int
+	// Srtp encrypts the pipe if the nodes are not in a trusted network.
+	Srtp *dtls.SdesOption
+}
+
+// PipeTransport connects two brokers for cascading, it's a plain transport with rtcp mux.
+// The senders created on a pipe connection are PipeSender, they keep the ssrcs of the receiver.
+type PipeTransport struct {
+	*PlainTransport
+}
+
+func NewPipeTransport(option *PipeOption) (*PipeTransport, error) {
+	t, err := NewPlainTransport(&PlainOption{
+		ID:       option.ID,
+		ListenIP: option.ListenIP,
+		Port:     option.Port,
+		RtcpMux:  true,
+		Srtp:     option.Srtp,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PipeTransport{PlainTransport: t}, nil
+}
+
+// Connect sets the address of the remote pipe transport.
+func (t *PipeTransport) Connect(ip string, port int) error {
+	return t.PlainTransport.Connect(ip, port, 0)
+}
+
+// newSender creates the PipeSender, so the receivers are sent with their ssrcs.
+func (t *PipeTransport) newSender(options *SenderOption, listener ConsumerListener, receiver *Receiver, stats *Stats) (Sender, error) {
+	return newPipeSender(options, listener, receiver, stats)
+}
+
+// ssrcKeyframeRequester is implemented by the senders forwarding more than one ssrc.
+type ssrcKeyframeRequester interface {
+	requestKeyframeBySSRC(ssrc uint32)
+}
+
+// PipeSender forwards all the streams of a receiver to another broker as they are,
+// all simulcast layers with the same ssrcs, the remote recreates the receiver by ReceiverOption.
+// It answers the nack by its own buffer, and passes the keyframe request to the receiver.
+type PipeSender struct {
+	*sender
+
+	// mutex guards the streams, the packets and the rtcp come from different goroutines.
+	mutex   sync.Mutex
+	streams map[uint32]SenderStream // by media ssrc
+	reports []rtcp.Packet           // sender reports of receiver, wait to be forwarded
+}
+
+func newPipeSender(options *SenderOption, listener ConsumerListener, receiver *Receiver, stats *Stats) (*PipeSender, error) {
+	s := &PipeSender{
+		sender: &sender{
+			id:         options.ID,
+			mid:        options.MID,
+			listener:   listener,
+			receiverID: receiver.ID(),
+			receiver:   receiver,
+			mediaType:  receiver.MediaType(),
+			headerMap:  make(map[rtc.HeaderExtensionID]rtc.HeaderExtensionID),
+			stats:      stats,
+		},
+		streams: map[uint32]SenderStream{},
+	}
+	headers := receiver.HeaderExtensions()
+	s.rtpHeaderExtensionIds = listener.getHeaderExtensions(headers)
+	for _, h := range headers {
+		if h.Encrypt && !s.rtpHeaderExtensionIds[h.URI].Encrypt {
+			continue
+		}
+		s.headerMap[h.ID] = s.rtpHeaderExtensionIds[h.URI].ID
+	}
+	// copy it, the payload type may be changed by the pipe connection.
+	codec := *receiver.Codec()
+	s.codec = listener.getCodec(&codec)
+	s.producerRTPStreams = receiver.GetRTPStreams()
+	first := s.producerRTPStreams[0]
+	s.stream = &StreamOption{SSRC: first.SSRC(), RTX: first.RtxSSRC(), Cname: first.Cname(), PayloadType: s.codec.PayloadType}
+	if s.mediaType == rtc.MediaTypeAudio {
+		s.maxRtcpInterval = rtc.MaxRTCPAudioInterval
+	} else {
+		s.maxRtcpInterval = rtc.MaxRTCPVideoInterval
+	}
+	receiver.AddSender(s)
+	return s, nil
+}
+
+// ReceiverOption is what the remote broker needs to create the receiver on its pipe connection.
+func (s *PipeSender) ReceiverOption() *ReceiverOption {
+	codec := *s.codec
+	option := &ReceiverOption{
+		ID:               s.receiver.ID(),
+		MID:              s.receiver.MID(),
+		MediaType:        s.mediaType,
+		Codec:            &codec,
+		HeaderExtensions: s.HeaderExtensions(),
+	}
+	for _, stream := range s.producerRTPStreams {
+		option.Streams = append(option.Streams, StreamOption{
+			SSRC:        stream.SSRC(),
+			RTX:         stream.RtxSSRC(),
+			RID:         stream.RID(),
+			Cname:       stream.Cname(),
+			PayloadType: codec.PayloadType,
+		})
+	}
+	return option
+}
+
+func (s *PipeSender) Kind() string {
+	return RTPTypePipe
+}
+
+// getStream returns the sender stream of the ssrc, it's created on first packet since the rid based
+// stream doesn't know its ssrc before. It's called with the lock.
+func (s *PipeSender) getStream(ssrc uint32) SenderStream {
+	if stream, ok := s.streams[ssrc]; ok {
+		return stream
+	}
+	for _, p := range s.producerRTPStreams {
+		if p.SSRC() != ssrc {
+			continue
+		}
+		stream := NewSenderStream(s, s.mediaType, StreamOption{
+			SSRC:        ssrc,
+			RTX:         p.RtxSSRC(),
+			Cname:       p.Cname(),
+			PayloadType: s.codec.PayloadType,
+		}, *s.codec)
+		s.streams[ssrc] = stream
+		s.listener.mapSenderSSRC(ssrc, p.RtxSSRC(), s)
+		return stream
+	}
+	return nil
+}
+
+func (s *PipeSender) SendRTPPacket(packet rtc.Packet) {
+	if !s.IsActive() {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stream := s.getStream(packet.SSRC())
+	if stream == nil {
+		return
+	}
+	originPayloadType := packet.PayloadType()
+	originHeader := packet.HeaderExtensions()
+	newHeaders := []rtp.Extension{}
+	for _, e := range originHeader {
+		id, ok := s.headerMap[rtc.HeaderExtensionID(e.ID)]
+		if !ok {
+			continue
+		}
+		newHeaders = append(newHeaders, rtp.Extension{
+			ID:      uint8(id),
+			Payload: e.Payload,
+		})
+	}
+	packet.SetPayloadType(s.codec.PayloadType)
+	packet.UpdateHeader(newHeaders)
+	// the packet will be reused by transport, so the nack buffer keeps a copy.
+	if err := stream.ReceivePacket(clonePacket(packet)); err == nil {
+		s.listener.sendRTPPacket(packet)
+	}
+	packet.SetPayloadType(originPayloadType)
+	packet.UpdateHeader(originHeader)
+}
+
+func (s *PipeSender) ReceiveNack(report *rtcp.TransportLayerNack) {
+	if !s.IsActive() {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stream := s.streams[report.MediaSSRC]; stream != nil {
+		stream.ReceiveNack(report)
+	}
+}
+
+// OnRTPStreamRetransmitRTPPacket unwraps the rtx after sent, the buffered packet may be nacked again.
+func (s *PipeSender) OnRTPStreamRetransmitRTPPacket(packet rtc.Packet) {
+	s.listener.sendRTPPacket(packet)
+	if !packet.IsRTX() {
+		return
+	}
+	for _, p := range s.producerRTPStreams {
+		if p.RtxSSRC() != 0 && p.RtxSSRC() == packet.SSRC() {
+			_ = packet.RtxDecode(s.codec.PayloadType, p.SSRC())
+			packet.SetRTX(false)
+			return
+		}
+	}
+}
+
+func (s *PipeSender) ReceiveRtcpReceiverReport(report rtcp.ReceptionReport) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stream := s.streams[report.SSRC]; stream != nil {
+		stream.ReceiveRtcpReceiverReport(report)
+	}
+}
+
+// ProducerRtcpSenderReport forwards the sender report, the remote needs it for simulcast and lip sync.
+func (s *PipeSender) ProducerRtcpSenderReport(stream ReceiverStream, _ bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sent := s.streams[stream.SSRC()]
+	if sent == nil {
+		return
+	}
+	report := &rtcp.SenderReport{
+		SSRC:        stream.SSRC(),
+		NTPTime:     stream.GetSenderReportNtpMs(),
+		RTPTime:     uint32(stream.GetSenderReportTS()),
+		PacketCount: uint32(sent.Stats().PacketsSent()),
+		OctetCount:  uint32(sent.Stats().BytesSent()),
+	}
+	s.reports = append(s.reports, report)
+}
+
+func (s *PipeSender) GetRtcp(_ int64) rtcp.Packet {
+	s.mutex.Lock()
+	reports := s.reports
+	s.reports = nil
+	var sdes rtcp.Packet
+	if len(reports) != 0 {
+		sdes = s.streams[reports[0].(*rtcp.SenderReport).SSRC].GetRtcpSdesChunk()
+	}
+	s.mutex.Unlock()
+	if len(reports) == 0 {
+		return nil
+	}
+	packet := rtcp.CompoundPacket(append(reports, sdes))
+	return &packet
+}
+
+func (s *PipeSender) FractionLost() uint8 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var lost uint8
+	for _, stream := range s.streams {
+		if l := stream.FractionLost(); l > lost {
+			lost = l
+		}
+	}
+	return lost
+}
+
+func (s *PipeSender) GetBitrate(_ int) int64 {
+	var bitrate int64
+	now := time.Now().UnixMilli()
+	for _, stream := range s.producerRTPStreams {
+		bitrate += stream.Stats().ReceiveBPS(now)
+	}
+	return bitrate
+}
+
+func (s *PipeSender) TransportConnected() {
+	s.transportConnected.Store(true)
+	s.RequestKeyframe()
+}
+
+// RequestKeyframe requests all layers, the remote may forward any of them.
+func (s *PipeSender) RequestKeyframe() {
+	if !s.IsActive() || s.mediaType != rtc.MediaTypeVideo {
+		return
+	}
+	for _, stream := range s.producerRTPStreams {
+		if stream.SSRC() != 0 {
+			s.receiver.RequestKeyFrame(stream.SSRC())
+		}
+	}
+}
+
+func (s *PipeSender) requestKeyframeBySSRC(ssrc uint32) {
+	if !s.IsActive() || s.mediaType != rtc.MediaTypeVideo {
+		return
+	}
+	s.receiver.RequestKeyFrame(ssrc)
+}
@@ -0,0 +1,129 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// mockRecordTransport is a connected transport keeps what it sends.
+type mockRecordTransport struct {
+	MockTransport
+	rtcp chan rtcp.Packet
+}
+
+func (t *mockRecordTransport) IsConnected() bool {
+	return true
+}
+
+func (t *mockRecordTransport) SendRtcpPacket(packet rtcp.Packet) {
+	select {
+	case t.rtcp <- packet:
+	default:
+	}
+}
+
+func (t *mockRecordTransport) Close() {}
+
+func newTestRTPPacket(t *testing.T, ssrc uint32, seq uint16, payload []byte) rtc.Packet {
+	raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SSRC: ssrc, SequenceNumber: seq}, Payload: payload}).Marshal()
+	assert(t, err, nil)
+	p := new(rtpPacket)
+	assert(t, p.Parse(raw), nil)
+	return p
+}
+
+func readTestRTP(t *testing.T, conn *net.UDPConn) *rtp.Packet {
+	buf := make([]byte, 1500)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	assert(t, err, nil)
+	p := new(rtp.Packet)
+	assert(t, p.Unmarshal(buf[:n]), nil)
+	return p
+}
+
+func TestPipeTransport(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "pipe sender",
+			description: "the remote gets the same ssrcs, and nack and pli are answered upstream",
+			method: func(t *testing.T) {
+				listener := &MockConnectionListener{conns: map[string]*Connection{}}
+				publisher := &mockRecordTransport{rtcp: make(chan rtcp.Packet, 10)}
+				pub := newConnection("pub", "", publisher, listener)
+				listener.conns["pub"] = pub
+				_, err := pub.NewReceiver(&ReceiverOption{
+					ID:        "video",
+					MID:       "0",
+					MediaType: rtc.MediaTypeVideo,
+					Codec: &Codec{
+						PayloadType: 96, EncoderName: "VP8", ClockRate: 90000, RTX: 97,
+						FeedbackParams: []RtcpFeedback{{Type: "nack"}, {Type: "nack", Parameter: "pli"}},
+					},
+					Streams: []StreamOption{{SSRC: 1111, RTX: 1112, PayloadType: 96}, {SSRC: 2222, RTX: 2223, PayloadType: 96}},
+				})
+				assert(t, err, nil)
+
+				transport, err := NewPipeTransport(&PipeOption{ListenIP: "127.0.0.1"})
+				assert(t, err, nil)
+				pipe := newConnection("pipe", "", transport, listener)
+				t.Cleanup(transport.Close)
+				remote := newTestUDPConn(t)
+				assert(t, transport.Connect("127.0.0.1", remote.LocalAddr().(*net.UDPAddr).Port), nil)
+				sender, err := pipe.NewSender(&SenderOption{ConnectionID: "pub", ReceiverID: "video"})
+				assert(t, err, nil)
+				assert(t, sender.Kind(), RTPTypePipe)
+
+				option := sender.(*PipeSender).ReceiverOption()
+				assert(t, option.Validate(), nil)
+				assert(t, len(option.Streams), 2)
+				assert(t, option.Streams[1].SSRC, uint32(2222))
+				assert(t, option.Streams[1].RTX, uint32(2223))
+
+				// a vp8 keyframe, so the receiver won't ask for one itself.
+				pub.receiveRTPPacket(newTestRTPPacket(t, 1111, 1, []byte{0x10, 0x00, 0x01, 0x02}))
+				pub.receiveRTPPacket(newTestRTPPacket(t, 1111, 2, []byte{0x10, 0x01, 0x01, 0x02}))
+				for seq := uint16(1); seq <= 2; seq++ {
+					p := readTestRTP(t, remote)
+					assert(t, p.SSRC, uint32(1111))
+					assert(t, p.SequenceNumber, seq)
+					assert(t, p.PayloadType, uint8(option.Codec.PayloadType))
+				}
+
+				port, _ := transport.LocalPort()
+				pipeAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+				nack, _ := (&rtcp.TransportLayerNack{MediaSSRC: 1111, Nacks: []rtcp.NackPair{{PacketID: 1}}}).Marshal()
+				// nack twice, the buffered packet must not be wrapped twice.
+				for i := 0; i < 2; i++ {
+					_, err = remote.WriteToUDP(nack, pipeAddr)
+					assert(t, err, nil)
+					p := readTestRTP(t, remote)
+					assert(t, p.SSRC, uint32(1112))
+					assert(t, p.PayloadType, uint8(option.Codec.RTX))
+					assert(t, p.Payload[:2], []byte{0, 1})
+					assert(t, len(p.Payload), 6)
+				}
+
+				pli, _ := (&rtcp.PictureLossIndication{MediaSSRC: 1111}).Marshal()
+				_, err = remote.WriteToUDP(pli, pipeAddr)
+				assert(t, err, nil)
+				select {
+				case p := <-publisher.rtcp:
+					assert(t, p.DestinationSSRC()[0], uint32(1111))
+				case <-time.After(5 * time.Second):
+					t.Fatal("keyframe request timeout")
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
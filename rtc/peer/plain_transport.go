@@ -0,0 +1,381 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/dtls"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/pion/rtcp"
+)
+
+var (
+	ErrPlainTransportConnected = errors.New("plain transport already connected")
+	ErrPlainTransportComedia   = errors.New("plain transport in comedia mode learns remote by itself")
+	ErrPlainTransportClosed    = errors.New("plain transport closed")
+)
+
+var _ Transport = new(PlainTransport)
+
+// PlainOption is the option of PlainTransport, the remote could be given here or by Connect later.
+type PlainOption struct {
+	ID       string
+	ListenIP string // default 0.0.0.0
+	Port     int    // zero means random
+	// RtcpMux sends rtcp with rtp on the same port, otherwise rtcp uses RtcpPort.
+	RtcpMux  bool
+	RtcpPort int // zero means random, ignored with rtcp mux
+	// Comedia learns the remote address from the first received packet, e.g. ffmpeg publishing to us.
+	Comedia        bool
+	RemoteIP       string
+	RemotePort     int
+	RemoteRtcpPort int // ignored with rtcp mux
+	BweType        string
+	// Srtp encrypts the rtp and rtcp with the keys exchanged by a=crypto, nil means plain rtp.
+	Srtp *dtls.SdesOption
+	// Timeout disconnects the connection has receivers if nothing received in it, the remote is
+	// considered dead. Default is 30s, negative disables it.
+	Timeout time.Duration
+}
+
+const defaultPlainTimeout = 30 * time.Second
+
+// PlainTransport is a rtp transport over udp, no ice, no dtls.
+// It's used to ingest from or egress to tools like ffmpeg and gstreamer, or sip gateways with sdes-srtp.
+// Without rtcp mux the rtp and rtcp are read in two goroutines, the connection serializes them.
+type PlainTransport struct {
+	connection   *Connection
+	rtpConn      *net.UDPConn
+	rtcpConn     *net.UDPConn // nil with rtcp mux
+	comedia      bool
+	timeout      time.Duration
+	lastReceived atomic.Int64 // unix ms
+
+	mutex      sync.RWMutex
+	remote     *net.UDPAddr
+	remoteRtcp *net.UDPAddr
+	closed     bool
+
+	packet rtc.Packet
+	buffer rtc.CowBuffer
+
+	// srtpSession is nil without sdes, the recvMutex is for rtp and rtcp read in different goroutines.
+	srtpSession *dtls.SrtpSession
+	recvMutex   sync.Mutex
+	sendMutex   sync.Mutex
+	sendBuffer  []byte
+}
+
+func NewPlainTransport(option *PlainOption) (*PlainTransport, error) {
+	ip := net.ParseIP(option.ListenIP)
+	if option.ListenIP == "" {
+		ip = net.IPv4zero
+	}
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: option.Port})
+	if err != nil {
+		return nil, err
+	}
+	t := &PlainTransport{
+		rtpConn: rtpConn,
+		comedia: option.Comedia,
+		timeout: option.Timeout,
+		packet:  new(rtpPacket),
+		buffer:  make(rtc.CowBuffer, 1500),
+	}
+	if t.timeout == 0 {
+		t.timeout = defaultPlainTimeout
+	}
+	if option.Srtp != nil {
+		if t.srtpSession, err = dtls.NewSdesSrtpSession(option.Srtp); err != nil {
+			_ = rtpConn.Close()
+			return nil, err
+		}
+		t.sendBuffer = make([]byte, 1500)
+	}
+	if !option.RtcpMux {
+		t.rtcpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: option.RtcpPort})
+		if err != nil {
+			_ = rtpConn.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// SetConnection starts reading, packets before it are left in socket buffer.
+func (t *PlainTransport) SetConnection(connection *Connection) {
+	t.connection = connection
+	go t.readLoop(t.rtpConn, false)
+	if t.rtcpConn != nil {
+		go t.readLoop(t.rtcpConn, true)
+	}
+}
+
+// Connect sets the remote address, rtcpPort is ignored with rtcp mux.
+func (t *PlainTransport) Connect(ip string, port, rtcpPort int) error {
+	if t.comedia {
+		return ErrPlainTransportComedia
+	}
+	remote, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return ErrPlainTransportClosed
+	}
+	if t.remote != nil {
+		t.mutex.Unlock()
+		return ErrPlainTransportConnected
+	}
+	t.remote = remote
+	t.remoteRtcp = remote
+	if t.rtcpConn != nil {
+		t.remoteRtcp = &net.UDPAddr{IP: remote.IP, Port: rtcpPort, Zone: remote.Zone}
+	}
+	t.mutex.Unlock()
+	t.lastReceived.Store(time.Now().UnixMilli())
+	t.connection.Connected()
+	return nil
+}
+
+func (t *PlainTransport) IsConnected() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.remote != nil && !t.closed
+}
+
+// LocalPort returns the rtp port and rtcp port, they are the same with rtcp mux.
+func (t *PlainTransport) LocalPort() (int, int) {
+	rtpPort := t.rtpConn.LocalAddr().(*net.UDPAddr).Port
+	if t.rtcpConn == nil {
+		return rtpPort, rtpPort
+	}
+	return rtpPort, t.rtcpConn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// readLoop reads until closed, the rtp loop checks the timeout, the packets of both loops count.
+func (t *PlainTransport) readLoop(conn *net.UDPConn, isRtcp bool) {
+	buf := make([]byte, 1500)
+	for {
+		if !isRtcp && t.timeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(t.timeout))
+		}
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if !t.dead() {
+					continue
+				}
+				logger.Info("plain transport timeout:", t.connection.ID())
+			} else {
+				logger.Debug("plain transport read stop:", err)
+			}
+			t.disconnect()
+			return
+		}
+		if !t.accept(addr, isRtcp) {
+			continue
+		}
+		t.lastReceived.Store(time.Now().UnixMilli())
+		data := buf[:n]
+		if isRtcp || CheckPacket(data) == RTCP {
+			t.onRtcpDataReceived(data)
+		} else if CheckPacket(data) == RTP {
+			t.onRTPDataReceived(data)
+		}
+	}
+}
+
+// dead returns true if the connected remote sent nothing in the timeout but it's expected to send,
+// the connection without receivers only sends, e.g. egress to ffmpeg.
+func (t *PlainTransport) dead() bool {
+	if !t.IsConnected() || len(t.connection.Receivers()) == 0 {
+		return false
+	}
+	return time.Now().UnixMilli()-t.lastReceived.Load() >= t.timeout.Milliseconds()
+}
+
+// disconnect closes the sockets and notifies the connection like ice failed, once only,
+// nothing is notified if it's closed by Close or never connected.
+func (t *PlainTransport) disconnect() {
+	t.mutex.RLock()
+	connected := t.remote != nil
+	t.mutex.RUnlock()
+	if t.close() && connected {
+		t.connection.Disconnected()
+	}
+}
+
+// accept checks the source of packet, in comedia mode the first packet decides the remote.
+// The rtcp without rtcp mux is learned only from the ip of the rtp remote, after it's learned.
+func (t *PlainTransport) accept(addr *net.UDPAddr, isRtcp bool) bool {
+	t.mutex.RLock()
+	remote := t.remote
+	if isRtcp {
+		remote = t.remoteRtcp
+	}
+	t.mutex.RUnlock()
+	if remote != nil {
+		return remote.IP.Equal(addr.IP) && remote.Port == addr.Port
+	}
+	if !t.comedia {
+		return false
+	}
+	t.mutex.Lock()
+	if isRtcp {
+		if t.remoteRtcp == nil && t.remote != nil && t.remote.IP.Equal(addr.IP) {
+			t.remoteRtcp = addr
+		}
+		remote = t.remoteRtcp
+		t.mutex.Unlock()
+		return remote != nil && remote.IP.Equal(addr.IP) && remote.Port == addr.Port
+	}
+	if t.remote != nil {
+		t.mutex.Unlock()
+		return false
+	}
+	t.remote = addr
+	if t.rtcpConn == nil {
+		t.remoteRtcp = addr
+	}
+	t.mutex.Unlock()
+	t.connection.Connected()
+	return true
+}
+
+// it's only called in read loop goroutine, so the buffer and packet could be reused.
+func (t *PlainTransport) onRTPDataReceived(data []byte) {
+	d := t.buffer[:len(data)]
+	if t.srtpSession != nil {
+		var err error
+		t.recvMutex.Lock()
+		d, err = t.srtpSession.DecryptSrtp(t.buffer, data)
+		t.recvMutex.Unlock()
+		if err != nil {
+			onDecryptFail(t.connection, err)
+			return
+		}
+	} else {
+		copy(d, data)
+	}
+	if err := t.packet.Parse(d); err != nil {
+		logger.Debug("parse rtp fail:", err)
+		return
+	}
+	t.connection.receiveRTPPacket(t.packet)
+}
+
+// rtcp is parsed into new packets, so it could decrypt in place.
+func (t *PlainTransport) onRtcpDataReceived(data []byte) {
+	if t.srtpSession != nil {
+		var err error
+		t.recvMutex.Lock()
+		data, err = t.srtpSession.DecryptSrtcp(data[:0], data)
+		t.recvMutex.Unlock()
+		if err != nil {
+			onDecryptFail(t.connection, err)
+			return
+		}
+	}
+	p, err := rtcp.Unmarshal(data)
+	if err != nil {
+		logger.Debug("parse rtcp fail:", err)
+		return
+	}
+	t.connection.receiveRtcpPacket(p)
+}
+
+func (t *PlainTransport) SendRTPPacket(packet rtc.Packet) {
+	t.mutex.RLock()
+	remote := t.remote
+	t.mutex.RUnlock()
+	if remote == nil {
+		return
+	}
+	raw, err := packet.Marshal()
+	if err != nil {
+		logger.Debug("marshal rtp fail:", err)
+		return
+	}
+	t.write(t.rtpConn, raw, remote, false)
+}
+
+func (t *PlainTransport) SendRtcpPacket(packet rtcp.Packet) {
+	t.mutex.RLock()
+	remote := t.remoteRtcp
+	t.mutex.RUnlock()
+	if remote == nil {
+		return
+	}
+	raw, err := packet.Marshal()
+	if err != nil {
+		return
+	}
+	conn := t.rtpConn
+	if t.rtcpConn != nil {
+		conn = t.rtcpConn
+	}
+	t.write(conn, raw, remote, true)
+}
+
+// write encrypts with sdes if needed, the senders could call it in concurrent.
+func (t *PlainTransport) write(conn *net.UDPConn, raw []byte, remote *net.UDPAddr, isRtcp bool) {
+	if t.srtpSession == nil {
+		if _, err := conn.WriteToUDP(raw, remote); err != nil {
+			logger.Debug("write fail:", err)
+		}
+		return
+	}
+	t.sendMutex.Lock()
+	defer t.sendMutex.Unlock()
+	var data []byte
+	var err error
+	if isRtcp {
+		data, _, err = t.srtpSession.EncryptRtcp(t.sendBuffer, raw)
+	} else {
+		data, _, err = t.srtpSession.EncryptRtp(t.sendBuffer, raw)
+	}
+	if err != nil {
+		logger.Debug("encrypt fail:", err)
+		return
+	}
+	if _, err = conn.WriteToUDP(data, remote); err != nil {
+		logger.Debug("write fail:", err)
+	}
+}
+
+func (t *PlainTransport) Info() TransportInfo {
+	info := TransportInfo{ID: t.connection.ID()}
+	info.PlainInfo.IP = t.rtpConn.LocalAddr().(*net.UDPAddr).IP.String()
+	info.PlainInfo.Port, info.PlainInfo.RtcpPort = t.LocalPort()
+	info.PlainInfo.RtcpMux = t.rtcpConn == nil
+	return info
+}
+
+func (t *PlainTransport) Close() {
+	t.close()
+}
+
+// close returns false if it's already closed.
+func (t *PlainTransport) close() bool {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return false
+	}
+	t.closed = true
+	t.mutex.Unlock()
+	_ = t.rtpConn.Close()
+	if t.rtcpConn != nil {
+		_ = t.rtcpConn.Close()
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/dtls"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+func newTestPlainConnection(t *testing.T, option *PlainOption) (*Connection, *PlainTransport) {
+	option.ListenIP = "127.0.0.1"
+	transport, err := NewPlainTransport(option)
+	assert(t, err, nil)
+	connection := newConnection("plain", "", transport, &MockConnectionListener{conns: map[string]*Connection{}})
+	t.Cleanup(transport.Close)
+	return connection, transport
+}
+
+func newTestUDPConn(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert(t, err, nil)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func readTestRtcp(t *testing.T, conn *net.UDPConn) []rtcp.Packet {
+	buf := make([]byte, 1500)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	assert(t, err, nil)
+	packets, err := rtcp.Unmarshal(buf[:n])
+	assert(t, err, nil)
+	return packets
+}
+
+func TestPlainTransport(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "comedia",
+			description: "the remote is learned from the first packet, and rtcp goes back to it with rtcp mux",
+			method: func(t *testing.T) {
+				_, transport := newTestPlainConnection(t, &PlainOption{RtcpMux: true, Comedia: true})
+				assert(t, transport.IsConnected(), false)
+				if err := transport.Connect("127.0.0.1", 5000, 0); !errors.Is(err, ErrPlainTransportComedia) {
+					t.Fatal("comedia should not connect:", err)
+				}
+				port, rtcpPort := transport.LocalPort()
+				assert(t, port, rtcpPort)
+				client := newTestUDPConn(t)
+				raw, _ := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SSRC: 1234}, Payload: []byte{1}}).Marshal()
+				_, err := client.WriteToUDP(raw, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+				assert(t, err, nil)
+				for deadline := time.Now().Add(5 * time.Second); !transport.IsConnected(); time.Sleep(time.Millisecond) {
+					if time.Now().After(deadline) {
+						t.Fatal("connect timeout")
+					}
+				}
+
+				transport.SendRtcpPacket(&rtcp.PictureLossIndication{MediaSSRC: 1234})
+				packets := readTestRtcp(t, client)
+				assert(t, len(packets), 1)
+				assert(t, packets[0].DestinationSSRC()[0], uint32(1234))
+			},
+		},
+		{
+			name:        "comedia rtcp",
+			description: "without rtcp mux, the rtcp remote is learned only from the ip of the rtp remote",
+			method: func(t *testing.T) {
+				_, transport := newTestPlainConnection(t, &PlainOption{Comedia: true})
+				rtpAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6000}
+				rtcpAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6001}
+				assert(t, transport.accept(rtcpAddr, true), false)
+				assert(t, transport.accept(rtpAddr, false), true)
+				assert(t, transport.accept(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 6001}, true), false)
+				assert(t, transport.accept(rtcpAddr, true), true)
+				assert(t, transport.accept(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6002}, true), false)
+			},
+		},
+		{
+			name:        "explicit remote",
+			description: "connect to given remote, rtcp goes to the rtcp port without rtcp mux",
+			method: func(t *testing.T) {
+				connection, transport := newTestPlainConnection(t, &PlainOption{})
+				port, rtcpPort := transport.LocalPort()
+				if port == rtcpPort {
+					t.Fatal("rtcp should use another port")
+				}
+				info := transport.Info()
+				assert(t, info.PlainInfo.Port, port)
+				assert(t, info.PlainInfo.RtcpMux, false)
+				assert(t, connection.ID(), "plain")
+
+				rtpClient, rtcpClient := newTestUDPConn(t), newTestUDPConn(t)
+				assert(t, transport.Connect("127.0.0.1", rtpClient.LocalAddr().(*net.UDPAddr).Port, rtcpClient.LocalAddr().(*net.UDPAddr).Port), nil)
+				assert(t, transport.IsConnected(), true)
+				if err := transport.Connect("127.0.0.1", 5000, 5001); !errors.Is(err, ErrPlainTransportConnected) {
+					t.Fatal("should be connected:", err)
+				}
+				transport.SendRtcpPacket(&rtcp.PictureLossIndication{MediaSSRC: 4321})
+				packets := readTestRtcp(t, rtcpClient)
+				assert(t, packets[0].DestinationSSRC()[0], uint32(4321))
+				transport.Close()
+				assert(t, transport.IsConnected(), false)
+			},
+		},
+		{
+			name:        "sdes",
+			description: "rtp and rtcp are encrypted with the keys from a=crypto",
+			method: func(t *testing.T) {
+				localKey, _ := dtls.GenerateSdesKey(dtls.SuiteAES128CMHmacSha1_80)
+				remoteKey, _ := dtls.GenerateSdesKey(dtls.SuiteAES128CMHmacSha1_80)
+				_, transport := newTestPlainConnection(t, &PlainOption{RtcpMux: true, Comedia: true, Srtp: &dtls.SdesOption{
+					Suite: dtls.SuiteAES128CMHmacSha1_80, LocalKey: localKey, RemoteKey: remoteKey,
+				}})
+				session, err := dtls.NewSdesSrtpSession(&dtls.SdesOption{
+					Suite: dtls.SuiteAES128CMHmacSha1_80, LocalKey: remoteKey, RemoteKey: localKey,
+				})
+				assert(t, err, nil)
+				port, _ := transport.LocalPort()
+				client := newTestUDPConn(t)
+				raw, _ := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SSRC: 1234}, Payload: []byte{1}}).Marshal()
+				encrypted, _, err := session.EncryptRtp(nil, raw)
+				assert(t, err, nil)
+				_, err = client.WriteToUDP(encrypted, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+				assert(t, err, nil)
+				for deadline := time.Now().Add(5 * time.Second); !transport.IsConnected(); time.Sleep(time.Millisecond) {
+					if time.Now().After(deadline) {
+						t.Fatal("connect timeout")
+					}
+				}
+
+				transport.SendRtcpPacket(&rtcp.PictureLossIndication{MediaSSRC: 1234})
+				buf := make([]byte, 1500)
+				_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, err := client.Read(buf)
+				assert(t, err, nil)
+				if _, err = rtcp.Unmarshal(buf[:n]); err == nil {
+					// the srtcp trailer makes it invalid as plain rtcp
+					t.Fatal("rtcp should be encrypted")
+				}
+				decrypted, err := session.DecryptSrtcp(nil, buf[:n])
+				assert(t, err, nil)
+				packets, err := rtcp.Unmarshal(decrypted)
+				assert(t, err, nil)
+				assert(t, packets[0].DestinationSSRC()[0], uint32(1234))
+			},
+		},
+		{
+			name:        "timeout",
+			description: "the rtp and rtcp of two sockets are received, the connection is disconnected once the remote stopped",
+			method: func(t *testing.T) {
+				connection, transport := newTestPlainConnection(t, &PlainOption{Timeout: 300 * time.Millisecond})
+				_, err := connection.NewReceiver(&ReceiverOption{
+					ID:        "video",
+					MID:       "0",
+					MediaType: rtc.MediaTypeVideo,
+					Codec:     &Codec{PayloadType: 96, EncoderName: "vp8", ClockRate: 90000},
+					Streams:   []StreamOption{{SSRC: 1234, PayloadType: 96}},
+				})
+				assert(t, err, nil)
+				states := make(chan int, 2)
+				connection.OnStateChange(func(state int) {
+					states <- state
+				})
+				rtpClient, rtcpClient := newTestUDPConn(t), newTestUDPConn(t)
+				assert(t, transport.Connect("127.0.0.1", rtpClient.LocalAddr().(*net.UDPAddr).Port, rtcpClient.LocalAddr().(*net.UDPAddr).Port), nil)
+				assert(t, <-states, 1)
+				port, rtcpPort := transport.LocalPort()
+				for seq := uint16(0); seq < 20; seq++ {
+					raw, _ := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SSRC: 1234, SequenceNumber: seq}, Payload: []byte{1}}).Marshal()
+					_, err = rtpClient.WriteToUDP(raw, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+					assert(t, err, nil)
+					raw, _ = (&rtcp.SenderReport{SSRC: 1234, NTPTime: uint64(seq)}).Marshal()
+					_, err = rtcpClient.WriteToUDP(raw, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: rtcpPort})
+					assert(t, err, nil)
+				}
+				select {
+				case state := <-states:
+					assert(t, state, 2)
+				case <-time.After(5 * time.Second):
+					t.Fatal("disconnect timeout")
+				}
+				assert(t, transport.IsConnected(), false)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package peer
 import (
 	"log"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gotolive/sfu/rtc"
 	"github.com/gotolive/sfu/rtc/logger"
@@ -30,6 +31,7 @@ func newReceiver(options *ReceiverOption, l ReceiverListener, stats *Stats) (*Re
 		// ridStreams: map[string]ReceiverStream,
 		listener: l,
 	}
+	receiver.newStreamKeyFrame.Store(-1)
 	receiver.init(options)
 	return receiver, nil
 }
@@ -40,14 +42,20 @@ type Receiver struct {
 	mediaType              string // video audio
 	kind                   string // simple,simulcast,svc,pipe
 	listener               ReceiverListener
-	currentRTPPacket       rtc.Packet // used in NotifyNewRTPStream
+	newStreamKeyFrame      atomic.Int64 // the ssrc of new stream started with a key frame, -1 if not
 	maxRtcpInterval        int64
 	keyframeManager        *keyframeManager
 	rtpStreamByEncodingIdx []ReceiverStream
-	ssrcRTPStreams         map[uint32]ReceiverStream
-	rtxSsrcRTPStreams      map[uint32]ReceiverStream
 	lastRtcpSentTime       int64
 
+	// streamMutex guards the ssrc maps and the streams, the packets, the rtcp loop and the keyframe
+	// manager access them in different goroutines. It's never held while calling the senders.
+	streamMutex       sync.Mutex
+	ssrcRTPStreams    map[uint32]ReceiverStream
+	rtxSsrcRTPStreams map[uint32]ReceiverStream
+	// worstRemoteFractionLost is of the senders, for the rr of in-band fec.
+	worstRemoteFractionLost uint8
+
 	// codec will be used for create rtp_stream
 	// in fact, there is no need to be more than one codec, only one codec is enough for sfu.
 	// but another codec could used when set sender payload i guess?
@@ -55,6 +63,9 @@ type Receiver struct {
 	rtpHeaderExtensionIds rtc.HeaderExtensionIDs
 	senders               sync.Map
 	stats                 *Stats
+
+	mutex   sync.Mutex
+	onClose []func()
 }
 
 func (r *Receiver) init(options *ReceiverOption) {
@@ -85,7 +96,13 @@ func (r *Receiver) AddSender(s Sender) {
 	r.senders.Store(s.ID(), s)
 }
 
+// OnRTPStreamNeedWorstRemoteFractionLost is called by the streams under the streamMutex, the senders
+// can't be called there, so it returns the one collected by GetRtcp before locking.
 func (r *Receiver) OnRTPStreamNeedWorstRemoteFractionLost(ssrc uint32) uint8 {
+	return r.worstRemoteFractionLost
+}
+
+func (r *Receiver) collectWorstRemoteFractionLost() uint8 {
 	var wl uint8
 	r.senders.Range(func(key, value any) bool {
 		l := value.(Sender).FractionLost()
@@ -102,6 +119,8 @@ func (r *Receiver) sendRtcp(packet rtcp.Packet) {
 }
 
 func (r *Receiver) keyFrameNeeded(ssrc uint32) {
+	r.streamMutex.Lock()
+	defer r.streamMutex.Unlock()
 	if stream, ok := r.ssrcRTPStreams[ssrc]; ok {
 		stream.RequestKeyFrame()
 	}
@@ -117,23 +136,50 @@ func (r *Receiver) ReceiveRTPPacket(packet rtc.Packet) string {
 		// We simply drop it.
 		return ReceiveRTPPacketDiscarded
 	}
-	r.currentRTPPacket = nil
+	r.newStreamKeyFrame.Store(-1)
+	result, forward, newStream := r.receivePacket(packet)
+	if !forward {
+		return result
+	}
+
+	if packet.IsKeyFrame() {
+		if r.keyframeManager != nil {
+			r.keyframeManager.keyFrameReceived(packet.SSRC())
+		}
+	}
+	if newStream {
+		if r.keyframeManager != nil && !packet.IsKeyFrame() {
+			r.keyframeManager.keyFrameNeeded(packet.SSRC())
+		}
+		if packet.IsKeyFrame() {
+			r.newStreamKeyFrame.Store(int64(packet.SSRC()))
+		}
+	}
+	r.sendPacket(packet)
+
+	return result
+}
+
+// receivePacket passes the packet to its stream under the lock, it reports whether the packet should be
+// forwarded and whether it started a new stream.
+func (r *Receiver) receivePacket(packet rtc.Packet) (result string, forward, newStream bool) {
+	r.streamMutex.Lock()
+	defer r.streamMutex.Unlock()
 	numRTPStreamsBefore := len(r.ssrcRTPStreams)
 
-	rtpStream := r.GetRTPStream(packet)
+	rtpStream := r.getRTPStream(packet)
 	if rtpStream == nil {
 		log.Println("cant find stream for rtpPacket:", packet.SSRC())
-		return ReceiveRTPPacketDiscarded
+		return ReceiveRTPPacketDiscarded, false, false
 	}
 	packet.SetHeaderExtensionIDs(r.rtpHeaderExtensionIds)
-	var result string
 	// var isRtx bool
 	switch packet.SSRC() {
 	case rtpStream.SSRC():
 		result = ReceiveRTPPacketMedia
 		if err := rtpStream.ReceivePacket(packet); err != nil {
 			if len(r.ssrcRTPStreams) > numRTPStreamsBefore {
-				return result
+				return result, false, false
 			}
 		}
 	case rtpStream.RtxSSRC():
@@ -142,30 +188,22 @@ func (r *Receiver) ReceiveRTPPacket(packet rtc.Packet) string {
 		packet.SetRTX(true)
 		if err := rtpStream.ReceivePacket(packet); err != nil {
 			log.Println(err)
-			return result
+			return result, false, false
 		}
 	default:
 		log.Println("we could not find the ssrc ")
-		return ReceiveRTPPacketDiscarded
-	}
-
-	if packet.IsKeyFrame() {
-		if r.keyframeManager != nil {
-			r.keyframeManager.keyFrameReceived(packet.SSRC())
-		}
-	}
-	if len(r.ssrcRTPStreams) > numRTPStreamsBefore {
-		if r.keyframeManager != nil && !packet.IsKeyFrame() {
-			r.keyframeManager.keyFrameNeeded(packet.SSRC())
-		}
-		r.currentRTPPacket = packet
+		return ReceiveRTPPacketDiscarded, false, false
 	}
-	r.sendPacket(packet)
-
-	return result
+	return result, true, len(r.ssrcRTPStreams) > numRTPStreamsBefore
 }
 
 func (r *Receiver) GetRTPStream(packet rtc.Packet) ReceiverStream {
+	r.streamMutex.Lock()
+	defer r.streamMutex.Unlock()
+	return r.getRTPStream(packet)
+}
+
+func (r *Receiver) getRTPStream(packet rtc.Packet) ReceiverStream {
 	if s, ok := r.ssrcRTPStreams[packet.SSRC()]; ok {
 		return s
 	}
@@ -222,7 +260,7 @@ func (r *Receiver) RequestKeyFrame(ssrc uint32) {
 		logger.Error(1)
 		return
 	}
-	if r.currentRTPPacket != nil && r.currentRTPPacket.SSRC() == ssrc && r.currentRTPPacket.IsKeyFrame() {
+	if r.newStreamKeyFrame.Load() == int64(ssrc) {
 		logger.Error(2)
 		return
 	}
@@ -230,10 +268,15 @@ func (r *Receiver) RequestKeyFrame(ssrc uint32) {
 }
 
 func (r *Receiver) ReceiveRtcpSenderReport(report *rtcp.SenderReport) {
+	r.streamMutex.Lock()
 	stream := r.ssrcRTPStreams[report.SSRC]
+	var first bool
 	if stream != nil {
-		first := stream.GetSenderReportNtpMs() == 0
+		first = stream.GetSenderReportNtpMs() == 0
 		stream.ReceiveRtcpSenderReport(report)
+	}
+	r.streamMutex.Unlock()
+	if stream != nil {
 		r.senders.Range(func(key, value any) bool {
 			value.(Sender).ProducerRtcpSenderReport(stream, first)
 			return true
@@ -247,6 +290,10 @@ func (r *Receiver) GetRtcp(ms int64) rtcp.Packet {
 		return nil
 	}
 
+	worst := r.collectWorstRemoteFractionLost()
+	r.streamMutex.Lock()
+	defer r.streamMutex.Unlock()
+	r.worstRemoteFractionLost = worst
 	for _, v := range r.ssrcRTPStreams {
 		report := v.GetRtcpReceiverReport()
 		if report != nil {
@@ -317,7 +364,23 @@ func (r *Receiver) removeSender(id string) {
 	r.senders.Delete(id)
 }
 
+// OnClose adds a callback called when the receiver closed, it's called in the goroutine of Close.
+func (r *Receiver) OnClose(callback func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.onClose = append(r.onClose, callback)
+}
+
 func (r *Receiver) Close() {
+	r.mutex.Lock()
+	callbacks := r.onClose
+	r.onClose = nil
+	r.mutex.Unlock()
+	defer func() {
+		for _, callback := range callbacks {
+			callback()
+		}
+	}()
 	if r.keyframeManager != nil {
 		r.keyframeManager.close()
 	}
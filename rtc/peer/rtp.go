@@ -231,3 +231,16 @@ func (p *rtpPacket) UpdateHeader(extensions []rtp.Extension) {
 func (p *rtpPacket) IsRTX() bool {
 	return p.rtx
 }
+
+// clonePacket returns a copy not sharing buffer with the origin, the origin is returned if fails.
+func clonePacket(packet rtc.Packet) rtc.Packet {
+	raw, err := packet.Marshal()
+	if err != nil {
+		return packet
+	}
+	p := new(rtpPacket)
+	if err = p.Parse(raw); err != nil {
+		return packet
+	}
+	return p
+}
@@ -0,0 +1,310 @@
+package peer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec"
+)
+
+// RTPCapabilities is the ORTC RtpCapabilities, the json is the same as mediasoup, so the clients
+// negotiate without sdp. The rtx is a codec of mime type video/rtx with the apt parameter.
+type RTPCapabilities struct {
+	Codecs           []CodecCapability           `json:"codecs"`
+	HeaderExtensions []HeaderExtensionCapability `json:"headerExtensions"`
+}
+
+type CodecCapability struct {
+	Kind                 string            `json:"kind"`
+	MimeType             string            `json:"mimeType"` // e.g. video/VP8
+	PreferredPayloadType rtc.PayloadType   `json:"preferredPayloadType"`
+	ClockRate            int               `json:"clockRate"`
+	Channels             int               `json:"channels,omitempty"`
+	Parameters           map[string]string `json:"parameters,omitempty"`
+	RtcpFeedback         []RtcpFeedback    `json:"rtcpFeedback,omitempty"`
+}
+
+// EncoderName returns the subtype of mime type.
+func (c CodecCapability) EncoderName() string {
+	if i := strings.IndexByte(c.MimeType, '/'); i >= 0 {
+		return c.MimeType[i+1:]
+	}
+	return c.MimeType
+}
+
+type HeaderExtensionCapability struct {
+	Kind             string                `json:"kind"`
+	URI              string                `json:"uri"`
+	PreferredID      rtc.HeaderExtensionID `json:"preferredId"`
+	PreferredEncrypt bool                  `json:"preferredEncrypt"`
+	Direction        string                `json:"direction,omitempty"`
+}
+
+// the header extensions handled by the sfu, the others are forwarded only if both sides have the same id.
+var supportedHeaderExtensions = []struct {
+	kind string
+	uri  string
+}{
+	{rtc.MediaTypeAudio, rtc.HeaderExtensionMid},
+	{rtc.MediaTypeAudio, rtc.HeaderExtensionAbsSendTime},
+	{rtc.MediaTypeAudio, rtc.HeaderExtensionTransportSequenceNumber},
+	{rtc.MediaTypeAudio, rtc.HeaderExtensionAudioLevel},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionMid},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionRid},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionRepairedRid},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionAbsSendTime},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionTransportSequenceNumber},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionTimestampOffset},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionVideoRotation},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionPlayoutDelay},
+	{rtc.MediaTypeVideo, rtc.HeaderExtensionDependencyDescriptor},
+}
+
+// codecFeedback returns the rtcp feedback supported, both of the bwe are listed, the connection drops the other one.
+func codecFeedback(kind string) []RtcpFeedback {
+	if kind == rtc.MediaTypeAudio {
+		return []RtcpFeedback{{Type: "transport-cc"}}
+	}
+	return []RtcpFeedback{
+		{Type: "goog-remb"},
+		{Type: "transport-cc"},
+		{Type: "ccm", Parameter: "fir"},
+		{Type: "nack"},
+		{Type: "nack", Parameter: "pli"},
+	}
+}
+
+// RTPCapabilities returns the codecs registered in the codec package and the header extensions supported,
+// the ids are rtc.DefaultHeaderExtensionID. The codecs are registered by importing their packages.
+func (b *Broker) RTPCapabilities() RTPCapabilities {
+	caps := RTPCapabilities{
+		Codecs:           []CodecCapability{},
+		HeaderExtensions: []HeaderExtensionCapability{},
+	}
+	for _, c := range codec.Codecs() {
+		if c.PayloadType == 0 || c.ClockRate == 0 {
+			// not negotiable, e.g. registered for depacketizing only.
+			continue
+		}
+		var parameters map[string]string
+		if len(c.Parameters) != 0 {
+			parameters = make(map[string]string, len(c.Parameters))
+			for k, v := range c.Parameters {
+				parameters[k] = v
+			}
+		}
+		caps.Codecs = append(caps.Codecs, CodecCapability{
+			Kind:                 c.MediaType,
+			MimeType:             c.MediaType + "/" + c.EncoderName,
+			PreferredPayloadType: c.PayloadType,
+			ClockRate:            c.ClockRate,
+			Channels:             c.Channels,
+			Parameters:           parameters,
+			RtcpFeedback:         codecFeedback(c.MediaType),
+		})
+		if c.RTX != 0 {
+			caps.Codecs = append(caps.Codecs, CodecCapability{
+				Kind:                 c.MediaType,
+				MimeType:             c.MediaType + "/rtx",
+				PreferredPayloadType: c.RTX,
+				ClockRate:            c.ClockRate,
+				Parameters:           map[string]string{"apt": strconv.Itoa(int(c.PayloadType))},
+			})
+		}
+	}
+	for _, h := range supportedHeaderExtensions {
+		caps.HeaderExtensions = append(caps.HeaderExtensions, HeaderExtensionCapability{
+			Kind:        h.kind,
+			URI:         h.uri,
+			PreferredID: rtc.DefaultHeaderExtensionID(h.uri),
+			Direction:   "sendrecv",
+		})
+	}
+	return caps
+}
+
+// CanConsume returns true if the receiver of connection could be sent to the peer of caps.
+func (b *Broker) CanConsume(connectionID, receiverID string, caps *RTPCapabilities) bool {
+	connection := b.Connection(connectionID)
+	if connection == nil {
+		return false
+	}
+	return connection.CanConsume(receiverID, caps)
+}
+
+// CanConsume returns true if the codec of receiver is in the caps, the header extensions are not required.
+func (c *Connection) CanConsume(receiverID string, caps *RTPCapabilities) bool {
+	receiver := c.receiver(receiverID)
+	if receiver == nil || caps == nil {
+		return false
+	}
+	return caps.codec(receiver.MediaType(), receiver.Codec(), true) != nil
+}
+
+// codec returns the capability matches the codec, nil if not found.
+// The h264 profile is compared only if strict, as mediasoup does for the consumers.
+func (c *RTPCapabilities) codec(kind string, target *Codec, strict bool) *CodecCapability {
+	for i, capability := range c.Codecs {
+		if capability.Kind == kind && matchCodec(capability, target, strict) {
+			return &c.Codecs[i]
+		}
+	}
+	return nil
+}
+
+// matchCodec compares the name, clock rate and channels, and the parameters which make the codecs incompatible.
+// The level of h264 is not compared since the level could be asymmetric.
+func matchCodec(capability CodecCapability, target *Codec, strict bool) bool {
+	if !strings.EqualFold(capability.EncoderName(), target.EncoderName) || capability.ClockRate != target.ClockRate {
+		return false
+	}
+	// the channels of audio is 1 if not given.
+	channels, targetChannels := capability.Channels, target.Channels
+	if channels == 0 {
+		channels = 1
+	}
+	if targetChannels == 0 {
+		targetChannels = 1
+	}
+	if capability.Kind == rtc.MediaTypeAudio && channels != targetChannels {
+		return false
+	}
+	switch strings.ToLower(target.EncoderName) {
+	case "h264":
+		if parameter(capability.Parameters, "packetization-mode", "0") != parameter(target.Parameters, "packetization-mode", "0") {
+			return false
+		}
+		return !strict || sameH264Profile(capability.Parameters, target.Parameters)
+	case "vp9":
+		return parameter(capability.Parameters, "profile-id", "0") == parameter(target.Parameters, "profile-id", "0")
+	}
+	return true
+}
+
+func parameter(parameters map[string]string, key, defaultValue string) string {
+	if v, ok := parameters[key]; ok {
+		return v
+	}
+	return defaultValue
+}
+
+// the h264 profiles of rfc6184 section 8.1, as they are identified in libwebrtc.
+const (
+	h264ProfileConstrainedBaseline = iota + 1
+	h264ProfileBaseline
+	h264ProfileMain
+	h264ProfileConstrainedHigh
+	h264ProfileHigh
+	h264ProfilePredictiveHigh444
+)
+
+// defaultH264ProfileLevelID is the profile-level-id if it's not given, the constrained baseline level 3.1
+// as libwebrtc and mediasoup assume, instead of the baseline level 1 of rfc6184.
+const defaultH264ProfileLevelID = "42e01f"
+
+// h264ProfilePatterns maps the profile_idc and the masked profile-iop to the profile.
+var h264ProfilePatterns = []struct {
+	idc     byte
+	iopMask byte
+	iop     byte
+	profile int
+}{
+	{0x42, 0x4f, 0x40, h264ProfileConstrainedBaseline},
+	{0x4d, 0x8f, 0x80, h264ProfileConstrainedBaseline},
+	{0x58, 0xcf, 0xc0, h264ProfileConstrainedBaseline},
+	{0x42, 0x4f, 0x00, h264ProfileBaseline},
+	{0x58, 0xcf, 0x80, h264ProfileBaseline},
+	{0x4d, 0xaf, 0x00, h264ProfileMain},
+	{0x64, 0xff, 0x00, h264ProfileHigh},
+	{0x64, 0xff, 0x0c, h264ProfileConstrainedHigh},
+	{0xf4, 0xff, 0x00, h264ProfilePredictiveHigh444},
+}
+
+// h264Levels are the valid level_idc, 9 is the level 1b of the high profiles.
+var h264Levels = map[byte]bool{
+	9: true, 10: true, 11: true, 12: true, 13: true, 20: true, 21: true, 22: true,
+	30: true, 31: true, 32: true, 40: true, 41: true, 42: true, 50: true, 51: true, 52: true,
+}
+
+// h264Profile returns the profile of the profile-level-id, 0 if it's invalid.
+func h264Profile(parameters map[string]string) int {
+	value := parameter(parameters, "profile-level-id", defaultH264ProfileLevelID)
+	if len(value) != 6 {
+		return 0
+	}
+	id, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return 0
+	}
+	idc, iop, level := byte(id>>16), byte(id>>8), byte(id)
+	if !h264Levels[level] {
+		return 0
+	}
+	for _, p := range h264ProfilePatterns {
+		if p.idc == idc && iop&p.iopMask == p.iop {
+			return p.profile
+		}
+	}
+	return 0
+}
+
+// sameH264Profile returns true if both profile-level-ids are valid and of the same profile.
+func sameH264Profile(a, b map[string]string) bool {
+	profile := h264Profile(a)
+	return profile != 0 && profile == h264Profile(b)
+}
+
+// ValidateReceiver checks the codec, rtx and header extensions of the option are supported by the caps,
+// it's usually called with the caps of Broker.RTPCapabilities.
+func (c *RTPCapabilities) ValidateReceiver(option *ReceiverOption) error {
+	if err := option.Validate(); err != nil {
+		return err
+	}
+	return c.validate(option.MediaType, option.Codec, option.HeaderExtensions, false)
+}
+
+// ValidateSender checks the sender of receiver is supported by the caps of the peer receives it,
+// the codec of receiver is checked if the option has no codec.
+func (c *RTPCapabilities) ValidateSender(option *SenderOption, receiver *Receiver) error {
+	target := option.Codec
+	if target == nil {
+		target = receiver.Codec()
+	}
+	return c.validate(receiver.MediaType(), target, option.HeaderExtensions, true)
+}
+
+func (c *RTPCapabilities) validate(kind string, target *Codec, headers []rtc.HeaderExtension, strict bool) error {
+	if target != nil {
+		if c.codec(kind, target, strict) == nil {
+			return ErrCodecNotSupported
+		}
+		if target.RTX != 0 && !c.hasRTX(kind) {
+			return ErrCodecNotSupported
+		}
+	}
+	for _, h := range headers {
+		if !c.hasHeaderExtension(kind, h.URI) {
+			return ErrHeaderExtensionNotSupported
+		}
+	}
+	return nil
+}
+
+func (c *RTPCapabilities) hasRTX(kind string) bool {
+	for _, capability := range c.Codecs {
+		if capability.Kind == kind && strings.EqualFold(capability.EncoderName(), "rtx") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RTPCapabilities) hasHeaderExtension(kind, uri string) bool {
+	for _, h := range c.HeaderExtensions {
+		if h.URI == uri && (h.Kind == "" || h.Kind == kind) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gotolive/sfu/rtc"
+	_ "github.com/gotolive/sfu/rtc/codec/h264"
+	_ "github.com/gotolive/sfu/rtc/codec/opus"
+	_ "github.com/gotolive/sfu/rtc/codec/vp8"
+)
+
+func TestRTPCapabilities(t *testing.T) {
+	broker, err := NewBroker(BrokerOption{})
+	assert(t, err, nil)
+	defer broker.Close()
+	caps := broker.RTPCapabilities()
+	tests := []testHelper{
+		{
+			name:        "capabilities",
+			description: "the registered codecs and their rtx are listed with the default header extension ids",
+			method: func(t *testing.T) {
+				var mimeTypes []string
+				for _, c := range caps.Codecs {
+					mimeTypes = append(mimeTypes, c.MimeType)
+				}
+				assert(t, mimeTypes, []string{"audio/opus", "video/H264", "video/rtx", "video/VP8", "video/rtx"})
+				assert(t, caps.Codecs[0].Channels, 2)
+				assert(t, caps.Codecs[2].Parameters, map[string]string{"apt": "102"})
+				for _, h := range caps.HeaderExtensions {
+					assert(t, h.PreferredID, rtc.DefaultHeaderExtensionID(h.URI))
+				}
+				data, err := json.Marshal(caps)
+				assert(t, err, nil)
+				assert(t, strings.Contains(string(data), `"mimeType":"video/VP8","preferredPayloadType":96,"clockRate":90000`), true)
+			},
+		},
+		{
+			name:        "validate",
+			description: "the options of codecs and header extensions not in the caps are rejected",
+			method: func(t *testing.T) {
+				option := &ReceiverOption{
+					ID:               "video",
+					MediaType:        rtc.MediaTypeVideo,
+					Codec:            &Codec{PayloadType: 100, EncoderName: "vp8", ClockRate: 90000, RTX: 101},
+					HeaderExtensions: []rtc.HeaderExtension{{URI: rtc.HeaderExtensionMid, ID: 4}},
+					Streams:          []StreamOption{{SSRC: 1234, PayloadType: 100}},
+				}
+				assert(t, caps.ValidateReceiver(option), nil)
+
+				option.HeaderExtensions = append(option.HeaderExtensions, rtc.HeaderExtension{URI: rtc.HeaderExtensionAudioLevel, ID: 1})
+				assert(t, errors.Is(caps.ValidateReceiver(option), ErrHeaderExtensionNotSupported), true)
+				option.HeaderExtensions = nil
+				option.Codec = &Codec{PayloadType: 100, EncoderName: "H264", ClockRate: 90000}
+				assert(t, errors.Is(caps.ValidateReceiver(option), ErrCodecNotSupported), true)
+				option.Codec.Parameters = map[string]string{"packetization-mode": "1"}
+				assert(t, caps.ValidateReceiver(option), nil)
+				option.Codec = &Codec{PayloadType: 100, EncoderName: "AV1", ClockRate: 90000}
+				assert(t, errors.Is(caps.ValidateReceiver(option), ErrCodecNotSupported), true)
+				option.Codec = nil
+				assert(t, errors.Is(caps.ValidateReceiver(option), ErrCodecCantBeNil), true)
+			},
+		},
+		{
+			name:        "consume",
+			description: "the receiver is consumable if its codec is in the caps of consumer",
+			method: func(t *testing.T) {
+				conn, err := broker.NewDirectConnection(&DirectOption{ID: "consume"})
+				assert(t, err, nil)
+				receiver, err := conn.NewReceiver(&ReceiverOption{
+					ID:        "audio",
+					MediaType: rtc.MediaTypeAudio,
+					Codec:     &Codec{PayloadType: 111, EncoderName: "opus", ClockRate: 48000, Channels: 2},
+					Streams:   []StreamOption{{SSRC: 1111, PayloadType: 111}},
+				})
+				assert(t, err, nil)
+				assert(t, broker.CanConsume("consume", "audio", &caps), true)
+				assert(t, caps.ValidateSender(&SenderOption{ReceiverID: "audio"}, receiver), nil)
+
+				videoOnly := RTPCapabilities{Codecs: caps.Codecs[1:]}
+				assert(t, conn.CanConsume("audio", &videoOnly), false)
+				assert(t, errors.Is(videoOnly.ValidateSender(&SenderOption{ReceiverID: "audio"}, receiver), ErrCodecNotSupported), true)
+				assert(t, broker.CanConsume("consume", "video", &caps), false)
+				assert(t, broker.CanConsume("unknown", "audio", &caps), false)
+			},
+		},
+		{
+			name:        "h264 profile",
+			description: "the h264 receiver is consumable only with the same packetization mode and profile, the level is ignored",
+			method: func(t *testing.T) {
+				conn, err := broker.NewDirectConnection(&DirectOption{ID: "h264"})
+				assert(t, err, nil)
+				receiver, err := conn.NewReceiver(&ReceiverOption{
+					ID:        "video",
+					MediaType: rtc.MediaTypeVideo,
+					Codec:     &Codec{PayloadType: 102, EncoderName: "H264", ClockRate: 90000, Parameters: map[string]string{"packetization-mode": "1", "profile-level-id": "42e034"}},
+					Streams:   []StreamOption{{SSRC: 2222, PayloadType: 102}},
+				})
+				assert(t, err, nil)
+				consumer := func(parameters map[string]string) *RTPCapabilities {
+					return &RTPCapabilities{Codecs: []CodecCapability{{Kind: rtc.MediaTypeVideo, MimeType: "video/H264", ClockRate: 90000, Parameters: parameters}}}
+				}
+				assert(t, conn.CanConsume("video", &caps), true)
+				// constrained baseline of profile_idc 0x4d.
+				assert(t, conn.CanConsume("video", consumer(map[string]string{"packetization-mode": "1", "profile-level-id": "4d800a"})), true)
+				assert(t, conn.CanConsume("video", consumer(map[string]string{"packetization-mode": "0", "profile-level-id": "42e01f"})), false)
+				assert(t, conn.CanConsume("video", consumer(map[string]string{"packetization-mode": "1", "profile-level-id": "42001f"})), false)
+				assert(t, conn.CanConsume("video", consumer(map[string]string{"packetization-mode": "1", "profile-level-id": "4d001f"})), false)
+				assert(t, conn.CanConsume("video", consumer(map[string]string{"packetization-mode": "1", "profile-level-id": "640c1f"})), false)
+				assert(t, conn.CanConsume("video", consumer(map[string]string{"packetization-mode": "1", "profile-level-id": "42e0ff"})), false)
+				assert(t, conn.CanConsume("video", consumer(map[string]string{"packetization-mode": "1", "profile-level-id": "zzzzzz"})), false)
+				assert(t, errors.Is(consumer(map[string]string{"packetization-mode": "1", "profile-level-id": "64001f"}).ValidateSender(&SenderOption{ReceiverID: "video"}, receiver), ErrCodecNotSupported), true)
+
+				// the profile of receivers is not checked, the same as the producers of mediasoup.
+				option := &ReceiverOption{
+					ID:        "high",
+					MediaType: rtc.MediaTypeVideo,
+					Codec:     &Codec{PayloadType: 102, EncoderName: "H264", ClockRate: 90000, Parameters: map[string]string{"packetization-mode": "1", "profile-level-id": "64001f"}},
+					Streams:   []StreamOption{{SSRC: 3333, PayloadType: 102}},
+				}
+				assert(t, caps.ValidateReceiver(option), nil)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
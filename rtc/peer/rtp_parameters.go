@@ -47,8 +47,8 @@ func (c Codec) Equal(c2 *Codec) bool {
 }
 
 type RtcpFeedback struct {
-	Type      string
-	Parameter string
+	Type      string `json:"type"`
+	Parameter string `json:"parameter,omitempty"`
 }
 
 const (
@@ -56,4 +56,5 @@ const (
 	RTPTypeSimple    = "simple"
 	RTPTypeSimulcast = "simulcast"
 	RTPTypeNone      = "none"
+	RTPTypePipe      = "pipe"
 )
@@ -1,6 +1,8 @@
 package peer
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gotolive/sfu/rtc"
@@ -17,6 +19,7 @@ type ConsumerListener interface {
 	updateCodecs(codec *Codec) error
 	updateHeaderExtensions(headers []rtc.HeaderExtension) error
 	removeSender(id string)
+	mapSenderSSRC(ssrc, rtx uint32, s Sender)
 }
 
 type Sender interface {
@@ -85,6 +88,10 @@ func newSender(listener ConsumerListener, receiver *Receiver, options *SenderOpt
 	}
 	c.rtpHeaderExtensionIds = listener.getHeaderExtensions(headers)
 	for _, h := range headers {
+		// never forward an encrypted header extension in the clear.
+		if h.Encrypt && !c.rtpHeaderExtensionIds[h.URI].Encrypt {
+			continue
+		}
 		c.headerMap[h.ID] = c.rtpHeaderExtensionIds[h.URI].ID
 	}
 	codecs := options.Codec
@@ -142,14 +149,18 @@ type sender struct {
 	rtpStream             SenderStream
 
 	maxRtcpInterval          int
-	transportConnected       bool
+	transportConnected       atomic.Bool
 	producerClosed           bool
 	externallyManagedBitrate bool
 	stats                    *Stats
-	lastRtcpSentTime         int64
-	syncRequired             bool
 	producerRTPStreams       []ReceiverStream
 	headerMap                map[rtc.HeaderExtensionID]rtc.HeaderExtensionID
+
+	// mutex guards the state below and the rtp stream, the packets come from the goroutine of the
+	// receiver, the rtcp from the goroutines of the connection. It's never held while calling the receiver.
+	mutex            sync.Mutex
+	lastRtcpSentTime int64
+	syncRequired     bool
 }
 
 func (s *sender) GetBitrate(_ int) int64 {
@@ -196,6 +207,8 @@ func (s *sender) FractionLost() uint8 {
 	if !s.IsActive() {
 		return 0
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.rtpStream.FractionLost()
 }
 
@@ -207,10 +220,14 @@ func (s *sender) ReceiveNack(report *rtcp.TransportLayerNack) {
 	if !s.IsActive() {
 		return
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.rtpStream.ReceiveNack(report)
 }
 
 func (s *sender) GetRtcp(ms int64) rtcp.Packet {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	packet := rtcp.CompoundPacket{}
 	if (float64(ms)-float64(s.lastRtcpSentTime))*1.15 < float64(s.maxRtcpInterval) {
 		return nil
@@ -228,6 +245,8 @@ func (s *sender) GetRtcp(ms int64) rtcp.Packet {
 }
 
 func (s *sender) ReceiveRtcpReceiverReport(report rtcp.ReceptionReport) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.rtpStream.ReceiveRtcpReceiverReport(report)
 }
 
@@ -249,11 +268,13 @@ func (s *sender) CreateRTPStream() error {
 }
 
 func (s *sender) IsActive() bool {
-	return s.transportConnected && !s.producerClosed
+	return s.transportConnected.Load() && !s.producerClosed
 }
 
 func (s *sender) UserOnTransportConnected() {
+	s.mutex.Lock()
 	s.syncRequired = true
+	s.mutex.Unlock()
 	if s.IsActive() {
 		s.RequestKeyframe()
 	}
@@ -275,6 +296,8 @@ func (s *sender) SendRTPPacket(packet rtc.Packet) {
 	if !s.IsActive() {
 		return
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.syncRequired && codec.CanBeKeyFrame(s.codec.EncoderName) && !packet.IsKeyFrame() {
 		return
 	}
@@ -292,8 +315,12 @@ func (s *sender) SendRTPPacket(packet rtc.Packet) {
 	originHeader := packet.HeaderExtensions()
 	newHeaders := []rtp.Extension{}
 	for _, e := range originHeader {
+		id, ok := s.headerMap[rtc.HeaderExtensionID(e.ID)]
+		if !ok {
+			continue
+		}
 		newHeaders = append(newHeaders, rtp.Extension{
-			ID:      uint8(s.headerMap[rtc.HeaderExtensionID(e.ID)]),
+			ID:      uint8(id),
 			Payload: e.Payload,
 		})
 	}
@@ -312,7 +339,7 @@ func (s *sender) SendRTPPacket(packet rtc.Packet) {
 }
 
 func (s *sender) TransportConnected() {
-	s.transportConnected = true
+	s.transportConnected.Store(true)
 	s.UserOnTransportConnected()
 }
 
@@ -2,6 +2,7 @@ package peer
 
 import (
 	"encoding/binary"
+	"time"
 
 	"github.com/gotolive/sfu/rtc"
 	"github.com/gotolive/sfu/rtc/nack"
@@ -61,7 +62,7 @@ func (r *senderStream) ReceiveNack(report *rtcp.TransportLayerNack) {
 // GetRtcpSenderReport returns an RTCP sender report packet for the senderStream.
 // It returns nil if no packets have been sent.
 // The packet contains the SSRC, NTP time, RTP time, packet count, octet count, and profile extensions.
-// The NTP time is calculated from the given milliseconds using the timeToNtp function.
+// The NTP time is calculated from the given milliseconds using rtc.ToNtpTime.
 // The RTP time is calculated from the maximum packet timestamp and the difference in milliseconds.
 // The difference in milliseconds is calculated by subtracting the maximum packet milliseconds from the given milliseconds,
 // and then multiplying it by the clock rate divided by 1000.
@@ -76,7 +77,7 @@ func (r *senderStream) GetRtcpSenderReport(ms int64) rtcp.Packet {
 	diffTimestamp := diffMs * int64(r.GetClockRate()) / 1000
 	packet := rtcp.SenderReport{
 		SSRC:              r.SSRC(),
-		NTPTime:           timeToNtp(ms),
+		NTPTime:           rtc.ToNtpTime(time.UnixMilli(ms)),
 		RTPTime:           r.maxPacketRTPTimestamp + uint32(diffTimestamp),
 		PacketCount:       uint32(r.stats.PacketsSent()),
 		OctetCount:        uint32(r.stats.BytesSent()),
@@ -61,11 +61,27 @@ type SimulcastConsumer struct {
 }
 
 func (s *SimulcastConsumer) UpdateLayer(layer int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.preferredSpatialLayer = layer
 	s.mayChangeLayers(true)
 }
 
+// SpatialLayers return the count of spatial layers of the receiver.
+func (s *SimulcastConsumer) SpatialLayers() int {
+	return len(s.producerRTPStreams)
+}
+
+// Layers return the current, target and preferred spatial layer, the current is -1 until the first key frame.
+func (s *SimulcastConsumer) Layers() (current, target, preferred int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.currentSpatialLayer, s.targetSpatialLayer, s.preferredSpatialLayer
+}
+
 func (s *SimulcastConsumer) ProducerRtcpSenderReport(stream ReceiverStream, first bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if first {
 		currentStream := s.getProducerCurrentRTPStream()
 		if currentStream == nil || currentStream.GetSenderReportNtpMs() <= 0 {
@@ -85,6 +101,8 @@ func (s *SimulcastConsumer) SendRTPPacket(packet rtc.Packet) {
 	if !s.IsActive() {
 		return
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.targetSpatialLayer == -1 {
 		return
 	}
@@ -180,15 +198,16 @@ func (s *SimulcastConsumer) calTimestampOffset(packet rtc.Packet, layer int, sho
 }
 
 func (s *SimulcastConsumer) TransportConnected() {
-	s.transportConnected = true
+	s.transportConnected.Store(true)
 	s.UserOnTransportConnected()
 }
 
 func (s *SimulcastConsumer) TransportDisconnected() {
-	panic("implement me")
 }
 
 func (s *SimulcastConsumer) UserOnTransportConnected() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.syncRequired = true
 	s.keyFrameForTSOffsetRequested = false
 	if s.IsActive() {
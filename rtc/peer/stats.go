@@ -1,6 +1,8 @@
 package peer
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gotolive/sfu/rtc"
@@ -18,6 +20,7 @@ func newStats() *Stats {
 // Stats connection.GetStats()
 // it design to be access from everywhere.
 type Stats struct {
+	mutex sync.Mutex
 	// This four stats should be transport level.
 	packetsSent     int64
 	bytesSent       int64
@@ -26,9 +29,16 @@ type Stats struct {
 	sendBps         *remb.RateStatistics
 	receiveBps      *remb.RateStatistics
 	streams         map[uint32]*StreamStats
+
+	// decrypt failures, they used to be log only. They are counted on the packet path without the mutex.
+	srtpAuthFailures   atomic.Int64 // srtp/srtcp packets failed auth check
+	srtpReplays        atomic.Int64 // srtp/srtcp packets dropped by replay window
+	unknownSsrcPackets atomic.Int64 // packets decrypted fine but no receiver matched
 }
 
 func (s *Stats) IncomingRTP(packet rtc.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.bytesReceived += int64(packet.Size())
 	s.packetsReceived++
 	s.receiveBps.Update(int64(packet.Size()), packet.ReceiveMS())
@@ -37,24 +47,58 @@ func (s *Stats) IncomingRTP(packet rtc.Packet) {
 }
 
 func (s *Stats) OutcomePacket(packet rtc.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.bytesSent += int64(packet.Size())
 	s.packetsSent++
 	s.sendBps.Update(int64(packet.Size()), time.Now().UnixMilli())
 }
 
+func (s *Stats) SrtpAuthFailed() {
+	s.srtpAuthFailures.Add(1)
+}
+
+func (s *Stats) SrtpReplayed() {
+	s.srtpReplays.Add(1)
+}
+
+func (s *Stats) UnknownSsrc() {
+	s.unknownSsrcPackets.Add(1)
+}
+
+func (s *Stats) SrtpAuthFailures() int64 {
+	return s.srtpAuthFailures.Load()
+}
+
+func (s *Stats) SrtpReplays() int64 {
+	return s.srtpReplays.Load()
+}
+
+func (s *Stats) UnknownSsrcPackets() int64 {
+	return s.unknownSsrcPackets.Load()
+}
+
 func (s *Stats) PacketsReceived() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.packetsReceived
 }
 
 func (s *Stats) BytesSend() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.bytesSent
 }
 
 func (s *Stats) BytesReceived() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.bytesReceived
 }
 
 func (s *Stats) ReceiveBPS(nowMs int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	bps := s.receiveBps.Rate(nowMs)
 	if bps == nil {
 		return 0
@@ -63,6 +107,8 @@ func (s *Stats) ReceiveBPS(nowMs int64) int64 {
 }
 
 func (s *Stats) SentBPS(nowMs int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	bps := s.sendBps.Rate(nowMs)
 	if bps == nil {
 		return 0
@@ -89,6 +135,7 @@ func newStreamStats(ssrc uint32) *StreamStats {
 }
 
 type StreamStats struct {
+	mutex           sync.Mutex
 	packetsSent     int64
 	bytesSent       int64
 	packetsReceived int64
@@ -110,26 +157,36 @@ type StreamStats struct {
 }
 
 func (s *StreamStats) incomingRTP(packet rtc.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.bytesReceived += int64(packet.Size())
 	s.packetsReceived++
 	s.receiveBps.Update(int64(packet.Size()), packet.ReceiveMS())
 }
 
 func (s *StreamStats) outcomingRTP(packet rtc.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.bytesSent += int64(packet.Size())
 	s.packetsSent++
 	s.sendBps.Update(int64(packet.Size()), packet.ReceiveMS())
 }
 
 func (s *StreamStats) PacketsReceived() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.packetsReceived
 }
 
 func (s *StreamStats) BytesReceived() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.bytesReceived
 }
 
 func (s *StreamStats) ReceiveBPS(nowMs int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	bps := s.receiveBps.Rate(nowMs)
 	if bps == nil {
 		return 0
@@ -138,14 +195,20 @@ func (s *StreamStats) ReceiveBPS(nowMs int64) int64 {
 }
 
 func (s *StreamStats) PacketsSent() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.packetsSent
 }
 
 func (s *StreamStats) BytesSent() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.bytesSent
 }
 
 func (s *StreamStats) SentBPS(nowMs int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	bps := s.sendBps.Rate(nowMs)
 	if bps == nil {
 		return 0
@@ -47,20 +47,6 @@ type Stream interface {
 	Stats() *StreamStats
 }
 
-const (
-	ntpEpoch = 2208988800
-)
-
-func timeToNtp(unixMilli int64) uint64 {
-	// Convert Unix time to NTP format
-	// Unix time starts from 1970, NTP time starts from 1900
-	seconds := uint64(unixMilli/1000 + ntpEpoch)
-	// 1<<32 = 1s,  1<<32*(ms/1000) = fractional = ms*1<<32/1000
-	fractional := uint64((unixMilli % 1000) * (1 << 32) / 1000)
-	// Combine the integer and fractional parts to form the NTP timestamp
-	return seconds<<32 | fractional
-}
-
 func newStream(mediaType string, s StreamOption, codec Codec) internalStream {
 	stream := internalStream{
 		mediaType:      mediaType,
@@ -1,8 +1,10 @@
 package peer
 
 import (
+	"errors"
 	"io"
 	"log"
+	"sync"
 
 	"github.com/gotolive/sfu/rtc"
 	"github.com/gotolive/sfu/rtc/dtls"
@@ -11,11 +13,38 @@ import (
 	"github.com/pion/rtcp"
 )
 
-var _ Transport = new(webRTCTransport)
+var (
+	_ Transport       = new(webRTCTransport)
+	_ headerEncrypter = new(webRTCTransport)
+	_ iceRestarter    = new(webRTCTransport)
+	_ remoteSetter    = new(webRTCTransport)
+)
 
 // NewWebRTCTransport is a webrtc implementation of peer.Transport, support ice, dtls, srtp.
 func NewWebRTCTransport(options *WebRTCOption, iceServer *ice.Server, cm dtls.CertificateGenerator) (Transport, error) {
-	transport := &webRTCTransport{
+	transport := newWebRTCTransport(options)
+	iceTransport, err := iceServer.NewTransport(RandomString(4), RandomString(24), options.ListenIPs, transport.onIceData, transport.onIceState)
+	if err != nil {
+		return nil, err
+	}
+	transport.iceServer = iceServer
+	transport.start(iceTransport, options.DtlsOption, cm)
+	return transport, nil
+}
+
+// NewWebRTCClientTransport is the offerer side of webrtc transport, it sends the ice checks as controlling agent.
+// The dtls role is always actpass, the transport connects after the answer given by SetRemote.
+func NewWebRTCClientTransport(options *WebRTCOption, cm dtls.CertificateGenerator) Transport {
+	transport := newWebRTCTransport(options)
+	transport.iceClient = ice.NewClient(RandomString(4), RandomString(24), transport.onIceData, transport.onIceState)
+	dtlsOption := options.DtlsOption
+	dtlsOption.Role = dtls.Actpass
+	transport.start(transport.iceClient, dtlsOption, cm)
+	return transport
+}
+
+func newWebRTCTransport(options *WebRTCOption) *webRTCTransport {
+	return &webRTCTransport{
 		buffer:       make(rtc.CowBuffer, 1500),
 		sendBuffer:   make([]byte, 1500),
 		sendChan:     make(chan []byte, 100),
@@ -23,30 +52,23 @@ func NewWebRTCTransport(options *WebRTCOption, iceServer *ice.Server, cm dtls.Ce
 		closeCh:      make(chan struct{}),
 		dtlsRole:     dtls.Actpass,
 		packet:       new(rtpPacket),
+		dataChannel:  options.DataChannel,
 	}
+}
 
-	ufrag := RandomString(4)
-
-	iceTransport, err := iceServer.NewTransport(ufrag, RandomString(24), options.ListenIPs, transport.onIceData, transport.onIceState)
-	if err != nil {
-		return nil, err
-	}
-
-	transport.iceTransport = iceTransport
-
-	transport.pipeR, transport.pipeW = io.Pipe()
-
-	transport.dtlsTransport = dtls.NewDtlsTransport(dtls.Option{
+func (t *webRTCTransport) start(iceTransport ice.Transport, option dtls.Option, cm dtls.CertificateGenerator) {
+	t.iceTransport = iceTransport
+	t.pipeR, t.pipeW = io.Pipe()
+	t.dtlsTransport = dtls.NewDtlsTransport(dtls.Option{
 		Certificate:  cm.GenerateCertificate(),
-		Reader:       transport.pipeR,
-		Writer:       transport.iceTransport,
-		Role:         options.DtlsOption.Role,
-		OnState:      transport.OnState,
-		Fingerprints: options.DtlsOption.Fingerprints,
+		Reader:       t.pipeR,
+		Writer:       t.iceTransport,
+		Role:         option.Role,
+		OnState:      t.OnState,
+		Fingerprints: option.Fingerprints,
+		Srtp:         option.Srtp,
 	})
-	go transport.sendInternal()
-
-	return transport, nil
+	go t.sendInternal()
 }
 
 type webRTCTransport struct {
@@ -57,25 +79,153 @@ type webRTCTransport struct {
 	dtlsRole      string
 	packet        rtc.Packet
 	buffer        rtc.CowBuffer
-	srtpSession   *dtls.SrtpSession
+	iceServer     *ice.Server // nil for client
+	iceClient     *ice.Client // only for client
 	iceTransport  ice.Transport
 	sendChan      chan []byte
 	sendBuffer    []byte
 	sendRtcpChan  chan []byte
 	closeCh       chan struct{}
+	closeOnce     sync.Once
+	dataChannel   bool
+	sctp          *sctpTransport
+
+	// protect srtpSession, encryptedHeaders, cryptex and sctp,
+	// encrypted header extensions may be negotiated before the srtp session is ready.
+	mutex            sync.Mutex
+	srtpSession      *dtls.SrtpSession
+	encryptedHeaders []uint8
+	cryptex          bool
+}
+
+func (t *webRTCTransport) SetEncryptedHeaderExtensions(ids []rtc.HeaderExtensionID) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.encryptedHeaders = make([]uint8, 0, len(ids))
+	for _, id := range ids {
+		t.encryptedHeaders = append(t.encryptedHeaders, uint8(id))
+	}
+	if t.srtpSession != nil {
+		t.applyEncryptedHeaders()
+	}
+}
+
+// SetCryptex enables rfc9335 for the rtp we send, it applies when the srtp session is ready if not yet.
+func (t *webRTCTransport) SetCryptex(enabled bool) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.srtpSession != nil {
+		if err := t.srtpSession.SetCryptex(enabled); err != nil {
+			return err
+		}
+	}
+	t.cryptex = enabled
+	return nil
+}
+
+func (t *webRTCTransport) applyEncryptedHeaders() {
+	if err := t.srtpSession.SetEncryptedHeaderExtensions(t.encryptedHeaders); err != nil {
+		logger.Warn("encrypt header extensions fail:", err)
+	}
+	if err := t.srtpSession.SetCryptex(t.cryptex); err != nil {
+		logger.Warn("enable cryptex fail:", err)
+	}
+}
+
+// RestartIce changes the local ice credentials, the dtls and srtp are kept.
+func (t *webRTCTransport) RestartIce() error {
+	if t.iceServer == nil {
+		return ErrIceRestartNotSupported
+	}
+	return t.iceServer.Restart(t.iceTransport, RandomString(4), RandomString(24))
+}
+
+// SetRemote applies the answer of our offer. The client transport connects to the remote,
+// the server transport only resolves the dtls role and starts the handshake if ice is connected already.
+func (t *webRTCTransport) SetRemote(remote TransportInfo) error {
+	if t.iceClient == nil && t.dtlsTransport.Role() != dtls.Actpass {
+		// the answer of a renegotiation, the dtls is kept.
+		return nil
+	}
+	if err := t.dtlsTransport.SetRemoteRole(remote.DtlsInfo.Role); err != nil {
+		return err
+	}
+	if len(remote.DtlsInfo.Fingerprints) != 0 {
+		if err := t.dtlsTransport.SetRemoteFingerprint(&remote.DtlsInfo.Fingerprints[0]); err != nil {
+			return err
+		}
+	}
+	if t.iceClient != nil {
+		return t.iceClient.Connect(remote.IceInfo.Ufrag, remote.IceInfo.Pwd, remote.IceInfo.Candidates)
+	}
+	if state := t.iceTransport.State(); state == ice.ConnectionConnected || state == ice.ConnectionCompleted {
+		t.runDtls()
+	}
+	return nil
+}
+
+// runDtls starts the handshake, it waits for the answer if we offered actpass.
+func (t *webRTCTransport) runDtls() {
+	if err := t.dtlsTransport.TryRun(); err != nil {
+		logger.Debug("dtls not started:", t.connection.ID(), err)
+		return
+	}
+	if t.dtlsTransport.GetState() == dtls.Connected {
+		t.connection.Connected()
+	}
 }
 
+// Close sends close_notify before closing ice, so remote could tear down immediately.
 func (t *webRTCTransport) Close() {
+	t.stop()
+	if err := t.dtlsTransport.Close(); err != nil {
+		logger.Debug("close dtls fail:", err)
+	}
 	t.pipeW.Close()
 	t.iceTransport.Close()
 }
 
+func (t *webRTCTransport) stop() {
+	t.closeOnce.Do(func() {
+		close(t.closeCh)
+		t.mutex.Lock()
+		if t.sctp != nil {
+			t.sctp.Close()
+		}
+		t.mutex.Unlock()
+	})
+}
+
+func (t *webRTCTransport) startSctp() {
+	conn := t.dtlsTransport.DataConn()
+	if conn == nil {
+		return
+	}
+	s, err := newSctpTransport(conn, t.dtlsTransport.Role() == dtls.Active)
+	if err != nil {
+		logger.Warn("start sctp fail:", t.connection.ID(), err)
+		return
+	}
+	t.mutex.Lock()
+	t.sctp = s
+	t.mutex.Unlock()
+	t.connection.sctpConnected(s)
+}
+
 func (t *webRTCTransport) SetConnection(connection *Connection) {
 	t.connection = connection
 }
 
+// session returns the srtp session, it's nil until dtls connected. It's set in the goroutine of dtls,
+// and used in the goroutines of ice and senders.
+func (t *webRTCTransport) session() *dtls.SrtpSession {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.srtpSession
+}
+
 func (t *webRTCTransport) SendRTPPacket(packet rtc.Packet) {
-	if !t.IsConnected() || t.srtpSession == nil {
+	if !t.IsConnected() || t.session() == nil {
 		return
 	}
 	raw, err := packet.Marshal()
@@ -97,6 +247,12 @@ func (t *webRTCTransport) onIceData(data []byte) {
 	case RTCP:
 		t.onRtcpDataReceived(data)
 	case DTLS:
+		if t.dtlsTransport.Role() == dtls.Actpass {
+			// the answer of our offer not arrived, the handshake is not started.
+			// the remote retransmits it, the write would block until then.
+			logger.Debug("dtls role not negotiated, drop dtls:", t.connection.ID())
+			return
+		}
 		// it will read and process in another goroutine, we need copy it.
 		c := rtc.CowBuffer(data).Copy()
 		_, _ = t.pipeW.Write(c)
@@ -107,43 +263,60 @@ func (t *webRTCTransport) onIceData(data []byte) {
 
 func (t *webRTCTransport) onRTPDataReceived(data []byte) {
 	if t.dtlsTransport.GetState() != dtls.Connected {
-		log.Println("dtls connecting ignore rtp")
+		logger.Debug("dtls connecting ignore rtp")
 		return
 	}
-	if t.srtpSession == nil {
-		log.Println("no srtp session")
+	session := t.session()
+	if session == nil {
+		logger.Debug("no srtp session")
 		return
 	}
 
-	if d, err := t.srtpSession.DecryptSrtp(t.buffer, data); err != nil {
-		log.Println("decode srtp fail:", err)
-	} else {
-		if e := t.packet.Parse(d); e != nil {
-			log.Println("parse rtp fail:", err)
-		} else {
-			t.connection.receiveRTPPacket(t.packet)
-		}
+	d, err := session.DecryptSrtp(t.buffer, data)
+	if err != nil {
+		onDecryptFail(t.connection, err)
+		return
+	}
+	if err = t.packet.Parse(d); err != nil {
+		logger.Debug("parse rtp fail:", err)
+		return
 	}
+	t.connection.receiveRTPPacket(t.packet)
 }
 
 func (t *webRTCTransport) onRtcpDataReceived(data []byte) {
 	if t.dtlsTransport.GetState() != dtls.Connected {
-		log.Println("dtls connecting ignore rtp")
+		logger.Debug("dtls connecting ignore rtcp")
 		return
 	}
-	if t.srtpSession == nil {
-		log.Println("no srtp session")
+	session := t.session()
+	if session == nil {
+		logger.Debug("no srtp session")
 		return
 	}
 
-	if d, err := t.srtpSession.DecryptSrtcp(t.buffer, data); err != nil {
-		log.Println("decode srtp fail:", err, err)
-	} else {
-		if p, e := rtcp.Unmarshal(d); e != nil {
-			log.Println("parse rtcp fail:", err)
-		} else {
-			t.connection.receiveRtcpPacket(p)
-		}
+	d, err := session.DecryptSrtcp(t.buffer, data)
+	if err != nil {
+		onDecryptFail(t.connection, err)
+		return
+	}
+	p, err := rtcp.Unmarshal(d)
+	if err != nil {
+		logger.Debug("parse rtcp fail:", err)
+		return
+	}
+	t.connection.receiveRtcpPacket(p)
+}
+
+// onDecryptFail counts the failure rather than logging it, a bad peer could flood us.
+func onDecryptFail(connection *Connection, err error) {
+	switch {
+	case errors.Is(err, dtls.ErrSrtpAuthFailed):
+		connection.Stats().SrtpAuthFailed()
+	case errors.Is(err, dtls.ErrSrtpReplayed):
+		connection.Stats().SrtpReplayed()
+	default:
+		logger.Debug("decrypt srtp fail:", err)
 	}
 }
 
@@ -152,6 +325,8 @@ type WebRTCOption struct {
 	ListenIPs  []string
 	DtlsOption dtls.Option
 	BweType    string
+	// DataChannel starts sctp after dtls connected, the remote must negotiate m=application too.
+	DataChannel bool
 }
 
 func (t *webRTCTransport) Info() TransportInfo {
@@ -182,7 +357,7 @@ func (t *webRTCTransport) Info() TransportInfo {
 }
 
 func (t *webRTCTransport) SendRtcpPacket(packet rtcp.Packet) {
-	if !t.IsConnected() || t.srtpSession == nil {
+	if !t.IsConnected() || t.session() == nil {
 		return
 	}
 
@@ -197,7 +372,7 @@ func (t *webRTCTransport) sendInternal() {
 	for {
 		select {
 		case raw := <-t.sendChan:
-			data, _, err := t.srtpSession.EncryptRtp(t.sendBuffer, raw)
+			data, _, err := t.session().EncryptRtp(t.sendBuffer, raw)
 			if err != nil {
 				log.Println("encrypt rtp fail:", err)
 			}
@@ -207,7 +382,7 @@ func (t *webRTCTransport) sendInternal() {
 				return
 			}
 		case raw := <-t.sendRtcpChan:
-			data, _, err := t.srtpSession.EncryptRtcp(t.sendBuffer, raw)
+			data, _, err := t.session().EncryptRtcp(t.sendBuffer, raw)
 			if err != nil {
 				return
 			}
@@ -227,41 +402,46 @@ func (t *webRTCTransport) OnState(state int) {
 	switch state {
 	case dtls.Connecting:
 	case dtls.Connected:
-		var err error
-		t.srtpSession, err = dtls.NewSrtpSession(t.dtlsTransport)
+		t.mutex.Lock()
+		session, err := dtls.NewSrtpSession(t.dtlsTransport)
 		if err != nil {
 			logger.Error("create srtp fail:", err)
+		} else {
+			t.srtpSession = session
+			t.applyEncryptedHeaders()
 		}
+		t.mutex.Unlock()
 		t.connection.Connected()
+		if t.dataChannel {
+			go t.startSctp()
+		}
 	case dtls.Failed:
 		t.iceTransport.Close()
+	case dtls.Closed:
+		// remote sent close_notify, no need to wait for ice timeout.
+		t.pipeW.Close()
+		t.connection.Disconnected()
+		t.iceTransport.Close()
+		t.stop()
 	}
 }
 
 func (t *webRTCTransport) onIceState(state ice.ConnectionState) {
 	logger.Debug("ice state change:", t.connection.ID(), state)
 	switch state {
-	case ice.ConnectionConnected:
-		t.dtlsTransport.TryRun()
-		if t.dtlsTransport.GetState() == dtls.Connected {
-			t.connection.Connected()
-		}
-	case ice.ConnectionCompleted:
-		t.dtlsTransport.TryRun()
-		if t.dtlsTransport.GetState() == dtls.Connected {
-			t.connection.Connected()
-		}
+	case ice.ConnectionConnected, ice.ConnectionCompleted:
+		t.runDtls()
 	case ice.ConnectionDisconnected:
 		if t.dtlsTransport.GetState() == dtls.Connected {
 			t.pipeW.Close()
 			t.connection.Disconnected()
 		}
-		close(t.closeCh)
+		t.stop()
 	case ice.ConnectionFailed:
 		if t.dtlsTransport != nil && t.dtlsTransport.GetState() == dtls.Connected {
 			t.pipeW.Close()
 			t.connection.Disconnected()
 		}
-		close(t.closeCh)
+		t.stop()
 	}
 }
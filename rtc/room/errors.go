@@ -0,0 +1,17 @@
+package room
+
+import "errors"
+
+var (
+	ErrRoomExist         = errors.New("room already exists")
+	ErrRoomClosed        = errors.New("room closed")
+	ErrParticipantExist  = errors.New("participant already exists")
+	ErrParticipantLeft   = errors.New("participant left")
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrConnectionExist   = errors.New("connection already exists")
+	ErrNoPublisher       = errors.New("publish connection not created")
+	ErrNoSubscriber      = errors.New("subscribe connection not created")
+	ErrTrackNotFound     = errors.New("track not found")
+	ErrSelfSubscribe     = errors.New("could not subscribe own track")
+	ErrAlreadySubscribed = errors.New("track already subscribed")
+)
@@ -0,0 +1,392 @@
+package room
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+// Permission is what the participant is allowed to do in the room.
+type Permission struct {
+	CanPublish   bool
+	CanSubscribe bool
+}
+
+type ParticipantOption struct {
+	ID string
+	// Permission allows both publish and subscribe if nil.
+	Permission *Permission
+	// Authorizer is set to the connections of participant, it's optional.
+	Authorizer peer.Authorizer
+}
+
+// Participant publishes the tracks by the publish connection, and receives the tracks of others
+// by the subscribe connection. It leaves the room when any of its connections disconnected.
+type Participant struct {
+	id   string
+	room *Room
+
+	mutex         sync.Mutex
+	left          bool
+	permission    Permission
+	authorizer    peer.Authorizer
+	publisher     *peer.Connection
+	subscriber    *peer.Connection
+	tracks        []*Track
+	subscriptions map[*Track]peer.Sender
+	mid           int // the next mid of the subscribe connection
+}
+
+func newParticipant(r *Room, option *ParticipantOption) *Participant {
+	p := &Participant{
+		id:            option.ID,
+		room:          r,
+		permission:    Permission{CanPublish: true, CanSubscribe: true},
+		authorizer:    option.Authorizer,
+		subscriptions: map[*Track]peer.Sender{},
+	}
+	if p.id == "" {
+		p.id = peer.RandomString(12)
+	}
+	if option.Permission != nil {
+		p.permission = *option.Permission
+	}
+	return p
+}
+
+func (p *Participant) ID() string {
+	return p.id
+}
+
+func (p *Participant) Room() *Room {
+	return p.room
+}
+
+func (p *Participant) Permission() Permission {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.permission
+}
+
+// SetPermission updates the permission, the tracks are unpublished if publish revoked,
+// and the subscriptions are removed if subscribe revoked.
+// The tracks of others are subscribed by the policy if subscribe granted.
+func (p *Participant) SetPermission(permission Permission) {
+	p.mutex.Lock()
+	old := p.permission
+	p.permission = permission
+	p.mutex.Unlock()
+	if old.CanPublish && !permission.CanPublish {
+		for _, track := range p.Tracks() {
+			_ = p.Unpublish(track)
+		}
+	}
+	switch {
+	case old.CanSubscribe && !permission.CanSubscribe:
+		for _, track := range p.Subscriptions() {
+			_ = p.Unsubscribe(track)
+		}
+	case !old.CanSubscribe && permission.CanSubscribe:
+		p.subscribeExisting()
+	}
+}
+
+func (p *Participant) Publisher() *peer.Connection {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.publisher
+}
+
+func (p *Participant) Subscriber() *peer.Connection {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.subscriber
+}
+
+// NewPublisher creates the connection receives the tracks of participant, the receivers are created by Publish.
+func (p *Participant) NewPublisher(option *peer.WebRTCOption) (*peer.Connection, error) {
+	p.mutex.Lock()
+	if !p.permission.CanPublish {
+		p.mutex.Unlock()
+		return nil, ErrPermissionDenied
+	}
+	conn, err := p.newConnection(option, &p.publisher)
+	p.mutex.Unlock()
+	return conn, err
+}
+
+// NewSubscriber creates the connection sends the tracks of others, the existing tracks are subscribed by the policy.
+func (p *Participant) NewSubscriber(option *peer.WebRTCOption) (*peer.Connection, error) {
+	p.mutex.Lock()
+	if !p.permission.CanSubscribe {
+		p.mutex.Unlock()
+		return nil, ErrPermissionDenied
+	}
+	conn, err := p.newConnection(option, &p.subscriber)
+	p.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	p.subscribeExisting()
+	return conn, nil
+}
+
+// newConnection must be called with the mutex held.
+func (p *Participant) newConnection(option *peer.WebRTCOption, slot **peer.Connection) (*peer.Connection, error) {
+	if p.left {
+		return nil, ErrParticipantLeft
+	}
+	if *slot != nil {
+		return nil, ErrConnectionExist
+	}
+	o := *option
+	if o.BweType == "" {
+		o.BweType = p.room.option.BweType
+	}
+	if len(o.ListenIPs) == 0 {
+		o.ListenIPs = p.room.option.ListenIPs
+	}
+	conn, err := p.room.manager.broker.NewWebRTCConnection(&o)
+	if err != nil {
+		return nil, err
+	}
+	if p.authorizer != nil {
+		conn.SetAuthorizer(p.authorizer)
+	}
+	conn.OnStateChange(func(state int) {
+		if state == 2 {
+			go p.Leave()
+		}
+	})
+	*slot = conn
+	return conn, nil
+}
+
+// Publish creates a receiver in the publish connection, the track is unpublished when the receiver closed.
+func (p *Participant) Publish(option *peer.ReceiverOption) (*Track, error) {
+	p.mutex.Lock()
+	switch {
+	case p.left:
+		p.mutex.Unlock()
+		return nil, ErrParticipantLeft
+	case !p.permission.CanPublish:
+		p.mutex.Unlock()
+		return nil, ErrPermissionDenied
+	case p.publisher == nil:
+		p.mutex.Unlock()
+		return nil, ErrNoPublisher
+	}
+	receiver, err := p.publisher.NewReceiver(option)
+	if err != nil {
+		p.mutex.Unlock()
+		return nil, err
+	}
+	track := &Track{publisher: p, connectionID: p.publisher.ID(), receiver: receiver}
+	p.tracks = append(p.tracks, track)
+	p.mutex.Unlock()
+	receiver.OnClose(func() {
+		p.removeTrack(track)
+	})
+	p.room.emit(Event{Type: TrackPublished, Participant: p, Track: track})
+	p.room.autoSubscribe(track)
+	return track, nil
+}
+
+// Unpublish closes the receiver of track, the senders of subscribers are closed too.
+func (p *Participant) Unpublish(track *Track) error {
+	if !p.hasTrack(track) {
+		return ErrTrackNotFound
+	}
+	track.receiver.Close()
+	return nil
+}
+
+func (p *Participant) hasTrack(track *Track) bool {
+	return p.Track(track.ID()) == track
+}
+
+// Track returns the published track by id, nil if not found.
+func (p *Participant) Track(id string) *Track {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, t := range p.tracks {
+		if t.ID() == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (p *Participant) removeTrack(track *Track) {
+	p.mutex.Lock()
+	found := false
+	for i, t := range p.tracks {
+		if t == track {
+			p.tracks = append(p.tracks[:i], p.tracks[i+1:]...)
+			found = true
+			break
+		}
+	}
+	p.mutex.Unlock()
+	if !found {
+		return
+	}
+	p.room.unsubscribeAll(track)
+	p.room.emit(Event{Type: TrackUnpublished, Participant: p, Track: track})
+}
+
+// SetMuted changes the muted state of the track, TrackMuted is emitted if changed.
+func (p *Participant) SetMuted(track *Track, muted bool) error {
+	if !p.hasTrack(track) {
+		return ErrTrackNotFound
+	}
+	if track.muted.Swap(muted) != muted {
+		p.room.emit(Event{Type: TrackMuted, Participant: p, Track: track})
+	}
+	return nil
+}
+
+// Tracks are the tracks published by the participant, in publish order.
+func (p *Participant) Tracks() []*Track {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]*Track(nil), p.tracks...)
+}
+
+// Subscribe creates a sender of track in the subscribe connection, the mid of senders starts from 0.
+func (p *Participant) Subscribe(track *Track) (peer.Sender, error) {
+	if track.publisher == p {
+		return nil, ErrSelfSubscribe
+	}
+	p.mutex.Lock()
+	switch {
+	case p.left:
+		p.mutex.Unlock()
+		return nil, ErrParticipantLeft
+	case !p.permission.CanSubscribe:
+		p.mutex.Unlock()
+		return nil, ErrPermissionDenied
+	case p.subscriber == nil:
+		p.mutex.Unlock()
+		return nil, ErrNoSubscriber
+	case p.subscriptions[track] != nil:
+		p.mutex.Unlock()
+		return nil, ErrAlreadySubscribed
+	}
+	sender, err := p.subscriber.NewSender(&peer.SenderOption{
+		MID:          strconv.Itoa(p.mid),
+		ConnectionID: track.connectionID,
+		ReceiverID:   track.ID(),
+		SwitchMode:   peer.ManualSwitchLayer,
+	})
+	if err != nil {
+		p.mutex.Unlock()
+		if errors.Is(err, peer.ErrReceiverNotExist) {
+			return nil, ErrTrackNotFound
+		}
+		return nil, err
+	}
+	p.mid++
+	p.subscriptions[track] = sender
+	p.mutex.Unlock()
+	p.room.emit(Event{Type: TrackSubscribed, Participant: p, Track: track})
+	return sender, nil
+}
+
+// Unsubscribe closes the sender of track.
+func (p *Participant) Unsubscribe(track *Track) error {
+	p.mutex.Lock()
+	sender, ok := p.subscriptions[track]
+	delete(p.subscriptions, track)
+	p.mutex.Unlock()
+	if !ok {
+		return ErrTrackNotFound
+	}
+	sender.Close()
+	p.room.emit(Event{Type: TrackUnsubscribed, Participant: p, Track: track})
+	return nil
+}
+
+// Subscriptions are the tracks subscribed by the participant.
+func (p *Participant) Subscriptions() []*Track {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	tracks := make([]*Track, 0, len(p.subscriptions))
+	for track := range p.subscriptions {
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// Sender returns the sender of the subscribed track, nil if not subscribed.
+func (p *Participant) Sender(track *Track) peer.Sender {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.subscriptions[track]
+}
+
+// dropSubscription removes the subscription of the unpublished track, the sender is already closed.
+func (p *Participant) dropSubscription(track *Track) {
+	p.mutex.Lock()
+	_, ok := p.subscriptions[track]
+	delete(p.subscriptions, track)
+	p.mutex.Unlock()
+	if ok {
+		p.room.emit(Event{Type: TrackUnsubscribed, Participant: p, Track: track})
+	}
+}
+
+func (p *Participant) autoSubscribable() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return !p.left && p.permission.CanSubscribe && p.subscriber != nil
+}
+
+// subscribeExisting subscribes the published tracks of others by the policy.
+func (p *Participant) subscribeExisting() {
+	if !p.autoSubscribable() {
+		return
+	}
+	for _, track := range p.room.Tracks() {
+		if track.publisher != p && p.room.option.Policy(p, track) {
+			p.trySubscribe(track)
+		}
+	}
+}
+
+func (p *Participant) trySubscribe(track *Track) {
+	// the track may be subscribed by both the publish and the subscriber creation.
+	if _, err := p.Subscribe(track); err != nil && !errors.Is(err, ErrAlreadySubscribed) {
+		logger.Warnf("participant %s subscribe track %s fail: %v", p.id, track.ID(), err)
+	}
+}
+
+// Leave closes the connections and removes the participant from the room,
+// the tracks are unpublished before the participant left event.
+func (p *Participant) Leave() {
+	p.mutex.Lock()
+	if p.left {
+		p.mutex.Unlock()
+		return
+	}
+	p.left = true
+	publisher, subscriber := p.publisher, p.subscriber
+	p.mutex.Unlock()
+	shouldClose := p.room.removeParticipant(p)
+	if publisher != nil {
+		publisher.Close()
+	}
+	if subscriber != nil {
+		subscriber.Close()
+	}
+	p.mutex.Lock()
+	p.subscriptions = map[*Track]peer.Sender{}
+	p.mutex.Unlock()
+	p.room.emit(Event{Type: ParticipantLeft, Participant: p})
+	if shouldClose {
+		p.room.Close()
+	}
+}
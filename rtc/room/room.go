@@ -0,0 +1,310 @@
+package room
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/bwe"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+// EventType is the type of the room events.
+type EventType int
+
+const (
+	ParticipantJoined EventType = iota + 1
+	ParticipantLeft
+	TrackPublished
+	TrackUnpublished
+	TrackSubscribed
+	TrackUnsubscribed
+	TrackMuted
+	RoomClosed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case ParticipantJoined:
+		return "participant_joined"
+	case ParticipantLeft:
+		return "participant_left"
+	case TrackPublished:
+		return "track_published"
+	case TrackUnpublished:
+		return "track_unpublished"
+	case TrackSubscribed:
+		return "track_subscribed"
+	case TrackUnsubscribed:
+		return "track_unsubscribed"
+	case TrackMuted:
+		return "track_muted"
+	case RoomClosed:
+		return "room_closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is emitted after the change done, Participant is the one joined, left, published or subscribed.
+// Track is nil for the participant events, and both are nil for RoomClosed.
+type Event struct {
+	Type        EventType
+	Participant *Participant
+	Track       *Track
+}
+
+// SubscribePolicy decides whether the track is subscribed by the participant automatically.
+type SubscribePolicy func(participant *Participant, track *Track) bool
+
+// SubscribeAll subscribes all tracks of the others.
+func SubscribeAll(*Participant, *Track) bool {
+	return true
+}
+
+// SubscribeNone leaves the subscription to Participant.Subscribe.
+func SubscribeNone(*Participant, *Track) bool {
+	return false
+}
+
+// SubscribeAudio subscribes the audio tracks only, e.g. the video is subscribed on demand.
+func SubscribeAudio(_ *Participant, track *Track) bool {
+	return track.MediaType() == rtc.MediaTypeAudio
+}
+
+type Option struct {
+	ID string
+	// Policy is SubscribeAll by default.
+	Policy SubscribePolicy
+	// BweType is the bwe of the connections created by participants, remb by default.
+	BweType   string
+	ListenIPs []string
+	// AutoClose closes the room after the last participant left.
+	AutoClose bool
+}
+
+// Manager keeps the rooms of a broker.
+type Manager struct {
+	broker *peer.Broker
+
+	mutex sync.Mutex
+	rooms map[string]*Room
+}
+
+func NewManager(broker *peer.Broker) *Manager {
+	return &Manager{
+		broker: broker,
+		rooms:  map[string]*Room{},
+	}
+}
+
+func (m *Manager) NewRoom(option *Option) (*Room, error) {
+	r := &Room{
+		option:       *option,
+		manager:      m,
+		participants: map[string]*Participant{},
+	}
+	if r.option.ID == "" {
+		r.option.ID = peer.RandomString(12)
+	}
+	if r.option.Policy == nil {
+		r.option.Policy = SubscribeAll
+	}
+	if r.option.BweType == "" {
+		r.option.BweType = bwe.Remb
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.rooms[r.option.ID]; ok {
+		return nil, ErrRoomExist
+	}
+	m.rooms[r.option.ID] = r
+	return r, nil
+}
+
+func (m *Manager) Room(id string) *Room {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.rooms[id]
+}
+
+// Rooms returns the rooms sorted by id.
+func (m *Manager) Rooms() []*Room {
+	m.mutex.Lock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		rooms = append(rooms, r)
+	}
+	m.mutex.Unlock()
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].ID() < rooms[j].ID()
+	})
+	return rooms
+}
+
+func (m *Manager) removeRoom(r *Room) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.rooms[r.ID()] == r {
+		delete(m.rooms, r.ID())
+	}
+}
+
+// Close closes all rooms.
+func (m *Manager) Close() {
+	for _, r := range m.Rooms() {
+		r.Close()
+	}
+}
+
+// Room is a group of participants, the tracks published by one are subscribed by the others
+// according to the policy and their permissions.
+type Room struct {
+	option  Option
+	manager *Manager
+
+	mutex        sync.Mutex
+	closed       bool
+	participants map[string]*Participant
+	listeners    []func(Event)
+}
+
+func (r *Room) ID() string {
+	return r.option.ID
+}
+
+// OnEvent adds a listener of the events, it's called in the goroutine of the change.
+func (r *Room) OnEvent(callback func(event Event)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.listeners = append(r.listeners, callback)
+}
+
+func (r *Room) emit(event Event) {
+	r.mutex.Lock()
+	listeners := r.listeners
+	r.mutex.Unlock()
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// Join adds a participant to the room, it has no connection until NewPublisher or NewSubscriber.
+func (r *Room) Join(option *ParticipantOption) (*Participant, error) {
+	p := newParticipant(r, option)
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return nil, ErrRoomClosed
+	}
+	if _, ok := r.participants[p.ID()]; ok {
+		r.mutex.Unlock()
+		return nil, ErrParticipantExist
+	}
+	r.participants[p.ID()] = p
+	r.mutex.Unlock()
+	r.emit(Event{Type: ParticipantJoined, Participant: p})
+	return p, nil
+}
+
+func (r *Room) Participant(id string) *Participant {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.participants[id]
+}
+
+// Participants returns the participants sorted by id.
+func (r *Room) Participants() []*Participant {
+	r.mutex.Lock()
+	participants := make([]*Participant, 0, len(r.participants))
+	for _, p := range r.participants {
+		participants = append(participants, p)
+	}
+	r.mutex.Unlock()
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].ID() < participants[j].ID()
+	})
+	return participants
+}
+
+// Tracks returns the tracks published by all participants.
+func (r *Room) Tracks() []*Track {
+	var tracks []*Track
+	for _, p := range r.Participants() {
+		tracks = append(tracks, p.Tracks()...)
+	}
+	return tracks
+}
+
+// removeParticipant returns true if the room should be closed.
+func (r *Room) removeParticipant(p *Participant) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.participants[p.ID()] == p {
+		delete(r.participants, p.ID())
+	}
+	return r.option.AutoClose && !r.closed && len(r.participants) == 0
+}
+
+// autoSubscribe subscribes the track for the others by the policy.
+func (r *Room) autoSubscribe(track *Track) {
+	for _, p := range r.Participants() {
+		if p != track.publisher && p.autoSubscribable() && r.option.Policy(p, track) {
+			p.trySubscribe(track)
+		}
+	}
+}
+
+// unsubscribeAll drops the subscriptions of the track, its senders are closed with the receiver.
+func (r *Room) unsubscribeAll(track *Track) {
+	for _, p := range r.Participants() {
+		p.dropSubscription(track)
+	}
+}
+
+// Close removes all participants and the room from the manager.
+func (r *Room) Close() {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return
+	}
+	r.closed = true
+	r.mutex.Unlock()
+	for _, p := range r.Participants() {
+		p.Leave()
+	}
+	r.manager.removeRoom(r)
+	r.emit(Event{Type: RoomClosed})
+}
+
+// Track is a receiver published by a participant.
+type Track struct {
+	publisher    *Participant
+	connectionID string
+	receiver     *peer.Receiver
+	muted        atomic.Bool
+}
+
+// ID is the receiver id, it's unique in the publisher.
+func (t *Track) ID() string {
+	return t.receiver.ID()
+}
+
+func (t *Track) Publisher() *Participant {
+	return t.publisher
+}
+
+func (t *Track) Receiver() *peer.Receiver {
+	return t.receiver
+}
+
+func (t *Track) MediaType() string {
+	return t.receiver.MediaType()
+}
+
+// Muted is the state set by the publisher, the media is still forwarded if the publisher keeps sending.
+func (t *Track) Muted() bool {
+	return t.muted.Load()
+}
@@ -0,0 +1,330 @@
+package room
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/ice"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+func newTestManager(t *testing.T) *Manager {
+	broker, err := peer.NewBroker(peer.BrokerOption{ICE: ice.Option{IPs: []string{"127.0.0.1"}}})
+	assert(t, err, nil)
+	manager := NewManager(broker)
+	t.Cleanup(func() {
+		manager.Close()
+		broker.Close()
+	})
+	return manager
+}
+
+// newTestRoom returns a room and the channel of its events.
+func newTestRoom(t *testing.T, option *Option) (*Room, chan Event) {
+	r, err := newTestManager(t).NewRoom(option)
+	assert(t, err, nil)
+	events := make(chan Event, 100)
+	r.OnEvent(func(event Event) {
+		events <- event
+	})
+	return r, events
+}
+
+func audioOption(id string) *peer.ReceiverOption {
+	return &peer.ReceiverOption{
+		ID:        id,
+		MediaType: rtc.MediaTypeAudio,
+		Codec:     &peer.Codec{PayloadType: 111, EncoderName: "opus", ClockRate: 48000, Channels: 2},
+		Streams:   []peer.StreamOption{{SSRC: 1111, PayloadType: 111}},
+	}
+}
+
+func videoOption(id string) *peer.ReceiverOption {
+	return &peer.ReceiverOption{
+		ID:        id,
+		MediaType: rtc.MediaTypeVideo,
+		Codec:     &peer.Codec{PayloadType: 96, EncoderName: "VP8", ClockRate: 90000},
+		Streams:   []peer.StreamOption{{SSRC: 2222, PayloadType: 96}},
+	}
+}
+
+func join(t *testing.T, r *Room, id string, publish, subscribe bool) *Participant {
+	p, err := r.Join(&ParticipantOption{ID: id})
+	assert(t, err, nil)
+	if publish {
+		_, err = p.NewPublisher(&peer.WebRTCOption{})
+		assert(t, err, nil)
+	}
+	if subscribe {
+		_, err = p.NewSubscriber(&peer.WebRTCOption{})
+		assert(t, err, nil)
+	}
+	return p
+}
+
+func nextEvent(t *testing.T, events chan Event, eventType EventType) Event {
+	select {
+	case event := <-events:
+		assert(t, event.Type, eventType)
+		return event
+	case <-time.After(3 * time.Second):
+		t.Log("no event:", eventType)
+		t.FailNow()
+		return Event{}
+	}
+}
+
+func TestManager(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "rooms",
+			description: "the rooms are kept by id until closed",
+			method: func(t *testing.T) {
+				manager := newTestManager(t)
+				a, err := manager.NewRoom(&Option{ID: "a"})
+				assert(t, err, nil)
+				_, err = manager.NewRoom(&Option{ID: "a"})
+				assert(t, errors.Is(err, ErrRoomExist), true)
+				b, err := manager.NewRoom(&Option{})
+				assert(t, err, nil)
+				assert(t, len(b.ID()), 12)
+				assert(t, manager.Room("a"), a)
+				assert(t, len(manager.Rooms()), 2)
+
+				events := make(chan Event, 10)
+				a.OnEvent(func(event Event) {
+					events <- event
+				})
+				join(t, a, "alice", true, true)
+				a.Close()
+				nextEvent(t, events, ParticipantJoined)
+				nextEvent(t, events, ParticipantLeft)
+				assert(t, nextEvent(t, events, RoomClosed).Participant == nil, true)
+				assert(t, manager.Room("a") == nil, true)
+				assert(t, len(a.Participants()), 0)
+				_, err = a.Join(&ParticipantOption{})
+				assert(t, errors.Is(err, ErrRoomClosed), true)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
+
+func TestRoom(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "auto_subscribe",
+			description: "the tracks are subscribed by the others automatically",
+			method: func(t *testing.T) {
+				r, events := newTestRoom(t, &Option{})
+				alice := join(t, r, "alice", true, true)
+				nextEvent(t, events, ParticipantJoined)
+				audio, err := alice.Publish(audioOption("audio"))
+				assert(t, err, nil)
+				assert(t, nextEvent(t, events, TrackPublished).Track, audio)
+
+				// the existing tracks are subscribed when the subscriber created.
+				bob := join(t, r, "bob", false, true)
+				nextEvent(t, events, ParticipantJoined)
+				event := nextEvent(t, events, TrackSubscribed)
+				assert(t, event.Participant, bob)
+				assert(t, event.Track, audio)
+				assert(t, bob.Sender(audio).MID(), "0")
+
+				video, err := alice.Publish(videoOption("video"))
+				assert(t, err, nil)
+				nextEvent(t, events, TrackPublished)
+				assert(t, nextEvent(t, events, TrackSubscribed).Track, video)
+				assert(t, bob.Sender(video).MID(), "1")
+				assert(t, len(bob.Subscriptions()), 2)
+				assert(t, len(bob.Subscriber().Senders()), 2)
+				assert(t, r.Tracks(), []*Track{audio, video})
+
+				// the own tracks are never subscribed.
+				assert(t, len(alice.Subscriptions()), 0)
+				_, err = alice.Subscribe(audio)
+				assert(t, errors.Is(err, ErrSelfSubscribe), true)
+
+				assert(t, alice.Unpublish(audio), nil)
+				assert(t, nextEvent(t, events, TrackUnsubscribed).Participant, bob)
+				assert(t, nextEvent(t, events, TrackUnpublished).Track, audio)
+				assert(t, alice.Unpublish(audio), ErrTrackNotFound)
+				assert(t, bob.Subscriptions(), []*Track{video})
+				assert(t, len(bob.Subscriber().Senders()), 1)
+			},
+		},
+		{
+			name:        "manual_subscribe",
+			description: "the tracks are subscribed by Subscribe with SubscribeNone",
+			method: func(t *testing.T) {
+				r, events := newTestRoom(t, &Option{Policy: SubscribeNone})
+				alice := join(t, r, "alice", true, false)
+				bob := join(t, r, "bob", false, false)
+				video, err := alice.Publish(videoOption("video"))
+				assert(t, err, nil)
+				_, err = bob.Subscribe(video)
+				assert(t, errors.Is(err, ErrNoSubscriber), true)
+				_, err = bob.Publish(audioOption("audio"))
+				assert(t, errors.Is(err, ErrNoPublisher), true)
+				_, err = bob.NewSubscriber(&peer.WebRTCOption{})
+				assert(t, err, nil)
+				_, err = bob.NewSubscriber(&peer.WebRTCOption{})
+				assert(t, errors.Is(err, ErrConnectionExist), true)
+				assert(t, len(bob.Subscriptions()), 0)
+
+				sender, err := bob.Subscribe(video)
+				assert(t, err, nil)
+				assert(t, sender.MID(), "0")
+				_, err = bob.Subscribe(video)
+				assert(t, errors.Is(err, ErrAlreadySubscribed), true)
+				nextEvent(t, events, ParticipantJoined)
+				nextEvent(t, events, ParticipantJoined)
+				nextEvent(t, events, TrackPublished)
+				nextEvent(t, events, TrackSubscribed)
+
+				assert(t, bob.Unsubscribe(video), nil)
+				assert(t, nextEvent(t, events, TrackUnsubscribed).Track, video)
+				assert(t, bob.Unsubscribe(video), ErrTrackNotFound)
+				assert(t, len(bob.Subscriber().Senders()), 0)
+				// the mid is not reused.
+				sender, err = bob.Subscribe(video)
+				assert(t, err, nil)
+				assert(t, sender.MID(), "1")
+			},
+		},
+		{
+			name:        "mute",
+			description: "the muted state is changed by the publisher only",
+			method: func(t *testing.T) {
+				r, events := newTestRoom(t, &Option{})
+				alice := join(t, r, "alice", true, false)
+				bob := join(t, r, "bob", false, true)
+				audio, err := alice.Publish(audioOption("audio"))
+				assert(t, err, nil)
+				assert(t, alice.Track("audio"), audio)
+				assert(t, alice.Track("video") == nil, true)
+				for i := 0; i < 4; i++ {
+					<-events
+				}
+				assert(t, alice.SetMuted(audio, true), nil)
+				assert(t, nextEvent(t, events, TrackMuted).Track.Muted(), true)
+				// no event if not changed.
+				assert(t, alice.SetMuted(audio, true), nil)
+				assert(t, alice.SetMuted(audio, false), nil)
+				assert(t, nextEvent(t, events, TrackMuted).Track.Muted(), false)
+				assert(t, bob.SetMuted(audio, true), ErrTrackNotFound)
+			},
+		},
+		{
+			name:        "audio_policy",
+			description: "only audio tracks are subscribed with SubscribeAudio",
+			method: func(t *testing.T) {
+				r, _ := newTestRoom(t, &Option{Policy: SubscribeAudio})
+				alice := join(t, r, "alice", true, false)
+				bob := join(t, r, "bob", false, true)
+				audio, err := alice.Publish(audioOption("audio"))
+				assert(t, err, nil)
+				_, err = alice.Publish(videoOption("video"))
+				assert(t, err, nil)
+				assert(t, bob.Subscriptions(), []*Track{audio})
+			},
+		},
+		{
+			name:        "permission",
+			description: "the permission limits the participant, and the change takes effect immediately",
+			method: func(t *testing.T) {
+				r, _ := newTestRoom(t, &Option{})
+				alice := join(t, r, "alice", true, false)
+				viewer, err := r.Join(&ParticipantOption{ID: "viewer", Permission: &Permission{}})
+				assert(t, err, nil)
+				_, err = viewer.NewPublisher(&peer.WebRTCOption{})
+				assert(t, errors.Is(err, ErrPermissionDenied), true)
+				_, err = viewer.NewSubscriber(&peer.WebRTCOption{})
+				assert(t, errors.Is(err, ErrPermissionDenied), true)
+
+				audio, err := alice.Publish(audioOption("audio"))
+				assert(t, err, nil)
+				viewer.SetPermission(Permission{CanSubscribe: true})
+				_, err = viewer.NewSubscriber(&peer.WebRTCOption{})
+				assert(t, err, nil)
+				assert(t, viewer.Subscriptions(), []*Track{audio})
+
+				viewer.SetPermission(Permission{})
+				assert(t, len(viewer.Subscriptions()), 0)
+				_, err = viewer.Subscribe(audio)
+				assert(t, errors.Is(err, ErrPermissionDenied), true)
+				// the tracks are subscribed again when granted.
+				viewer.SetPermission(Permission{CanSubscribe: true})
+				assert(t, viewer.Subscriptions(), []*Track{audio})
+
+				alice.SetPermission(Permission{CanSubscribe: true})
+				assert(t, len(alice.Tracks()), 0)
+				assert(t, len(viewer.Subscriptions()), 0)
+				_, err = alice.Publish(audioOption("audio"))
+				assert(t, errors.Is(err, ErrPermissionDenied), true)
+			},
+		},
+		{
+			name:        "disconnect",
+			description: "the participant leaves when its connection disconnected, and the room closed after the last one left",
+			method: func(t *testing.T) {
+				manager := newTestManager(t)
+				r, err := manager.NewRoom(&Option{ID: "room", AutoClose: true})
+				assert(t, err, nil)
+				events := make(chan Event, 100)
+				r.OnEvent(func(event Event) {
+					events <- event
+				})
+				alice := join(t, r, "alice", true, false)
+				bob := join(t, r, "bob", false, true)
+				_, err = alice.Publish(audioOption("audio"))
+				assert(t, err, nil)
+				_, err = r.Join(&ParticipantOption{ID: "bob"})
+				assert(t, errors.Is(err, ErrParticipantExist), true)
+				for i := 0; i < 4; i++ {
+					<-events
+				}
+
+				alice.Publisher().Disconnected()
+				nextEvent(t, events, TrackUnsubscribed)
+				nextEvent(t, events, TrackUnpublished)
+				assert(t, nextEvent(t, events, ParticipantLeft).Participant, alice)
+				assert(t, r.Participants(), []*Participant{bob})
+				assert(t, len(bob.Subscriptions()), 0)
+				assert(t, manager.Room("room"), r)
+				_, err = alice.NewSubscriber(&peer.WebRTCOption{})
+				assert(t, errors.Is(err, ErrParticipantLeft), true)
+
+				bob.Leave()
+				assert(t, nextEvent(t, events, ParticipantLeft).Participant, bob)
+				assert(t, manager.Room("room") == nil, true)
+				assert(t, len(manager.broker.Connections()), 0)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
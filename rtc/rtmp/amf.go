@@ -0,0 +1,195 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// amf0 markers
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+// amfMap is decoded from object and ecma array, the null and undefined are decoded as nil.
+type amfMap = map[string]any
+
+// amfDecode decodes all values of the data, the numbers are float64.
+func amfDecode(data []byte) ([]any, error) {
+	var values []any
+	for len(data) != 0 {
+		value, n, err := amfDecodeValue(data)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+		data = data[n:]
+	}
+	return values, nil
+}
+
+func amfDecodeValue(data []byte) (any, int, error) {
+	if len(data) == 0 {
+		return nil, 0, ErrInvalidAMF
+	}
+	switch data[0] {
+	case amfNumber:
+		if len(data) < 9 {
+			return nil, 0, ErrInvalidAMF
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 9, nil
+	case amfBoolean:
+		if len(data) < 2 {
+			return nil, 0, ErrInvalidAMF
+		}
+		return data[1] != 0, 2, nil
+	case amfString:
+		s, n, err := amfDecodeString(data[1:], 2)
+		return s, n + 1, err
+	case amfLongString:
+		s, n, err := amfDecodeString(data[1:], 4)
+		return s, n + 1, err
+	case amfNull, amfUndefined:
+		return nil, 1, nil
+	case amfObject:
+		m, n, err := amfDecodeObject(data[1:])
+		return m, n + 1, err
+	case amfECMAArray:
+		if len(data) < 5 {
+			return nil, 0, ErrInvalidAMF
+		}
+		// the count is not reliable, it ends with the object end marker as object.
+		m, n, err := amfDecodeObject(data[5:])
+		return m, n + 5, err
+	case amfStrictArray:
+		if len(data) < 5 {
+			return nil, 0, ErrInvalidAMF
+		}
+		count := int(binary.BigEndian.Uint32(data[1:]))
+		offset := 5
+		var values []any
+		for i := 0; i < count; i++ {
+			value, n, err := amfDecodeValue(data[offset:])
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset += n
+		}
+		return values, offset, nil
+	case amfDate:
+		if len(data) < 11 {
+			return nil, 0, ErrInvalidAMF
+		}
+		// milliseconds and the time zone.
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 11, nil
+	default:
+		return nil, 0, ErrInvalidAMF
+	}
+}
+
+// amfDecodeString decodes the string with the size of 2 or 4 bytes length.
+func amfDecodeString(data []byte, size int) (string, int, error) {
+	if len(data) < size {
+		return "", 0, ErrInvalidAMF
+	}
+	var length int
+	if size == 2 {
+		length = int(binary.BigEndian.Uint16(data))
+	} else {
+		length = int(binary.BigEndian.Uint32(data))
+	}
+	if len(data) < size+length {
+		return "", 0, ErrInvalidAMF
+	}
+	return string(data[size : size+length]), size + length, nil
+}
+
+// amfDecodeObject decodes the properties until the object end marker.
+func amfDecodeObject(data []byte) (amfMap, int, error) {
+	m := amfMap{}
+	offset := 0
+	for {
+		key, n, err := amfDecodeString(data[offset:], 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		if key == "" && offset < len(data) && data[offset] == amfObjectEnd {
+			return m, offset + 1, nil
+		}
+		value, n, err := amfDecodeValue(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		m[key] = value
+		offset += n
+	}
+}
+
+// amfEncode encodes the values, the supported types are the numbers, bool, string, nil, amfMap and []any.
+// The keys of map are sorted.
+func amfEncode(values ...any) []byte {
+	var data []byte
+	for _, value := range values {
+		data = amfAppend(data, value)
+	}
+	return data
+}
+
+func amfAppend(data []byte, value any) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(data, amfNull)
+	case bool:
+		if v {
+			return append(data, amfBoolean, 1)
+		}
+		return append(data, amfBoolean, 0)
+	case int:
+		return amfAppend(data, float64(v))
+	case uint32:
+		return amfAppend(data, float64(v))
+	case float64:
+		return binary.BigEndian.AppendUint64(append(data, amfNumber), math.Float64bits(v))
+	case string:
+		if len(v) > math.MaxUint16 {
+			data = binary.BigEndian.AppendUint32(append(data, amfLongString), uint32(len(v)))
+			return append(data, v...)
+		}
+		return amfAppendString(append(data, amfString), v)
+	case amfMap:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		data = append(data, amfObject)
+		for _, key := range keys {
+			data = amfAppend(amfAppendString(data, key), v[key])
+		}
+		return append(amfAppendString(data, ""), amfObjectEnd)
+	case []any:
+		data = binary.BigEndian.AppendUint32(append(data, amfStrictArray), uint32(len(v)))
+		for _, item := range v {
+			data = amfAppend(data, item)
+		}
+		return data
+	default:
+		return append(data, amfUndefined)
+	}
+}
+
+func amfAppendString(data []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint16(data, uint16(len(s))), s...)
+}
@@ -0,0 +1,68 @@
+package rtmp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+func TestAMF(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "round trip",
+			description: "the encoded values are decoded as the same",
+			method: func(t *testing.T) {
+				values := []any{"connect", float64(1), amfMap{"app": "live", "tcUrl": "rtmp://localhost/live", "fpad": false}, nil, []any{float64(2), "a"}}
+				decoded, err := amfDecode(amfEncode(values...))
+				assert(t, err, nil)
+				assert(t, decoded, values)
+			},
+		},
+		{
+			name:        "ecma array",
+			description: "the ecma array of metadata is decoded as object",
+			method: func(t *testing.T) {
+				data := []byte{amfString, 0, 10}
+				data = append(data, "onMetaData"...)
+				data = append(data, amfECMAArray, 0, 0, 0, 1, 0, 5)
+				data = append(data, "width"...)
+				data = append(data, amfEncode(1280)...)
+				data = append(data, 0, 0, amfObjectEnd)
+				decoded, err := amfDecode(data)
+				assert(t, err, nil)
+				assert(t, decoded, []any{"onMetaData", amfMap{"width": float64(1280)}})
+			},
+		},
+		{
+			name:        "invalid",
+			description: "the truncated data is rejected",
+			method: func(t *testing.T) {
+				data := amfEncode("publish", amfMap{"a": "b"})
+				for i := 1; i < len(data); i++ {
+					if _, err := amfDecode(data[:i]); err == nil && i != 10 {
+						t.Fatal("truncated data should fail:", i)
+					}
+				}
+				_, err := amfDecode([]byte{0x10})
+				assert(t, err, ErrInvalidAMF)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package rtmp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	rtmpVersion      = 3
+	handshakeSize    = 1536
+	defaultChunkSize = 128
+	maxChunkSize     = 0xffffff
+	extendedTS       = 0xffffff
+)
+
+// message types
+const (
+	msgSetChunkSize     = 1
+	msgAbort            = 2
+	msgAck              = 3
+	msgUserControl      = 4
+	msgWindowAckSize    = 5
+	msgSetPeerBandwidth = 6
+	msgAudio            = 8
+	msgVideo            = 9
+	msgDataAMF3         = 15
+	msgCommandAMF3      = 17
+	msgDataAMF0         = 18
+	msgCommandAMF0      = 20
+)
+
+// chunk stream ids of the sent messages.
+const (
+	csidControl = 2
+	csidCommand = 3
+)
+
+type message struct {
+	typeID    uint8
+	streamID  uint32
+	timestamp uint32
+	data      []byte
+}
+
+// serverHandshake does the simple handshake, C2 is not verified, most clients accept it.
+func serverHandshake(rw io.ReadWriter) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(rw, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != rtmpVersion {
+		return ErrInvalidHandshake
+	}
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = rtmpVersion
+	// s1 is time, zero and random bytes.
+	if _, err := rand.Read(s0s1s2[9 : 1+handshakeSize]); err != nil {
+		return err
+	}
+	// s2 echoes c1.
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+	if _, err := rw.Write(s0s1s2); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(rw, make([]byte, handshakeSize))
+	return err
+}
+
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typeID    uint8
+	streamID  uint32
+	extended  bool
+	data      []byte
+}
+
+// chunkReader reads the messages interleaved in chunk streams.
+type chunkReader struct {
+	reader    *bufio.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+	// received is the count of bytes, for acknowledgement.
+	received uint32
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{
+		reader:    bufio.NewReader(r),
+		chunkSize: defaultChunkSize,
+		streams:   map[uint32]*chunkStream{},
+	}
+}
+
+func (r *chunkReader) read(n int) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, err
+	}
+	r.received += uint32(n)
+	return data, nil
+}
+
+func (r *chunkReader) readUint(n int) (uint32, error) {
+	data, err := r.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint32
+	for _, b := range data {
+		v = v<<8 | uint32(b)
+	}
+	return v, nil
+}
+
+// readMessage returns the next complete message.
+func (r *chunkReader) readMessage() (*message, error) {
+	for {
+		msg, err := r.readChunk()
+		if err != nil || msg != nil {
+			return msg, err
+		}
+	}
+}
+
+// readChunk returns a message if it's completed by the chunk.
+func (r *chunkReader) readChunk() (*message, error) {
+	b, err := r.readUint(1)
+	if err != nil {
+		return nil, err
+	}
+	format, csid := b>>6, b&0x3f
+	switch csid {
+	case 0:
+		if csid, err = r.readUint(1); err != nil {
+			return nil, err
+		}
+		csid += 64
+	case 1:
+		if csid, err = r.readUint(2); err != nil {
+			return nil, err
+		}
+		// little endian
+		csid = (csid>>8 | csid&0xff<<8) + 64
+	}
+	stream := r.streams[csid]
+	if stream == nil {
+		if format != 0 {
+			return nil, ErrInvalidChunk
+		}
+		stream = &chunkStream{}
+		r.streams[csid] = stream
+	}
+	// a new message starts if no pending data.
+	start := len(stream.data) == 0
+	var ts uint32
+	if format <= 2 {
+		if ts, err = r.readUint(3); err != nil {
+			return nil, err
+		}
+		stream.extended = ts == extendedTS
+	}
+	if format <= 1 {
+		if stream.length, err = r.readUint(3); err != nil {
+			return nil, err
+		}
+		typeID, err := r.readUint(1)
+		if err != nil {
+			return nil, err
+		}
+		stream.typeID = uint8(typeID)
+	}
+	if format == 0 {
+		data, err := r.read(4)
+		if err != nil {
+			return nil, err
+		}
+		stream.streamID = binary.LittleEndian.Uint32(data)
+	}
+	if stream.extended {
+		// it's also present in format 3 chunks if the previous chunk has it.
+		if ts, err = r.readUint(4); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case format == 0:
+		stream.timestamp, stream.delta = ts, 0
+	case format <= 2:
+		stream.timestamp += ts
+		stream.delta = ts
+	case start:
+		stream.timestamp += stream.delta
+	}
+	if !start && format != 3 {
+		// the previous message is aborted.
+		stream.data = nil
+	}
+	n := stream.length - uint32(len(stream.data))
+	if n > r.chunkSize {
+		n = r.chunkSize
+	}
+	data, err := r.read(int(n))
+	if err != nil {
+		return nil, err
+	}
+	stream.data = append(stream.data, data...)
+	if uint32(len(stream.data)) < stream.length {
+		return nil, nil
+	}
+	msg := &message{typeID: stream.typeID, streamID: stream.streamID, timestamp: stream.timestamp, data: stream.data}
+	stream.data = nil
+	return msg, nil
+}
+
+// chunkWriter writes each message as a chunk of format 0, and the format 3 chunks.
+type chunkWriter struct {
+	writer    io.Writer
+	chunkSize int
+}
+
+func newChunkWriter(w io.Writer) *chunkWriter {
+	return &chunkWriter{writer: w, chunkSize: defaultChunkSize}
+}
+
+func (w *chunkWriter) writeMessage(csid uint8, msg *message) error {
+	ts := msg.timestamp
+	if ts >= extendedTS {
+		ts = extendedTS
+	}
+	data := []byte{csid & 0x3f, byte(ts >> 16), byte(ts >> 8), byte(ts)}
+	length := len(msg.data)
+	data = append(data, byte(length>>16), byte(length>>8), byte(length), msg.typeID)
+	data = binary.LittleEndian.AppendUint32(data, msg.streamID)
+	payload := msg.data
+	for {
+		if ts == extendedTS {
+			data = binary.BigEndian.AppendUint32(data, msg.timestamp)
+		}
+		n := min(len(payload), w.chunkSize)
+		data = append(data, payload[:n]...)
+		payload = payload[n:]
+		if len(payload) == 0 {
+			break
+		}
+		data = append(data, 3<<6|csid&0x3f)
+	}
+	_, err := w.writer.Write(data)
+	return err
+}
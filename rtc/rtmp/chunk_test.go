@@ -0,0 +1,68 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkReader(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "formats",
+			description: "the timestamps of format 1, 2 and 3 are deltas of the chunk stream",
+			method: func(t *testing.T) {
+				data := []byte{
+					// format 0 of csid 4, timestamp 1000, length 2, video, stream 1.
+					0x04, 0, 0x03, 0xe8, 0, 0, 2, msgVideo, 1, 0, 0, 0, 0xaa, 0xbb,
+					// format 1, delta 40, length 1.
+					0x44, 0, 0, 40, 0, 0, 1, msgVideo, 0xcc,
+					// format 2, delta 20.
+					0x84, 0, 0, 20, 0xdd,
+					// format 3, the delta again.
+					0xc4, 0xee,
+					// format 0 of csid 64 by the 2 bytes basic header.
+					0x00, 0x00, 0, 0, 5, 0, 0, 1, msgAudio, 1, 0, 0, 0, 0xff,
+				}
+				reader := newChunkReader(bytes.NewReader(data))
+				expected := []message{
+					{msgVideo, 1, 1000, []byte{0xaa, 0xbb}},
+					{msgVideo, 1, 1040, []byte{0xcc}},
+					{msgVideo, 1, 1060, []byte{0xdd}},
+					{msgVideo, 1, 1080, []byte{0xee}},
+					{msgAudio, 1, 5, []byte{0xff}},
+				}
+				for _, e := range expected {
+					msg, err := reader.readMessage()
+					assert(t, err, nil)
+					assert(t, *msg, e)
+				}
+				assert(t, reader.received, uint32(len(data)))
+			},
+		},
+		{
+			name:        "split",
+			description: "the message larger than chunk size is split, and the extended timestamp is in each chunk",
+			method: func(t *testing.T) {
+				payload := bytes.Repeat([]byte{1, 2, 3}, 100)
+				buffer := &bytes.Buffer{}
+				writer := newChunkWriter(buffer)
+				msg := &message{typeID: msgVideo, streamID: 1, timestamp: 0x1000000, data: payload}
+				assert(t, writer.writeMessage(csidCommand, msg), nil)
+				// three chunks, with 4 bytes extended timestamp each.
+				assert(t, buffer.Len(), 12+4+300+2*(1+4))
+				reader := newChunkReader(buffer)
+				decoded, err := reader.readMessage()
+				assert(t, err, nil)
+				assert(t, decoded, msg)
+
+				_, err = newChunkReader(bytes.NewReader([]byte{0x44, 0, 0, 0, 0, 0, 1, msgVideo, 0})).readMessage()
+				assert(t, err, ErrInvalidChunk)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
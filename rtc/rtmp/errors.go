@@ -0,0 +1,11 @@
+package rtmp
+
+import "errors"
+
+var (
+	ErrInvalidHandshake = errors.New("invalid rtmp handshake")
+	ErrInvalidChunk     = errors.New("invalid rtmp chunk")
+	ErrInvalidAMF       = errors.New("invalid amf0 data")
+	ErrServerClosed     = errors.New("rtmp server closed")
+	ErrStreamExist      = errors.New("stream already exists")
+)
@@ -0,0 +1,304 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	readTimeout      = 30 * time.Second
+	serverChunkSize  = 4096
+	serverWindowSize = 2500000
+	publishStreamID  = 1
+)
+
+type ServerOption struct {
+	Broker *peer.Broker
+	// OnPublish returns the connection id of the stream, the publishing is rejected if it returns error.
+	// The name is the connection id if it's nil.
+	OnPublish func(app, name string) (string, error)
+	// OnUnpublish is optional, it's called after the stream closed.
+	OnUnpublish func(stream *Stream)
+	MTU         int // 1200 if zero
+}
+
+// Server accepts the rtmp publishers, each stream is a direct connection of the broker,
+// and the subscribers consume its receivers by NewSender as any other connection.
+// Only publishing is supported, the video should be h264, and the audio should be opus by
+// enhanced rtmp, aac is dropped since it's not supported by webrtc.
+type Server struct {
+	option ServerOption
+
+	mutex     sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	streams   map[*Stream]struct{}
+}
+
+func NewServer(option *ServerOption) *Server {
+	s := &Server{
+		option:    *option,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+		streams:   map[*Stream]struct{}{},
+	}
+	if s.option.MTU == 0 {
+		s.option.MTU = defaultMTU
+	}
+	return s
+}
+
+// Serve accepts the connections of the listener until it's closed, it always returns a non-nil error.
+func (s *Server) Serve(lis net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[lis] = struct{}{}
+	s.mutex.Unlock()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			delete(s.listeners, lis)
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a connection until it's closed.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		_ = conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mutex.Unlock()
+
+	sess := &session{server: s, conn: conn, reader: newChunkReader(conn), writer: newChunkWriter(conn)}
+	if err := sess.run(); err != nil {
+		logger.Debug("rtmp connection", conn.RemoteAddr(), "closed:", err)
+	}
+	sess.unpublish()
+	_ = conn.Close()
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+}
+
+func (s *Server) Streams() []*Stream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	streams := make([]*Stream, 0, len(s.streams))
+	for stream := range s.streams {
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// Close stops the listeners and closes the connections, the streams are closed with them.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	for lis := range s.listeners {
+		_ = lis.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mutex.Unlock()
+}
+
+// session is a rtmp connection, it publishes one stream at most.
+type session struct {
+	server *Server
+	conn   net.Conn
+	reader *chunkReader
+	writer *chunkWriter
+	app    string
+	stream *Stream
+	// windowAckSize is set by the peer, the acknowledgement is sent after received it.
+	windowAckSize uint32
+	lastAck       uint32
+}
+
+func (s *session) run() error {
+	_ = s.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := serverHandshake(s.conn); err != nil {
+		return err
+	}
+	_ = s.conn.SetDeadline(time.Time{})
+	for {
+		_ = s.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		msg, err := s.reader.readMessage()
+		if err != nil {
+			return err
+		}
+		if err = s.handleMessage(msg); err != nil {
+			return err
+		}
+		if s.windowAckSize != 0 && s.reader.received-s.lastAck >= s.windowAckSize {
+			s.lastAck = s.reader.received
+			if err = s.writeControl(msgAck, binary.BigEndian.AppendUint32(nil, s.lastAck)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *session) handleMessage(msg *message) error {
+	switch msg.typeID {
+	case msgSetChunkSize:
+		if len(msg.data) < 4 {
+			return ErrInvalidChunk
+		}
+		size := binary.BigEndian.Uint32(msg.data) & 0x7fffffff
+		if size == 0 || size > maxChunkSize {
+			return ErrInvalidChunk
+		}
+		s.reader.chunkSize = size
+	case msgWindowAckSize:
+		if len(msg.data) < 4 {
+			return ErrInvalidChunk
+		}
+		s.windowAckSize = binary.BigEndian.Uint32(msg.data)
+	case msgAbort:
+		if len(msg.data) < 4 {
+			return ErrInvalidChunk
+		}
+		if stream := s.reader.streams[binary.BigEndian.Uint32(msg.data)]; stream != nil {
+			stream.data = nil
+		}
+	case msgCommandAMF0, msgCommandAMF3:
+		data := msg.data
+		// the amf3 command starts with a format byte, the values are amf0 still.
+		if msg.typeID == msgCommandAMF3 && len(data) != 0 {
+			data = data[1:]
+		}
+		values, err := amfDecode(data)
+		if err != nil {
+			return err
+		}
+		return s.handleCommand(msg.streamID, values)
+	case msgVideo:
+		if s.stream != nil {
+			s.stream.onVideo(msg.timestamp, msg.data)
+		}
+	case msgAudio:
+		if s.stream != nil {
+			s.stream.onAudio(msg.timestamp, msg.data)
+		}
+	}
+	// the metadata, acknowledgement, user control and peer bandwidth are ignored.
+	return nil
+}
+
+func (s *session) handleCommand(streamID uint32, values []any) error {
+	if len(values) < 2 {
+		return ErrInvalidAMF
+	}
+	name, _ := values[0].(string)
+	txn, _ := values[1].(float64)
+	switch name {
+	case "connect":
+		if len(values) > 2 {
+			if object, ok := values[2].(amfMap); ok {
+				s.app, _ = object["app"].(string)
+			}
+		}
+		if err := s.writeControl(msgWindowAckSize, binary.BigEndian.AppendUint32(nil, serverWindowSize)); err != nil {
+			return err
+		}
+		// the limit type is dynamic.
+		if err := s.writeControl(msgSetPeerBandwidth, append(binary.BigEndian.AppendUint32(nil, serverWindowSize), 2)); err != nil {
+			return err
+		}
+		if err := s.writeControl(msgSetChunkSize, binary.BigEndian.AppendUint32(nil, serverChunkSize)); err != nil {
+			return err
+		}
+		s.writer.chunkSize = serverChunkSize
+		return s.writeCommand(0, "_result", txn,
+			amfMap{"fmsVer": "FMS/3,0,1,123", "capabilities": 31},
+			amfMap{"level": "status", "code": "NetConnection.Connect.Success", "description": "Connection succeeded.", "objectEncoding": 0},
+		)
+	case "releaseStream", "FCPublish":
+		return s.writeCommand(0, "_result", txn, nil)
+	case "createStream":
+		return s.writeCommand(0, "_result", txn, nil, publishStreamID)
+	case "publish":
+		if len(values) < 4 {
+			return ErrInvalidAMF
+		}
+		streamName, _ := values[3].(string)
+		return s.publish(streamID, streamName)
+	case "FCUnpublish", "deleteStream", "closeStream":
+		s.unpublish()
+	}
+	return nil
+}
+
+func (s *session) publish(streamID uint32, name string) error {
+	if s.stream != nil {
+		return s.onStatus(streamID, "error", "NetStream.Publish.BadName", "Already publishing")
+	}
+	id := name
+	var err error
+	if s.server.option.OnPublish != nil {
+		id, err = s.server.option.OnPublish(s.app, name)
+	}
+	if err == nil {
+		s.stream, err = newStream(s.server.option.Broker, id, s.app, name, s.server.option.MTU)
+	}
+	if err != nil {
+		_ = s.onStatus(streamID, "error", "NetStream.Publish.BadName", err.Error())
+		// the publisher is disconnected, some clients keep sending data after the error.
+		return err
+	}
+	s.server.mutex.Lock()
+	s.server.streams[s.stream] = struct{}{}
+	s.server.mutex.Unlock()
+	return s.onStatus(streamID, "status", "NetStream.Publish.Start", "Start publishing")
+}
+
+func (s *session) unpublish() {
+	if s.stream == nil {
+		return
+	}
+	stream := s.stream
+	s.stream = nil
+	stream.Close()
+	s.server.mutex.Lock()
+	delete(s.server.streams, stream)
+	s.server.mutex.Unlock()
+	if s.server.option.OnUnpublish != nil {
+		s.server.option.OnUnpublish(stream)
+	}
+}
+
+func (s *session) writeControl(typeID uint8, data []byte) error {
+	return s.writer.writeMessage(csidControl, &message{typeID: typeID, data: data})
+}
+
+func (s *session) writeCommand(streamID uint32, values ...any) error {
+	return s.writer.writeMessage(csidCommand, &message{typeID: msgCommandAMF0, streamID: streamID, data: amfEncode(values...)})
+}
+
+func (s *session) onStatus(streamID uint32, level, code, description string) error {
+	return s.writeCommand(streamID, "onStatus", 0, nil, amfMap{"level": level, "code": code, "description": description})
+}
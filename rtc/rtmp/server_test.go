@@ -0,0 +1,247 @@
+package rtmp
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtp"
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0x40, 0x16, 0xe8}
+	testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+	testIDR = []byte{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}
+	testP   = []byte{0x41, 0x9a, 0x24, 0x6c}
+)
+
+// testClient publishes by the simple handshake.
+type testClient struct {
+	conn   net.Conn
+	reader *chunkReader
+	writer *chunkWriter
+}
+
+func newTestClient(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	assert(t, err, nil)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	c0c1 := make([]byte, 1+handshakeSize)
+	c0c1[0] = rtmpVersion
+	_, err = conn.Write(c0c1)
+	assert(t, err, nil)
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	_, err = io.ReadFull(conn, s0s1s2)
+	assert(t, err, nil)
+	assert(t, s0s1s2[1+handshakeSize:], c0c1[1:])
+	_, err = conn.Write(s0s1s2[1 : 1+handshakeSize])
+	assert(t, err, nil)
+	return &testClient{conn: conn, reader: newChunkReader(conn), writer: newChunkWriter(conn)}
+}
+
+func (c *testClient) command(t *testing.T, streamID uint32, values ...any) {
+	assert(t, c.writer.writeMessage(csidCommand, &message{typeID: msgCommandAMF0, streamID: streamID, data: amfEncode(values...)}), nil)
+}
+
+// result returns the values of next command, the control messages are handled.
+func (c *testClient) result(t *testing.T) []any {
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		msg, err := c.reader.readMessage()
+		assert(t, err, nil)
+		switch msg.typeID {
+		case msgSetChunkSize:
+			c.reader.chunkSize = binary.BigEndian.Uint32(msg.data)
+		case msgCommandAMF0:
+			values, err := amfDecode(msg.data)
+			assert(t, err, nil)
+			return values
+		}
+	}
+}
+
+func (c *testClient) publish(t *testing.T, name string) []any {
+	c.command(t, 0, "connect", 1, amfMap{"app": "live"})
+	values := c.result(t)
+	assert(t, values[0], "_result")
+	assert(t, values[3].(amfMap)["code"], "NetConnection.Connect.Success")
+	c.command(t, 0, "createStream", 2, nil)
+	assert(t, c.result(t), []any{"_result", float64(2), nil, float64(publishStreamID)})
+	c.command(t, publishStreamID, "publish", 3, nil, name, "live")
+	return c.result(t)
+}
+
+func (c *testClient) write(t *testing.T, typeID uint8, timestamp uint32, data []byte) {
+	assert(t, c.writer.writeMessage(4, &message{typeID: typeID, streamID: publishStreamID, timestamp: timestamp, data: data}), nil)
+}
+
+func avcConfig() []byte {
+	data := []byte{0x17, avcSequence, 0, 0, 0, 1, testSPS[1], testSPS[2], testSPS[3], 0xff, 0xe1}
+	data = append(binary.BigEndian.AppendUint16(data, uint16(len(testSPS))), testSPS...)
+	data = append(data, 1)
+	return append(binary.BigEndian.AppendUint16(data, uint16(len(testPPS))), testPPS...)
+}
+
+func avcFrame(keyframe bool, cts uint32, nalus ...[]byte) []byte {
+	data := []byte{0x27, avcNALU, byte(cts >> 16), byte(cts >> 8), byte(cts)}
+	if keyframe {
+		data[0] = 0x17
+	}
+	for _, nalu := range nalus {
+		data = append(binary.BigEndian.AppendUint32(data, uint32(len(nalu))), nalu...)
+	}
+	return data
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("wait timeout")
+}
+
+func newTestServer(t *testing.T, option *ServerOption) (*Server, string) {
+	broker, err := peer.NewBroker(peer.BrokerOption{})
+	assert(t, err, nil)
+	t.Cleanup(broker.Close)
+	option.Broker = broker
+	server := NewServer(option)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err, nil)
+	done := make(chan error)
+	go func() {
+		done <- server.Serve(lis)
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		assert(t, <-done, ErrServerClosed)
+	})
+	return server, lis.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "publish",
+			description: "the h264 and opus are published as receivers, and aac is dropped",
+			method: func(t *testing.T) {
+				unpublished := make(chan *Stream, 1)
+				server, addr := newTestServer(t, &ServerOption{
+					OnPublish: func(app, name string) (string, error) {
+						return app + "-" + name, nil
+					},
+					OnUnpublish: func(stream *Stream) {
+						unpublished <- stream
+					},
+				})
+				broker := server.option.Broker
+				client := newTestClient(t, addr)
+				values := client.publish(t, "key")
+				assert(t, values[3].(amfMap)["code"], "NetStream.Publish.Start")
+				stream := server.Streams()[0]
+				assert(t, stream.App(), "live")
+				assert(t, stream.Name(), "key")
+				assert(t, broker.Connection("live-key"), stream.Connection())
+
+				client.write(t, msgVideo, 0, avcConfig())
+				client.write(t, msgVideo, 0, avcFrame(true, 0, testIDR))
+				// aac sequence header and opus of enhanced rtmp.
+				client.write(t, msgAudio, 0, []byte{0xaf, 0x00, 0x12, 0x10})
+				client.write(t, msgAudio, 0, append([]byte{flvExHeader<<4 | exFrames}, append(fourCCOpus[:], 0xfc, 1)...))
+				waitFor(t, func() bool {
+					return len(stream.Connection().Receivers()) == 2
+				})
+				var parameters map[string]string
+				for _, receiver := range stream.Connection().Receivers() {
+					if receiver.ID() == VideoReceiverID {
+						parameters = receiver.Codec().Parameters
+					}
+				}
+				assert(t, parameters["profile-level-id"], hex.EncodeToString(testSPS[1:4]))
+				assert(t, parameters["sprop-parameter-sets"], base64.StdEncoding.EncodeToString(testSPS)+","+base64.StdEncoding.EncodeToString(testPPS))
+
+				sub, err := broker.NewDirectConnection(&peer.DirectOption{ID: "sub"})
+				assert(t, err, nil)
+				packets := make(chan *rtp.Packet, 10)
+				sub.Transport().(*peer.DirectTransport).OnRTP(func(p *rtp.Packet) {
+					packets <- p
+				})
+				_, err = sub.NewSender(&peer.SenderOption{ConnectionID: "live-key", ReceiverID: VideoReceiverID})
+				assert(t, err, nil)
+				client.write(t, msgVideo, 40, avcFrame(false, 0, testP))
+				client.write(t, msgVideo, 80, avcFrame(true, 40, testIDR))
+				var received []*rtp.Packet
+				for len(received) < 2 {
+					select {
+					case p := <-packets:
+						received = append(received, p)
+					case <-time.After(time.Second):
+						t.Fatal("packet timeout")
+					}
+				}
+				// the sender starts with the keyframe, the parameter sets are inserted before the idr in STAP-A.
+				stapA := append(append([]byte{0x78}, binary.BigEndian.AppendUint16(nil, uint16(len(testSPS)))...), testSPS...)
+				stapA = append(append(stapA, binary.BigEndian.AppendUint16(nil, uint16(len(testPPS)))...), testPPS...)
+				assert(t, received[0].Payload, stapA)
+				assert(t, received[1].Payload, testIDR)
+				assert(t, received[1].Marker, true)
+				assert(t, received[1].Timestamp, received[0].Timestamp)
+
+				stats := stream.Stats()
+				assert(t, stats.VideoFrames, 3)
+				assert(t, stats.AudioFrames, 1)
+				assert(t, stats.DroppedFrames, 1)
+				assert(t, stats.UnsupportedCodecs, []string{codecNameAAC})
+				client.command(t, publishStreamID, "deleteStream", 4, nil, float64(publishStreamID))
+				assert(t, <-unpublished, stream)
+				assert(t, broker.Connection("live-key") == nil, true)
+				assert(t, len(server.Streams()), 0)
+			},
+		},
+		{
+			name:        "rejected",
+			description: "the publisher is disconnected if rejected, or the stream exists",
+			method: func(t *testing.T) {
+				server, addr := newTestServer(t, &ServerOption{
+					OnPublish: func(app, name string) (string, error) {
+						if name != "key" {
+							return "", errors.New("invalid key")
+						}
+						return name, nil
+					},
+				})
+				values := newTestClient(t, addr).publish(t, "bad")
+				assert(t, values[3].(amfMap)["code"], "NetStream.Publish.BadName")
+				assert(t, values[3].(amfMap)["description"], "invalid key")
+
+				client := newTestClient(t, addr)
+				values = client.publish(t, "key")
+				assert(t, values[3].(amfMap)["code"], "NetStream.Publish.Start")
+				values = newTestClient(t, addr).publish(t, "key")
+				assert(t, values[3].(amfMap)["description"], ErrStreamExist.Error())
+
+				// the stream is closed with the connection.
+				_ = client.conn.Close()
+				waitFor(t, func() bool {
+					return len(server.Streams()) == 0 && server.option.Broker.Connection("key") == nil
+				})
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package rtmp
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/codec"
+	"github.com/gotolive/sfu/rtc/codec/h264"
+	"github.com/gotolive/sfu/rtc/codec/opus"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtcp"
+)
+
+const (
+	VideoReceiverID = "video"
+	AudioReceiverID = "audio"
+
+	defaultMTU           = 1200
+	videoPayloadType     = 96
+	audioPayloadType     = 111
+	videoClockRate       = 90000
+	audioClockRate       = 48000
+	senderReportInterval = time.Second
+
+	codecNameAAC = "aac"
+)
+
+// flv tag fields
+const (
+	flvCodecAVC  = 7
+	flvSoundAAC  = 10
+	flvExHeader  = 9 // the sound format of enhanced rtmp
+	flvExVideo   = 0x80
+	avcSequence  = 0
+	avcNALU      = 1
+	exSequence   = 0
+	exFrames     = 1
+	exFramesX    = 3 // no composition time
+	naluTypeIDR  = 5
+	naluTypeSPS  = 7
+	naluTypePPS  = 8
+	naluTypeMask = 0x1f
+)
+
+var (
+	fourCCAVC  = [4]byte{'a', 'v', 'c', '1'}
+	fourCCOpus = [4]byte{'O', 'p', 'u', 's'}
+	startCode  = []byte{0, 0, 0, 1}
+)
+
+// StreamStats counts the frames since published, the frames of unsupported codecs are dropped,
+// such as aac, which needs to be transcoded for webrtc.
+type StreamStats struct {
+	VideoFrames       int      `json:"videoFrames"`
+	AudioFrames       int      `json:"audioFrames"`
+	DroppedFrames     int      `json:"droppedFrames"`
+	UnsupportedCodecs []string `json:"unsupportedCodecs,omitempty"`
+}
+
+// Stream is a published rtmp stream, it's a direct connection of the broker, the video and audio
+// are the receivers of VideoReceiverID and AudioReceiverID, which are created by the first frame.
+type Stream struct {
+	app        string
+	name       string
+	connection *peer.Connection
+	transport  *peer.DirectTransport
+	mtu        int
+	// start maps the rtmp timestamp to wall clock for the sender reports.
+	start time.Time
+	video *track
+	audio *track
+	// the avc decoder configuration.
+	sps        [][]byte
+	pps        [][]byte
+	lengthSize int
+
+	mutex  sync.Mutex
+	stats  StreamStats
+	closed bool
+}
+
+type track struct {
+	receiver   *peer.Receiver
+	packetizer *codec.FramePacketizer
+	ssrc       uint32
+	clockRate  uint32
+	base       uint32
+	packets    uint32
+	octets     uint32
+	lastSR     time.Time
+}
+
+func newStream(broker *peer.Broker, id, app, name string, mtu int) (*Stream, error) {
+	// the broker rejects the existing id too, check it first to return a clear error.
+	if broker.Connection(id) != nil {
+		return nil, ErrStreamExist
+	}
+	connection, err := broker.NewDirectConnection(&peer.DirectOption{ID: id})
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{
+		app:        app,
+		name:       name,
+		connection: connection,
+		transport:  connection.Transport().(*peer.DirectTransport),
+		mtu:        mtu,
+		start:      time.Now(),
+		lengthSize: 4,
+	}, nil
+}
+
+func (s *Stream) App() string {
+	return s.app
+}
+
+// Name is the stream name of publish, it could have query.
+func (s *Stream) Name() string {
+	return s.name
+}
+
+func (s *Stream) Connection() *peer.Connection {
+	return s.connection
+}
+
+func (s *Stream) Stats() StreamStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := s.stats
+	stats.UnsupportedCodecs = append([]string(nil), s.stats.UnsupportedCodecs...)
+	return stats
+}
+
+func (s *Stream) newTrack(id, mid, mediaType string, mediaCodec *peer.Codec) (*track, error) {
+	t := &track{ssrc: rtc.GenerateSSRC(), clockRate: uint32(mediaCodec.ClockRate), base: rtc.GenerateSSRC()}
+	var err error
+	t.packetizer, err = codec.NewFramePacketizer(mediaCodec.EncoderName, uint8(mediaCodec.PayloadType), t.ssrc, s.mtu)
+	if err != nil {
+		return nil, err
+	}
+	t.receiver, err = s.connection.NewReceiver(&peer.ReceiverOption{
+		ID:        id,
+		MID:       mid,
+		MediaType: mediaType,
+		Codec:     mediaCodec,
+		Streams:   []peer.StreamOption{{SSRC: t.ssrc, Cname: s.connection.ID(), PayloadType: mediaCodec.PayloadType}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// unsupported drops the frame and records the codec.
+func (s *Stream) unsupported(name string) {
+	s.stats.DroppedFrames++
+	for _, c := range s.stats.UnsupportedCodecs {
+		if c == name {
+			return
+		}
+	}
+	logger.Warn("rtmp stream", s.connection.ID(), "has unsupported codec:", name)
+	s.stats.UnsupportedCodecs = append(s.stats.UnsupportedCodecs, name)
+}
+
+// onVideo handles the flv video tag, both legacy avc and enhanced rtmp avc1 are supported.
+func (s *Stream) onVideo(timestamp uint32, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || len(data) < 2 {
+		return
+	}
+	var packetType byte
+	if data[0]&flvExVideo != 0 {
+		if len(data) < 5 {
+			return
+		}
+		packetType = data[0] & 0x0f
+		if [4]byte(data[1:5]) != fourCCAVC {
+			s.unsupported(string(data[1:5]))
+			return
+		}
+		data = data[5:]
+		// as the legacy avc packet, the composition time is zero if not present.
+		switch packetType {
+		case exSequence:
+			packetType = avcSequence
+			data = append([]byte{0, 0, 0}, data...)
+		case exFrames:
+			packetType = avcNALU
+		case exFramesX:
+			packetType = avcNALU
+			data = append([]byte{0, 0, 0}, data...)
+		default:
+			return
+		}
+	} else {
+		if data[0]&0x0f != flvCodecAVC {
+			s.unsupported(fmt.Sprint("flv video codec ", data[0]&0x0f))
+			return
+		}
+		packetType = data[1]
+		data = data[2:]
+	}
+	switch packetType {
+	case avcSequence:
+		if len(data) < 3 {
+			return
+		}
+		s.parseAVCConfig(data[3:])
+	case avcNALU:
+		if len(data) < 3 {
+			return
+		}
+		// composition time is signed 24 bits.
+		cts := int32(uint32(data[0])<<16|uint32(data[1])<<8|uint32(data[2])) << 8 >> 8
+		frame := s.annexB(data[3:])
+		if len(frame) == 0 {
+			return
+		}
+		if s.video == nil {
+			var err error
+			s.video, err = s.newTrack(VideoReceiverID, "0", rtc.MediaTypeVideo, &peer.Codec{
+				PayloadType:    videoPayloadType,
+				EncoderName:    h264.CodecName,
+				ClockRate:      videoClockRate,
+				Parameters:     s.h264Parameters(),
+				FeedbackParams: []peer.RtcpFeedback{{Type: "nack"}, {Type: "nack", Parameter: "pli"}},
+			})
+			if err != nil {
+				logger.Error("create video receiver fail:", err)
+				s.unsupported(h264.CodecName)
+				return
+			}
+		}
+		s.stats.VideoFrames++
+		s.writeFrame(s.video, frame, uint32(int64(timestamp)+int64(cts)))
+	}
+}
+
+// h264Parameters returns the fmtp of the video, the profile-level-id and sprop-parameter-sets
+// are of the sequence header, rfc6184#section-8.1.
+func (s *Stream) h264Parameters() map[string]string {
+	parameters := map[string]string{"packetization-mode": "1", "level-asymmetry-allowed": "1"}
+	if len(s.sps) != 0 && len(s.sps[0]) >= 4 {
+		parameters["profile-level-id"] = hex.EncodeToString(s.sps[0][1:4])
+	}
+	sets := make([]string, 0, len(s.sps)+len(s.pps))
+	for _, ps := range append(append([][]byte(nil), s.sps...), s.pps...) {
+		sets = append(sets, base64.StdEncoding.EncodeToString(ps))
+	}
+	if len(sets) != 0 {
+		parameters["sprop-parameter-sets"] = strings.Join(sets, ",")
+	}
+	return parameters
+}
+
+// parseAVCConfig parses the AVCDecoderConfigurationRecord.
+func (s *Stream) parseAVCConfig(data []byte) {
+	if len(data) < 5 {
+		return
+	}
+	lengthSize := int(data[4]&0x03) + 1
+	data = data[5:]
+	// the sps and pps.
+	var sets [2][][]byte
+	for i := range sets {
+		if len(data) < 1 {
+			return
+		}
+		count := int(data[0])
+		if i == 0 {
+			count &= 0x1f
+		}
+		data = data[1:]
+		for j := 0; j < count; j++ {
+			if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+				return
+			}
+			size := int(binary.BigEndian.Uint16(data))
+			sets[i] = append(sets[i], data[2:2+size])
+			data = data[2+size:]
+		}
+	}
+	s.lengthSize, s.sps, s.pps = lengthSize, sets[0], sets[1]
+}
+
+// annexB converts the nal units to annex-b, the parameter sets are inserted before the idr,
+// since the packetizer sends them only if they are in the frame.
+func (s *Stream) annexB(data []byte) []byte {
+	var frame []byte
+	inserted := false
+	for len(data) > s.lengthSize {
+		var size int
+		for _, b := range data[:s.lengthSize] {
+			size = size<<8 | int(b)
+		}
+		data = data[s.lengthSize:]
+		if size == 0 || size > len(data) {
+			break
+		}
+		nalu := data[:size]
+		data = data[size:]
+		switch nalu[0] & naluTypeMask {
+		case naluTypeSPS, naluTypePPS:
+			inserted = true
+		case naluTypeIDR:
+			if !inserted {
+				inserted = true
+				for _, ps := range append(append([][]byte(nil), s.sps...), s.pps...) {
+					frame = append(append(frame, startCode...), ps...)
+				}
+			}
+		}
+		frame = append(append(frame, startCode...), nalu...)
+	}
+	return frame
+}
+
+// onAudio handles the flv audio tag, the opus is by enhanced rtmp, and aac is dropped.
+func (s *Stream) onAudio(timestamp uint32, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || len(data) < 1 {
+		return
+	}
+	switch data[0] >> 4 {
+	case flvExHeader:
+		if len(data) < 5 {
+			return
+		}
+		if [4]byte(data[1:5]) != fourCCOpus {
+			s.unsupported(string(data[1:5]))
+			return
+		}
+		// the sequence start is the OpusHead, it's not needed by rtp.
+		if data[0]&0x0f != exFrames || len(data) == 5 {
+			return
+		}
+		if s.audio == nil {
+			var err error
+			s.audio, err = s.newTrack(AudioReceiverID, "1", rtc.MediaTypeAudio, &peer.Codec{
+				PayloadType: audioPayloadType,
+				EncoderName: opus.CodecName,
+				ClockRate:   audioClockRate,
+				Channels:    2,
+				Parameters:  map[string]string{"minptime": "10", "useinbandfec": "1"},
+			})
+			if err != nil {
+				logger.Error("create audio receiver fail:", err)
+				s.unsupported(opus.CodecName)
+				return
+			}
+		}
+		s.stats.AudioFrames++
+		s.writeFrame(s.audio, data[5:], timestamp)
+	case flvSoundAAC:
+		s.unsupported(codecNameAAC)
+	default:
+		s.unsupported(fmt.Sprint("flv sound format ", data[0]>>4))
+	}
+}
+
+// writeFrame sends the frame of the rtmp timestamp in milliseconds.
+func (s *Stream) writeFrame(t *track, data []byte, timestamp uint32) {
+	ts := t.base + uint32(uint64(timestamp)*uint64(t.clockRate)/1000)
+	for _, packet := range t.packetizer.Packetize(data, ts) {
+		if err := s.transport.WriteRTP(packet); err != nil {
+			logger.Debug("write rtp fail:", err)
+			return
+		}
+		t.packets++
+		t.octets += uint32(len(packet.Payload))
+	}
+	// the sender reports of both tracks are by the same clock for lip sync.
+	now := s.start.Add(time.Duration(timestamp) * time.Millisecond)
+	if now.Sub(t.lastSR) < senderReportInterval {
+		return
+	}
+	t.lastSR = now
+	_ = s.transport.WriteRtcp(&rtcp.SenderReport{
+		SSRC:        t.ssrc,
+		NTPTime:     rtc.ToNtpTime(now),
+		RTPTime:     ts,
+		PacketCount: t.packets,
+		OctetCount:  t.octets,
+	})
+}
+
+// Close closes the connection, the subscribers are notified by the receivers.
+func (s *Stream) Close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	s.mutex.Unlock()
+	s.connection.Close()
+}
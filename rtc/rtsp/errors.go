@@ -0,0 +1,9 @@
+package rtsp
+
+import "errors"
+
+var (
+	ErrInvalidRequest = errors.New("invalid rtsp request")
+	ErrServerClosed   = errors.New("rtsp server closed")
+	ErrPathNotFound   = errors.New("rtsp path not found")
+)
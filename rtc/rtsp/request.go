@@ -0,0 +1,163 @@
+package rtsp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	rtspVersion      = "RTSP/1.0"
+	maxContentLength = 64 * 1024
+)
+
+const (
+	statusOK                   = 200
+	statusBadRequest           = 400
+	statusNotFound             = 404
+	statusSessionNotFound      = 454
+	statusMethodNotValid       = 455
+	statusUnsupportedTransport = 461
+	statusInternalServerError  = 500
+	statusNotImplemented       = 501
+)
+
+var statusText = map[int]string{
+	statusOK:                   "OK",
+	statusBadRequest:           "Bad Request",
+	statusNotFound:             "Not Found",
+	statusSessionNotFound:      "Session Not Found",
+	statusMethodNotValid:       "Method Not Valid in This State",
+	statusUnsupportedTransport: "Unsupported Transport",
+	statusInternalServerError:  "Internal Server Error",
+	statusNotImplemented:       "Not Implemented",
+}
+
+type request struct {
+	method string
+	url    *url.URL
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func readRequest(r *bufio.Reader) (*request, error) {
+	reader := textproto.NewReader(r)
+	line, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || parts[2] != rtspVersion {
+		return nil, ErrInvalidRequest
+	}
+	req := &request{method: parts[0]}
+	if req.url, err = url.Parse(parts[1]); err != nil {
+		return nil, ErrInvalidRequest
+	}
+	if req.header, err = reader.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+	if value := req.header.Get("Content-Length"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 || length > maxContentLength {
+			return nil, ErrInvalidRequest
+		}
+		req.body = make([]byte, length)
+		if _, err = io.ReadFull(r, req.body); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// path returns the path of the url without the leading and trailing slash.
+func (r *request) path() string {
+	return strings.Trim(r.url.Path, "/")
+}
+
+type response struct {
+	status int
+	header [][2]string
+	body   []byte
+}
+
+func newResponse(status int) *response {
+	return &response{status: status}
+}
+
+func (r *response) set(key, value string) *response {
+	r.header = append(r.header, [2]string{key, value})
+	return r
+}
+
+func (r *response) marshal(cseq string) []byte {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %d %s\r\nCSeq: %s\r\n", rtspVersion, r.status, statusText[r.status], cseq)
+	for _, h := range r.header {
+		fmt.Fprintf(b, "%s: %s\r\n", h[0], h[1])
+	}
+	if len(r.body) != 0 {
+		fmt.Fprintf(b, "Content-Length: %d\r\n", len(r.body))
+	}
+	b.WriteString("\r\n")
+	b.Write(r.body)
+	return []byte(b.String())
+}
+
+// transportSpec is the first supported transport of the Transport header.
+type transportSpec struct {
+	tcp         bool
+	channels    [2]int // -1 if not set
+	clientPorts [2]int
+}
+
+func parseTransport(value string) (*transportSpec, bool) {
+	for _, option := range strings.Split(value, ",") {
+		spec := &transportSpec{channels: [2]int{-1, -1}}
+		fields := strings.Split(strings.TrimSpace(option), ";")
+		switch fields[0] {
+		case "RTP/AVP", "RTP/AVP/UDP":
+		case "RTP/AVP/TCP":
+			spec.tcp = true
+		default:
+			continue
+		}
+		supported := true
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "multicast":
+				supported = false
+			case "interleaved":
+				supported = parseRange(value, &spec.channels) && supported
+			case "client_port":
+				supported = parseRange(value, &spec.clientPorts) && supported
+			}
+		}
+		if supported && (spec.tcp || spec.clientPorts[0] != 0) {
+			return spec, true
+		}
+	}
+	return nil, false
+}
+
+// parseRange parses "a-b" or "a", b is a+1 if not present.
+func parseRange(value string, r *[2]int) bool {
+	first, second, found := strings.Cut(value, "-")
+	a, err := strconv.Atoi(first)
+	if err != nil || a < 0 || a > 0xffff {
+		return false
+	}
+	b := a + 1
+	if found {
+		if b, err = strconv.Atoi(second); err != nil || b < 0 || b > 0xffff {
+			return false
+		}
+	}
+	r[0], r[1] = a, b
+	return true
+}
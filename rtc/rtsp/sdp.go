@@ -0,0 +1,52 @@
+package rtsp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+const trackPrefix = "trackID="
+
+// describe generates the sdp of the receivers, the track id is the index of receiver.
+func describe(name string, ip net.IP, receivers []*peer.Receiver) []byte {
+	family := "IP4"
+	if ip.To4() == nil {
+		family = "IP6"
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "v=0\r\no=- %d 1 IN %s %s\r\ns=%s\r\nc=IN %s %s\r\nt=0 0\r\na=control:*\r\n",
+		time.Now().Unix(), family, ip, name, family, ip)
+	for i, receiver := range receivers {
+		codec := receiver.Codec()
+		mediaType := "video"
+		if receiver.MediaType() == rtc.MediaTypeAudio {
+			mediaType = "audio"
+		}
+		fmt.Fprintf(b, "m=%s 0 RTP/AVP %d\r\n", mediaType, codec.PayloadType)
+		fmt.Fprintf(b, "a=rtpmap:%d %s/%d", codec.PayloadType, codec.EncoderName, codec.ClockRate)
+		if codec.Channels > 0 {
+			fmt.Fprintf(b, "/%d", codec.Channels)
+		}
+		b.WriteString("\r\n")
+		if len(codec.Parameters) != 0 {
+			keys := make([]string, 0, len(codec.Parameters))
+			for key := range codec.Parameters {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			parameters := make([]string, 0, len(keys))
+			for _, key := range keys {
+				parameters = append(parameters, key+"="+codec.Parameters[key])
+			}
+			fmt.Fprintf(b, "a=fmtp:%d %s\r\n", codec.PayloadType, strings.Join(parameters, ";"))
+		}
+		fmt.Fprintf(b, "a=control:%s%d\r\n", trackPrefix, i)
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,209 @@
+package rtsp
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+)
+
+const udpBufferSize = 1500
+
+type ServerOption struct {
+	Broker *peer.Broker
+	// RTPAddress and RTCPAddress are the udp addresses of rtp over udp, only interleaved tcp is supported if empty.
+	RTPAddress  string
+	RTCPAddress string
+	// Resolve maps the path to the connection id and receiver ids, all receivers of the connection if nil ids.
+	// It's optional, the path is the connection id, or the connection id and a receiver id by default.
+	Resolve func(path string) (connectionID string, receiverIDs []string, err error)
+}
+
+// Server exposes the receivers of the broker as rtsp paths, each client consumes them
+// by a direct connection, so the ssrc and sequence number are rewritten by the senders.
+// The rtp is sent over udp or interleaved tcp, and the rtcp of client goes back to the senders.
+type Server struct {
+	option   ServerOption
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+
+	mutex     sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	sessions  sync.WaitGroup // the sessions of conns, Close waits them
+	// udpSessions are the sessions by the rtcp address of client.
+	udpSessions map[string]*session
+}
+
+func NewServer(option *ServerOption) (*Server, error) {
+	s := &Server{
+		option:      *option,
+		listeners:   map[net.Listener]struct{}{},
+		conns:       map[net.Conn]struct{}{},
+		udpSessions: map[string]*session{},
+	}
+	if s.option.Resolve == nil {
+		s.option.Resolve = resolvePath
+	}
+	if option.RTPAddress != "" && option.RTCPAddress != "" {
+		var err error
+		if s.rtpConn, err = listenUDP(option.RTPAddress); err != nil {
+			return nil, err
+		}
+		if s.rtcpConn, err = listenUDP(option.RTCPAddress); err != nil {
+			_ = s.rtpConn.Close()
+			return nil, err
+		}
+		// the rtp from client is ignored, it's usually for the nat.
+		go s.readUDP(s.rtpConn, nil)
+		go s.readUDP(s.rtcpConn, s.onRtcp)
+	}
+	return s, nil
+}
+
+func listenUDP(address string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", addr)
+}
+
+// resolvePath is the default resolve, the path is connection id or connection id/receiver id.
+func resolvePath(path string) (string, []string, error) {
+	connectionID, receiverID, found := strings.Cut(path, "/")
+	switch {
+	case connectionID == "" || strings.Contains(receiverID, "/"):
+		return "", nil, ErrPathNotFound
+	case found:
+		return connectionID, []string{receiverID}, nil
+	default:
+		return connectionID, nil, nil
+	}
+}
+
+// receivers resolves the path to the receivers.
+func (s *Server) receivers(path string) ([]*peer.Receiver, string, error) {
+	connectionID, receiverIDs, err := s.option.Resolve(path)
+	if err != nil {
+		return nil, "", err
+	}
+	connection := s.option.Broker.Connection(connectionID)
+	if connection == nil {
+		return nil, "", ErrPathNotFound
+	}
+	all := connection.Receivers()
+	if receiverIDs == nil {
+		if len(all) == 0 {
+			return nil, "", ErrPathNotFound
+		}
+		return all, connectionID, nil
+	}
+	receivers := make([]*peer.Receiver, 0, len(receiverIDs))
+	for _, id := range receiverIDs {
+		var receiver *peer.Receiver
+		for _, r := range all {
+			if r.ID() == id {
+				receiver = r
+			}
+		}
+		if receiver == nil {
+			return nil, "", ErrPathNotFound
+		}
+		receivers = append(receivers, receiver)
+	}
+	return receivers, connectionID, nil
+}
+
+func (s *Server) readUDP(conn *net.UDPConn, callback func(addr *net.UDPAddr, data []byte)) {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if callback != nil {
+			callback(addr, buf[:n])
+		}
+	}
+}
+
+func (s *Server) onRtcp(addr *net.UDPAddr, data []byte) {
+	s.mutex.Lock()
+	sess := s.udpSessions[addr.String()]
+	s.mutex.Unlock()
+	if sess != nil {
+		sess.onRtcp(data)
+	}
+}
+
+// Serve accepts the connections of the listener until it's closed, it always returns a non-nil error.
+func (s *Server) Serve(lis net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[lis] = struct{}{}
+	s.mutex.Unlock()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			delete(s.listeners, lis)
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a connection until it's closed, the session is closed with it.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		_ = conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.sessions.Add(1)
+	s.mutex.Unlock()
+	defer s.sessions.Done()
+
+	sess := newSession(s, conn)
+	if err := sess.run(); err != nil {
+		logger.Debug("rtsp connection", conn.RemoteAddr(), "closed:", err)
+	}
+	sess.close()
+	_ = conn.Close()
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+}
+
+// Close stops the listeners and closes the connections, it returns after the sessions closed their
+// connections of the broker, so the broker could be closed after it.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	for lis := range s.listeners {
+		_ = lis.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mutex.Unlock()
+	if s.rtpConn != nil {
+		_ = s.rtpConn.Close()
+		_ = s.rtcpConn.Close()
+	}
+	s.sessions.Wait()
+}
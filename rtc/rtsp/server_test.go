@@ -0,0 +1,267 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	cseq   int
+}
+
+type testResponse struct {
+	status int
+	header textproto.MIMEHeader
+	body   string
+}
+
+func newTestClient(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	assert(t, err, nil)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return &testClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *testClient) do(t *testing.T, method, url string, headers ...string) *testResponse {
+	c.cseq++
+	request := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\n", method, url, c.cseq)
+	for _, header := range headers {
+		request += header + "\r\n"
+	}
+	_, err := c.conn.Write([]byte(request + "\r\n"))
+	assert(t, err, nil)
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := textproto.NewReader(c.reader)
+	line, err := reader.ReadLine()
+	assert(t, err, nil)
+	fields := strings.Fields(line)
+	res := &testResponse{}
+	res.status, err = strconv.Atoi(fields[1])
+	assert(t, err, nil)
+	res.header, err = reader.ReadMIMEHeader()
+	assert(t, err, nil)
+	assert(t, res.header.Get("CSeq"), strconv.Itoa(c.cseq))
+	if length := res.header.Get("Content-Length"); length != "" {
+		n, _ := strconv.Atoi(length)
+		body := make([]byte, n)
+		_, err = io.ReadFull(c.reader, body)
+		assert(t, err, nil)
+		res.body = string(body)
+	}
+	return res
+}
+
+// readInterleaved returns the channel and data of next interleaved frame.
+func (c *testClient) readInterleaved(t *testing.T) (uint8, []byte) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	header := make([]byte, 4)
+	_, err := io.ReadFull(c.reader, header)
+	assert(t, err, nil)
+	assert(t, header[0], byte('$'))
+	data := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	_, err = io.ReadFull(c.reader, data)
+	assert(t, err, nil)
+	return header[1], data
+}
+
+// newTestServer returns a server of a broker has the connection "pub" with an opus receiver and a h264 receiver.
+func newTestServer(t *testing.T, option *ServerOption) (*Server, *peer.DirectTransport, string) {
+	broker, err := peer.NewBroker(peer.BrokerOption{})
+	assert(t, err, nil)
+	t.Cleanup(broker.Close)
+	conn, err := broker.NewDirectConnection(&peer.DirectOption{ID: "pub"})
+	assert(t, err, nil)
+	_, err = conn.NewReceiver(&peer.ReceiverOption{
+		ID:        "audio",
+		MID:       "0",
+		MediaType: rtc.MediaTypeAudio,
+		Codec:     &peer.Codec{PayloadType: 111, EncoderName: "opus", ClockRate: 48000, Channels: 2, Parameters: map[string]string{"useinbandfec": "1", "minptime": "10"}},
+		Streams:   []peer.StreamOption{{SSRC: 1111, PayloadType: 111}},
+	})
+	assert(t, err, nil)
+	_, err = conn.NewReceiver(&peer.ReceiverOption{
+		ID:        "video",
+		MID:       "1",
+		MediaType: rtc.MediaTypeVideo,
+		Codec:     &peer.Codec{PayloadType: 96, EncoderName: "H264", ClockRate: 90000},
+		Streams:   []peer.StreamOption{{SSRC: 2222, PayloadType: 96}},
+	})
+	assert(t, err, nil)
+
+	option.Broker = broker
+	server, err := NewServer(option)
+	assert(t, err, nil)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err, nil)
+	done := make(chan error)
+	go func() {
+		done <- server.Serve(lis)
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		assert(t, <-done, ErrServerClosed)
+	})
+	return server, conn.Transport().(*peer.DirectTransport), lis.Addr().String()
+}
+
+func audioPacket(seq uint16, payload ...byte) *rtp.Packet {
+	return &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 111, SSRC: 1111, SequenceNumber: seq, Timestamp: uint32(seq) * 960, Marker: true}, Payload: payload}
+}
+
+// testSSRC returns the ssrc of the Transport header.
+func testSSRC(t *testing.T, transport string) uint32 {
+	_, value, found := strings.Cut(transport, "ssrc=")
+	assert(t, found, true)
+	ssrc, err := strconv.ParseUint(value, 16, 32)
+	assert(t, err, nil)
+	return uint32(ssrc)
+}
+
+func TestServer(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "tcp",
+			description: "the receiver is described and relayed by interleaved tcp",
+			method: func(t *testing.T) {
+				_, publisher, addr := newTestServer(t, &ServerOption{})
+				client := newTestClient(t, addr)
+				url := "rtsp://" + addr + "/pub"
+				res := client.do(t, "OPTIONS", url)
+				assert(t, res.status, 200)
+				assert(t, res.header.Get("Public"), publicMethods)
+
+				res = client.do(t, "DESCRIBE", url, "Accept: application/sdp")
+				assert(t, res.status, 200)
+				assert(t, res.header.Get("Content-Base"), url+"/")
+				assert(t, strings.Contains(res.body, "m=audio 0 RTP/AVP 111\r\na=rtpmap:111 opus/48000/2\r\na=fmtp:111 minptime=10;useinbandfec=1\r\na=control:trackID=0\r\n"), true)
+				assert(t, strings.HasSuffix(res.body, "m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:trackID=1\r\n"), true)
+
+				res = client.do(t, "SETUP", url+"/trackID=0", "Transport: RTP/AVP/TCP;unicast;interleaved=0-1")
+				assert(t, res.status, 200)
+				session, _, _ := strings.Cut(res.header.Get("Session"), ";")
+				ssrc := testSSRC(t, res.header.Get("Transport"))
+				assert(t, strings.HasPrefix(res.header.Get("Transport"), "RTP/AVP/TCP;unicast;interleaved=0-1;"), true)
+				// not relayed before play.
+				assert(t, publisher.WriteRTP(audioPacket(1, 1)), nil)
+				res = client.do(t, "PLAY", url+"/", "Session: "+session)
+				assert(t, res.status, 200)
+
+				assert(t, publisher.WriteRTP(audioPacket(2, 2)), nil)
+				assert(t, publisher.WriteRTP(audioPacket(3, 3)), nil)
+				var seq uint16
+				for i, payload := range []byte{2, 3} {
+					channel, data := client.readInterleaved(t)
+					assert(t, channel, uint8(0))
+					packet := &rtp.Packet{}
+					assert(t, packet.Unmarshal(data), nil)
+					assert(t, packet.SSRC, ssrc)
+					assert(t, packet.Payload, []byte{payload})
+					if i != 0 {
+						assert(t, packet.SequenceNumber, seq+1)
+					}
+					seq = packet.SequenceNumber
+				}
+				// the rtcp of client goes to the sender.
+				report := &rtcp.ReceiverReport{SSRC: 1, Reports: []rtcp.ReceptionReport{{SSRC: ssrc}}}
+				data, _ := report.Marshal()
+				_, err := client.conn.Write(append([]byte{'$', 1, 0, byte(len(data))}, data...))
+				assert(t, err, nil)
+
+				res = client.do(t, "TEARDOWN", url+"/", "Session: "+session)
+				assert(t, res.status, 200)
+				res = client.do(t, "PLAY", url+"/", "Session: "+session)
+				assert(t, res.status, 454)
+			},
+		},
+		{
+			name:        "udp",
+			description: "the rtp is sent to the client ports",
+			method: func(t *testing.T) {
+				_, publisher, addr := newTestServer(t, &ServerOption{RTPAddress: "127.0.0.1:0", RTCPAddress: "127.0.0.1:0"})
+				rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				assert(t, err, nil)
+				defer rtpConn.Close()
+				client := newTestClient(t, addr)
+				url := "rtsp://" + addr + "/pub/audio"
+				res := client.do(t, "DESCRIBE", url)
+				assert(t, strings.Count(res.body, "m="), 1)
+				port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+				res = client.do(t, "SETUP", url+"/trackID=0", fmt.Sprintf("Transport: RTP/AVP;unicast;client_port=%d-%d", port, port+1))
+				assert(t, res.status, 200)
+				assert(t, strings.HasPrefix(res.header.Get("Transport"), fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=", port, port+1)), true)
+				session, _, _ := strings.Cut(res.header.Get("Session"), ";")
+				assert(t, client.do(t, "PLAY", url, "Session: "+session).status, 200)
+
+				assert(t, publisher.WriteRTP(audioPacket(1, 9)), nil)
+				_ = rtpConn.SetReadDeadline(time.Now().Add(time.Second))
+				buf := make([]byte, 1500)
+				n, err := rtpConn.Read(buf)
+				assert(t, err, nil)
+				packet := &rtp.Packet{}
+				assert(t, packet.Unmarshal(buf[:n]), nil)
+				assert(t, packet.SSRC, testSSRC(t, res.header.Get("Transport")))
+				assert(t, packet.Payload, []byte{9})
+			},
+		},
+		{
+			name:        "errors",
+			description: "the invalid requests are rejected with the status",
+			method: func(t *testing.T) {
+				_, _, addr := newTestServer(t, &ServerOption{})
+				client := newTestClient(t, addr)
+				url := "rtsp://" + addr + "/pub"
+				assert(t, client.do(t, "DESCRIBE", "rtsp://"+addr+"/none").status, 404)
+				assert(t, client.do(t, "DESCRIBE", url+"/none").status, 404)
+				assert(t, client.do(t, "PLAY", url).status, 455)
+				assert(t, client.do(t, "SETUP", url+"/trackID=2", "Transport: RTP/AVP/TCP;unicast").status, 404)
+				// no udp address.
+				assert(t, client.do(t, "SETUP", url+"/trackID=0", "Transport: RTP/AVP;unicast;client_port=5000-5001").status, 461)
+				assert(t, client.do(t, "SETUP", url+"/trackID=0", "Transport: RTP/AVP;multicast").status, 461)
+				assert(t, client.do(t, "RECORD", url).status, 501)
+				assert(t, client.do(t, "PLAY", url, "Session: 1234").status, 454)
+
+				res := client.do(t, "SETUP", url+"/trackID=1", "Transport: RTP/AVP/TCP;unicast")
+				assert(t, res.status, 200)
+				assert(t, strings.HasPrefix(res.header.Get("Transport"), "RTP/AVP/TCP;unicast;interleaved=0-1;"), true)
+				session, _, _ := strings.Cut(res.header.Get("Session"), ";")
+				assert(t, client.do(t, "SETUP", "rtsp://"+addr+"/pub/audio/trackID=0", "Transport: RTP/AVP/TCP;unicast", "Session: "+session).status, 455)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	// sessionTimeout is told to client, the connection is closed if nothing received in twice of it.
+	sessionTimeout = 60 * time.Second
+	interleaved    = '$'
+	publicMethods  = "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER, SET_PARAMETER"
+)
+
+// track is a setup track, the rtcp channel or port is the next one of rtp.
+type track struct {
+	sender   peer.Sender
+	tcp      bool
+	channel  uint8
+	rtpAddr  *net.UDPAddr
+	rtcpAddr *net.UDPAddr
+}
+
+// session is the rtsp session of a connection, the setup tracks are consumed by a direct connection.
+type session struct {
+	server     *Server
+	conn       net.Conn
+	reader     *bufio.Reader
+	writeMutex sync.Mutex
+
+	mutex      sync.Mutex
+	id         string
+	path       string
+	connection *peer.Connection
+	transport  *peer.DirectTransport
+	tracks     map[uint32]*track // by the ssrc of sender
+	playing    bool
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server: server,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		tracks: map[uint32]*track{},
+	}
+}
+
+func (s *session) run() error {
+	for {
+		_ = s.conn.SetReadDeadline(time.Now().Add(2 * sessionTimeout))
+		b, err := s.reader.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == interleaved {
+			if err = s.readInterleaved(); err != nil {
+				return err
+			}
+			continue
+		}
+		req, err := readRequest(s.reader)
+		if err != nil {
+			return err
+		}
+		res := s.handle(req)
+		if err = s.write(res.marshal(req.header.Get("CSeq"))); err != nil {
+			return err
+		}
+	}
+}
+
+// readInterleaved reads the binary data of client, the rtcp goes to the senders.
+func (s *session) readInterleaved() error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(s.reader, header); err != nil {
+		return err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(s.reader, data); err != nil {
+		return err
+	}
+	if header[1]%2 == 1 {
+		s.onRtcp(data)
+	}
+	return nil
+}
+
+func (s *session) write(data []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err := s.conn.Write(data)
+	return err
+}
+
+func (s *session) handle(req *request) *response {
+	if id := req.header.Get("Session"); id != "" {
+		id, _, _ = strings.Cut(id, ";")
+		s.mutex.Lock()
+		matched := id == s.id
+		s.mutex.Unlock()
+		if !matched {
+			return newResponse(statusSessionNotFound)
+		}
+	}
+	switch req.method {
+	case "OPTIONS":
+		return newResponse(statusOK).set("Public", publicMethods)
+	case "DESCRIBE":
+		return s.describe(req)
+	case "SETUP":
+		return s.setup(req)
+	case "PLAY":
+		return s.play(true)
+	case "PAUSE":
+		return s.play(false)
+	case "TEARDOWN":
+		s.close()
+		return newResponse(statusOK)
+	case "GET_PARAMETER", "SET_PARAMETER":
+		return newResponse(statusOK)
+	default:
+		return newResponse(statusNotImplemented)
+	}
+}
+
+func (s *session) describe(req *request) *response {
+	receivers, _, err := s.server.receivers(req.path())
+	if err != nil {
+		return newResponse(statusNotFound)
+	}
+	base := req.url.String()
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	res := newResponse(statusOK).set("Content-Type", "application/sdp").set("Content-Base", base)
+	ip := net.IPv4zero
+	if addr, ok := s.conn.LocalAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	res.body = describe(req.path(), ip, receivers)
+	return res
+}
+
+func (s *session) setup(req *request) *response {
+	// the url is the content base and the control of track.
+	path := req.path()
+	index := 0
+	if i := strings.LastIndex(path, "/"+trackPrefix); i >= 0 {
+		var err error
+		if index, err = strconv.Atoi(path[i+len(trackPrefix)+1:]); err != nil {
+			return newResponse(statusBadRequest)
+		}
+		path = path[:i]
+	}
+	receivers, connectionID, err := s.server.receivers(path)
+	if err != nil || index < 0 || index >= len(receivers) {
+		return newResponse(statusNotFound)
+	}
+	spec, ok := parseTransport(req.header.Get("Transport"))
+	remote, isTCP := s.conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !spec.tcp && (s.server.rtpConn == nil || !isTCP) {
+		return newResponse(statusUnsupportedTransport)
+	}
+
+	// the session is changed by the requests only, the lock is for the callbacks of transport.
+	if s.path != "" && s.path != path {
+		// the tracks of a session are of the same path.
+		return newResponse(statusMethodNotValid)
+	}
+	if s.connection == nil {
+		id := peer.RandomString(16)
+		connection, err := s.server.option.Broker.NewDirectConnection(&peer.DirectOption{ID: "rtsp-" + id})
+		if err != nil {
+			logger.Error("create rtsp connection fail:", err)
+			return newResponse(statusInternalServerError)
+		}
+		transport := connection.Transport().(*peer.DirectTransport)
+		transport.OnRTP(s.onRTP)
+		transport.OnRtcp(s.onSenderRtcp)
+		s.mutex.Lock()
+		s.id, s.path, s.connection, s.transport = id, path, connection, transport
+		s.mutex.Unlock()
+	}
+	sender, err := s.connection.NewSender(&peer.SenderOption{ConnectionID: connectionID, ReceiverID: receivers[index].ID()})
+	if err != nil {
+		return newResponse(statusNotFound)
+	}
+	t := &track{sender: sender, tcp: spec.tcp}
+	ssrc := sender.Stream().SSRC
+	var transport string
+	if spec.tcp {
+		if spec.channels[0] < 0 {
+			spec.channels = [2]int{2 * len(s.tracks), 2*len(s.tracks) + 1}
+		}
+		t.channel = uint8(spec.channels[0])
+		transport = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d;ssrc=%08X", spec.channels[0], spec.channels[0]+1, ssrc)
+	} else {
+		t.rtpAddr = &net.UDPAddr{IP: remote.IP, Port: spec.clientPorts[0]}
+		t.rtcpAddr = &net.UDPAddr{IP: remote.IP, Port: spec.clientPorts[1]}
+		s.server.mutex.Lock()
+		s.server.udpSessions[t.rtcpAddr.String()] = s
+		s.server.mutex.Unlock()
+		transport = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d;ssrc=%08X",
+			spec.clientPorts[0], spec.clientPorts[1],
+			s.server.rtpConn.LocalAddr().(*net.UDPAddr).Port, s.server.rtcpConn.LocalAddr().(*net.UDPAddr).Port, ssrc)
+	}
+	s.mutex.Lock()
+	s.tracks[ssrc] = t
+	s.mutex.Unlock()
+	return newResponse(statusOK).set("Transport", transport).set("Session", s.sessionHeader())
+}
+
+func (s *session) sessionHeader() string {
+	return fmt.Sprintf("%s;timeout=%d", s.id, int(sessionTimeout.Seconds()))
+}
+
+// play starts or pauses the relay, a keyframe is requested for the video when started.
+func (s *session) play(playing bool) *response {
+	s.mutex.Lock()
+	tracks := make([]*track, 0, len(s.tracks))
+	for _, t := range s.tracks {
+		tracks = append(tracks, t)
+	}
+	s.playing = playing
+	s.mutex.Unlock()
+	if len(tracks) == 0 {
+		return newResponse(statusMethodNotValid)
+	}
+	if playing {
+		for _, t := range tracks {
+			if t.sender.MediaType() == rtc.MediaTypeVideo {
+				t.sender.RequestKeyframe()
+			}
+		}
+	}
+	res := newResponse(statusOK).set("Session", s.sessionHeader())
+	if playing {
+		res.set("Range", "npt=0.000-")
+	}
+	return res
+}
+
+func (s *session) onRTP(packet *rtp.Packet) {
+	s.mutex.Lock()
+	t := s.tracks[packet.SSRC]
+	playing := s.playing
+	s.mutex.Unlock()
+	if t == nil || !playing {
+		return
+	}
+	data, err := packet.Marshal()
+	if err != nil {
+		return
+	}
+	s.send(t, data, false)
+}
+
+// onSenderRtcp relays the sender reports to the client.
+func (s *session) onSenderRtcp(packet rtcp.Packet) {
+	s.mutex.Lock()
+	var t *track
+	for _, ssrc := range packet.DestinationSSRC() {
+		if t = s.tracks[ssrc]; t != nil {
+			break
+		}
+	}
+	playing := s.playing
+	s.mutex.Unlock()
+	if t == nil || !playing {
+		return
+	}
+	data, err := packet.Marshal()
+	if err != nil {
+		return
+	}
+	s.send(t, data, true)
+}
+
+func (s *session) send(t *track, data []byte, isRtcp bool) {
+	var err error
+	switch {
+	case t.tcp:
+		channel := t.channel
+		if isRtcp {
+			channel++
+		}
+		frame := append([]byte{interleaved, channel, 0, 0}, data...)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+		err = s.write(frame)
+	case isRtcp:
+		_, err = s.server.rtcpConn.WriteToUDP(data, t.rtcpAddr)
+	default:
+		_, err = s.server.rtpConn.WriteToUDP(data, t.rtpAddr)
+	}
+	if err != nil {
+		logger.Debug("rtsp send fail:", err)
+	}
+}
+
+// onRtcp forwards the rtcp of client to the senders, such as receiver report and pli.
+func (s *session) onRtcp(data []byte) {
+	packets, err := rtcp.Unmarshal(data)
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	transport := s.transport
+	s.mutex.Unlock()
+	if transport != nil {
+		_ = transport.WriteRtcp(packets...)
+	}
+}
+
+// close closes the direct connection, the session could setup again.
+func (s *session) close() {
+	s.mutex.Lock()
+	connection := s.connection
+	for _, t := range s.tracks {
+		if t.rtcpAddr != nil {
+			s.server.mutex.Lock()
+			if s.server.udpSessions[t.rtcpAddr.String()] == s {
+				delete(s.server.udpSessions, t.rtcpAddr.String())
+			}
+			s.server.mutex.Unlock()
+		}
+	}
+	s.connection, s.transport = nil, nil
+	s.tracks = map[uint32]*track{}
+	s.id, s.path, s.playing = "", "", false
+	s.mutex.Unlock()
+	if connection != nil {
+		connection.Close()
+	}
+}
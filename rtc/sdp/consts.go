@@ -75,6 +75,10 @@ const (
 	ssrcAttributeCname        = "cname"
 	attributeExtmapAllowMixed = "extmap-allow-mixed"
 	attributeExtmap           = "extmap"
+	attributeCryptex          = "cryptex"
+	attributeSctpPort         = "sctp-port"
+	attributeMaxMessageSize   = "max-message-size"
+	attributeCrypto           = "crypto"
 
 	attributeMsidSemantics = "msid-semantic"
 	ssrcAttributeMslabel   = "mslabel"
@@ -115,6 +119,10 @@ const (
 	encryptHeaderExtensions = "urn:ietf:params:rtp-hdrext:encrypt"
 )
 
+const (
+	keyMethodInline = "inline:"
+)
+
 const (
 	mediaProtocolRTPPrefix = "RTP/"
 )
@@ -1,6 +1,7 @@
 package sdp
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"strconv"
@@ -148,6 +149,7 @@ func (u *unmarshaler) parseMline(sdp *SessionDescription) error {
 				return err
 			}
 			desc.Codecs[uint8(pt)] = &Codec{PayloadType: uint8(pt)}
+			desc.Formats = append(desc.Formats, uint8(pt))
 		}
 	}
 	sdp.MediaDescription = append(sdp.MediaDescription, &desc)
@@ -200,6 +202,8 @@ func sessionAttributeParser(line string) parseFunc {
 		return emptyParser
 	case attributeExtmapAllowMixed:
 		return extmapAllowMixedParser
+	case attributeCryptex:
+		return sessionCryptexParser
 	case attributeIceUfrag:
 		return iceUfragParser
 	case attributeIcePwd:
@@ -229,6 +233,14 @@ func mediaAttributeParser(line string) parseFunc {
 	switch attr {
 	case attributeExtmap:
 		return extmapParser
+	case attributeCryptex:
+		return mediaCryptexParser
+	case attributeSctpPort:
+		return sctpPortParser
+	case attributeMaxMessageSize:
+		return maxMessageSizeParser
+	case attributeCrypto:
+		return cryptoParser
 	case attributeRtpmap:
 		return rtpmapParser
 	case attributeFmtp:
@@ -641,6 +653,76 @@ func extmapAllowMixedParser(_ string, description *SessionDescription) error {
 	return nil
 }
 
+// a=cryptex, see rfc9335#section-6
+func sessionCryptexParser(_ string, description *SessionDescription) error {
+	description.Cryptex = true
+	return nil
+}
+
+func mediaCryptexParser(_ string, description *SessionDescription) error {
+	description.MediaDescription[len(description.MediaDescription)-1].Cryptex = true
+	return nil
+}
+
+// a=sctp-port:5000, see rfc8841#section-5
+func sctpPortParser(line string, description *SessionDescription) error {
+	port, err := strconv.Atoi(line[sdpLinePrefixLength+len(attributeSctpPort)+1:])
+	if err != nil {
+		return failParse(line)
+	}
+	description.MediaDescription[len(description.MediaDescription)-1].SctpPort = port
+	return nil
+}
+
+// a=max-message-size:262144, see rfc8841#section-6
+func maxMessageSizeParser(line string, description *SessionDescription) error {
+	size, err := strconv.Atoi(line[sdpLinePrefixLength+len(attributeMaxMessageSize)+1:])
+	if err != nil {
+		return failParse(line)
+	}
+	description.MediaDescription[len(description.MediaDescription)-1].MaxMessageSize = size
+	return nil
+}
+
+// a=crypto:<tag> <crypto-suite> <key-params> *(<session-param>)
+// a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|1:4, see rfc4568#section-9.1
+func cryptoParser(line string, description *SessionDescription) error {
+	fields := strings.Split(line[sdpLinePrefixLength:], sdpDelimiterSpace)
+	if len(fields) < 3 {
+		return failParse(line)
+	}
+	tagStr, err := getValue(line, fields[0], attributeCrypto)
+	if err != nil {
+		return err
+	}
+	tag, err := strconv.Atoi(tagStr)
+	if err != nil {
+		return failParse(line)
+	}
+	// multiple keys are separated by ';', we only take the first one.
+	keyParam := strings.Split(fields[2], ";")[0]
+	if !strings.HasPrefix(keyParam, keyMethodInline) {
+		return failParse(line)
+	}
+	parts := strings.Split(keyParam[len(keyMethodInline):], "|")
+	key, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return failParse(line)
+	}
+	crypto := Crypto{Tag: tag, Suite: fields[1], Key: key, Params: fields[3:]}
+	for _, part := range parts[1:] {
+		// the mki always has a ':', the lifetime never.
+		if strings.Contains(part, ":") {
+			crypto.MKI = part
+		} else {
+			crypto.Lifetime = part
+		}
+	}
+	media := description.MediaDescription[len(description.MediaDescription)-1]
+	media.Cryptos = append(media.Cryptos, crypto)
+	return nil
+}
+
 func rtcpFbParser(line string, description *SessionDescription) error {
 	media := description.MediaDescription[len(description.MediaDescription)-1]
 	if media.MediaType != rtc.MediaTypeAudio && media.MediaType != rtc.MediaTypeVideo {
@@ -2,6 +2,7 @@ package sdp
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -79,6 +80,9 @@ func TestSDPBasic(t *testing.T) {
 	if len(s.MediaDescription[0].Codecs) == 0 || len(s.MediaDescription[1].Codecs) == 0 {
 		t.Fatal("should ok")
 	}
+	if len(s.MediaDescription[1].Formats) != 9 || s.MediaDescription[1].Formats[0] != 96 || s.MediaDescription[1].Formats[8] != 125 {
+		t.Fatal("formats should be in order:", s.MediaDescription[1].Formats)
+	}
 
 	if s.MediaDescription[0].RtcpReducedSize || !s.MediaDescription[1].RtcpReducedSize {
 		t.Fatal("should ok", s.MediaDescription[0].RtcpReducedSize, s.MediaDescription[1].RtcpReducedSize)
@@ -165,3 +169,76 @@ func TestSDPSIM(t *testing.T) {
 		t.Fatal("should be 3", len(media.Streams))
 	}
 }
+
+func TestSDPCryptex(t *testing.T) {
+	b, err := os.ReadFile("../../testdata/sdp/sdp-cryptex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Unmarshal(string(b))
+	if err != nil {
+		t.Fatal("err:", err)
+	}
+	if !s.Cryptex {
+		t.Fatal("session should be cryptex")
+	}
+	if s.MediaDescription[0].Cryptex || !s.MediaDescription[1].Cryptex {
+		t.Fatal("only video has media level cryptex")
+	}
+	if !s.CryptexEnabled() {
+		t.Fatal("cryptex should be enabled by the session level")
+	}
+	s.Cryptex = false
+	if s.CryptexEnabled() {
+		t.Fatal("cryptex should not be enabled if any m-line lacks it")
+	}
+	for _, h := range s.MediaDescription[0].HeaderExtensions {
+		if h.Encrypt != (h.ID == 1) {
+			t.Fatal("only audio level should be encrypted:", h.URI)
+		}
+	}
+}
+
+func TestSDPDataChannel(t *testing.T) {
+	b, err := os.ReadFile("../../testdata/sdp/sdp-datachannel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Unmarshal(string(b))
+	if err != nil {
+		t.Fatal("err:", err)
+	}
+	if len(s.MediaDescription) != 2 {
+		t.Fatal("not expected")
+	}
+	media := s.MediaDescription[1]
+	if media.MediaType != "application" || media.SctpPort != 5000 || media.MaxMessageSize != 262144 {
+		t.Fatal("unmarshal application fail:", media.MediaType, media.SctpPort, media.MaxMessageSize)
+	}
+}
+
+func TestSDPCrypto(t *testing.T) {
+	b, err := os.ReadFile("../../testdata/sdp/sdp-sdes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Unmarshal(string(b))
+	if err != nil {
+		t.Fatal("err:", err)
+	}
+	cryptos := s.MediaDescription[0].Cryptos
+	if len(cryptos) != 2 {
+		t.Fatal("expected two crypto:", len(cryptos))
+	}
+	first := cryptos[0]
+	if first.Tag != 1 || first.Suite != "AES_CM_128_HMAC_SHA1_80" || len(first.Key) != 30 || first.Lifetime != "2^20" || first.MKI != "1:4" {
+		t.Fatal("unmarshal crypto fail:", first)
+	}
+	if cryptos[1].Tag != 2 || len(cryptos[1].Params) != 1 || cryptos[1].Params[0] != "UNENCRYPTED_SRTCP" {
+		t.Fatal("unmarshal crypto params fail:", cryptos[1])
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if first.String() != lines[8] || cryptos[1].String() != lines[9] {
+		t.Fatal("marshal crypto fail:", first.String(), cryptos[1].String())
+	}
+}
@@ -1,6 +1,8 @@
 package sdp
 
 import (
+	"encoding/base64"
+	"fmt"
 	"strconv"
 )
 
@@ -13,6 +15,34 @@ type HeaderExtension struct {
 	ID      uint8
 	Encrypt bool
 }
+
+// Crypto is a=crypto of sdes, see rfc4568#section-9.1.
+// Only the first inline key is kept, the key is master key concatenated with master salt.
+type Crypto struct {
+	Tag      int
+	Suite    string
+	Key      []byte
+	Lifetime string // optional, e.g. 2^31
+	MKI      string // optional, e.g. 1:4
+	Params   []string
+}
+
+// String returns the a=crypto line without CRLF.
+func (c Crypto) String() string {
+	param := keyMethodInline + base64.StdEncoding.EncodeToString(c.Key)
+	if c.Lifetime != "" {
+		param += "|" + c.Lifetime
+	}
+	if c.MKI != "" {
+		param += "|" + c.MKI
+	}
+	line := fmt.Sprintf("a=%s:%d %s %s", attributeCrypto, c.Tag, c.Suite, param)
+	for _, p := range c.Params {
+		line += " " + p
+	}
+	return line
+}
+
 type Fingerprint struct {
 	Algorithm string
 	Value     string
@@ -52,6 +82,7 @@ type TransportInfo struct {
 type SessionDescription struct {
 	ExtmapAllowMixed bool
 	MsidSupported    bool
+	Cryptex          bool // rfc9335, applies to all media
 	TransportInfo    TransportInfo
 	MediaDescription []*MediaDescription
 }
@@ -104,9 +135,14 @@ type MediaDescription struct {
 	MID              string
 	RtcpMux          bool
 	RtcpReducedSize  bool
+	Cryptex          bool
+	SctpPort         int // only for application
+	MaxMessageSize   int // only for application
+	Cryptos          []Crypto
 	Direction        string
 	HeaderExtensions []HeaderExtension
 	Codecs           map[uint8]*Codec
+	Formats          []uint8 // the payload types of m-line, in the order of preference
 	TrackID          string
 	Streams          []StreamParams
 	ssrcInfo         map[uint32]*ssrcInfo
@@ -167,6 +203,20 @@ func (d *MediaDescription) updateSendStreams() error {
 	return nil
 }
 
+// CryptexEnabled returns true if a=cryptex is at the session level or in every m-line,
+// the bundled m-lines share one srtp session so it could not be enabled for some of them.
+func (s *SessionDescription) CryptexEnabled() bool {
+	if s.Cryptex {
+		return true
+	}
+	for _, media := range s.MediaDescription {
+		if !media.Cryptex {
+			return false
+		}
+	}
+	return len(s.MediaDescription) != 0
+}
+
 func (s *SessionDescription) Marshal() (string, error) {
 	return "", nil
 }
@@ -0,0 +1,215 @@
+package signaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gotolive/sfu/rtc/logger"
+)
+
+const defaultClientTimeout = 10 * time.Second
+
+type ClientOption struct {
+	// URL is the endpoint, e.g. wss://example.com/signaling.
+	URL    string
+	Header http.Header
+	// Timeout is the time waiting for the response of a call, 10s by default.
+	Timeout time.Duration
+	// OnNotification is called in the read loop, it should not block.
+	OnNotification func(method string, params json.RawMessage)
+}
+
+// Client is the protocol client, it doesn't create the connections, the sdp are given by the caller.
+type Client struct {
+	option ClientOption
+	conn   *websocket.Conn
+
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	nextID     uint64
+	pending    map[uint64]chan *Message
+	closed     bool
+	done       chan struct{}
+}
+
+// Dial connects the endpoint, the read loop runs until Close or the socket closed.
+func Dial(option *ClientOption) (*Client, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(option.URL, option.Header)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		option:  *option,
+		conn:    conn,
+		pending: map[uint64]chan *Message{},
+		done:    make(chan struct{}),
+	}
+	if c.option.Timeout == 0 {
+		c.option.Timeout = defaultClientTimeout
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *Client) read() {
+	defer func() {
+		c.mutex.Lock()
+		c.closed = true
+		c.pending = map[uint64]chan *Message{}
+		c.mutex.Unlock()
+		close(c.done)
+	}()
+	for {
+		m := &Message{}
+		if err := c.conn.ReadJSON(m); err != nil {
+			logger.Debug("signaling client read stop:", err)
+			return
+		}
+		if m.Method != "" {
+			if c.option.OnNotification != nil {
+				c.option.OnNotification(m.Method, m.Params)
+			}
+			continue
+		}
+		c.mutex.Lock()
+		ch := c.pending[m.ID]
+		delete(c.pending, m.ID)
+		c.mutex.Unlock()
+		if ch != nil {
+			ch <- m
+		}
+	}
+}
+
+// Call sends the request and waits the response, the result is decoded if not nil.
+// The error of response is returned as *Error.
+func (c *Client) Call(method string, params, result any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return ErrClientClosed
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan *Message, 1)
+	c.pending[id] = ch
+	c.mutex.Unlock()
+
+	c.writeMutex.Lock()
+	err = c.conn.WriteJSON(&Message{ID: id, Method: method, Params: data})
+	c.writeMutex.Unlock()
+	if err != nil {
+		c.remove(id)
+		return err
+	}
+
+	timer := time.NewTimer(c.option.Timeout)
+	defer timer.Stop()
+	select {
+	case m := <-ch:
+		if m.Error != nil {
+			return m.Error
+		}
+		if result != nil {
+			return json.Unmarshal(m.Result, result)
+		}
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	case <-timer.C:
+		c.remove(id)
+		return ErrRequestTimeout
+	}
+}
+
+func (c *Client) remove(id uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.pending, id)
+}
+
+// Join joins the room, the version is ProtocolVersion if not given.
+func (c *Client) Join(params *JoinParams) (*JoinResult, error) {
+	p := *params
+	if p.Version == 0 {
+		p.Version = ProtocolVersion
+	}
+	result := &JoinResult{}
+	if err := c.Call(MethodJoin, &p, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Publish sends the offer of publish connection, it returns the answer and the tracks published.
+func (c *Client) Publish(offer string) (*PublishResult, error) {
+	result := &PublishResult{}
+	if err := c.Call(MethodPublish, &SDPParams{SDP: offer}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) Unpublish(track string) error {
+	return c.Call(MethodUnpublish, &UnpublishParams{Track: track}, nil)
+}
+
+func (c *Client) Subscribe(participant, track string) error {
+	return c.Call(MethodSubscribe, &SubscribeParams{Participant: participant, Track: track}, nil)
+}
+
+func (c *Client) Unsubscribe(participant, track string) error {
+	return c.Call(MethodUnsubscribe, &SubscribeParams{Participant: participant, Track: track}, nil)
+}
+
+// Answer sends the answer of the offer notification.
+func (c *Client) Answer(answer string) error {
+	return c.Call(MethodAnswer, &SDPParams{SDP: answer}, nil)
+}
+
+func (c *Client) Trickle(target, candidate string) error {
+	return c.Call(MethodTrickle, &TrickleParams{Target: target, Candidate: candidate}, nil)
+}
+
+func (c *Client) SetLayer(participant, track string, layer int) error {
+	return c.Call(MethodSetLayer, &SubscribeParams{Participant: participant, Track: track, Layer: layer}, nil)
+}
+
+func (c *Client) Mute(track string, muted bool) error {
+	return c.Call(MethodMute, &MuteParams{Track: track, Muted: muted}, nil)
+}
+
+func (c *Client) Stats() (*StatsResult, error) {
+	result := &StatsResult{}
+	if err := c.Call(MethodStats, empty{}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Leave leaves the room, the server closes the socket then.
+func (c *Client) Leave() error {
+	return c.Call(MethodLeave, empty{}, nil)
+}
+
+// Close closes the socket without leave, the participant could be resumed by the token until the resume timeout.
+func (c *Client) Close() error {
+	c.writeMutex.Lock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMutex.Unlock()
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Done is closed after the socket closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
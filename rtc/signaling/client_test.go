@@ -0,0 +1,86 @@
+package signaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newSilentServer returns the url of a server which reads the requests without reply,
+// the notify is sent to the client after the first request.
+func newSilentServer(t *testing.T, notify *Message) string {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+			if notify != nil {
+				_ = conn.WriteJSON(notify)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestClient(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "timeout",
+			description: "the call returns ErrRequestTimeout if no response",
+			method: func(t *testing.T) {
+				notifications := make(chan string, 1)
+				c, err := Dial(&ClientOption{
+					URL:     newSilentServer(t, &Message{Method: NotifyParticipantJoined, Params: json.RawMessage(`{"participant":"bob"}`)}),
+					Timeout: 100 * time.Millisecond,
+					OnNotification: func(method string, params json.RawMessage) {
+						notifications <- method + " " + string(params)
+					},
+				})
+				assert(t, err, nil)
+				defer c.Close()
+				_, err = c.Join(&JoinParams{Room: "room"})
+				assert(t, err, ErrRequestTimeout)
+				assert(t, <-notifications, `participantJoined {"participant":"bob"}`)
+			},
+		},
+		{
+			name:        "closed",
+			description: "the calls fail after the client closed",
+			method: func(t *testing.T) {
+				c, err := Dial(&ClientOption{URL: newSilentServer(t, nil)})
+				assert(t, err, nil)
+				assert(t, c.Close(), nil)
+				<-c.Done()
+				assert(t, c.Mute("0", true), ErrClientClosed)
+			},
+		},
+		{
+			name:        "dial",
+			description: "the dial fails if the endpoint is not websocket",
+			method: func(t *testing.T) {
+				server := httptest.NewServer(http.NotFoundHandler())
+				defer server.Close()
+				c, err := Dial(&ClientOption{URL: "ws" + strings.TrimPrefix(server.URL, "http")})
+				assert(t, err != nil, true)
+				assert(t, c == nil, true)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
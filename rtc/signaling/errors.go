@@ -0,0 +1,52 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the codes of the errors, the negatives are defined by JSON-RPC 2.0, the others are similar to http status.
+const (
+	CodeParseError         = -32700
+	CodeInvalidRequest     = -32600
+	CodeMethodNotFound     = -32601
+	CodeInvalidParams      = -32602
+	CodeInternalError      = -32603
+	CodeBadRequest         = 400
+	CodeUnauthorized       = 401
+	CodeForbidden          = 403
+	CodeNotFound           = 404
+	CodeConflict           = 409
+	CodeNotAcceptable      = 406
+	CodeUnsupportedVersion = 426
+)
+
+var (
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrNotJoined           = errors.New("not joined")
+	ErrAlreadyJoined       = errors.New("already joined")
+	ErrUnsupportedVersion  = errors.New("unsupported protocol version")
+	ErrRoomRequired        = errors.New("room required")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrParticipantNotFound = errors.New("participant not found")
+	ErrInvalidTarget       = errors.New("invalid trickle target")
+	ErrInvalidSDP          = errors.New("invalid sdp")
+	ErrNoMedia             = errors.New("no acceptable media")
+	ErrHandlerClosed       = errors.New("handler closed")
+	ErrClientClosed        = errors.New("client closed")
+	ErrRequestTimeout      = errors.New("request timeout")
+)
+
+// Error is the error of response, it's returned by the client calls too.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func newError(code int, err error) *Error {
+	return &Error{Code: code, Message: err.Error()}
+}
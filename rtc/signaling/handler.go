@@ -0,0 +1,431 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gotolive/sfu/rtc/auth"
+	"github.com/gotolive/sfu/rtc/bwe"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/negotiation"
+	"github.com/gotolive/sfu/rtc/room"
+)
+
+const (
+	maxMessageSize = 64 * 1024
+	writeTimeout   = 10 * time.Second
+
+	defaultPingInterval  = 10 * time.Second
+	defaultPongTimeout   = 30 * time.Second
+	defaultResumeTimeout = 30 * time.Second
+)
+
+type HandlerOption struct {
+	Rooms *room.Manager
+	// RoomOption returns the option of the room created by join, the id is set by the handler.
+	// It's optional, the rooms are closed after the last participant left by default.
+	RoomOption func(id string) *room.Option
+	// Codecs are the accepted encoder names of the publishers, opus, VP8, VP9, H264 and AV1 by default.
+	Codecs []string
+	// BweType is the bwe of the rooms created by default, remb by default.
+	BweType string
+	// PingInterval is 10s and PongTimeout is 30s by default, the socket is closed if no pong in PongTimeout.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+	// ResumeTimeout is how long the participant is kept after the socket closed, 30s by default.
+	ResumeTimeout time.Duration
+	// CheckOrigin checks the origin of upgrade request, all origins are allowed if nil.
+	CheckOrigin func(r *http.Request) bool
+	// Verifier verifies the token of join if not nil, see the package doc.
+	Verifier *auth.Verifier
+}
+
+// Handler serves the signaling protocol over WebSocket, each socket joins a room as a participant.
+type Handler struct {
+	option   HandlerOption
+	upgrader websocket.Upgrader
+
+	mutex    sync.Mutex
+	closed   bool
+	sessions map[string]*session // by resume token
+	byOwner  map[*room.Participant]*session
+	rooms    map[*room.Room]struct{} // the rooms listened
+}
+
+func NewHandler(option *HandlerOption) *Handler {
+	h := &Handler{
+		option:   *option,
+		sessions: map[string]*session{},
+		byOwner:  map[*room.Participant]*session{},
+		rooms:    map[*room.Room]struct{}{},
+	}
+	if len(h.option.Codecs) == 0 {
+		h.option.Codecs = negotiation.DefaultCodecs
+	}
+	if h.option.BweType == "" {
+		h.option.BweType = bwe.Remb
+	}
+	if h.option.PingInterval == 0 {
+		h.option.PingInterval = defaultPingInterval
+	}
+	if h.option.PongTimeout == 0 {
+		h.option.PongTimeout = defaultPongTimeout
+	}
+	if h.option.ResumeTimeout == 0 {
+		h.option.ResumeTimeout = defaultResumeTimeout
+	}
+	h.upgrader.CheckOrigin = h.option.CheckOrigin
+	if h.upgrader.CheckOrigin == nil {
+		h.upgrader.CheckOrigin = func(*http.Request) bool {
+			return true
+		}
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied the error.
+		logger.Debug("signaling upgrade fail:", err)
+		return
+	}
+	s := newSocket(conn)
+	conn.SetReadLimit(maxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(h.option.PongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(h.option.PongTimeout))
+	})
+	go s.ping(h.option.PingInterval)
+	h.serve(s)
+}
+
+// serve handles the requests of socket in order until the socket closed.
+func (h *Handler) serve(s *socket) {
+	var current *session
+	defer func() {
+		s.close()
+		if current != nil {
+			current.detach(s)
+		}
+	}()
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			logger.Debug("signaling read stop:", err)
+			return
+		}
+		m := &Message{}
+		if err = json.Unmarshal(data, m); err != nil {
+			s.reply(0, nil, newError(CodeParseError, err))
+			continue
+		}
+		if m.ID == 0 || m.Method == "" {
+			s.reply(m.ID, nil, &Error{Code: CodeInvalidRequest, Message: "id and method required"})
+			continue
+		}
+		if m.Method == MethodJoin {
+			if current != nil {
+				s.reply(m.ID, nil, newError(CodeConflict, ErrAlreadyJoined))
+				continue
+			}
+			current = h.join(s, m)
+			continue
+		}
+		if current == nil {
+			s.reply(m.ID, nil, newError(CodeBadRequest, ErrNotJoined))
+			continue
+		}
+		result, rpcErr := current.handle(m)
+		s.reply(m.ID, result, rpcErr)
+		if m.Method == MethodLeave && rpcErr == nil {
+			current.leave()
+			return
+		}
+	}
+}
+
+// join replies the join request, it returns the session attached to the socket, nil if failed.
+func (h *Handler) join(s *socket, m *Message) *session {
+	params := &JoinParams{}
+	if err := json.Unmarshal(m.Params, params); err != nil {
+		s.reply(m.ID, nil, newError(CodeInvalidParams, err))
+		return nil
+	}
+	if params.Version != ProtocolVersion {
+		s.reply(m.ID, nil, newError(CodeUnsupportedVersion, ErrUnsupportedVersion))
+		return nil
+	}
+	var (
+		current *session
+		err     error
+	)
+	if params.ResumeToken != "" {
+		current, err = h.resume(params.ResumeToken)
+	} else {
+		current, err = h.newSession(params)
+	}
+	if err != nil {
+		s.reply(m.ID, nil, newError(errorCode(err), err))
+		return nil
+	}
+	current.attach(s, m.ID)
+	return current
+}
+
+func (h *Handler) resume(token string) (*session, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.sessions[token]
+	if s == nil {
+		return nil, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+func (h *Handler) newSession(params *JoinParams) (*session, error) {
+	if params.Room == "" {
+		return nil, ErrRoomRequired
+	}
+	option := &room.ParticipantOption{ID: params.Participant}
+	if h.option.Verifier != nil {
+		claims, err := h.option.Verifier.Authorize(params.Token, params.Room, auth.ActionJoin)
+		if err != nil {
+			if errors.Is(err, auth.ErrForbidden) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+		if claims.Subject != "" {
+			if option.ID != "" && option.ID != claims.Subject {
+				return nil, fmt.Errorf("%w: participant is not the subject", auth.ErrForbidden)
+			}
+			option.ID = claims.Subject
+		}
+		option.Permission = &room.Permission{CanPublish: claims.Publish, CanSubscribe: claims.Subscribe}
+		option.Authorizer = h.option.Verifier.Authorizer(claims, params.Room)
+	}
+	r, err := h.room(params.Room)
+	if err != nil {
+		return nil, err
+	}
+	p, err := r.Join(option)
+	if err != nil {
+		return nil, err
+	}
+	s := newSession(h, p)
+	h.mutex.Lock()
+	if h.closed {
+		h.mutex.Unlock()
+		p.Leave()
+		return nil, ErrHandlerClosed
+	}
+	h.sessions[s.token] = s
+	h.byOwner[p] = s
+	h.mutex.Unlock()
+	// the subscriptions by policy are negotiated after the join replied.
+	if err = s.start(); err != nil {
+		p.Leave()
+		return nil, err
+	}
+	return s, nil
+}
+
+// room returns the room of id, it's created if not exist, and the events are listened once.
+func (h *Handler) room(id string) (*room.Room, error) {
+	r := h.option.Rooms.Room(id)
+	if r == nil {
+		option := &room.Option{AutoClose: true, BweType: h.option.BweType}
+		if h.option.RoomOption != nil {
+			option = h.option.RoomOption(id)
+		}
+		option.ID = id
+		var err error
+		if r, err = h.option.Rooms.NewRoom(option); errors.Is(err, room.ErrRoomExist) {
+			// created by another join.
+			r = h.option.Rooms.Room(id)
+		}
+		if r == nil {
+			return nil, err
+		}
+	}
+	h.mutex.Lock()
+	_, ok := h.rooms[r]
+	h.rooms[r] = struct{}{}
+	h.mutex.Unlock()
+	if !ok {
+		r.OnEvent(func(event room.Event) {
+			h.onEvent(r, event)
+		})
+	}
+	return r, nil
+}
+
+func (h *Handler) session(p *room.Participant) *session {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.byOwner[p]
+}
+
+func (h *Handler) removeSession(s *session) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.sessions, s.token)
+	delete(h.byOwner, s.participant)
+}
+
+// onEvent notifies the sessions of room, the subscriptions are negotiated by the session of subscriber.
+func (h *Handler) onEvent(r *room.Room, event room.Event) {
+	switch event.Type {
+	case room.ParticipantJoined:
+		h.broadcast(r, event.Participant, NotifyParticipantJoined, &ParticipantParams{Participant: event.Participant.ID()})
+	case room.ParticipantLeft:
+		// the one left is notified too, so it knows the socket is going to close.
+		h.broadcast(r, event.Participant, NotifyParticipantLeft, &ParticipantParams{Participant: event.Participant.ID()})
+		if s := h.session(event.Participant); s != nil {
+			s.notify(NotifyParticipantLeft, &ParticipantParams{Participant: event.Participant.ID()})
+			s.close()
+		}
+	case room.TrackPublished:
+		h.broadcast(r, event.Participant, NotifyTrackPublished, &TrackParams{Track: trackInfo(event.Track)})
+	case room.TrackUnpublished:
+		h.broadcast(r, event.Participant, NotifyTrackUnpublished, &TrackParams{Track: trackInfo(event.Track)})
+	case room.TrackMuted:
+		h.broadcast(r, event.Participant, NotifyTrackMuted, &TrackParams{Track: trackInfo(event.Track)})
+	case room.TrackSubscribed, room.TrackUnsubscribed:
+		if s := h.session(event.Participant); s != nil {
+			s.updateSubscription(event)
+		}
+	case room.RoomClosed:
+		// the listener is kept by the room until closed, so it's added once.
+		h.mutex.Lock()
+		delete(h.rooms, r)
+		h.mutex.Unlock()
+	}
+}
+
+// broadcast notifies the sessions of participants in room except the one.
+func (h *Handler) broadcast(r *room.Room, except *room.Participant, method string, params any) {
+	for _, p := range r.Participants() {
+		if p == except {
+			continue
+		}
+		if s := h.session(p); s != nil {
+			s.notify(method, params)
+		}
+	}
+}
+
+// Close removes all participants joined by the handler, the sockets are closed.
+func (h *Handler) Close() {
+	h.mutex.Lock()
+	h.closed = true
+	sessions := make([]*session, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	h.mutex.Unlock()
+	for _, s := range sessions {
+		s.leave()
+	}
+}
+
+// errorCode maps the errors of room and session to the codes.
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return CodeUnauthorized
+	case errors.Is(err, room.ErrPermissionDenied), errors.Is(err, auth.ErrForbidden):
+		return CodeForbidden
+	case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrParticipantNotFound),
+		errors.Is(err, room.ErrTrackNotFound), errors.Is(err, room.ErrParticipantLeft),
+		errors.Is(err, room.ErrRoomClosed), errors.Is(err, room.ErrNoSubscriber):
+		return CodeNotFound
+	case errors.Is(err, room.ErrParticipantExist), errors.Is(err, room.ErrAlreadySubscribed),
+		errors.Is(err, room.ErrConnectionExist):
+		return CodeConflict
+	case errors.Is(err, ErrNoMedia):
+		return CodeNotAcceptable
+	case errors.Is(err, ErrRoomRequired), errors.Is(err, ErrInvalidTarget), errors.Is(err, ErrInvalidSDP), errors.Is(err, room.ErrSelfSubscribe):
+		return CodeBadRequest
+	default:
+		return CodeInternalError
+	}
+}
+
+// socket is a websocket connection, gorilla websocket allows only one writer at a time.
+type socket struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newSocket(conn *websocket.Conn) *socket {
+	return &socket{conn: conn, done: make(chan struct{})}
+}
+
+func (s *socket) write(m *Message) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_ = s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return s.conn.WriteJSON(m)
+}
+
+func (s *socket) reply(id uint64, result any, rpcErr *Error) {
+	m := &Message{ID: id, Error: rpcErr}
+	if rpcErr == nil {
+		if result == nil {
+			result = empty{}
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			m.Error = newError(CodeInternalError, err)
+		} else {
+			m.Result = data
+		}
+	}
+	if err := s.write(m); err != nil {
+		logger.Debug("signaling reply fail:", err)
+	}
+}
+
+func (s *socket) notify(method string, params any) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		logger.Error("marshal notification fail:", err)
+		return
+	}
+	if err = s.write(&Message{Method: method, Params: data}); err != nil {
+		logger.Debug("signaling notify fail:", err)
+	}
+}
+
+// ping keeps pinging until the socket closed.
+func (s *socket) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close closes the socket, the read of serve fails then.
+func (s *socket) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		_ = s.conn.Close()
+	})
+}
@@ -0,0 +1,444 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gotolive/sfu/rtc/auth"
+	"github.com/gotolive/sfu/rtc/ice"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/room"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+func readSDP(t *testing.T, name string) string {
+	b, err := os.ReadFile("../../testdata/sdp/" + name)
+	assert(t, err, nil)
+	return string(b)
+}
+
+// newTestHandler returns the handler and the websocket url of it.
+func newTestHandler(t *testing.T, option *HandlerOption) (*Handler, string) {
+	broker, err := peer.NewBroker(peer.BrokerOption{ICE: ice.Option{IPs: []string{"127.0.0.1"}}})
+	assert(t, err, nil)
+	option.Rooms = room.NewManager(broker)
+	handler := NewHandler(option)
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		handler.Close()
+		option.Rooms.Close()
+		broker.Close()
+	})
+	return handler, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+type notification struct {
+	method string
+	params json.RawMessage
+}
+
+// dial returns a client and the channel of its notifications.
+func dial(t *testing.T, url string) (*Client, chan notification) {
+	notifications := make(chan notification, 100)
+	c, err := Dial(&ClientOption{URL: url, OnNotification: func(method string, params json.RawMessage) {
+		notifications <- notification{method: method, params: params}
+	}})
+	assert(t, err, nil)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c, notifications
+}
+
+func join(t *testing.T, url, roomID, participant string) (*Client, chan notification, *JoinResult) {
+	c, notifications := dial(t, url)
+	result, err := c.Join(&JoinParams{Room: roomID, Participant: participant})
+	assert(t, err, nil)
+	return c, notifications, result
+}
+
+// next returns the params of next notification, the notifications of other methods are skipped.
+func next(t *testing.T, notifications chan notification, method string, params any) {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case n := <-notifications:
+			if n.method != method {
+				continue
+			}
+			assert(t, json.Unmarshal(n.params, params), nil)
+			return
+		case <-timeout:
+			t.Log("no notification:", method)
+			t.FailNow()
+		}
+	}
+}
+
+func code(err error) int {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code
+	}
+	return 0
+}
+
+func directions(description *sdp.SessionDescription) []string {
+	var result []string
+	for _, media := range description.MediaDescription {
+		result = append(result, media.Direction)
+	}
+	return result
+}
+
+func TestHandler(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "join",
+			description: "the participants and tracks of room are returned, the others are notified",
+			method: func(t *testing.T) {
+				_, url := newTestHandler(t, &HandlerOption{})
+				_, aliceNotifications, alice := join(t, url, "room", "alice")
+				assert(t, alice.Participant, "alice")
+				assert(t, alice.Participants, []string{})
+				assert(t, alice.ResumeToken != "", true)
+
+				_, _, bob := join(t, url, "room", "bob")
+				assert(t, bob.Participants, []string{"alice"})
+				params := &ParticipantParams{}
+				next(t, aliceNotifications, NotifyParticipantJoined, params)
+				assert(t, params.Participant, "bob")
+			},
+		},
+		{
+			name:        "publish",
+			description: "the publish offer is answered, the subscribers receive the offer of the tracks and resolve the dtls role by the answer",
+			method: func(t *testing.T) {
+				handler, url := newTestHandler(t, &HandlerOption{})
+				alice, _, _ := join(t, url, "room", "alice")
+				bob, bobNotifications, _ := join(t, url, "room", "bob")
+
+				result, err := alice.Publish(readSDP(t, "sdp-3"))
+				assert(t, err, nil)
+				assert(t, len(result.Tracks), 2)
+				answer, err := sdp.Unmarshal(result.SDP)
+				assert(t, err, nil)
+				assert(t, directions(answer), []string{"recvonly", "recvonly"})
+
+				track := &TrackParams{}
+				next(t, bobNotifications, NotifyTrackPublished, track)
+				assert(t, track.Track.Participant, "alice")
+				// the offer is sent for each subscription, the last one has both.
+				offer := &SDPParams{}
+				next(t, bobNotifications, NotifyOffer, offer)
+				next(t, bobNotifications, NotifyOffer, offer)
+				description, err := sdp.Unmarshal(offer.SDP)
+				assert(t, err, nil)
+				assert(t, directions(description), []string{"sendonly", "sendonly"})
+				assert(t, description.TransportInfo.ConnectionRole, "actpass")
+				assert(t, bob.Answer(readSDP(t, "sdp-answer")), nil)
+				// the answer is active, the subscriber offered actpass is passive.
+				subscriber := handler.option.Rooms.Room("room").Participant("bob").Subscriber()
+				assert(t, subscriber.Transport().Info().DtlsInfo.Role, "passive")
+				// the answer of renegotiation keeps the dtls.
+				assert(t, bob.Answer(readSDP(t, "sdp-answer")), nil)
+
+				// publish again keeps the tracks.
+				again, err := alice.Publish(readSDP(t, "sdp-3"))
+				assert(t, err, nil)
+				assert(t, again.Tracks, result.Tracks)
+			},
+		},
+		{
+			name:        "unpublish",
+			description: "the m-line of unpublished track is rejected in the next offer",
+			method: func(t *testing.T) {
+				_, url := newTestHandler(t, &HandlerOption{})
+				alice, _, _ := join(t, url, "room", "alice")
+				_, bobNotifications, _ := join(t, url, "room", "bob")
+				_, err := alice.Publish(readSDP(t, "sdp-3"))
+				assert(t, err, nil)
+				offer := &SDPParams{}
+				next(t, bobNotifications, NotifyOffer, offer)
+				next(t, bobNotifications, NotifyOffer, offer)
+
+				assert(t, alice.Unpublish("0"), nil)
+				// the subscription is removed before the track unpublished.
+				next(t, bobNotifications, NotifyOffer, offer)
+				track := &TrackParams{}
+				next(t, bobNotifications, NotifyTrackUnpublished, track)
+				assert(t, track.Track.ID, "0")
+				description, err := sdp.Unmarshal(offer.SDP)
+				assert(t, err, nil)
+				assert(t, len(description.MediaDescription), 2)
+				assert(t, strings.Contains(offer.SDP, "m=audio 0 "), true)
+				assert(t, code(alice.Unpublish("0")), CodeNotFound)
+			},
+		},
+		{
+			name:        "manual",
+			description: "the tracks are subscribed by request if the room doesn't subscribe automatically",
+			method: func(t *testing.T) {
+				_, url := newTestHandler(t, &HandlerOption{RoomOption: func(string) *room.Option {
+					return &room.Option{Policy: room.SubscribeNone, AutoClose: true}
+				}})
+				alice, _, _ := join(t, url, "room", "alice")
+				bob, bobNotifications, _ := join(t, url, "room", "bob")
+				_, err := alice.Publish(readSDP(t, "sdp-3"))
+				assert(t, err, nil)
+
+				assert(t, bob.Subscribe("alice", "1"), nil)
+				offer := &SDPParams{}
+				next(t, bobNotifications, NotifyOffer, offer)
+				description, err := sdp.Unmarshal(offer.SDP)
+				assert(t, err, nil)
+				assert(t, description.MediaDescription[0].MediaType, "video")
+				assert(t, code(bob.Subscribe("alice", "1")), CodeConflict)
+				assert(t, bob.SetLayer("alice", "1", 1), nil)
+				assert(t, code(bob.SetLayer("alice", "0", 1)), CodeNotFound)
+
+				assert(t, bob.Unsubscribe("alice", "1"), nil)
+				next(t, bobNotifications, NotifyOffer, offer)
+				assert(t, strings.Contains(offer.SDP, "m=video 0 "), true)
+			},
+		},
+		{
+			name:        "errors",
+			description: "the invalid requests are replied with the codes",
+			method: func(t *testing.T) {
+				_, url := newTestHandler(t, &HandlerOption{})
+				c, _ := dial(t, url)
+				assert(t, code(c.Mute("0", true)), CodeBadRequest)
+				_, err := c.Join(&JoinParams{Version: 2, Room: "room"})
+				assert(t, code(err), CodeUnsupportedVersion)
+				_, err = c.Join(&JoinParams{})
+				assert(t, code(err), CodeBadRequest)
+				_, err = c.Join(&JoinParams{ResumeToken: "unknown"})
+				assert(t, code(err), CodeNotFound)
+
+				_, err = c.Join(&JoinParams{Room: "room", Participant: "alice"})
+				assert(t, err, nil)
+				_, err = c.Join(&JoinParams{Room: "room"})
+				assert(t, code(err), CodeConflict)
+				assert(t, code(c.Call("unknown", empty{}, nil)), CodeMethodNotFound)
+				assert(t, code(c.Call(MethodMute, "invalid", nil)), CodeInvalidParams)
+				assert(t, code(c.Subscribe("nobody", "0")), CodeNotFound)
+				assert(t, code(c.Trickle("unknown", "")), CodeBadRequest)
+				_, err = c.Publish("invalid")
+				assert(t, code(err), CodeBadRequest)
+				_, err = c.Publish(readSDP(t, "sdp-1"))
+				assert(t, code(err), CodeNotAcceptable)
+
+				other, _ := dial(t, url)
+				_, err = other.Join(&JoinParams{Room: "room", Participant: "alice"})
+				assert(t, code(err), CodeConflict)
+			},
+		},
+		{
+			name:        "permission",
+			description: "the participant could not publish if the room denies",
+			method: func(t *testing.T) {
+				handler, url := newTestHandler(t, &HandlerOption{})
+				alice, _, _ := join(t, url, "room", "alice")
+				p := handler.option.Rooms.Room("room").Participant("alice")
+				p.SetPermission(room.Permission{CanSubscribe: true})
+				_, err := alice.Publish(readSDP(t, "sdp-3"))
+				assert(t, code(err), CodeForbidden)
+			},
+		},
+		{
+			name:        "jwt",
+			description: "the join is verified by the token, the grants are the permissions of participant",
+			method: func(t *testing.T) {
+				secret := []byte("secret")
+				verifier, err := auth.NewVerifier(&auth.VerifierOption{Secret: secret})
+				assert(t, err, nil)
+				_, url := newTestHandler(t, &HandlerOption{Verifier: verifier})
+				sign := func(claims *auth.Claims) string {
+					claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+					token, err := auth.SignHS256(claims, secret)
+					assert(t, err, nil)
+					return token
+				}
+				c, _ := dial(t, url)
+				_, err = c.Join(&JoinParams{Room: "room"})
+				assert(t, code(err), CodeUnauthorized)
+				_, err = c.Join(&JoinParams{Room: "room", Token: sign(&auth.Claims{Subject: "alice", Room: "other"})})
+				assert(t, code(err), CodeForbidden)
+				_, err = c.Join(&JoinParams{Room: "room", Participant: "bob", Token: sign(&auth.Claims{Subject: "alice", Room: "room"})})
+				assert(t, code(err), CodeForbidden)
+
+				token := sign(&auth.Claims{Subject: "alice", Room: "room", Publish: true, Kinds: []string{"audio"}})
+				result, err := c.Join(&JoinParams{Room: "room", Token: token})
+				assert(t, err, nil)
+				assert(t, result.Participant, "alice")
+				published, err := c.Publish(readSDP(t, "sdp-3"))
+				assert(t, err, nil)
+				assert(t, len(published.Tracks), 1)
+				assert(t, published.Tracks[0].Kind, "audio")
+				assert(t, strings.Contains(published.SDP, "m=video 0 "), true)
+				// no subscribe connection without the grant.
+				stats, err := c.Stats()
+				assert(t, err, nil)
+				assert(t, stats.Subscriber == nil, true)
+
+				viewer, _ := dial(t, url)
+				_, err = viewer.Join(&JoinParams{Room: "room", Token: sign(&auth.Claims{Subject: "bob", Room: "room", Subscribe: true})})
+				assert(t, err, nil)
+				_, err = viewer.Publish(readSDP(t, "sdp-3"))
+				assert(t, code(err), CodeForbidden)
+			},
+		},
+		{
+			name:        "mute",
+			description: "the others are notified of muted tracks, the stats has the connections",
+			method: func(t *testing.T) {
+				_, url := newTestHandler(t, &HandlerOption{})
+				alice, _, _ := join(t, url, "room", "alice")
+				_, bobNotifications, _ := join(t, url, "room", "bob")
+				_, err := alice.Publish(readSDP(t, "sdp-3"))
+				assert(t, err, nil)
+
+				assert(t, alice.Mute("1", true), nil)
+				track := &TrackParams{}
+				next(t, bobNotifications, NotifyTrackMuted, track)
+				assert(t, track.Track.ID, "1")
+				assert(t, track.Track.Muted, true)
+
+				stats, err := alice.Stats()
+				assert(t, err, nil)
+				assert(t, stats.Publisher != nil, true)
+				assert(t, stats.Subscriber != nil, true)
+				assert(t, alice.Trickle(TargetPublisher, "candidate:1 1 udp 1 127.0.0.1 9 typ host"), nil)
+			},
+		},
+		{
+			name:        "resume",
+			description: "the participant is resumed by the token, and left after the resume timeout",
+			method: func(t *testing.T) {
+				_, url := newTestHandler(t, &HandlerOption{ResumeTimeout: 200 * time.Millisecond})
+				alice, _, result := join(t, url, "room", "alice")
+				_, bobNotifications, _ := join(t, url, "room", "bob")
+				assert(t, alice.Close(), nil)
+
+				resumed, _ := dial(t, url)
+				again, err := resumed.Join(&JoinParams{ResumeToken: result.ResumeToken})
+				assert(t, err, nil)
+				assert(t, again.Participant, "alice")
+				assert(t, again.Participants, []string{"bob"})
+
+				// stay longer than the resume timeout.
+				time.Sleep(300 * time.Millisecond)
+				assert(t, resumed.Mute("0", true) != nil, true)
+				assert(t, resumed.Close(), nil)
+				left := &ParticipantParams{}
+				next(t, bobNotifications, NotifyParticipantLeft, left)
+				assert(t, left.Participant, "alice")
+			},
+		},
+		{
+			name:        "leave",
+			description: "the socket is closed after leave",
+			method: func(t *testing.T) {
+				_, url := newTestHandler(t, &HandlerOption{})
+				alice, _, result := join(t, url, "room", "alice")
+				assert(t, alice.Leave(), nil)
+				select {
+				case <-alice.Done():
+				case <-time.After(3 * time.Second):
+					t.FailNow()
+				}
+				c, _ := dial(t, url)
+				_, err := c.Join(&JoinParams{ResumeToken: result.ResumeToken})
+				assert(t, code(err), CodeNotFound)
+			},
+		},
+		{
+			name:        "room kept",
+			description: "the room not closed after the last participant left is listened once",
+			method: func(t *testing.T) {
+				handler, url := newTestHandler(t, &HandlerOption{RoomOption: func(id string) *room.Option {
+					return &room.Option{}
+				}})
+				alice, _, _ := join(t, url, "room", "alice")
+				assert(t, alice.Leave(), nil)
+				<-alice.Done()
+				_, bobNotifications, _ := join(t, url, "room", "bob")
+				join(t, url, "room", "carol")
+				params := &ParticipantParams{}
+				next(t, bobNotifications, NotifyParticipantJoined, params)
+				assert(t, params.Participant, "carol")
+				select {
+				case n := <-bobNotifications:
+					t.Fatal("notified twice:", n.method)
+				case <-time.After(200 * time.Millisecond):
+				}
+
+				r := handler.option.Rooms.Room("room")
+				r.Close()
+				handler.mutex.Lock()
+				_, ok := handler.rooms[r]
+				handler.mutex.Unlock()
+				assert(t, ok, false)
+			},
+		},
+		{
+			name:        "heartbeat",
+			description: "the socket is closed if no pong in the timeout",
+			method: func(t *testing.T) {
+				_, url := newTestHandler(t, &HandlerOption{
+					PingInterval:  50 * time.Millisecond,
+					PongTimeout:   200 * time.Millisecond,
+					ResumeTimeout: 100 * time.Millisecond,
+				})
+				_, bobNotifications, _ := join(t, url, "room", "bob")
+				alice, _, _ := join(t, url, "room", "alice")
+				joined := &ParticipantParams{}
+				next(t, bobNotifications, NotifyParticipantJoined, joined)
+				// the client answers the pings, the socket is kept.
+				time.Sleep(300 * time.Millisecond)
+				_, err := alice.Stats()
+				assert(t, err, nil)
+
+				// the pings are answered only when reading.
+				conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+				assert(t, err, nil)
+				defer conn.Close()
+				params, err := json.Marshal(&JoinParams{Version: ProtocolVersion, Room: "room", Participant: "carol"})
+				assert(t, err, nil)
+				assert(t, conn.WriteJSON(&Message{ID: 1, Method: MethodJoin, Params: params}), nil)
+				next(t, bobNotifications, NotifyParticipantJoined, joined)
+				assert(t, joined.Participant, "carol")
+				left := &ParticipantParams{}
+				next(t, bobNotifications, NotifyParticipantLeft, left)
+				assert(t, left.Participant, "carol")
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package signaling
+
+import "encoding/json"
+
+// ProtocolVersion is the version of the protocol implemented, it's given by join.
+const ProtocolVersion = 1
+
+const (
+	MethodJoin        = "join"
+	MethodPublish     = "publish"
+	MethodUnpublish   = "unpublish"
+	MethodSubscribe   = "subscribe"
+	MethodUnsubscribe = "unsubscribe"
+	MethodAnswer      = "answer"
+	MethodTrickle     = "trickle"
+	MethodSetLayer    = "setLayer"
+	MethodMute        = "mute"
+	MethodStats       = "stats"
+	MethodLeave       = "leave"
+
+	NotifyParticipantJoined = "participantJoined"
+	NotifyParticipantLeft   = "participantLeft"
+	NotifyTrackPublished    = "trackPublished"
+	NotifyTrackUnpublished  = "trackUnpublished"
+	NotifyTrackMuted        = "trackMuted"
+	NotifyOffer             = "offer"
+)
+
+const (
+	TargetPublisher  = "publisher"
+	TargetSubscriber = "subscriber"
+)
+
+// Message is a request, response or notification, see the package doc.
+type Message struct {
+	ID     uint64          `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+type JoinParams struct {
+	Version     int    `json:"version"`
+	Room        string `json:"room"`
+	Participant string `json:"participant,omitempty"`
+	// Token is the JWT, it's required if the handler verifies the tokens.
+	Token string `json:"token,omitempty"`
+	// ResumeToken resumes the participant of a closed socket, the room and participant are ignored.
+	ResumeToken string `json:"resumeToken,omitempty"`
+}
+
+type JoinResult struct {
+	Participant  string      `json:"participant"`
+	ResumeToken  string      `json:"resumeToken"`
+	Participants []string    `json:"participants"`
+	Tracks       []TrackInfo `json:"tracks"`
+}
+
+// TrackInfo is a published track, the id is unique in the participant.
+type TrackInfo struct {
+	Participant string `json:"participant"`
+	ID          string `json:"id"`
+	Kind        string `json:"kind"`
+	Muted       bool   `json:"muted,omitempty"`
+}
+
+type ParticipantParams struct {
+	Participant string `json:"participant"`
+}
+
+type TrackParams struct {
+	Track TrackInfo `json:"track"`
+}
+
+type SDPParams struct {
+	SDP string `json:"sdp"`
+}
+
+type PublishResult struct {
+	SDP    string      `json:"sdp"`
+	Tracks []TrackInfo `json:"tracks"`
+}
+
+type UnpublishParams struct {
+	Track string `json:"track"`
+}
+
+// SubscribeParams identifies a track of other participant, it's for subscribe, unsubscribe and setLayer.
+type SubscribeParams struct {
+	Participant string `json:"participant"`
+	Track       string `json:"track"`
+	// Layer is the simulcast layer for setLayer only.
+	Layer int `json:"layer,omitempty"`
+}
+
+type TrickleParams struct {
+	Target    string `json:"target"`
+	Candidate string `json:"candidate"`
+}
+
+type MuteParams struct {
+	Track string `json:"track"`
+	Muted bool   `json:"muted"`
+}
+
+// ConnectionStats is the stats of a connection, the bitrates are in bps.
+type ConnectionStats struct {
+	ID              string `json:"id"`
+	BytesSent       int64  `json:"bytesSent"`
+	BytesReceived   int64  `json:"bytesReceived"`
+	PacketsReceived int64  `json:"packetsReceived"`
+	SendBitrate     int64  `json:"sendBitrate"`
+	ReceiveBitrate  int64  `json:"receiveBitrate"`
+}
+
+// StatsResult has the stats of the connections created, nil if not created.
+type StatsResult struct {
+	Publisher  *ConnectionStats `json:"publisher,omitempty"`
+	Subscriber *ConnectionStats `json:"subscriber,omitempty"`
+}
+
+type empty struct{}
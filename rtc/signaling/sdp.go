@@ -0,0 +1,68 @@
+package signaling
+
+import (
+	"strings"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/negotiation"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+// writeAnswer generates the answer of the publish offer, receivers are by mid, the m-lines without receiver are rejected.
+// The cryptex is accepted if it's enabled on the connection.
+func writeAnswer(offer *sdp.SessionDescription, info peer.TransportInfo, receivers map[string]*peer.Receiver, cryptex bool) string {
+	b := &strings.Builder{}
+	header := &negotiation.Header{Lite: true, Cryptex: cryptex}
+	for _, media := range offer.MediaDescription {
+		if receivers[media.MID] != nil {
+			header.Mids = append(header.Mids, media.MID)
+		}
+	}
+	negotiation.WriteHeader(b, header)
+	for _, media := range offer.MediaDescription {
+		receiver := receivers[media.MID]
+		if receiver == nil {
+			negotiation.WriteRejected(b, media.MediaType, media.MID, media.Formats)
+			continue
+		}
+		negotiation.WriteAnswerMedia(b, info, media, receiver, nil)
+	}
+	return b.String()
+}
+
+// mline is an m-line of the subscribe offer, the sender is nil after unsubscribed.
+type mline struct {
+	mid         string
+	mediaType   string
+	payloadType rtc.PayloadType
+	sender      peer.Sender
+}
+
+// writeOffer generates the offer of the subscribe connection, the rejected m-lines are kept for the order.
+// The cryptex is always offered, it's enabled if the answer accepts it.
+func writeOffer(info peer.TransportInfo, mlines []*mline) string {
+	b := &strings.Builder{}
+	header := &negotiation.Header{Lite: true, Cryptex: true}
+	for _, m := range mlines {
+		if m.sender != nil {
+			header.Mids = append(header.Mids, m.mid)
+		}
+	}
+	negotiation.WriteHeader(b, header)
+	for _, m := range mlines {
+		if m.sender == nil {
+			negotiation.WriteRejected(b, m.mediaType, m.mid, []uint8{uint8(m.payloadType)})
+			continue
+		}
+		codec := m.sender.Codec()
+		negotiation.WriteMline(b, m.mediaType, codec)
+		negotiation.WriteTransport(b, info, m.mid)
+		negotiation.WriteExtmaps(b, m.sender.HeaderExtensions())
+		b.WriteString("a=sendonly\r\na=rtcp-mux\r\na=rtcp-rsize\r\n")
+		negotiation.WriteCodec(b, codec)
+		negotiation.WriteCandidates(b, info.IceInfo.Candidates)
+		negotiation.WriteSSRC(b, m.sender)
+	}
+	return b.String()
+}
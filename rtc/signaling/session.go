@@ -0,0 +1,407 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/dtls"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/negotiation"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/room"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+// session is a participant joined by signaling, it survives the socket closed until the resume timeout.
+type session struct {
+	handler     *Handler
+	token       string
+	participant *room.Participant
+
+	mutex  sync.Mutex
+	socket *socket // nil if the socket closed
+	joined bool    // the join replied to the socket, the offers are sent after it.
+	// pending is true if the subscriptions changed before joined.
+	pending bool
+	closed  bool
+	timer   *time.Timer // the resume timeout
+	mlines  []*mline
+	tracks  map[*room.Track]*mline
+}
+
+func newSession(h *Handler, p *room.Participant) *session {
+	return &session{
+		handler:     h,
+		token:       peer.RandomString(24),
+		participant: p,
+		tracks:      map[*room.Track]*mline{},
+	}
+}
+
+// start creates the subscribe connection if the participant could subscribe.
+func (s *session) start() error {
+	if !s.participant.Permission().CanSubscribe {
+		return nil
+	}
+	// we are the offerer, rfc8842 requires actpass.
+	_, err := s.participant.NewSubscriber(&peer.WebRTCOption{DtlsOption: dtls.Option{Role: dtls.Actpass}})
+	return err
+}
+
+// attach replies the join by the socket, the socket attached before is closed.
+func (s *session) attach(socket *socket, id uint64) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		socket.reply(id, nil, newError(CodeNotFound, ErrSessionNotFound))
+		return
+	}
+	old := s.socket
+	s.socket, s.joined = socket, false
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	resend := len(s.mlines) != 0
+	s.mutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+	socket.reply(id, s.joinResult(), nil)
+	s.mutex.Lock()
+	s.joined = true
+	send := s.pending || resend
+	s.pending = false
+	s.mutex.Unlock()
+	if send {
+		s.sendOffer()
+	}
+}
+
+// detach starts the resume timeout if the socket is the attached one.
+func (s *session) detach(socket *socket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.socket != socket || s.closed {
+		return
+	}
+	s.socket, s.joined = nil, false
+	s.timer = time.AfterFunc(s.handler.option.ResumeTimeout, s.leave)
+}
+
+func (s *session) joinResult() *JoinResult {
+	result := &JoinResult{
+		Participant:  s.participant.ID(),
+		ResumeToken:  s.token,
+		Participants: []string{},
+		Tracks:       []TrackInfo{},
+	}
+	r := s.participant.Room()
+	for _, p := range r.Participants() {
+		if p != s.participant {
+			result.Participants = append(result.Participants, p.ID())
+		}
+	}
+	for _, t := range r.Tracks() {
+		result.Tracks = append(result.Tracks, trackInfo(t))
+	}
+	return result
+}
+
+func (s *session) notify(method string, params any) {
+	s.mutex.Lock()
+	socket := s.socket
+	s.mutex.Unlock()
+	if socket != nil {
+		socket.notify(method, params)
+	}
+}
+
+// updateSubscription updates the m-lines of the subscribe offer and sends the offer.
+func (s *session) updateSubscription(event room.Event) {
+	sender := s.participant.Sender(event.Track)
+	s.mutex.Lock()
+	switch event.Type {
+	case room.TrackSubscribed:
+		if sender == nil || s.tracks[event.Track] != nil {
+			s.mutex.Unlock()
+			return
+		}
+		m := &mline{mid: sender.MID(), mediaType: sender.MediaType(), payloadType: sender.Codec().PayloadType, sender: sender}
+		s.mlines = append(s.mlines, m)
+		s.tracks[event.Track] = m
+	case room.TrackUnsubscribed:
+		m := s.tracks[event.Track]
+		if m == nil {
+			s.mutex.Unlock()
+			return
+		}
+		m.sender = nil
+		delete(s.tracks, event.Track)
+	}
+	if !s.joined {
+		s.pending = true
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+	s.sendOffer()
+}
+
+func (s *session) sendOffer() {
+	subscriber := s.participant.Subscriber()
+	if subscriber == nil {
+		return
+	}
+	s.mutex.Lock()
+	offer := writeOffer(subscriber.Transport().Info(), s.mlines)
+	socket := s.socket
+	s.mutex.Unlock()
+	if socket != nil {
+		socket.notify(NotifyOffer, &SDPParams{SDP: offer})
+	}
+}
+
+// leave removes the participant, the session is closed by the left event.
+func (s *session) leave() {
+	s.participant.Leave()
+	s.close()
+}
+
+func (s *session) close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	socket := s.socket
+	s.socket = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mutex.Unlock()
+	s.handler.removeSession(s)
+	if socket != nil {
+		socket.close()
+	}
+}
+
+// handle returns the result of request except join.
+func (s *session) handle(m *Message) (any, *Error) {
+	var (
+		result any
+		err    error
+	)
+	switch m.Method {
+	case MethodPublish:
+		params := &SDPParams{}
+		if rpcErr := decode(m, params); rpcErr != nil {
+			return nil, rpcErr
+		}
+		result, err = s.publish(params)
+	case MethodUnpublish:
+		params := &UnpublishParams{}
+		if rpcErr := decode(m, params); rpcErr != nil {
+			return nil, rpcErr
+		}
+		track := s.participant.Track(params.Track)
+		if track == nil {
+			err = room.ErrTrackNotFound
+			break
+		}
+		err = s.participant.Unpublish(track)
+	case MethodSubscribe, MethodUnsubscribe, MethodSetLayer:
+		params := &SubscribeParams{}
+		if rpcErr := decode(m, params); rpcErr != nil {
+			return nil, rpcErr
+		}
+		err = s.subscribe(m.Method, params)
+	case MethodAnswer:
+		params := &SDPParams{}
+		if rpcErr := decode(m, params); rpcErr != nil {
+			return nil, rpcErr
+		}
+		err = s.answer(params)
+	case MethodTrickle:
+		params := &TrickleParams{}
+		if rpcErr := decode(m, params); rpcErr != nil {
+			return nil, rpcErr
+		}
+		if params.Target != TargetPublisher && params.Target != TargetSubscriber {
+			err = ErrInvalidTarget
+			break
+		}
+		// we are ice-lite, the remote candidates are learned by the checks.
+		logger.Debug("ignore trickle candidate:", params.Target, params.Candidate)
+	case MethodMute:
+		params := &MuteParams{}
+		if rpcErr := decode(m, params); rpcErr != nil {
+			return nil, rpcErr
+		}
+		track := s.participant.Track(params.Track)
+		if track == nil {
+			err = room.ErrTrackNotFound
+			break
+		}
+		err = s.participant.SetMuted(track, params.Muted)
+	case MethodStats:
+		result = &StatsResult{
+			Publisher:  connectionStats(s.participant.Publisher()),
+			Subscriber: connectionStats(s.participant.Subscriber()),
+		}
+	case MethodLeave:
+	default:
+		return nil, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %s not found", m.Method)}
+	}
+	if err != nil {
+		return nil, newError(errorCode(err), err)
+	}
+	return result, nil
+}
+
+func decode(m *Message, params any) *Error {
+	if err := json.Unmarshal(m.Params, params); err != nil {
+		return newError(CodeInvalidParams, err)
+	}
+	return nil
+}
+
+// publish answers the offer of publish connection, the m-lines published before are kept,
+// the tracks are unpublished if their m-lines are removed or not sending.
+func (s *session) publish(params *SDPParams) (*PublishResult, error) {
+	offer, err := sdp.Unmarshal(params.SDP)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSDP, err)
+	}
+	p := s.participant
+	publisher := p.Publisher()
+	if publisher == nil {
+		role, err := dtls.NegotiateRole(offer.TransportInfo.ConnectionRole)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSDP, err)
+		}
+		dtlsOption := dtls.Option{Role: role}
+		if fp := offer.TransportInfo.FingerPrint; fp != nil {
+			dtlsOption.Fingerprints = &dtls.Fingerprint{Algorithm: fp.Algorithm, Value: fp.Value}
+		}
+		if publisher, err = p.NewPublisher(&peer.WebRTCOption{DtlsOption: dtlsOption}); err != nil {
+			return nil, err
+		}
+	}
+	receivers := map[string]*peer.Receiver{}
+	offered := map[string]bool{}
+	result := &PublishResult{Tracks: []TrackInfo{}}
+	for _, media := range offer.MediaDescription {
+		offered[media.MID] = true
+		track := p.Track(media.MID)
+		if track != nil && !negotiation.Publishable(media) {
+			_ = p.Unpublish(track)
+			continue
+		}
+		if track == nil {
+			option := negotiation.ReceiverOption(media, s.handler.option.Codecs, s.handler.option.BweType)
+			if option == nil {
+				continue
+			}
+			if track, err = p.Publish(option); err != nil {
+				if errors.Is(err, room.ErrPermissionDenied) {
+					return nil, err
+				}
+				logger.Warnf("participant %s publish %s fail: %v", p.ID(), media.MID, err)
+				continue
+			}
+		}
+		receivers[media.MID] = track.Receiver()
+		result.Tracks = append(result.Tracks, trackInfo(track))
+	}
+	for _, track := range p.Tracks() {
+		if !offered[track.ID()] {
+			_ = p.Unpublish(track)
+		}
+	}
+	if len(receivers) == 0 {
+		return nil, ErrNoMedia
+	}
+	cryptex := negotiation.NegotiateCryptex(publisher, offer)
+	result.SDP = writeAnswer(offer, publisher.Transport().Info(), receivers, cryptex)
+	return result, nil
+}
+
+func (s *session) subscribe(method string, params *SubscribeParams) error {
+	target := s.participant.Room().Participant(params.Participant)
+	if target == nil {
+		return ErrParticipantNotFound
+	}
+	track := target.Track(params.Track)
+	if track == nil {
+		return room.ErrTrackNotFound
+	}
+	switch method {
+	case MethodSubscribe:
+		_, err := s.participant.Subscribe(track)
+		return err
+	case MethodUnsubscribe:
+		return s.participant.Unsubscribe(track)
+	default:
+		sender := s.participant.Sender(track)
+		if sender == nil {
+			return room.ErrTrackNotFound
+		}
+		sender.UpdateLayer(params.Layer)
+		return nil
+	}
+}
+
+// answer checks the answer of subscribe offer, the remote candidates and fingerprint are not used
+// since the server transport is ice-lite and the dtls is not started until the checks arrived.
+// The cryptex offered is enabled if the answer accepts it.
+func (s *session) answer(params *SDPParams) error {
+	subscriber := s.participant.Subscriber()
+	if subscriber == nil {
+		return room.ErrNoSubscriber
+	}
+	answer, err := sdp.Unmarshal(params.SDP)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSDP, err)
+	}
+	// the subscriber offered actpass, the handshake waits for the role of the answer.
+	remote, err := negotiation.RemoteInfo(answer)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSDP, err)
+	}
+	if err = subscriber.SetRemote(remote); err != nil {
+		return err
+	}
+	negotiation.NegotiateCryptex(subscriber, answer)
+	return nil
+}
+
+func trackInfo(t *room.Track) TrackInfo {
+	return TrackInfo{
+		Participant: t.Publisher().ID(),
+		ID:          t.ID(),
+		Kind:        t.MediaType(),
+		Muted:       t.Muted(),
+	}
+}
+
+func connectionStats(conn *peer.Connection) *ConnectionStats {
+	if conn == nil {
+		return nil
+	}
+	stats := conn.Stats()
+	now := time.Now().UnixMilli()
+	return &ConnectionStats{
+		ID:              conn.ID(),
+		BytesSent:       stats.BytesSend(),
+		BytesReceived:   stats.BytesReceived(),
+		PacketsReceived: stats.PacketsReceived(),
+		SendBitrate:     stats.SentBPS(now),
+		ReceiveBitrate:  stats.ReceiveBPS(now),
+	}
+}
@@ -0,0 +1,50 @@
+// Package signaling implements a JSON-RPC style protocol over WebSocket on top of the rooms.
+//
+// Every WebSocket text message is one JSON object. A request has an id, a method and params,
+// the server replies the response with the same id, and either a result or an error:
+//
+//	{"id": 1, "method": "join", "params": {"version": 1, "room": "demo", "participant": "alice"}}
+//	{"id": 1, "result": {"participant": "alice", "resumeToken": "...", "participants": [], "tracks": []}}
+//	{"id": 2, "error": {"code": 404, "message": "track not found"}}
+//
+// A notification has a method and params without id, they are sent by the server only.
+// The ids are chosen by the client, they must be unique among the pending requests.
+//
+// Requests of client:
+//
+//	join         {version, room, participant, token, resumeToken} -> {participant, resumeToken, participants, tracks}
+//	publish      {sdp} -> {sdp, tracks}, sdp is the offer of the publish connection, the result is the answer.
+//	unpublish    {track} -> {}
+//	subscribe    {participant, track} -> {}
+//	unsubscribe  {participant, track} -> {}
+//	answer       {sdp} -> {}, sdp is the answer of the offer notification.
+//	trickle      {target, candidate} -> {}, target is publisher or subscriber.
+//	setLayer     {participant, track, layer} -> {}, selects the simulcast layer of the subscribed track.
+//	mute         {track, muted} -> {}
+//	stats        {} -> {publisher, subscriber}
+//	leave        {} -> {}, the socket is closed after the response.
+//
+// Notifications of server:
+//
+//	participantJoined  {participant}
+//	participantLeft    {participant}
+//	trackPublished     {track}
+//	trackUnpublished   {track}
+//	trackMuted         {track}
+//	offer              {sdp}, the offer of the subscribe connection, it's sent after the subscriptions changed.
+//
+// The publish connection is offered by client, it's renegotiated by publishing the new offer,
+// the m-lines published before must be kept in the same order. The subscribe connection is offered
+// by server, the m-lines are never removed, the one of the unsubscribed track is rejected by port 0.
+// The server is ice-lite and gathers all candidates in the sdp, so the trickle candidates of client
+// are accepted but not used, the client is always the controlling agent.
+//
+// The protocol version is given by join, the server rejects the versions it does not support with 426.
+// The server pings the socket and closes it if no pong received in time. The participant is kept for
+// a while after the socket closed without leave, the client joins with the resume token to resume it,
+// then the offer is sent again if there are subscriptions.
+//
+// If the handler has a verifier, join requires the JWT in token, see the auth package. The participant id
+// is the subject of claims, and the publish and subscribe grants are the permissions of the participant.
+// The join is rejected with 401 if the token is invalid, or 403 if the room is not granted.
+package signaling
@@ -0,0 +1,294 @@
+package whip
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/bwe"
+	"github.com/gotolive/sfu/rtc/negotiation"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+type ClientOption struct {
+	Broker *peer.Broker
+	// URL is the endpoint, e.g. https://example.com/whip/live.
+	URL string
+	// Token is sent as the bearer token if not empty.
+	Token string
+	// HTTPClient is http.DefaultClient by default.
+	HTTPClient *http.Client
+	// Codecs are the encoder names offered by Play, opus, VP8, VP9, H264 and AV1 by default.
+	Codecs []string
+	// BweType is the bwe of the connection, remb by default.
+	BweType string
+	// ConnectTimeout is the time waiting for the connection connected, 30s by default.
+	ConnectTimeout time.Duration
+	// OnStateChange is the callback of Connection.OnStateChange, the Client takes that one.
+	OnStateChange func(state int)
+}
+
+// Client is the session created on a remote endpoint, by Publish or Play.
+// The connection is the offerer, it sends the ice checks to the candidates of answer.
+type Client struct {
+	option     ClientOption
+	connection *peer.Connection
+	resource   string
+	connected  chan struct{}
+	closeOnce  sync.Once
+}
+
+// Publish publishes the receivers of source to the WHIP endpoint, all receivers of source if receivers not given.
+// It returns after the connection connected.
+func Publish(option *ClientOption, source *peer.Connection, receivers ...*peer.Receiver) (*Client, error) {
+	if len(receivers) == 0 {
+		receivers = source.Receivers()
+	}
+	c, err := newClient(option)
+	if err != nil {
+		return nil, err
+	}
+	// the ids of header extensions are shared by all m-lines.
+	ids := map[string]rtc.HeaderExtensionID{bweHeaderURI(c.option.BweType): 1}
+	medias := make([]*mediaOffer, 0, len(receivers))
+	for i, r := range receivers {
+		headers := []rtc.HeaderExtension{{URI: bweHeaderURI(c.option.BweType), ID: 1}}
+		for _, h := range r.HeaderExtensions() {
+			switch h.URI {
+			case rtc.HeaderExtensionRid, rtc.HeaderExtensionRepairedRid, rtc.HeaderExtensionMid,
+				rtc.HeaderExtensionAbsSendTime, rtc.HeaderExtensionTransportSequenceNumber:
+				continue
+			}
+			if _, ok := ids[h.URI]; !ok {
+				ids[h.URI] = rtc.HeaderExtensionID(len(ids) + 1)
+			}
+			headers = append(headers, rtc.HeaderExtension{URI: h.URI, ID: ids[h.URI]})
+		}
+		codec := *r.Codec()
+		codec.FeedbackParams = clientFeedback(r.MediaType(), c.option.BweType)
+		sender, err := c.connection.NewSender(&peer.SenderOption{
+			MID:              strconv.Itoa(i),
+			ConnectionID:     source.ID(),
+			ReceiverID:       r.ID(),
+			Codec:            &codec,
+			HeaderExtensions: headers,
+			SwitchMode:       peer.ManualSwitchLayer,
+		})
+		if err != nil {
+			c.connection.Close()
+			return nil, err
+		}
+		medias = append(medias, &mediaOffer{
+			mediaType: r.MediaType(),
+			mid:       strconv.Itoa(i),
+			direction: "sendonly",
+			codecs:    []*peer.Codec{sender.Codec()},
+			headers:   headers,
+			sender:    sender,
+		})
+	}
+	answer, err := c.offer(medias)
+	if err != nil {
+		return nil, err
+	}
+	for _, media := range answer.MediaDescription {
+		if media.Direction != "inactive" {
+			if err = c.connect(answer); err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+	}
+	_ = c.Close()
+	return nil, ErrNoMedia
+}
+
+// Play plays the stream of the WHEP endpoint, the remote media are the receivers of the connection.
+// It returns after the connection connected.
+func Play(option *ClientOption) (*Client, error) {
+	c, err := newClient(option)
+	if err != nil {
+		return nil, err
+	}
+	answer, err := c.offer(playOffers(c.option.Codecs, c.option.BweType))
+	if err != nil {
+		return nil, err
+	}
+	// the receivers must be ready before the rtp arrived.
+	for _, media := range answer.MediaDescription {
+		receiverOption := negotiation.ReceiverOption(media, c.option.Codecs, c.option.BweType)
+		if receiverOption == nil {
+			continue
+		}
+		if _, err = c.connection.NewReceiver(receiverOption); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	if len(c.connection.Receivers()) == 0 {
+		_ = c.Close()
+		return nil, ErrNoMedia
+	}
+	if err = c.connect(answer); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newClient(option *ClientOption) (*Client, error) {
+	c := &Client{
+		option:    *option,
+		connected: make(chan struct{}),
+	}
+	if c.option.HTTPClient == nil {
+		c.option.HTTPClient = http.DefaultClient
+	}
+	if len(c.option.Codecs) == 0 {
+		c.option.Codecs = negotiation.DefaultCodecs
+	}
+	if c.option.BweType == "" {
+		c.option.BweType = bwe.Remb
+	}
+	if c.option.ConnectTimeout == 0 {
+		c.option.ConnectTimeout = defaultConnectTimeout
+	}
+	connection, err := c.option.Broker.NewWebRTCClientConnection(&peer.WebRTCOption{BweType: c.option.BweType})
+	if err != nil {
+		return nil, err
+	}
+	c.connection = connection
+	connection.OnStateChange(c.onStateChange)
+	return c, nil
+}
+
+func (c *Client) onStateChange(state int) {
+	if state == 1 {
+		close(c.connected)
+	}
+	if c.option.OnStateChange != nil {
+		c.option.OnStateChange(state)
+	}
+}
+
+// offer posts the offer to the endpoint and returns the answer, the connection is closed if failed.
+func (c *Client) offer(medias []*mediaOffer) (*sdp.SessionDescription, error) {
+	answer, err := c.post(writeOffer(c.connection.Transport().Info(), medias))
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return answer, nil
+}
+
+func (c *Client) post(offer string) (*sdp.SessionDescription, error) {
+	res, body, err := c.do(http.MethodPost, c.option.URL, contentTypeSDP, offer)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusCreated {
+		return nil, statusError(res)
+	}
+	location, err := res.Request.URL.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return nil, err
+	}
+	c.resource = location.String()
+	return sdp.Unmarshal(body)
+}
+
+// connect sets the remote by answer and waits for the connection connected.
+func (c *Client) connect(answer *sdp.SessionDescription) error {
+	negotiation.NegotiateCryptex(c.connection, answer)
+	remote, err := negotiation.RemoteInfo(answer)
+	if err == nil {
+		err = c.connection.SetRemote(remote)
+	}
+	if err != nil {
+		_ = c.Close()
+		return err
+	}
+	select {
+	case <-c.connected:
+		return nil
+	case <-time.After(c.option.ConnectTimeout):
+		_ = c.Close()
+		return ErrConnectTimeout
+	}
+}
+
+func (c *Client) do(method, url, contentType, body string) (*http.Response, string, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.option.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.option.Token)
+	}
+	res, err := c.option.HTTPClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxBodySize))
+	if err != nil {
+		return nil, "", err
+	}
+	return res, string(b), nil
+}
+
+// statusError converts the status of endpoint to the error of handler.
+func statusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrStreamNotFound
+	case http.StatusConflict:
+		return ErrStreamExist
+	case http.StatusNotAcceptable:
+		return ErrNoMedia
+	default:
+		return fmt.Errorf("%w: %s", ErrUnexpectedReply, res.Status)
+	}
+}
+
+func (c *Client) Connection() *peer.Connection {
+	return c.connection
+}
+
+// Receivers are the remote media played, only for Play.
+func (c *Client) Receivers() []*peer.Receiver {
+	return c.connection.Receivers()
+}
+
+// Resource is the url of the session on the endpoint.
+func (c *Client) Resource() string {
+	return c.resource
+}
+
+// Close deletes the session on the endpoint and closes the connection.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		// delete it first, or the endpoint may remove the session by the close_notify.
+		if c.resource != "" {
+			var res *http.Response
+			if res, _, err = c.do(http.MethodDelete, c.resource, "", ""); err == nil && res.StatusCode != http.StatusOK {
+				err = statusError(res)
+			}
+		}
+		c.connection.Close()
+	})
+	return err
+}
@@ -0,0 +1,145 @@
+package whip
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/ice"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/pion/rtp"
+)
+
+// newTestSource returns a broker with a direct connection has an opus and a H264 receivers.
+func newTestSource(t *testing.T) (*peer.Broker, *peer.Connection) {
+	broker, err := peer.NewBroker(peer.BrokerOption{ICE: ice.Option{IPs: []string{"127.0.0.1"}}})
+	assert(t, err, nil)
+	t.Cleanup(broker.Close)
+	source, err := broker.NewDirectConnection(&peer.DirectOption{ID: "source"})
+	assert(t, err, nil)
+	_, err = source.NewReceiver(&peer.ReceiverOption{
+		ID:               "audio",
+		MID:              "0",
+		MediaType:        rtc.MediaTypeAudio,
+		Codec:            &peer.Codec{PayloadType: 111, EncoderName: "opus", ClockRate: 48000, Channels: 2, Parameters: map[string]string{"useinbandfec": "1", "minptime": "10"}},
+		HeaderExtensions: []rtc.HeaderExtension{{URI: rtc.HeaderExtensionAudioLevel, ID: 5}, {URI: rtc.HeaderExtensionMid, ID: 6}},
+		Streams:          []peer.StreamOption{{SSRC: 1111, PayloadType: 111}},
+	})
+	assert(t, err, nil)
+	_, err = source.NewReceiver(&peer.ReceiverOption{
+		ID:        "video",
+		MID:       "1",
+		MediaType: rtc.MediaTypeVideo,
+		Codec:     &peer.Codec{PayloadType: 96, EncoderName: "H264", ClockRate: 90000, RTX: 97},
+		Streams:   []peer.StreamOption{{SSRC: 2222, RTX: 3333, PayloadType: 96}},
+	})
+	assert(t, err, nil)
+	return broker, source
+}
+
+func TestClient(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "publish_and_play",
+			description: "the stream published by WHIP client is played by WHEP client",
+			method: func(t *testing.T) {
+				handler, server := newTestHandler(t, &HandlerOption{})
+				broker, source := newTestSource(t)
+
+				states := make(chan int, 10)
+				publisher, err := Publish(&ClientOption{Broker: broker, URL: server.URL + "/whip/live", OnStateChange: func(state int) {
+					states <- state
+				}}, source)
+				assert(t, err, nil)
+				assert(t, <-states, 1)
+				assert(t, len(publisher.Connection().Senders()), 2)
+				remote := handler.Publisher("live")
+				assert(t, len(remote.Receivers()), 2)
+				assert(t, remote.Receivers()[0].Codec().EncoderName, "opus")
+				assert(t, remote.Receivers()[1].Codec().EncoderName, "H264")
+
+				player, err := Play(&ClientOption{Broker: broker, URL: server.URL + "/whep/live", Codecs: []string{"opus", "H264"}})
+				assert(t, err, nil)
+				receivers := player.Receivers()
+				assert(t, len(receivers), 2)
+				assert(t, receivers[0].MediaType(), rtc.MediaTypeAudio)
+				assert(t, receivers[0].Codec().EncoderName, "opus")
+				assert(t, receivers[1].Codec().EncoderName, "H264")
+
+				// the rtp goes through the publisher and player.
+				sink, err := broker.NewDirectConnection(&peer.DirectOption{ID: "sink"})
+				assert(t, err, nil)
+				_, err = sink.NewSender(&peer.SenderOption{ConnectionID: player.Connection().ID(), ReceiverID: receivers[0].ID()})
+				assert(t, err, nil)
+				packets := make(chan *rtp.Packet, 100)
+				sink.Transport().(*peer.DirectTransport).OnRTP(func(packet *rtp.Packet) {
+					packets <- packet
+				})
+				input := source.Transport().(*peer.DirectTransport)
+				var seq uint16
+				timeout := time.After(10 * time.Second)
+				for received := false; !received; {
+					seq++
+					assert(t, input.WriteRTP(&rtp.Packet{
+						Header:  rtp.Header{Version: 2, PayloadType: 111, SSRC: 1111, SequenceNumber: seq, Timestamp: uint32(seq) * 960},
+						Payload: []byte{0xfc, 1, 2, 3},
+					}), nil)
+					select {
+					case packet := <-packets:
+						assert(t, packet.Payload, []byte{0xfc, 1, 2, 3})
+						received = true
+					case <-time.After(20 * time.Millisecond):
+					case <-timeout:
+						t.Log("no rtp received")
+						t.FailNow()
+					}
+				}
+
+				assert(t, player.Close(), nil)
+				assert(t, player.Close(), nil)
+				assert(t, publisher.Close(), nil)
+				assert(t, handler.Publisher("live") == nil, true)
+				assert(t, <-states, 2)
+			},
+		},
+		{
+			name:        "errors",
+			description: "the status of endpoint is converted to the errors",
+			method: func(t *testing.T) {
+				_, server := newTestHandler(t, &HandlerOption{
+					Authorize: func(kind Kind, streamKey, token string) error {
+						if token != "secret" {
+							return ErrUnauthorized
+						}
+						return nil
+					},
+				})
+				broker, source := newTestSource(t)
+				_, err := Publish(&ClientOption{Broker: broker, URL: server.URL + "/whip/live"}, source)
+				assert(t, errors.Is(err, ErrUnauthorized), true)
+				_, err = Play(&ClientOption{Broker: broker, URL: server.URL + "/whep/live", Token: "secret"})
+				assert(t, errors.Is(err, ErrStreamNotFound), true)
+				_, err = Play(&ClientOption{Broker: broker, URL: server.URL + "/other/live", Token: "secret"})
+				assert(t, errors.Is(err, ErrStreamNotFound), true)
+				_, err = Play(&ClientOption{Broker: broker, URL: server.URL + "/whep/live/session", Token: "secret"})
+				assert(t, errors.Is(err, ErrUnexpectedReply), true)
+				// the connections are closed.
+				assert(t, len(broker.Connections()), 1)
+
+				publisher, err := Publish(&ClientOption{Broker: broker, URL: server.URL + "/whip/live", Token: "secret"}, source, source.Receivers()[0])
+				assert(t, err, nil)
+				defer publisher.Close()
+				_, err = Publish(&ClientOption{Broker: broker, URL: server.URL + "/whip/live", Token: "secret"}, source)
+				assert(t, errors.Is(err, ErrStreamExist), true)
+				_, err = Play(&ClientOption{Broker: broker, URL: server.URL + "/whep/live", Token: "secret", Codecs: []string{"VP8"}})
+				assert(t, errors.Is(err, ErrNoMedia), true)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package whip
+
+import "errors"
+
+var (
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrStreamNotFound  = errors.New("stream not found")
+	ErrStreamExist     = errors.New("stream already exists")
+	ErrNoMedia         = errors.New("no acceptable media")
+	ErrSessionNotFound = errors.New("session not found")
+	ErrConnectTimeout  = errors.New("connect timeout")
+	ErrUnexpectedReply = errors.New("unexpected reply")
+)
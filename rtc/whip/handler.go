@@ -0,0 +1,515 @@
+package whip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/auth"
+	"github.com/gotolive/sfu/rtc/bwe"
+	"github.com/gotolive/sfu/rtc/dtls"
+	"github.com/gotolive/sfu/rtc/logger"
+	"github.com/gotolive/sfu/rtc/negotiation"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+const (
+	contentTypeSDP      = "application/sdp"
+	contentTypeFragment = "application/trickle-ice-sdpfrag"
+	maxBodySize         = 64 * 1024
+
+	defaultConnectTimeout = 30 * time.Second
+)
+
+// Kind is the protocol of the endpoint, whip for publishing and whep for playing.
+type Kind string
+
+const (
+	KindWHIP Kind = "whip"
+	KindWHEP Kind = "whep"
+)
+
+// ICEServer is advertised to the clients by the Link headers.
+type ICEServer struct {
+	URLs       []string
+	Username   string
+	Credential string
+}
+
+type HandlerOption struct {
+	Broker *peer.Broker
+	// ICEServers are sent in the Link headers of the created and OPTIONS responses.
+	ICEServers []ICEServer
+	// Authorize checks the bearer token of the request, the token is empty if not present.
+	// The request is rejected with 403 if the error is ErrForbidden, or 401 for others.
+	// It's optional, all requests are allowed if nil.
+	Authorize func(kind Kind, streamKey, token string) error
+	// Verifier verifies the bearer token as a JWT if not nil, the room of claims is the stream key,
+	// whip requires the publish grant and whep requires the subscribe grant. The receivers and senders
+	// of the session are authorized by the claims too. It's checked after Authorize.
+	Verifier *auth.Verifier
+	// Codecs are the accepted encoder names of the publishers, opus, VP8, VP9, H264 and AV1 by default.
+	Codecs []string
+	// BweType is the bwe of the connections, remb by default.
+	BweType   string
+	ListenIPs []string
+	// ConnectTimeout closes the sessions not connected in time, 30s by default.
+	ConnectTimeout time.Duration
+}
+
+// Handler implements WHIP(rfc9725) and WHEP, the endpoints are /whip/{key} and /whep/{key},
+// the players of a stream key consume the receivers of the publisher of the key.
+// The session resource is the endpoint followed by the session id, which supports PATCH
+// for trickle and ice restart, and DELETE for teardown.
+type Handler struct {
+	option HandlerOption
+	mux    *http.ServeMux
+
+	mutex      sync.Mutex
+	sessions   map[string]*session
+	publishers map[string]*session // by stream key
+}
+
+func NewHandler(option *HandlerOption) *Handler {
+	h := &Handler{
+		option:     *option,
+		mux:        http.NewServeMux(),
+		sessions:   map[string]*session{},
+		publishers: map[string]*session{},
+	}
+	if len(h.option.Codecs) == 0 {
+		h.option.Codecs = negotiation.DefaultCodecs
+	}
+	if h.option.BweType == "" {
+		h.option.BweType = bwe.Remb
+	}
+	if h.option.ConnectTimeout == 0 {
+		h.option.ConnectTimeout = defaultConnectTimeout
+	}
+	h.mux.HandleFunc("POST /{kind}/{key}", h.create)
+	h.mux.HandleFunc("OPTIONS /{kind}/{key}", h.options)
+	h.mux.HandleFunc("PATCH /{kind}/{key}/{id}", h.patch)
+	h.mux.HandleFunc("DELETE /{kind}/{key}/{id}", h.delete)
+	h.mux.HandleFunc("OPTIONS /{kind}/{key}/{id}", h.options)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Close closes all sessions.
+func (h *Handler) Close() {
+	h.mutex.Lock()
+	sessions := make([]*session, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	h.mutex.Unlock()
+	for _, s := range sessions {
+		h.remove(s)
+	}
+}
+
+func kindOf(r *http.Request) (Kind, bool) {
+	kind := Kind(r.PathValue("kind"))
+	return kind, kind == KindWHIP || kind == KindWHEP
+}
+
+func bearerToken(r *http.Request) string {
+	value := r.Header.Get("Authorization")
+	if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+		return strings.TrimSpace(value[7:])
+	}
+	return ""
+}
+
+// authorize writes the error response if the request is not allowed,
+// the claims are nil if the Verifier is not set.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, kind Kind, key string) (*auth.Claims, bool) {
+	var (
+		claims *auth.Claims
+		err    error
+	)
+	if h.option.Authorize != nil {
+		err = h.option.Authorize(kind, key, bearerToken(r))
+	}
+	if err == nil && h.option.Verifier != nil {
+		action := auth.ActionPublish
+		if kind == KindWHEP {
+			action = auth.ActionSubscribe
+		}
+		claims, err = h.option.Verifier.Authorize(bearerToken(r), key, action)
+	}
+	switch {
+	case err == nil:
+		return claims, true
+	case errors.Is(err, ErrForbidden), errors.Is(err, auth.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	}
+	return nil, false
+}
+
+func hasContentType(r *http.Request, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == contentType
+}
+
+func (h *Handler) setLinks(w http.ResponseWriter) {
+	for _, server := range h.option.ICEServers {
+		for _, u := range server.URLs {
+			link := fmt.Sprintf(`<%s>; rel="ice-server"`, u)
+			if server.Username != "" {
+				link += fmt.Sprintf(`; username=%q; credential=%q; credential-type="password"`, server.Username, server.Credential)
+			}
+			w.Header().Add("Link", link)
+		}
+	}
+}
+
+func (h *Handler) options(w http.ResponseWriter, r *http.Request) {
+	if _, ok := kindOf(r); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.PathValue("id") != "" {
+		w.Header().Set("Allow", "OPTIONS, PATCH, DELETE")
+		w.Header().Set("Accept-Patch", contentTypeFragment)
+	} else {
+		w.Header().Set("Allow", "OPTIONS, POST")
+		w.Header().Set("Accept-Post", contentTypeSDP)
+		h.setLinks(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// create creates the session of the offer, a publisher for whip or a player for whep.
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	kind, ok := kindOf(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	key := r.PathValue("key")
+	claims, ok := h.authorize(w, r, kind, key)
+	if !ok {
+		return
+	}
+	if !hasContentType(r, contentTypeSDP) {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offer, err := sdp.Unmarshal(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	role, err := dtls.NegotiateRole(offer.TransportInfo.ConnectionRole)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s := &session{
+		id:    peer.RandomString(16),
+		kind:  kind,
+		key:   key,
+		offer: offer,
+		etag:  newETag(),
+		ufrag: offer.TransportInfo.IceUfrag,
+	}
+	h.mutex.Lock()
+	publisher := h.publishers[key]
+	if kind == KindWHIP && publisher == nil {
+		// reserve the key before the connection created.
+		h.publishers[key] = s
+	}
+	// the publisher is ready after it's in the sessions.
+	ready := publisher != nil && h.sessions[publisher.id] == publisher
+	h.mutex.Unlock()
+	switch {
+	case kind == KindWHIP && publisher != nil:
+		http.Error(w, ErrStreamExist.Error(), http.StatusConflict)
+		return
+	case kind == KindWHEP && !ready:
+		http.Error(w, ErrStreamNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	dtlsOption := dtls.Option{Role: role}
+	if fp := offer.TransportInfo.FingerPrint; fp != nil {
+		dtlsOption.Fingerprints = &dtls.Fingerprint{Algorithm: fp.Algorithm, Value: fp.Value}
+	}
+	s.connection, err = h.option.Broker.NewWebRTCConnection(&peer.WebRTCOption{
+		ID:         s.id,
+		ListenIPs:  h.option.ListenIPs,
+		DtlsOption: dtlsOption,
+		BweType:    h.option.BweType,
+	})
+	if err != nil {
+		h.unreserve(s)
+		logger.Error("create whip connection fail:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if claims != nil {
+		s.connection.SetAuthorizer(h.option.Verifier.Authorizer(claims, key))
+	}
+	if kind == KindWHIP {
+		s.medias = h.receive(s.connection, offer)
+	} else {
+		s.medias = h.send(s.connection, publisher, offer)
+	}
+	if s.media() == nil {
+		h.unreserve(s)
+		s.connection.Close()
+		http.Error(w, ErrNoMedia.Error(), http.StatusNotAcceptable)
+		return
+	}
+	cryptex := negotiation.NegotiateCryptex(s.connection, offer)
+	answer := writeAnswer(offer, s.connection.Transport().Info(), s.medias, cryptex)
+
+	h.mutex.Lock()
+	h.sessions[s.id] = s
+	h.mutex.Unlock()
+	connected := make(chan struct{})
+	s.connection.OnStateChange(func(state int) {
+		switch state {
+		case 1:
+			close(connected)
+		case 2:
+			go h.remove(s)
+		}
+	})
+	go func() {
+		select {
+		case <-connected:
+		case <-time.After(h.option.ConnectTimeout):
+			logger.Warn("whip session not connected in time:", s.id)
+			h.remove(s)
+		}
+	}()
+
+	w.Header().Set("Content-Type", contentTypeSDP)
+	w.Header().Set("Location", url.PathEscape(key)+"/"+s.id)
+	w.Header().Set("ETag", s.etag)
+	h.setLinks(w)
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(answer))
+}
+
+// receive creates the receivers of the offer.
+func (h *Handler) receive(connection *peer.Connection, offer *sdp.SessionDescription) []*mediaAnswer {
+	medias := make([]*mediaAnswer, 0, len(offer.MediaDescription))
+	for _, media := range offer.MediaDescription {
+		m := &mediaAnswer{offer: media}
+		medias = append(medias, m)
+		option := negotiation.ReceiverOption(media, h.option.Codecs, h.option.BweType)
+		if option == nil {
+			continue
+		}
+		receiver, err := connection.NewReceiver(option)
+		if err != nil {
+			logger.Warn("create whip receiver fail:", media.MID, err)
+			continue
+		}
+		m.receiver = receiver
+	}
+	return medias
+}
+
+// send creates the senders of the publisher receivers, a receiver is sent by the first offered m-line of its media type.
+func (h *Handler) send(connection *peer.Connection, publisher *session, offer *sdp.SessionDescription) []*mediaAnswer {
+	receivers := publisher.connection.Receivers()
+	headers := bweHeaders(offer)
+	used := map[string]bool{}
+	medias := make([]*mediaAnswer, 0, len(offer.MediaDescription))
+	for _, media := range offer.MediaDescription {
+		m := &mediaAnswer{offer: media}
+		medias = append(medias, m)
+		for _, receiver := range receivers {
+			if used[receiver.ID()] || receiver.MediaType() != media.MediaType {
+				continue
+			}
+			option := senderOption(media, publisher.connection.ID(), receiver, h.option.BweType, headers)
+			if option == nil {
+				continue
+			}
+			sender, err := connection.NewSender(option)
+			if err != nil {
+				logger.Warn("create whep sender fail:", media.MID, err)
+				continue
+			}
+			used[receiver.ID()] = true
+			m.sender = sender
+			break
+		}
+	}
+	return medias
+}
+
+// session returns the session of the resource url, it writes the error response if not found or not allowed.
+func (h *Handler) session(w http.ResponseWriter, r *http.Request) *session {
+	kind, ok := kindOf(r)
+	if !ok {
+		http.NotFound(w, r)
+		return nil
+	}
+	key := r.PathValue("key")
+	if _, ok = h.authorize(w, r, kind, key); !ok {
+		return nil
+	}
+	h.mutex.Lock()
+	s := h.sessions[r.PathValue("id")]
+	h.mutex.Unlock()
+	if s == nil || s.kind != kind || s.key != key {
+		http.Error(w, ErrSessionNotFound.Error(), http.StatusNotFound)
+		return nil
+	}
+	return s
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	s := h.session(w, r)
+	if s == nil {
+		return
+	}
+	h.remove(s)
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch handles the trickle and ice restart, see rfc9725#section-4.3.
+// The server is ice lite, so the trickled candidates are not needed and ignored.
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request) {
+	s := h.session(w, r)
+	if s == nil {
+		return
+	}
+	if !hasContentType(r, contentTypeFragment) {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		http.Error(w, "If-Match required", http.StatusPreconditionRequired)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fragment, err := sdp.Unmarshal(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if match != "*" && match != s.etag {
+		http.Error(w, "etag not match", http.StatusPreconditionFailed)
+		return
+	}
+	ufrag := fragment.TransportInfo.IceUfrag
+	if ufrag == "" || ufrag == s.ufrag {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// the remote credentials changed, it's an ice restart.
+	if err = s.connection.RestartIce(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.ufrag = ufrag
+	s.etag = newETag()
+	w.Header().Set("Content-Type", contentTypeFragment)
+	w.Header().Set("ETag", s.etag)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(writeFragment(s.connection.Transport().Info(), s.media().offer)))
+}
+
+// unreserve releases the stream key of a publisher failed to create.
+func (h *Handler) unreserve(s *session) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.publishers[s.key] == s {
+		delete(h.publishers, s.key)
+	}
+}
+
+// remove closes the session, the players are closed with the publisher.
+func (h *Handler) remove(s *session) {
+	h.mutex.Lock()
+	if h.sessions[s.id] != s {
+		h.mutex.Unlock()
+		return
+	}
+	delete(h.sessions, s.id)
+	var players []*session
+	if s.kind == KindWHIP && h.publishers[s.key] == s {
+		delete(h.publishers, s.key)
+		for _, player := range h.sessions {
+			if player.kind == KindWHEP && player.key == s.key {
+				players = append(players, player)
+			}
+		}
+	}
+	h.mutex.Unlock()
+	s.connection.Close()
+	for _, player := range players {
+		h.remove(player)
+	}
+}
+
+// Publisher returns the connection publishing the stream key, nil if not exist.
+func (h *Handler) Publisher(key string) *peer.Connection {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if s := h.publishers[key]; s != nil && h.sessions[s.id] == s {
+		return s.connection
+	}
+	return nil
+}
+
+type session struct {
+	id         string
+	kind       Kind
+	key        string
+	offer      *sdp.SessionDescription
+	connection *peer.Connection
+	medias     []*mediaAnswer
+
+	mutex sync.Mutex
+	etag  string
+	ufrag string // the remote ufrag
+}
+
+// media returns the first accepted media, nil if none.
+func (s *session) media() *mediaAnswer {
+	for _, m := range s.medias {
+		if m.accepted() {
+			return m
+		}
+	}
+	return nil
+}
+
+func newETag() string {
+	return `"` + peer.RandomString(16) + `"`
+}
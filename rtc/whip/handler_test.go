@@ -0,0 +1,301 @@
+package whip
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotolive/sfu/rtc/auth"
+	"github.com/gotolive/sfu/rtc/ice"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+func assert(t *testing.T, actual, expected any) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Logf("%v expected: %v, but got: %v", t.Name(), expected, actual)
+		t.FailNow()
+	}
+}
+
+type testHelper struct {
+	name        string
+	description string
+	method      func(t *testing.T)
+}
+
+func readOffer(t *testing.T, name string) string {
+	b, err := os.ReadFile("../../testdata/sdp/" + name)
+	assert(t, err, nil)
+	return string(b)
+}
+
+func newTestHandler(t *testing.T, option *HandlerOption) (*Handler, *httptest.Server) {
+	broker, err := peer.NewBroker(peer.BrokerOption{ICE: ice.Option{IPs: []string{"127.0.0.1"}}})
+	assert(t, err, nil)
+	option.Broker = broker
+	handler := NewHandler(option)
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		handler.Close()
+		broker.Close()
+	})
+	return handler, server
+}
+
+func do(t *testing.T, method, url, contentType, body string, headers ...string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert(t, err, nil)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	assert(t, err, nil)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	assert(t, err, nil)
+	return res, string(b)
+}
+
+// resource returns the url of the Location.
+func resource(t *testing.T, res *http.Response) string {
+	location, err := res.Request.URL.Parse(res.Header.Get("Location"))
+	assert(t, err, nil)
+	return location.String()
+}
+
+func TestHandler(t *testing.T) {
+	tests := []testHelper{
+		{
+			name:        "publish",
+			description: "the publisher offer is answered with a session resource",
+			method: func(t *testing.T) {
+				handler, server := newTestHandler(t, &HandlerOption{
+					ICEServers: []ICEServer{{URLs: []string{"stun:stun.example.net"}}, {URLs: []string{"turn:turn.example.net"}, Username: "user", Credential: "pass"}},
+				})
+				res, _ := do(t, http.MethodOptions, server.URL+"/whip/live", "", "")
+				assert(t, res.StatusCode, http.StatusNoContent)
+				assert(t, res.Header.Get("Accept-Post"), contentTypeSDP)
+				assert(t, res.Header.Values("Link"), []string{
+					`<stun:stun.example.net>; rel="ice-server"`,
+					`<turn:turn.example.net>; rel="ice-server"; username="user"; credential="pass"; credential-type="password"`,
+				})
+
+				res, _ = do(t, http.MethodPost, server.URL+"/whip/live", "text/plain", readOffer(t, "sdp-3"))
+				assert(t, res.StatusCode, http.StatusUnsupportedMediaType)
+				res, _ = do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, "m=")
+				assert(t, res.StatusCode, http.StatusBadRequest)
+
+				res, body := do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"))
+				assert(t, res.StatusCode, http.StatusCreated)
+				assert(t, res.Header.Get("Content-Type"), contentTypeSDP)
+				assert(t, strings.HasPrefix(res.Header.Get("Location"), "live/"), true)
+				assert(t, res.Header.Get("ETag") != "", true)
+				assert(t, len(res.Header.Values("Link")), 2)
+
+				answer, err := sdp.Unmarshal(body)
+				assert(t, err, nil)
+				assert(t, answer.TransportInfo.ConnectionRole, "active")
+				assert(t, answer.TransportInfo.IceMode, sdp.IceModeLite)
+				assert(t, len(answer.MediaDescription), 2)
+				audio, video := answer.MediaDescription[0], answer.MediaDescription[1]
+				assert(t, audio.MID, "0")
+				assert(t, audio.Direction, "recvonly")
+				assert(t, audio.Formats, []uint8{111})
+				assert(t, audio.Codecs[111].EncoderName, "opus")
+				assert(t, audio.Codecs[111].FeedbackParams, []sdp.FeedbackParams(nil))
+				assert(t, video.Formats, []uint8{96, 97})
+				assert(t, video.Codecs[96].RTX, uint8(97))
+				assert(t, video.RtcpReducedSize, true)
+
+				connection := handler.Publisher("live")
+				assert(t, len(connection.Receivers()), 2)
+				res, _ = do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"))
+				assert(t, res.StatusCode, http.StatusConflict)
+
+				// sdp-1 is recvonly.
+				res, _ = do(t, http.MethodPost, server.URL+"/whip/other", contentTypeSDP, readOffer(t, "sdp-1"))
+				assert(t, res.StatusCode, http.StatusNotAcceptable)
+				assert(t, handler.Publisher("other") == nil, true)
+
+				res, _ = do(t, http.MethodDelete, server.URL+"/whep/live/"+connection.ID(), "", "")
+				assert(t, res.StatusCode, http.StatusNotFound)
+				res, _ = do(t, http.MethodDelete, server.URL+"/whip/live/"+connection.ID(), "", "")
+				assert(t, res.StatusCode, http.StatusOK)
+				assert(t, handler.Publisher("live") == nil, true)
+				res, _ = do(t, http.MethodDelete, server.URL+"/whip/live/"+connection.ID(), "", "")
+				assert(t, res.StatusCode, http.StatusNotFound)
+			},
+		},
+		{
+			name:        "cryptex",
+			description: "cryptex is accepted in the answer only if it's offered",
+			method: func(t *testing.T) {
+				_, server := newTestHandler(t, &HandlerOption{})
+				res, body := do(t, http.MethodPost, server.URL+"/whip/plain", contentTypeSDP, readOffer(t, "sdp-3"))
+				assert(t, res.StatusCode, http.StatusCreated)
+				answer, err := sdp.Unmarshal(body)
+				assert(t, err, nil)
+				assert(t, answer.CryptexEnabled(), false)
+
+				offer := strings.Replace(readOffer(t, "sdp-3"), "a=group:BUNDLE", "a=cryptex\na=group:BUNDLE", 1)
+				res, body = do(t, http.MethodPost, server.URL+"/whip/cryptex", contentTypeSDP, offer)
+				assert(t, res.StatusCode, http.StatusCreated)
+				answer, err = sdp.Unmarshal(body)
+				assert(t, err, nil)
+				assert(t, answer.CryptexEnabled(), true)
+			},
+		},
+		{
+			name:        "play",
+			description: "the player consumes the receivers of the publisher",
+			method: func(t *testing.T) {
+				handler, server := newTestHandler(t, &HandlerOption{})
+				res, _ := do(t, http.MethodPost, server.URL+"/whep/live", contentTypeSDP, readOffer(t, "sdp-1"))
+				assert(t, res.StatusCode, http.StatusNotFound)
+				res, _ = do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"))
+				assert(t, res.StatusCode, http.StatusCreated)
+				publisher := resource(t, res)
+
+				res, body := do(t, http.MethodPost, server.URL+"/whep/live", contentTypeSDP, readOffer(t, "sdp-1"))
+				assert(t, res.StatusCode, http.StatusCreated)
+				player := resource(t, res)
+				answer, err := sdp.Unmarshal(body)
+				assert(t, err, nil)
+				assert(t, len(answer.MediaDescription), 2)
+				audio, video := answer.MediaDescription[0], answer.MediaDescription[1]
+				assert(t, audio.MID, "audio")
+				assert(t, audio.Direction, "sendonly")
+				assert(t, audio.Formats, []uint8{111})
+				assert(t, video.Formats, []uint8{96, 97})
+				assert(t, len(audio.Streams), 1)
+				assert(t, len(video.Streams), 1)
+
+				// the ssrcs are rewritten by the senders.
+				assert(t, audio.Streams[0].SSRC != 3000297603, true)
+				assert(t, video.Streams[0].SSRC != 4180466998, true)
+				assert(t, len(handler.Publisher("live").Receivers()), 2)
+
+				// the player is closed with the publisher.
+				res, _ = do(t, http.MethodDelete, publisher, "", "")
+				assert(t, res.StatusCode, http.StatusOK)
+				res, _ = do(t, http.MethodDelete, player, "", "")
+				assert(t, res.StatusCode, http.StatusNotFound)
+			},
+		},
+		{
+			name:        "patch",
+			description: "the trickle is accepted and the ice restart changes the credentials",
+			method: func(t *testing.T) {
+				_, server := newTestHandler(t, &HandlerOption{})
+				res, body := do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"))
+				assert(t, res.StatusCode, http.StatusCreated)
+				answer, err := sdp.Unmarshal(body)
+				assert(t, err, nil)
+				url, etag := resource(t, res), res.Header.Get("ETag")
+
+				res, _ = do(t, http.MethodOptions, url, "", "")
+				assert(t, res.StatusCode, http.StatusNoContent)
+				assert(t, res.Header.Get("Accept-Patch"), contentTypeFragment)
+
+				trickle := "a=ice-ufrag:YBRm\r\na=ice-pwd:axxZievRUnSQW4FBpJyKz1oN\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=mid:0\r\n" +
+					"a=candidate:1 1 udp 2113937151 127.0.0.1 5000 typ host\r\na=end-of-candidates\r\n"
+				res, _ = do(t, http.MethodPatch, url, contentTypeSDP, trickle, "If-Match", etag)
+				assert(t, res.StatusCode, http.StatusUnsupportedMediaType)
+				res, _ = do(t, http.MethodPatch, url, contentTypeFragment, trickle)
+				assert(t, res.StatusCode, http.StatusPreconditionRequired)
+				res, _ = do(t, http.MethodPatch, url, contentTypeFragment, trickle, "If-Match", `"none"`)
+				assert(t, res.StatusCode, http.StatusPreconditionFailed)
+				res, _ = do(t, http.MethodPatch, url, contentTypeFragment, trickle, "If-Match", etag)
+				assert(t, res.StatusCode, http.StatusNoContent)
+
+				restart := "a=ice-ufrag:abcd\r\na=ice-pwd:efghijklmnopqrstuvwxyz12\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=mid:0\r\n"
+				res, body = do(t, http.MethodPatch, url, contentTypeFragment, restart, "If-Match", "*")
+				assert(t, res.StatusCode, http.StatusOK)
+				assert(t, res.Header.Get("Content-Type"), contentTypeFragment)
+				assert(t, res.Header.Get("ETag") != etag, true)
+				fragment, err := sdp.Unmarshal(body)
+				assert(t, err, nil)
+				assert(t, fragment.TransportInfo.IceUfrag != answer.TransportInfo.IceUfrag, true)
+				assert(t, len(fragment.TransportInfo.Candidates), len(answer.TransportInfo.Candidates)/2)
+				res, _ = do(t, http.MethodPatch, url, contentTypeFragment, trickle, "If-Match", etag)
+				assert(t, res.StatusCode, http.StatusPreconditionFailed)
+			},
+		},
+		{
+			name:        "authorize",
+			description: "the requests are checked by the bearer token",
+			method: func(t *testing.T) {
+				_, server := newTestHandler(t, &HandlerOption{
+					Authorize: func(kind Kind, streamKey, token string) error {
+						switch {
+						case token != "secret":
+							return ErrUnauthorized
+						case kind == KindWHEP:
+							return ErrForbidden
+						}
+						return nil
+					},
+				})
+				res, _ := do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"))
+				assert(t, res.StatusCode, http.StatusUnauthorized)
+				assert(t, res.Header.Get("WWW-Authenticate"), "Bearer")
+				res, _ = do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"), "Authorization", "Bearer secret")
+				assert(t, res.StatusCode, http.StatusCreated)
+				url := resource(t, res)
+				res, _ = do(t, http.MethodPost, server.URL+"/whep/live", contentTypeSDP, readOffer(t, "sdp-1"), "Authorization", "Bearer secret")
+				assert(t, res.StatusCode, http.StatusForbidden)
+				res, _ = do(t, http.MethodDelete, url, "", "")
+				assert(t, res.StatusCode, http.StatusUnauthorized)
+				res, _ = do(t, http.MethodDelete, url, "", "", "Authorization", "Bearer secret")
+				assert(t, res.StatusCode, http.StatusOK)
+				res, _ = do(t, http.MethodPost, server.URL+"/other/live", contentTypeSDP, readOffer(t, "sdp-3"))
+				assert(t, res.StatusCode, http.StatusNotFound)
+			},
+		},
+		{
+			name:        "jwt",
+			description: "the bearer token is verified, and the receivers are limited to the kinds granted",
+			method: func(t *testing.T) {
+				secret := []byte("secret")
+				verifier, err := auth.NewVerifier(&auth.VerifierOption{Secret: secret})
+				assert(t, err, nil)
+				_, server := newTestHandler(t, &HandlerOption{Verifier: verifier})
+				sign := func(claims *auth.Claims) string {
+					claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+					token, err := auth.SignHS256(claims, secret)
+					assert(t, err, nil)
+					return "Bearer " + token
+				}
+				res, _ := do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"), "Authorization", "Bearer invalid")
+				assert(t, res.StatusCode, http.StatusUnauthorized)
+				res, _ = do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"),
+					"Authorization", sign(&auth.Claims{Room: "other", Publish: true}))
+				assert(t, res.StatusCode, http.StatusForbidden)
+				res, _ = do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"),
+					"Authorization", sign(&auth.Claims{Room: "live", Subscribe: true}))
+				assert(t, res.StatusCode, http.StatusForbidden)
+
+				res, body := do(t, http.MethodPost, server.URL+"/whip/live", contentTypeSDP, readOffer(t, "sdp-3"),
+					"Authorization", sign(&auth.Claims{Room: "live", Publish: true, Kinds: []string{"audio"}}))
+				assert(t, res.StatusCode, http.StatusCreated)
+				// the video m-line is rejected.
+				assert(t, strings.Contains(body, "m=video 0 "), true)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.method(t)
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package whip
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gotolive/sfu/rtc"
+	"github.com/gotolive/sfu/rtc/bwe"
+	"github.com/gotolive/sfu/rtc/negotiation"
+	"github.com/gotolive/sfu/rtc/peer"
+	"github.com/gotolive/sfu/rtc/sdp"
+)
+
+// mediaAnswer is the answer of an offered m-line, it's rejected if both receiver and sender are nil.
+type mediaAnswer struct {
+	offer    *sdp.MediaDescription
+	receiver *peer.Receiver
+	sender   peer.Sender
+}
+
+func (m *mediaAnswer) accepted() bool {
+	return m.receiver != nil || m.sender != nil
+}
+
+// senderOption returns the option to send the receiver by the offered m-line, nil if the codec is not offered.
+func senderOption(media *sdp.MediaDescription, connectionID string, receiver *peer.Receiver, bweType string, bweHeaders []rtc.HeaderExtension) *peer.SenderOption {
+	if media.Direction == "sendonly" || media.Direction == "inactive" {
+		return nil
+	}
+	source := receiver.Codec()
+	var codec *sdp.Codec
+	for _, pt := range media.Formats {
+		c := media.Codecs[pt]
+		if c == nil || !strings.EqualFold(c.EncoderName, source.EncoderName) {
+			continue
+		}
+		if codec == nil || source.Equal(&peer.Codec{EncoderName: source.EncoderName, Parameters: c.Parameters}) {
+			codec = c
+		}
+	}
+	if codec == nil {
+		return nil
+	}
+	option := &peer.SenderOption{
+		ID:           peer.RandomString(12),
+		MID:          media.MID,
+		ConnectionID: connectionID,
+		ReceiverID:   receiver.ID(),
+		Codec: &peer.Codec{
+			EncoderName:    source.EncoderName,
+			PayloadType:    rtc.PayloadType(codec.PayloadType),
+			ClockRate:      source.ClockRate,
+			Channels:       source.Channels,
+			Parameters:     source.Parameters,
+			RTX:            rtc.PayloadType(codec.RTX),
+			FeedbackParams: negotiation.Feedback(codec.FeedbackParams, bweType),
+		},
+		SwitchMode: peer.ManualSwitchLayer,
+	}
+	// the stream identification of the receiver is not forwarded.
+	uris := map[string]bool{}
+	for _, h := range receiver.HeaderExtensions() {
+		switch h.URI {
+		case rtc.HeaderExtensionRid, rtc.HeaderExtensionRepairedRid, rtc.HeaderExtensionMid:
+		default:
+			uris[h.URI] = true
+		}
+	}
+	for _, h := range media.HeaderExtensions {
+		if uris[h.URI] {
+			option.HeaderExtensions = append(option.HeaderExtensions, rtc.HeaderExtension{
+				URI:     h.URI,
+				ID:      rtc.HeaderExtensionID(h.ID),
+				Encrypt: h.Encrypt,
+			})
+		}
+	}
+	// the bwe header is added to every sender by the connection, so it must be the offered id of any m-line.
+	option.HeaderExtensions = append(option.HeaderExtensions, bweHeaders...)
+	return option
+}
+
+// bweHeaders returns the offered bwe header extensions of all m-lines.
+func bweHeaders(offer *sdp.SessionDescription) []rtc.HeaderExtension {
+	var result []rtc.HeaderExtension
+	found := map[string]bool{}
+	for _, media := range offer.MediaDescription {
+		for _, h := range media.HeaderExtensions {
+			if (h.URI == rtc.HeaderExtensionAbsSendTime || h.URI == rtc.HeaderExtensionTransportSequenceNumber) && !found[h.URI] {
+				found[h.URI] = true
+				result = append(result, rtc.HeaderExtension{URI: h.URI, ID: rtc.HeaderExtensionID(h.ID)})
+			}
+		}
+	}
+	return result
+}
+
+// writeAnswer generates the answer of the offer, the m-lines are in the order of offer.
+// The cryptex is accepted if it's enabled on the connection.
+func writeAnswer(offer *sdp.SessionDescription, info peer.TransportInfo, medias []*mediaAnswer, cryptex bool) string {
+	b := &strings.Builder{}
+	header := &negotiation.Header{Lite: true, ExtmapAllowMixed: offer.ExtmapAllowMixed, Cryptex: cryptex}
+	for _, m := range medias {
+		if m.accepted() {
+			header.Mids = append(header.Mids, m.offer.MID)
+		}
+	}
+	negotiation.WriteHeader(b, header)
+	for _, m := range medias {
+		if !m.accepted() {
+			negotiation.WriteRejected(b, m.offer.MediaType, m.offer.MID, m.offer.Formats)
+			continue
+		}
+		negotiation.WriteAnswerMedia(b, info, m.offer, m.receiver, m.sender)
+	}
+	return b.String()
+}
+
+// writeFragment generates the sdp fragment of the ice restart response, see rfc8840.
+func writeFragment(info peer.TransportInfo, media *sdp.MediaDescription) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "a=ice-lite\r\na=ice-ufrag:%s\r\na=ice-pwd:%s\r\n", info.IceInfo.Ufrag, info.IceInfo.Pwd)
+	format := "0"
+	if len(media.Formats) != 0 {
+		format = fmt.Sprint(media.Formats[0])
+	}
+	fmt.Fprintf(b, "m=%s 9 %s %s\r\na=mid:%s\r\n", media.MediaType, negotiation.MediaProtocol, format, media.MID)
+	negotiation.WriteCandidates(b, info.IceInfo.Candidates)
+	return b.String()
+}
+
+// offerCodecs are offered by the WHEP client by media type, in the order of preference.
+var offerCodecs = map[string][]*peer.Codec{
+	rtc.MediaTypeAudio: {
+		{EncoderName: "opus", PayloadType: 111, ClockRate: 48000, Channels: 2, Parameters: map[string]string{"minptime": "10", "useinbandfec": "1"}},
+	},
+	rtc.MediaTypeVideo: {
+		{EncoderName: "VP8", PayloadType: 96, ClockRate: 90000, RTX: 97},
+		{EncoderName: "H264", PayloadType: 102, ClockRate: 90000, RTX: 103, Parameters: map[string]string{"level-asymmetry-allowed": "1", "packetization-mode": "1", "profile-level-id": "42e01f"}},
+		{EncoderName: "VP9", PayloadType: 98, ClockRate: 90000, RTX: 99, Parameters: map[string]string{"profile-id": "0"}},
+		{EncoderName: "AV1", PayloadType: 45, ClockRate: 90000, RTX: 46},
+	},
+}
+
+// mediaOffer is an m-line offered by client, the sender is only for sendonly.
+type mediaOffer struct {
+	mediaType string
+	mid       string
+	direction string
+	codecs    []*peer.Codec
+	headers   []rtc.HeaderExtension
+	sender    peer.Sender
+}
+
+// bweHeaderURI returns the header extension used by the bwe.
+func bweHeaderURI(bweType string) string {
+	if bweType == bwe.TransportCC {
+		return rtc.HeaderExtensionTransportSequenceNumber
+	}
+	return rtc.HeaderExtensionAbsSendTime
+}
+
+// clientFeedback returns the rtcp feedback offered by client.
+func clientFeedback(mediaType, bweType string) []peer.RtcpFeedback {
+	var result []peer.RtcpFeedback
+	if mediaType == rtc.MediaTypeVideo {
+		result = append(result, peer.RtcpFeedback{Type: "nack"}, peer.RtcpFeedback{Type: "nack", Parameter: "pli"}, peer.RtcpFeedback{Type: "ccm", Parameter: "fir"})
+		if bweType == bwe.Remb {
+			result = append(result, peer.RtcpFeedback{Type: "goog-remb"})
+		}
+	}
+	if bweType == bwe.TransportCC {
+		result = append(result, peer.RtcpFeedback{Type: "transport-cc"})
+	}
+	return result
+}
+
+// playOffers returns the recvonly m-lines of audio and video with the codecs of names.
+func playOffers(names []string, bweType string) []*mediaOffer {
+	headers := []rtc.HeaderExtension{{URI: bweHeaderURI(bweType), ID: 1}}
+	var medias []*mediaOffer
+	for _, mediaType := range []string{rtc.MediaTypeAudio, rtc.MediaTypeVideo} {
+		media := &mediaOffer{mediaType: mediaType, mid: fmt.Sprint(len(medias)), direction: "recvonly", headers: headers}
+		for _, codec := range offerCodecs[mediaType] {
+			for _, name := range names {
+				if strings.EqualFold(codec.EncoderName, name) {
+					c := *codec
+					c.FeedbackParams = clientFeedback(mediaType, bweType)
+					media.codecs = append(media.codecs, &c)
+					break
+				}
+			}
+		}
+		if len(media.codecs) != 0 {
+			medias = append(medias, media)
+		}
+	}
+	return medias
+}
+
+// writeOffer generates the offer of client, the candidates are not known before the answer.
+// The cryptex is always offered, it's enabled if the answer accepts it.
+func writeOffer(info peer.TransportInfo, medias []*mediaOffer) string {
+	b := &strings.Builder{}
+	header := &negotiation.Header{Mids: make([]string, 0, len(medias)), Cryptex: true}
+	for _, m := range medias {
+		header.Mids = append(header.Mids, m.mid)
+	}
+	negotiation.WriteHeader(b, header)
+	for _, m := range medias {
+		negotiation.WriteMline(b, m.mediaType, m.codecs...)
+		negotiation.WriteTransport(b, info, m.mid)
+		negotiation.WriteExtmaps(b, m.headers)
+		fmt.Fprintf(b, "a=%s\r\na=rtcp-mux\r\na=rtcp-rsize\r\n", m.direction)
+		for _, codec := range m.codecs {
+			negotiation.WriteCodec(b, codec)
+		}
+		if m.sender != nil {
+			negotiation.WriteSSRC(b, m.sender)
+		}
+	}
+	return b.String()
+}